@@ -94,6 +94,46 @@ var builtins_baseio = map[string]*env.Builtin{
 		},
 	},
 
+	"import": { // **
+		Argsn: 1,
+		Doc:   "Imports a module: evaluates the file once per program in its own context and returns that context. The file is searched for next to the importing script, in the project directory, in RYE_PATH and in rye_modules. Unlike Import it doesn't change the current context.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			switch s1 := arg0.(type) {
+			case env.Uri:
+				return evaldo.ImportModule(ps, s1, "import")
+			default:
+				return evaldo.MakeArgError(ps, 1, []env.Type{env.UriType}, "import")
+			}
+		},
+	},
+
+	"export": {
+		Argsn: 1,
+		Doc:   "Sets the words a module exports, import returns a context with only these words. Without export all words of the module are returned.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			switch s1 := arg0.(type) {
+			case env.Block:
+				return evaldo.ExportModuleWords(ps, s1, "export")
+			default:
+				return evaldo.MakeArgError(ps, 1, []env.Type{env.BlockType}, "export")
+			}
+		},
+	},
+
+	"modules?": {
+		Argsn: 0,
+		Doc:   "Returns the paths of the modules imported so far.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			paths := make([]env.Object, 0)
+			if ps.Modules != nil {
+				for _, p := range ps.Modules.Loaded() {
+					paths = append(paths, *env.NewString(p))
+				}
+			}
+			return *env.NewBlock(*env.NewTSeries(paths))
+		},
+	},
+
 	"file-uri//Import\\live": { // **
 		Argsn: 1,
 		Doc:   "Imports a file, loads and does it from script local path.",
@@ -130,6 +170,7 @@ var builtins_baseio = map[string]*env.Builtin{
 				scrip := ps.ScriptPath
 				ps.ScriptPath = s1.GetPath()
 				block := loader.LoadString(str, false, ps)
+				evaldo.SourceLoaded(s1.GetPath(), str, block)
 				ps.ScriptPath = scrip
 				return block
 			default:
@@ -210,7 +251,7 @@ var builtins_baseio = map[string]*env.Builtin{
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
 			switch s0 := arg0.(type) {
 			case env.String:
-				r := exec.Command("sh", "-c", s0.Value) //nolint: gosec
+				r := exec.CommandContext(ps.GoContext(), "sh", "-c", s0.Value) //nolint: gosec
 				r.Stdout = os.Stdout
 				r.Stderr = os.Stderr
 
@@ -235,7 +276,7 @@ var builtins_baseio = map[string]*env.Builtin{
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
 			switch s0 := arg0.(type) {
 			case env.String:
-				r := exec.Command("sh", "-c", s0.Value) //nolint: gosec
+				r := exec.CommandContext(ps.GoContext(), "sh", "-c", s0.Value) //nolint: gosec
 				var outb, errb bytes.Buffer
 				r.Stdout = &outb
 				r.Stderr = &errb
@@ -264,6 +305,9 @@ var builtins_baseio = map[string]*env.Builtin{
 		Doc:   "Exits the process with the given integer status code (or 0 for any non-integer).",
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
 			util.BeforeExit()
+			if evaldo.ExitHook != nil {
+				evaldo.ExitHook()
+			}
 			switch code := arg0.(type) {
 			case env.Integer:
 				os.Exit(int(code.Value))
@@ -392,6 +436,9 @@ var builtins_baseio = map[string]*env.Builtin{
 // ---------------------------------------------------------------------------
 
 func ryeFirstArgIdx(ps *env.ProgramState) int {
+	if ps.ScriptArgIdx > 0 {
+		return ps.ScriptArgIdx
+	}
 	if ps.Embedded {
 		return 1
 	}
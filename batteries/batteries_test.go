@@ -0,0 +1,57 @@
+package batteries
+
+import (
+	"testing"
+
+	"github.com/refaktor/rye/env"
+	"github.com/refaktor/rye/evaldo"
+	"github.com/refaktor/rye/loader"
+)
+
+// Go tests of the batteries are for what the doc comment tests can't do, like running servers
+// or checking what a client sent. They evaluate Rye code with these helpers.
+
+// testState returns a program state with all builtins, the code a test evaluates shares its context
+func testState() *env.ProgramState {
+	ps := env.NewProgramState()
+	evaldo.RegisterBuiltins(ps)
+	RegisterBatteries(ps)
+	return ps
+}
+
+// testRun evaluates the code in the program state and returns the result and whether it failed,
+// the result is nil if the code didn't load
+func testRun(ps *env.ProgramState, code string) (env.Object, bool) {
+	block, ok := loader.LoadString(code, false, ps).(env.Block)
+	if !ok {
+		return nil, true
+	}
+	ps.FailureFlag, ps.ErrorFlag, ps.ReturnFlag = false, false, false
+	ps.Ser = block.Series
+	evaldo.EvalBlockInj(ps, nil, false)
+	return ps.Res, ps.FailureFlag || ps.ErrorFlag
+}
+
+// testEval evaluates the code, it fails the test when the code doesn't load or when it fails and
+// shouldn't (or the other way around)
+func testEval(t *testing.T, ps *env.ProgramState, code string, shouldFail bool) env.Object {
+	t.Helper()
+	res, failed := testRun(ps, code)
+	if res == nil {
+		t.Fatalf("code didn't load: %s", code)
+	}
+	if failed != shouldFail {
+		t.Fatalf("%s: expected failure %v, got %s", code, shouldFail, res.Inspect(*ps.Idx))
+	}
+	return res
+}
+
+// testFailure evaluates code that should fail and returns the message of the failure
+func testFailure(t *testing.T, ps *env.ProgramState, code string) string {
+	t.Helper()
+	res := testEval(t, ps, code, true)
+	if err, ok := res.(*env.Error); ok {
+		return err.Message
+	}
+	return res.Inspect(*ps.Idx)
+}
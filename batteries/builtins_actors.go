@@ -0,0 +1,741 @@
+//go:build !b_tiny
+// +build !b_tiny
+
+package batteries
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/refaktor/rye/env"
+	"github.com/refaktor/rye/evaldo"
+
+	"github.com/jinzhu/copier"
+)
+
+// actor is a Rye function running in its own goroutine with a mailbox. It's stored in the task
+// context of the program state it runs in, so self and receive find it and kill cancels it. The
+// context is made from the task context of the code that spawns the actor, so an actor spawned in
+// a task group is killed when the group ends or is cancelled. Actors spawned by an actor outside of
+// a task group outlive it, they get the context the spawning actor was made from.
+type actor struct {
+	id      int64
+	base    context.Context // the context the actor's context was made from
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+	mu      sync.Mutex
+	mailbox []env.Object
+	signal  chan struct{} // a message arrived
+	done    chan struct{} // closed when the actor exits
+	reason  env.Object    // "normal" or the error the actor crashed with
+	crashed bool
+	links   map[*actor]bool
+	downs   []func(a *actor, reason env.Object, crashed bool) // monitors, called when the actor exits
+	sup     *supervisor                                       // set for supervisors
+}
+
+type actorKey struct{}
+
+var (
+	actorIds  atomic.Int64
+	rootActor *actor
+	rootOnce  sync.Once
+)
+
+var errKilled = errors.New("killed")
+
+func newActor(base context.Context) *actor {
+	a := &actor{
+		id:     actorIds.Add(1),
+		base:   base,
+		signal: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+		links:  make(map[*actor]bool),
+	}
+	a.ctx, a.cancel = context.WithCancelCause(base)
+	a.ctx = context.WithValue(a.ctx, actorKey{}, a)
+	return a
+}
+
+// currentActor returns the actor the program state runs in, the main program has its own actor
+// that never exits so it can receive messages too
+func currentActor(ps *env.ProgramState) *actor {
+	if a, ok := ps.GoContext().Value(actorKey{}).(*actor); ok {
+		return a
+	}
+	rootOnce.Do(func() {
+		rootActor = newActor(context.Background())
+		rootActor.cancel = func(error) {}
+	})
+	return rootActor
+}
+
+// actorBase returns the context for the actors the program state spawns, the code of an actor
+// spawns them from the context the actor was made from, so they don't exit with it
+func actorBase(ps *env.ProgramState) context.Context {
+	ctx := ps.GoContext()
+	if a, ok := ctx.Value(actorKey{}).(*actor); ok && ctx == a.ctx {
+		return a.base
+	}
+	return ctx
+}
+
+// spawnActor calls the function with the argument (nil for none) in a new actor, linked to the
+// given actor if it's not nil
+func spawnActor(ps *env.ProgramState, fn env.Function, arg env.Object, link *actor) (*actor, error) {
+	psTemp := env.ProgramState{}
+	if err := copier.Copy(&psTemp, &ps); err != nil {
+		return nil, err
+	}
+	psTemp.FailureFlag = false
+	psTemp.ErrorFlag = false
+	psTemp.ReturnFlag = false
+	psTemp.InErrHandler = true // crashes are reported to links and monitors
+	a := newActor(actorBase(ps))
+	psTemp.TaskCtx = a.ctx
+	if link != nil {
+		linkActors(a, link)
+	}
+	go func() {
+		reason, crashed := env.Object(*env.NewString("normal")), false
+		defer func() {
+			if r := recover(); r != nil {
+				reason, crashed = env.NewError(fmt.Sprintf("actor panicked: %v", r)), true
+			}
+			a.exit(reason, crashed)
+		}()
+		evaldo.CallFunction_CollectArgs(fn, &psTemp, arg, false, nil)
+		if psTemp.ErrorFlag || psTemp.FailureFlag {
+			reason, crashed = psTemp.Res, true
+		}
+	}()
+	return a, nil
+}
+
+func (a *actor) send(msg env.Object) {
+	a.mu.Lock()
+	a.mailbox = append(a.mailbox, msg)
+	a.mu.Unlock()
+	select {
+	case a.signal <- struct{}{}:
+	default:
+	}
+}
+
+// receive removes the first message that matches one of the patterns from the mailbox and returns
+// it with its action, it waits for one until the timeout (0 waits forever) or the actor is killed
+func (a *actor) receive(ps *env.ProgramState, patterns env.Block, timeout time.Duration) (env.Object, env.Block, error) {
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	for {
+		a.mu.Lock()
+		for i, msg := range a.mailbox {
+			if action, ok := matchPatterns(ps, msg, patterns); ok {
+				a.mailbox = append(a.mailbox[:i:i], a.mailbox[i+1:]...)
+				a.mu.Unlock()
+				return msg, action, nil
+			}
+		}
+		a.mu.Unlock()
+		select {
+		case <-a.signal:
+		case <-ps.GoContext().Done():
+			return nil, env.Block{}, context.Cause(ps.GoContext())
+		case <-deadline:
+			return nil, env.Block{}, fmt.Errorf("no matching message in %d ms", timeout.Milliseconds())
+		}
+	}
+}
+
+// matchPatterns returns the action of the first pattern the message matches, like match does
+func matchPatterns(ps *env.ProgramState, msg env.Object, patterns env.Block) (env.Block, bool) {
+	failureFlag := ps.FailureFlag
+	defer func() { ps.FailureFlag = failureFlag }()
+	for i := 0; i+1 < patterns.Series.Len(); i += 2 {
+		action, ok := patterns.Series.Get(i + 1).(env.Block)
+		if !ok {
+			continue
+		}
+		ps.FailureFlag = false
+		matchValue(ps, msg, patterns.Series.Get(i), matchMode{0})
+		if !ps.FailureFlag {
+			return action, true
+		}
+	}
+	return env.Block{}, false
+}
+
+func (a *actor) exit(reason env.Object, crashed bool) {
+	a.mu.Lock()
+	a.reason, a.crashed = reason, crashed
+	links, downs := a.links, a.downs
+	a.links, a.downs = nil, nil
+	a.mu.Unlock()
+	close(a.done)
+	stopped := a.ctx.Err() != nil
+	a.cancel(errKilled)
+
+	if len(links) == 0 && len(downs) == 0 && crashed && !stopped {
+		// nobody is told about the crash, so it shouldn't go unnoticed
+		fmt.Fprintf(os.Stderr, "Actor %d crashed: %s\n", a.id, reasonString(reason))
+	}
+	for b := range links {
+		b.mu.Lock()
+		delete(b.links, a)
+		b.mu.Unlock()
+		if crashed {
+			b.cancel(fmt.Errorf("linked actor %d crashed: %s", a.id, reasonString(reason)))
+		}
+	}
+	for _, down := range downs {
+		down(a, reason, crashed)
+	}
+}
+
+// linkActors links the actors so that when one crashes the other is killed, if one has already
+// crashed the other is killed right away
+func linkActors(a, b *actor) {
+	for _, pair := range [][2]*actor{{a, b}, {b, a}} {
+		x, y := pair[0], pair[1]
+		x.mu.Lock()
+		if x.links == nil {
+			// x has exited
+			crashed, reason := x.crashed, x.reason
+			x.mu.Unlock()
+			if crashed {
+				y.cancel(fmt.Errorf("linked actor %d crashed: %s", x.id, reasonString(reason)))
+			}
+			return
+		}
+		x.links[y] = true
+		x.mu.Unlock()
+	}
+}
+
+// monitor calls down when the actor exits, right away if it has already exited
+func (a *actor) monitor(down func(a *actor, reason env.Object, crashed bool)) {
+	a.mu.Lock()
+	if a.reason != nil {
+		// a has exited
+		reason, crashed := a.reason, a.crashed
+		a.mu.Unlock()
+		down(a, reason, crashed)
+		return
+	}
+	a.downs = append(a.downs, down)
+	a.mu.Unlock()
+}
+
+func reasonString(reason env.Object) string {
+	switch r := reason.(type) {
+	case *env.Error:
+		return r.Message
+	case env.Error:
+		return r.Message
+	case env.String:
+		return r.Value
+	}
+	return fmt.Sprint(reason)
+}
+
+// supervisor is an actor that restarts the children that crash according to its strategy, if
+// they crash more than maxRestarts times in restartPeriod it gives up and crashes itself
+type supervisor struct {
+	ps       *env.ProgramState
+	strategy string
+	fns      []env.Function
+	children []*actor
+	restarts []time.Time
+	events   chan *actor
+}
+
+const (
+	maxRestarts   = 5
+	restartPeriod = 5 * time.Second
+)
+
+func startSupervisor(ps *env.ProgramState, strategy string, fns []env.Function) (*actor, error) {
+	// the children are restarted from a copy, the caller's program state changes meanwhile
+	psCopy := &env.ProgramState{}
+	if err := copier.Copy(psCopy, ps); err != nil {
+		return nil, err
+	}
+	s := &supervisor{ps: psCopy, strategy: strategy, fns: fns, children: make([]*actor, len(fns)), events: make(chan *actor)}
+	a := newActor(actorBase(ps))
+	a.sup = s
+	for i := range fns {
+		if err := s.start(a, i); err != nil {
+			for _, child := range s.children[:i] {
+				child.cancel(errKilled)
+			}
+			return nil, err
+		}
+	}
+	go func() {
+		reason, crashed := s.run(a)
+		a.exit(reason, crashed)
+	}()
+	return a, nil
+}
+
+func (s *supervisor) start(a *actor, i int) error {
+	child, err := spawnActor(s.ps, s.fns[i], nil, nil)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	s.children[i] = child
+	a.mu.Unlock()
+	child.monitor(func(child *actor, reason env.Object, crashed bool) {
+		if crashed {
+			select {
+			case s.events <- child:
+			case <-a.done:
+			}
+		}
+	})
+	return nil
+}
+
+func (s *supervisor) run(a *actor) (env.Object, bool) {
+	for {
+		select {
+		case <-a.ctx.Done():
+			return s.cancelled(a)
+		case child := <-s.events:
+			i := -1
+			for j, c := range s.children {
+				if c == child {
+					i = j
+				}
+			}
+			if i == -1 {
+				// a child that was stopped by an earlier restart
+				continue
+			}
+			now := time.Now()
+			recent := s.restarts[:0]
+			for _, t := range s.restarts {
+				if now.Sub(t) < restartPeriod {
+					recent = append(recent, t)
+				}
+			}
+			s.restarts = append(recent, now)
+			if len(s.restarts) > maxRestarts {
+				for _, c := range s.children {
+					c.cancel(errKilled)
+				}
+				return env.NewError(fmt.Sprintf("too many restarts, the last child crashed with: %s", reasonString(child.reason))), true
+			}
+			restart := []int{i}
+			switch s.strategy {
+			case "one-for-all":
+				restart = restart[:0]
+				for j := range s.children {
+					restart = append(restart, j)
+				}
+			case "rest-for-one":
+				for j := i + 1; j < len(s.children); j++ {
+					restart = append(restart, j)
+				}
+			}
+			// all the old children are stopped before the new ones start, so they never run together
+			for _, j := range restart {
+				s.children[j].cancel(errKilled)
+			}
+			for _, j := range restart {
+				select {
+				case <-s.children[j].done:
+				case <-a.ctx.Done():
+					return s.cancelled(a)
+				}
+			}
+			for _, j := range restart {
+				if err := s.start(a, j); err != nil {
+					return env.NewError(err.Error()), true
+				}
+			}
+		}
+	}
+}
+
+// cancelled kills the children when the supervisor is cancelled
+func (s *supervisor) cancelled(a *actor) (env.Object, bool) {
+	for _, child := range s.children {
+		child.cancel(errKilled)
+	}
+	return env.NewError("Task cancelled: " + context.Cause(a.ctx).Error()), true
+}
+
+func actorArg(ps *env.ProgramState, arg env.Object, pos int, fnName string) (*actor, env.Object) {
+	if native, ok := arg.(env.Native); ok {
+		if a, ok := native.Value.(*actor); ok {
+			return a, nil
+		}
+	}
+	ps.FailureFlag = true
+	return nil, evaldo.MakeArgError(ps, pos, []env.Type{env.NativeType}, fnName)
+}
+
+func receiveMessage(ps *env.ProgramState, patterns env.Object, timeout time.Duration, pos int, fnName string) env.Object {
+	block, ok := patterns.(env.Block)
+	if !ok {
+		ps.FailureFlag = true
+		return evaldo.MakeArgError(ps, pos, []env.Type{env.BlockType}, fnName)
+	}
+	if block.Series.Len()%2 != 0 {
+		return evaldo.MakeBuiltinError(ps, "Patterns block must contain an even number of elements (pairs)", fnName)
+	}
+	msg, action, err := currentActor(ps).receive(ps, block, timeout)
+	if err != nil {
+		return evaldo.MakeBuiltinError(ps, err.Error(), fnName)
+	}
+	ser := ps.Ser
+	ps.Ser = action.Series
+	evaldo.EvalBlockInj(ps, msg, true)
+	ps.Ser = ser
+	return ps.Res
+}
+
+var Builtins_actors = map[string]*env.Builtin{
+
+	//
+	// ##### Actors ##### "processes with mailboxes, links, monitors and supervisors"
+	//
+	// Example:
+	//  ; An actor that answers pings until it's told to stop
+	//  pinger: spawn fn { } {
+	//    forever {
+	//      receive {
+	//        { "ping" from } { send from "pong" }
+	//        "stop" { ^return "stopped" }
+	//      }
+	//    }
+	//  }
+	//  send pinger [ "ping" self ]
+	//  receive { "pong" { print "got pong" } }
+	//
+	//  ; Restart the workers of a gateway when they crash
+	//  sup: supervisor 'one-for-one { ?read-sensors ?upload }
+	//
+	// Tests:
+	// equal { spawn fn { } { 1 } |type? } 'native
+	// equal { a: spawn fn { } { 1 + 1 } , a .Wait } "normal"
+	// equal { a: spawn-with self fn { p } { spawn-with p fn { p } { sleep 20 , send p "child" } } , a .Wait , receive { x { x } } } "child"
+	// equal { task-group { :g send self spawn fn { } { receive { "never" { } } } } , receive { x { x } } |Wait |message? } "Task cancelled: the task group was cancelled"
+	// Args:
+	// * function: Function without arguments that the actor runs
+	// Returns:
+	// * the actor
+	"spawn": {
+		Argsn: 1,
+		Doc:   "Runs a function as a new actor with its own mailbox.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			switch fn := arg0.(type) {
+			case env.Function:
+				a, err := spawnActor(ps, fn, nil, nil)
+				if err != nil {
+					return evaldo.MakeBuiltinError(ps, fmt.Sprintf("failed to copy program state: %s", err), "spawn")
+				}
+				return *env.NewNative(ps.Idx, a, "Rye-actor")
+			default:
+				ps.FailureFlag = true
+				return evaldo.MakeArgError(ps, 1, []env.Type{env.FunctionType}, "spawn")
+			}
+		},
+	},
+
+	// Tests:
+	// equal { spawn-with self fn { parent } { send parent 42 } , receive { <integer> { + 1 } } } 43
+	// Args:
+	// * value: Value passed to the function
+	// * function: Function with one argument that the actor runs
+	// Returns:
+	// * the actor
+	"spawn-with": {
+		Argsn: 2,
+		Doc:   "Runs a function with the value as a new actor with its own mailbox.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			switch fn := arg1.(type) {
+			case env.Function:
+				a, err := spawnActor(ps, fn, arg0, nil)
+				if err != nil {
+					return evaldo.MakeBuiltinError(ps, fmt.Sprintf("failed to copy program state: %s", err), "spawn-with")
+				}
+				return *env.NewNative(ps.Idx, a, "Rye-actor")
+			default:
+				ps.FailureFlag = true
+				return evaldo.MakeArgError(ps, 2, []env.Type{env.FunctionType}, "spawn-with")
+			}
+		},
+	},
+
+	// Tests:
+	// equal { a: spawn fn { } { spawn\link fn { } { fail "boom" } , receive { "never" { } } } , a .Wait |message? |contains "crashed: boom" } true
+	// Args:
+	// * function: Function without arguments that the actor runs
+	// Returns:
+	// * the actor, linked to the current one so when either crashes the other is killed
+	"spawn\\link": {
+		Argsn: 1,
+		Doc:   "Runs a function as a new actor that is linked to the current one.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			switch fn := arg0.(type) {
+			case env.Function:
+				a, err := spawnActor(ps, fn, nil, currentActor(ps))
+				if err != nil {
+					return evaldo.MakeBuiltinError(ps, fmt.Sprintf("failed to copy program state: %s", err), "spawn\\link")
+				}
+				return *env.NewNative(ps.Idx, a, "Rye-actor")
+			default:
+				ps.FailureFlag = true
+				return evaldo.MakeArgError(ps, 1, []env.Type{env.FunctionType}, "spawn\\link")
+			}
+		},
+	},
+
+	// Tests:
+	// equal { self |type? } 'native
+	// equal { send self 1 , receive { x { x } } } 1
+	// Args:
+	// Returns:
+	// * the current actor, the main program is an actor too
+	"self": {
+		Argsn: 0,
+		Doc:   "Returns the actor the code runs in.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			return *env.NewNative(ps.Idx, currentActor(ps), "Rye-actor")
+		},
+	},
+
+	// Tests:
+	// equal { send self "hi" , receive { <string> { ++ "!" } } } "hi!"
+	// Args:
+	// * actor: Actor to send the message to
+	// * message: Any value
+	// Returns:
+	// * the message
+	"send": {
+		Argsn: 2,
+		Doc:   "Puts a message in the mailbox of an actor, sending never waits.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			a, err := actorArg(ps, arg0, 1, "send")
+			if err != nil {
+				return err
+			}
+			a.send(arg1)
+			return arg1
+		},
+	},
+
+	// Tests:
+	// equal { send self 1 , send self "two" , receive { <string> { .length? } } |+ receive { <integer> { * 10 } } } 13
+	// equal { send self { "add" 2 3 } , receive { { "add" p q } { p + q } } } 5
+	// Args:
+	// * patterns: Block of pattern and action pairs, like match
+	// Returns:
+	// * the result of the action of the first message that matches, other messages stay in the mailbox
+	"receive": {
+		Argsn: 1,
+		Doc:   "Waits for a message that matches one of the patterns, removes it from the mailbox and does its action with the message injected.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			return receiveMessage(ps, arg0, 0, 1, "receive")
+		},
+	},
+
+	// Tests:
+	// equal { try { receive\timeout 10 { "never" { 1 } } } |message? |contains "no matching message in 10 ms" } true
+	// equal { send self 5 , receive\timeout 10 { x { x * 2 } } } 10
+	// Args:
+	// * timeout: Integer, milliseconds to wait for a matching message
+	// * patterns: Block of pattern and action pairs, like match
+	// Returns:
+	// * the result of the action of the first message that matches, or a failure if none came in time
+	"receive\\timeout": {
+		Argsn: 2,
+		Doc:   "Like receive, but fails if no matching message arrives in the given milliseconds.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			timeout, ok := arg0.(env.Integer)
+			if !ok {
+				ps.FailureFlag = true
+				return evaldo.MakeArgError(ps, 1, []env.Type{env.IntegerType}, "receive\\timeout")
+			}
+			if timeout.Value <= 0 {
+				return evaldo.MakeBuiltinError(ps, "timeout must be positive", "receive\\timeout")
+			}
+			return receiveMessage(ps, arg1, time.Duration(timeout.Value)*time.Millisecond, 2, "receive\\timeout")
+		},
+	},
+
+	// Tests:
+	// equal { a: spawn fn { } { receive { "crash" { fail "boom" } } } , b: spawn-with a fn { a } { link a , receive { "never" { } } } , sleep 20 , send a "crash" , b .Wait |message? |contains "crashed: boom" } true
+	// Args:
+	// * actor: Actor to link the current one with
+	// Returns:
+	// * the actor, when either of the two crashes the other is killed
+	"link": {
+		Argsn: 1,
+		Doc:   "Links the current actor with another one, so when one crashes the other is killed too.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			a, err := actorArg(ps, arg0, 1, "link")
+			if err != nil {
+				return err
+			}
+			linkActors(currentActor(ps), a)
+			return arg0
+		},
+	},
+
+	// Tests:
+	// equal { a: spawn fn { } { fail "boom" } , monitor a , receive { { "down" x reason } { reason |message? } } } "boom"
+	// equal { a: spawn fn { } { 1 } , monitor a , receive { { "down" x reason } { reason } } } "normal"
+	// Args:
+	// * actor: Actor to monitor
+	// Returns:
+	// * the actor, when it exits the current actor gets a message { "down" actor reason }, reason is "normal" or the error it crashed with
+	"monitor": {
+		Argsn: 1,
+		Doc:   "Monitors an actor, the current actor gets a down message when it exits.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			a, err := actorArg(ps, arg0, 1, "monitor")
+			if err != nil {
+				return err
+			}
+			watcher := currentActor(ps)
+			a.monitor(func(a *actor, reason env.Object, crashed bool) {
+				watcher.send(*env.NewBlock(*env.NewTSeries([]env.Object{*env.NewString("down"), arg0, reason})))
+			})
+			return arg0
+		},
+	},
+
+	// Tests:
+	// equal { a: spawn fn { } { receive { "never" { } } } , kill a , a .Wait |message? } "Task cancelled: killed"
+	// Args:
+	// * actor: Actor to stop
+	// Returns:
+	// * the actor, its links and monitors see it as crashed
+	"kill": {
+		Argsn: 1,
+		Doc:   "Stops an actor, waiting receives and long-running builtins in it are cancelled.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			a, err := actorArg(ps, arg0, 1, "kill")
+			if err != nil {
+				return err
+			}
+			a.cancel(errKilled)
+			return arg0
+		},
+	},
+
+	// Tests:
+	// equal { a: spawn fn { } { sleep 10 } , a .Wait } "normal"
+	// Args:
+	// * actor: Actor to wait for
+	// Returns:
+	// * "normal" if the actor finished or the error it crashed with
+	"Rye-actor//Wait": {
+		Argsn: 1,
+		Doc:   "Waits until the actor exits and returns the reason.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			a, err := actorArg(ps, arg0, 1, "Rye-actor//Wait")
+			if err != nil {
+				return err
+			}
+			select {
+			case <-a.done:
+				return a.reason
+			case <-ps.GoContext().Done():
+				return evaldo.MakeBuiltinError(ps, context.Cause(ps.GoContext()).Error(), "Rye-actor//Wait")
+			}
+		},
+	},
+
+	// Tests:
+	// equal { supervisor 'one-for-one { fn { } { 1 } fn { } { 2 } } |Children? |length? } 2
+	// equal { spawn fn { } { 1 } |Children? } { }
+	// Args:
+	// * actor: Supervisor
+	// Returns:
+	// * block with the current children of a supervisor, empty for other actors
+	"Rye-actor//Children?": {
+		Argsn: 1,
+		Doc:   "Returns the current children of a supervisor.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			a, err := actorArg(ps, arg0, 1, "Rye-actor//Children?")
+			if err != nil {
+				return err
+			}
+			children := make([]env.Object, 0)
+			if a.sup != nil {
+				a.mu.Lock()
+				for _, child := range a.sup.children {
+					children = append(children, *env.NewNative(ps.Idx, child, "Rye-actor"))
+				}
+				a.mu.Unlock()
+			}
+			return *env.NewBlock(*env.NewTSeries(children))
+		},
+	},
+
+	// Tests:
+	// equal { s: supervisor 'one-for-one { fn { } { receive { "crash" { fail "boom" } } } } , c: s .Children? |first , send c "crash" , sleep 50 , s .Children? |first |= c } false
+	// equal { s: supervisor 'one-for-all { fn { } { receive { "crash" { fail "boom" } } } fn { } { receive { "x" { 1 } } } } , c: s .Children? , send first c "crash" , sleep 50 , s .Children? |second |= second c } false
+	// equal { try { supervisor 'any { } } |message? } "`supervisor`: strategy must be one-for-one, one-for-all or rest-for-one"
+	// Args:
+	// * strategy: Word, one-for-one restarts the child that crashed, one-for-all restarts all children and rest-for-one restarts it and the children after it
+	// * children: Block of functions without arguments, each is run as an actor
+	// Returns:
+	// * the supervisor actor, it crashes if its children crash more than 5 times in 5 seconds
+	"supervisor": {
+		Argsn: 2,
+		Doc:   "Starts an actor that runs the functions as its children and restarts those that crash according to the strategy.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			word, ok := arg0.(env.Word)
+			if !ok {
+				ps.FailureFlag = true
+				return evaldo.MakeArgError(ps, 1, []env.Type{env.WordType}, "supervisor")
+			}
+			strategy := ps.Idx.GetWord(word.Index)
+			if strategy != "one-for-one" && strategy != "one-for-all" && strategy != "rest-for-one" {
+				return evaldo.MakeBuiltinError(ps, "strategy must be one-for-one, one-for-all or rest-for-one", "supervisor")
+			}
+			block, ok := arg1.(env.Block)
+			if !ok {
+				ps.FailureFlag = true
+				return evaldo.MakeArgError(ps, 2, []env.Type{env.BlockType}, "supervisor")
+			}
+			var fns []env.Function
+			ser := ps.Ser
+			ps.Ser = block.Series
+			for ps.Ser.Pos() < ps.Ser.Len() {
+				evaldo.EvalExpression_CollectArg(ps, false, false)
+				if ps.ErrorFlag || ps.FailureFlag {
+					ps.Ser = ser
+					return ps.Res
+				}
+				fn, ok := ps.Res.(env.Function)
+				if !ok {
+					ps.Ser = ser
+					return evaldo.MakeBuiltinError(ps, "children must be functions", "supervisor")
+				}
+				fns = append(fns, fn)
+			}
+			ps.Ser = ser
+			a, err := startSupervisor(ps, strategy, fns)
+			if err != nil {
+				return evaldo.MakeBuiltinError(ps, fmt.Sprintf("failed to copy program state: %s", err), "supervisor")
+			}
+			return *env.NewNative(ps.Idx, a, "Rye-actor")
+		},
+	},
+}
@@ -0,0 +1,10 @@
+//go:build b_tiny
+// +build b_tiny
+
+package batteries
+
+import (
+	"github.com/refaktor/rye/env"
+)
+
+var Builtins_actors = map[string]*env.Builtin{}
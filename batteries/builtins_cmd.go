@@ -203,7 +203,7 @@ var Builtins_cmd = map[string]*env.Builtin{
 						if len(args) == 0 {
 							return evaldo.MakeBuiltinError(ps, "missing command before pipe", "cmd")
 						}
-						pipecmd := exec.Command(args[0], args[1:]...)
+						pipecmd := exec.CommandContext(ps.GoContext(), args[0], args[1:]...)
 						pipecmd.Stdin = os.Stdin
 						pipecmd.Stderr = os.Stderr
 						pipe = append(pipe, pipecmd)
@@ -252,7 +252,7 @@ var Builtins_cmd = map[string]*env.Builtin{
 			if len(args) == 0 {
 				return evaldo.MakeBuiltinError(ps, "missing command", "cmd")
 			}
-			cmd := exec.Command(args[0], args[1:]...)
+			cmd := exec.CommandContext(ps.GoContext(), args[0], args[1:]...)
 			cmd.Stdin = os.Stdin
 			cmd.Stdout = os.Stdout
 			cmd.Stderr = os.Stderr
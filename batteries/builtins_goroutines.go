@@ -4,10 +4,13 @@
 package batteries
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"reflect"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/refaktor/rye/env"
 	"github.com/refaktor/rye/evaldo"
@@ -35,6 +38,123 @@ func (m *RyeMutex) Unlock() error {
 	return nil
 }
 
+// taskGroup runs the tasks of a task-group, the first task that fails cancels the others through
+// the group's context
+type taskGroup struct {
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+	stop    context.CancelFunc
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	results []env.Object
+	failure env.Object
+}
+
+var (
+	errTaskFailed     = errors.New("a task of the group failed")
+	errGroupCancelled = errors.New("the task group was cancelled")
+)
+
+func newTaskGroup(ps *env.ProgramState, timeout time.Duration) *taskGroup {
+	g := &taskGroup{stop: func() {}}
+	g.ctx, g.cancel = context.WithCancelCause(ps.GoContext())
+	if timeout > 0 {
+		g.ctx, g.stop = context.WithTimeoutCause(g.ctx, timeout, fmt.Errorf("task group timed out after %d ms", timeout.Milliseconds()))
+	}
+	return g
+}
+
+// spawn calls the function with the argument (nil for none) in a goroutine whose program state is
+// cancelled with the group
+func (g *taskGroup) spawn(ps *env.ProgramState, fn env.Function, arg env.Object) error {
+	psTemp := env.ProgramState{}
+	if err := copier.Copy(&psTemp, &ps); err != nil {
+		return err
+	}
+	psTemp.FailureFlag = false
+	psTemp.ErrorFlag = false
+	psTemp.ReturnFlag = false
+	psTemp.TaskCtx = g.ctx
+	psTemp.InErrHandler = true // failures are returned by the group, not displayed
+
+	g.mu.Lock()
+	i := len(g.results)
+	g.results = append(g.results, env.Void{})
+	g.mu.Unlock()
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		defer func() {
+			if r := recover(); r != nil {
+				g.fail(env.NewError(fmt.Sprintf("task panicked: %v", r)))
+			}
+		}()
+		evaldo.CallFunction_CollectArgs(fn, &psTemp, arg, false, nil)
+		g.mu.Lock()
+		g.results[i] = psTemp.Res
+		g.mu.Unlock()
+		if psTemp.ErrorFlag || psTemp.FailureFlag {
+			g.fail(psTemp.Res)
+		}
+	}()
+	return nil
+}
+
+// fail records the first failure and cancels the other tasks, tasks that fail because the group
+// was already cancelled don't count
+func (g *taskGroup) fail(res env.Object) {
+	g.mu.Lock()
+	if g.failure == nil && g.ctx.Err() == nil {
+		g.failure = res
+	}
+	g.mu.Unlock()
+	g.cancel(errTaskFailed)
+}
+
+// wait waits for all tasks and returns the block of their results, the first failure or the
+// failure of the timeout
+func (g *taskGroup) wait(ps *env.ProgramState, fnName string) env.Object {
+	g.wg.Wait()
+	cause := context.Cause(g.ctx)
+	g.cancel(errGroupCancelled)
+	g.stop()
+	if g.failure != nil {
+		ps.FailureFlag = true
+		return g.failure
+	}
+	if cause != nil && cause != errGroupCancelled {
+		return evaldo.MakeBuiltinError(ps, cause.Error(), fnName)
+	}
+	return *env.NewBlock(*env.NewTSeries(g.results))
+}
+
+// runTaskGroup evaluates the block in a subcontext with the group injected and waits for the
+// tasks it spawned
+func runTaskGroup(ps *env.ProgramState, block env.Block, timeout time.Duration, fnName string) env.Object {
+	g := newTaskGroup(ps, timeout)
+	ser, ctx, taskCtx := ps.Ser, ps.Ctx, ps.TaskCtx
+	ps.Ser = block.Series
+	ps.Ctx = env.NewEnv(ps.Ctx)
+	ps.TaskCtx = g.ctx
+	evaldo.EvalBlockInj(ps, *env.NewNative(ps.Idx, g, "Rye-task-group"), true)
+	ps.Ser, ps.Ctx, ps.TaskCtx = ser, ctx, taskCtx
+	if ps.ErrorFlag || ps.FailureFlag {
+		if g.ctx.Err() == nil {
+			// the block itself failed, the tasks are cancelled and its failure is returned
+			res, errorFlag := ps.Res, ps.ErrorFlag
+			g.cancel(errGroupCancelled)
+			g.wait(ps, fnName)
+			ps.ErrorFlag = errorFlag
+			ps.FailureFlag = !errorFlag
+			return res
+		}
+		// the block was cancelled by a failed task or the timeout
+		ps.ErrorFlag = false
+		ps.FailureFlag = false
+	}
+	return g.wait(ps, fnName)
+}
+
 var Builtins_goroutines = map[string]*env.Builtin{
 
 	//
@@ -61,6 +181,11 @@ var Builtins_goroutines = map[string]*env.Builtin{
 	//  mtx: mutex
 	//  go fn { } { mtx .Lock , change! counter + 1 'counter , mtx .Unlock }
 	//
+	//  ; Task group, the first failed request cancels the others
+	//  pages: task-group\timeout 5000 { :g
+	//    for urls { ::u g .Go-with u fn { u } { Get u } }
+	//  }
+	//
 	// Tests:
 	// equal { x:: 0 , go-with 5 fn { v } { change! v 'x } , sleep 100 , x } 5
 	// equal { y:: 0 , go-with "test" fn { v } { change! length? v 'y } , sleep 100 , y } 4
@@ -183,6 +308,7 @@ var Builtins_goroutines = map[string]*env.Builtin{
 	// equal { ch: channel 1 , ch .Send 123 , ch .Read } 123
 	// equal { ch: channel 1 , ch .Send "test" , ch .Read } "test"
 	// equal { ch: channel 1 , ch .Close , try { ch .Read } |type? } 'error
+	// equal { ch: channel 0 , try { with-timeout 50 { ch .Read } } |message? |contains "timed out after 50 ms" } true
 	// Args:
 	// * channel: Channel to read from
 	// Returns:
@@ -200,11 +326,16 @@ var Builtins_goroutines = map[string]*env.Builtin{
 					return evaldo.MakeBuiltinError(ps, "Invalid channel type", "Rye-channel//Read")
 				}
 
-				msg, ok := <-ch
-				if ok {
-					return *msg
-				} else {
-					return *env.NewError("channel closed")
+				ctx := ps.GoContext()
+				select {
+				case msg, ok := <-ch:
+					if ok {
+						return *msg
+					} else {
+						return *env.NewError("channel closed")
+					}
+				case <-ctx.Done():
+					return evaldo.MakeBuiltinError(ps, "Read cancelled: "+context.Cause(ctx).Error(), "Rye-channel//Read")
 				}
 			default:
 				ps.FailureFlag = true
@@ -234,8 +365,18 @@ var Builtins_goroutines = map[string]*env.Builtin{
 			}()
 			switch chn := arg0.(type) {
 			case env.Native:
-				chn.Value.(chan *env.Object) <- &arg1
-				return arg0
+				ch, ok := chn.Value.(chan *env.Object)
+				if !ok {
+					ps.FailureFlag = true
+					return evaldo.MakeBuiltinError(ps, "Invalid channel type", "Rye-channel//Send")
+				}
+				ctx := ps.GoContext()
+				select {
+				case ch <- &arg1:
+					return arg0
+				case <-ctx.Done():
+					return evaldo.MakeBuiltinError(ps, "Send cancelled: "+context.Cause(ctx).Error(), "Rye-channel//Send")
+				}
 			default:
 				ps.FailureFlag = true
 				return evaldo.MakeArgError(ps, 1, []env.Type{env.NativeType}, "Rye-channel//Send")
@@ -488,7 +629,13 @@ var Builtins_goroutines = map[string]*env.Builtin{
 				}
 				ps.Ser = ser
 
+				// the task's context is the last case, so a cancelled task doesn't wait forever
+				ctx := ps.GoContext()
+				cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())})
 				chosen, value, recvOK := reflect.Select(cases)
+				if chosen == len(funcs) {
+					return evaldo.MakeBuiltinError(ps, "Select cancelled: "+context.Cause(ctx).Error(), "select\\fn")
+				}
 				fn := funcs[chosen]
 
 				psTemp := env.ProgramState{}
@@ -590,7 +737,13 @@ var Builtins_goroutines = map[string]*env.Builtin{
 				}
 				ps.Ser = ser
 
+				// the task's context is the last case, so a cancelled task doesn't wait forever
+				ctx := ps.GoContext()
+				cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())})
 				chosen, value, recvOK := reflect.Select(cases)
+				if chosen == len(funcs) {
+					return evaldo.MakeBuiltinError(ps, "Select cancelled: "+context.Cause(ctx).Error(), "select")
+				}
 				fn := funcs[chosen]
 
 				psTemp := env.ProgramState{}
@@ -628,4 +781,166 @@ var Builtins_goroutines = map[string]*env.Builtin{
 			return arg0
 		},
 	},
+	// Tests:
+	// equal { task-group { :g g .Go fn { } { 1 + 1 } , g .Go fn { } { 2 + 2 } } } { 2 4 }
+	// equal { task-group { :g for { 1 2 3 } { ::n g .Go-with n fn { x } { x * 10 } } } } { 10 20 30 }
+	// equal { try { task-group { :g g .Go fn { } { fail "not found" } , g .Go fn { } { sleep 10000 } } } |message? } "not found"
+	// Args:
+	// * block: Block that spawns the tasks, the task group is injected into it
+	// Returns:
+	// * a block with the results of the tasks in the order they were spawned, or the first failure of a task
+	"task-group": {
+		Argsn: 1,
+		Doc:   "Evaluates a block that spawns tasks with .Go and waits for all of them, the first task that fails cancels the others and its failure is returned.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			switch block := arg0.(type) {
+			case env.Block:
+				return runTaskGroup(ps, block, 0, "task-group")
+			default:
+				ps.FailureFlag = true
+				return evaldo.MakeArgError(ps, 1, []env.Type{env.BlockType}, "task-group")
+			}
+		},
+	},
+
+	// Tests:
+	// equal { task-group\timeout 1000 { :g g .Go fn { } { 42 } } } { 42 }
+	// equal { try { task-group\timeout 50 { :g g .Go fn { } { sleep 10000 } } } |message? |contains "task group timed out after 50 ms" } true
+	// equal { ch: channel 0 , try { task-group\timeout 50 { :g g .Go fn { } { ch .Read } } } |message? |contains "task group timed out after 50 ms" } true
+	// equal { ch: channel 0 , try { task-group\timeout 50 { :g g .Go fn { } { ch .Send 1 } } } |message? |contains "task group timed out after 50 ms" } true
+	// equal { ch: channel 0 , try { task-group\timeout 50 { :g g .Go fn { } { select { ch { 1 } } } } } |message? |contains "task group timed out after 50 ms" } true
+	// Args:
+	// * timeout: Integer, milliseconds the tasks have to finish in
+	// * block: Block that spawns the tasks, the task group is injected into it
+	// Returns:
+	// * a block with the results of the tasks, the first failure of a task or a failure if the deadline passed
+	"task-group\\timeout": {
+		Argsn: 2,
+		Doc:   "Like task-group, but cancels the tasks and fails if they don't finish in the given milliseconds.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			timeout, ok := arg0.(env.Integer)
+			if !ok {
+				ps.FailureFlag = true
+				return evaldo.MakeArgError(ps, 1, []env.Type{env.IntegerType}, "task-group\\timeout")
+			}
+			if timeout.Value <= 0 {
+				return evaldo.MakeBuiltinError(ps, "timeout must be positive", "task-group\\timeout")
+			}
+			switch block := arg1.(type) {
+			case env.Block:
+				return runTaskGroup(ps, block, time.Duration(timeout.Value)*time.Millisecond, "task-group\\timeout")
+			default:
+				ps.FailureFlag = true
+				return evaldo.MakeArgError(ps, 2, []env.Type{env.BlockType}, "task-group\\timeout")
+			}
+		},
+	},
+
+	// Tests:
+	// equal { task-group { :g g .Go fn { } { 7 } |type? } } { 7 }
+	// Args:
+	// * group: Task group
+	// * function: Function without arguments to run as a task
+	// Returns:
+	// * the task group
+	"Rye-task-group//Go": {
+		Argsn: 2,
+		Doc:   "Runs a function as a task of the group in a separate goroutine.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			return spawnTask(ps, arg0, nil, arg1, 2, "Rye-task-group//Go")
+		},
+	},
+
+	// Tests:
+	// equal { task-group { :g g .Go-with "abc" fn { s } { length? s } } } { 3 }
+	// Args:
+	// * group: Task group
+	// * value: Value passed to the function
+	// * function: Function with one argument to run as a task
+	// Returns:
+	// * the task group
+	"Rye-task-group//Go-with": {
+		Argsn: 3,
+		Doc:   "Runs a function with the value as a task of the group in a separate goroutine.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			return spawnTask(ps, arg0, arg1, arg2, 3, "Rye-task-group//Go-with")
+		},
+	},
+
+	// Tests:
+	// equal { task-group { :g g .Go fn { } { sleep 10000 } , g .Cancel } |first |type? } 'error
+	// Args:
+	// * group: Task group
+	// Returns:
+	// * the task group, the cancelled tasks return errors that are not treated as failures of the group
+	"Rye-task-group//Cancel": {
+		Argsn: 1,
+		Doc:   "Cancels all the tasks of the group.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			switch g := arg0.(type) {
+			case env.Native:
+				if group, ok := g.Value.(*taskGroup); ok {
+					group.cancel(errGroupCancelled)
+					return arg0
+				}
+			}
+			ps.FailureFlag = true
+			return evaldo.MakeArgError(ps, 1, []env.Type{env.NativeType}, "Rye-task-group//Cancel")
+		},
+	},
+
+	// Tests:
+	// equal { with-timeout 1000 { 1 + 2 } } 3
+	// equal { try { with-timeout 50 { sleep 10000 } } |message? } "`with-timeout`: timed out after 50 ms"
+	// Args:
+	// * timeout: Integer, milliseconds the block has to finish in
+	// * block: Block to evaluate
+	// Returns:
+	// * the result of the block or a failure if the deadline passed, sleep, http calls and commands in it are cancelled
+	"with-timeout": {
+		Argsn: 2,
+		Doc:   "Evaluates a block and cancels it if it doesn't finish in the given milliseconds.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			timeout, ok := arg0.(env.Integer)
+			if !ok {
+				ps.FailureFlag = true
+				return evaldo.MakeArgError(ps, 1, []env.Type{env.IntegerType}, "with-timeout")
+			}
+			block, ok := arg1.(env.Block)
+			if !ok {
+				ps.FailureFlag = true
+				return evaldo.MakeArgError(ps, 2, []env.Type{env.BlockType}, "with-timeout")
+			}
+			ctx, cancel := context.WithTimeoutCause(ps.GoContext(), time.Duration(timeout.Value)*time.Millisecond, fmt.Errorf("timed out after %d ms", timeout.Value))
+			defer cancel()
+			ser, taskCtx := ps.Ser, ps.TaskCtx
+			ps.Ser = block.Series
+			ps.TaskCtx = ctx
+			evaldo.EvalBlockInj(ps, nil, false)
+			ps.Ser, ps.TaskCtx = ser, taskCtx
+			if (ps.ErrorFlag || ps.FailureFlag) && ctx.Err() != nil && (taskCtx == nil || taskCtx.Err() == nil) {
+				ps.ErrorFlag = false
+				return evaldo.MakeBuiltinError(ps, context.Cause(ctx).Error(), "with-timeout")
+			}
+			return ps.Res
+		},
+	},
+}
+
+func spawnTask(ps *env.ProgramState, arg0 env.Object, value env.Object, arg env.Object, fnPos int, fnName string) env.Object {
+	g, ok := arg0.(env.Native)
+	group, ok2 := g.Value.(*taskGroup)
+	if !ok || !ok2 {
+		ps.FailureFlag = true
+		return evaldo.MakeArgError(ps, 1, []env.Type{env.NativeType}, fnName)
+	}
+	fn, ok := arg.(env.Function)
+	if !ok {
+		ps.FailureFlag = true
+		return evaldo.MakeArgError(ps, fnPos, []env.Type{env.FunctionType}, fnName)
+	}
+	if err := group.spawn(ps, fn, value); err != nil {
+		return evaldo.MakeBuiltinError(ps, fmt.Sprintf("failed to copy program state: %s", err), fnName)
+	}
+	return arg0
 }
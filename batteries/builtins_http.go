@@ -6,11 +6,16 @@ package batteries
 // import "C"
 
 import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/refaktor/rye/env"
@@ -82,7 +87,7 @@ var Builtins_http = map[string]*env.Builtin{
 			case env.Native:
 				// ListenAndServe blocks until the server stops or encounters an error
 				err := server.Value.(*http.Server).ListenAndServe()
-				if err != nil {
+				if err != nil && err != http.ErrServerClosed {
 					return evaldo.MakeError(ps, err.Error())
 				}
 				return arg0
@@ -93,6 +98,170 @@ var Builtins_http = map[string]*env.Builtin{
 		},
 	},
 
+	// Example:
+	// srv: http-server ":8443"
+	// srv .Serve-tls "cert.pem" "key.pem"
+	// ; or with a generated certificate for development
+	// pair: crypto/generate-self-signed-certificate 2048 dict { "CommonName" "localhost" }
+	// srv .Serve-tls first pair second pair
+	// Args:
+	// * server: Native Go-server object created by http-server
+	// * cert: String or file Uri of a PEM certificate, or a native x509-certificate
+	// * key: String or file Uri of a PEM private key, or a native private key
+	// Returns:
+	// * the server object after it stops serving, or error if unable to serve
+	"Go-server//Serve-tls": {
+		Argsn: 3,
+		Doc:   "Starts the HTTPS server with the given certificate and private key (blocking call).",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			switch server := arg0.(type) {
+			case env.Native:
+				srv, ok := server.Value.(*http.Server)
+				if !ok {
+					return evaldo.MakeBuiltinError(ps, "Expected Go-server.", "Go-server//Serve-tls")
+				}
+				var certFile, keyFile string
+				switch cert := arg1.(type) {
+				case env.String:
+					certFile = cert.Value
+				case env.Uri:
+					certFile = cert.GetPath()
+				case env.Native:
+					x509cert, ok := cert.Value.(*x509.Certificate)
+					if !ok {
+						return evaldo.MakeNativeArgError(ps, 2, []string{"x509-certificate"}, "Go-server//Serve-tls")
+					}
+					key, ok := arg2.(env.Native)
+					if !ok {
+						ps.FailureFlag = true
+						return evaldo.MakeArgError(ps, 3, []env.Type{env.NativeType}, "Go-server//Serve-tls")
+					}
+					// TLS signs the handshake with the key, anything else would panic there
+					signer, ok := key.Value.(crypto.Signer)
+					if !ok {
+						return evaldo.MakeNativeArgError(ps, 3, []string{"rsa-private-key", "Ed25519-priv-key"}, "Go-server//Serve-tls")
+					}
+					if srv.TLSConfig == nil {
+						srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+					}
+					srv.TLSConfig.Certificates = []tls.Certificate{{Certificate: [][]byte{x509cert.Raw}, PrivateKey: signer}}
+				default:
+					return evaldo.MakeArgError(ps, 2, []env.Type{env.StringType, env.UriType, env.NativeType}, "Go-server//Serve-tls")
+				}
+				if certFile != "" {
+					switch key := arg2.(type) {
+					case env.String:
+						keyFile = key.Value
+					case env.Uri:
+						keyFile = key.GetPath()
+					default:
+						return evaldo.MakeArgError(ps, 3, []env.Type{env.StringType, env.UriType}, "Go-server//Serve-tls")
+					}
+				}
+				// ListenAndServeTLS blocks until the server stops or encounters an error
+				err := srv.ListenAndServeTLS(certFile, keyFile)
+				if err != nil && err != http.ErrServerClosed {
+					return evaldo.MakeError(ps, err.Error())
+				}
+				return arg0
+			default:
+				ps.FailureFlag = true
+				return evaldo.MakeArgError(ps, 1, []env.Type{env.NativeType}, "Go-server//Serve-tls")
+			}
+		},
+	},
+
+	// Example:
+	// srv: http-server ":8080"
+	// srv .Set-timeouts { read: 5000 write: 10000 idle: 60000 }
+	// Args:
+	// * server: Native Go-server object created by http-server
+	// * timeouts: Dict or block with read, read-header, write and idle timeouts in milliseconds
+	// Returns:
+	// * the server object to allow method chaining
+	"Go-server//Set-timeouts": {
+		Argsn: 2,
+		Doc:   "Sets read, read-header, write and idle timeouts (in milliseconds) of the HTTP server.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			switch server := arg0.(type) {
+			case env.Native:
+				srv, ok := server.Value.(*http.Server)
+				if !ok {
+					return evaldo.MakeBuiltinError(ps, "Expected Go-server.", "Go-server//Set-timeouts")
+				}
+				var opts env.Dict
+				switch o := arg1.(type) {
+				case env.Dict:
+					opts = o
+				case env.Block:
+					opts = env.NewDictFromSeries(o.Series, ps.Idx)
+				default:
+					return evaldo.MakeArgError(ps, 2, []env.Type{env.DictType, env.BlockType}, "Go-server//Set-timeouts")
+				}
+				for name, val := range opts.Data {
+					ms, ok := val.(env.Integer)
+					if !ok {
+						return evaldo.MakeBuiltinError(ps, "Timeout "+name+" must be an integer (milliseconds).", "Go-server//Set-timeouts")
+					}
+					d := time.Duration(ms.Value) * time.Millisecond
+					switch name {
+					case "read":
+						srv.ReadTimeout = d
+					case "read-header":
+						srv.ReadHeaderTimeout = d
+					case "write":
+						srv.WriteTimeout = d
+					case "idle":
+						srv.IdleTimeout = d
+					default:
+						return evaldo.MakeBuiltinError(ps, "Unknown timeout "+name+", use read, read-header, write or idle.", "Go-server//Set-timeouts")
+					}
+				}
+				return arg0
+			default:
+				ps.FailureFlag = true
+				return evaldo.MakeArgError(ps, 1, []env.Type{env.NativeType}, "Go-server//Set-timeouts")
+			}
+		},
+	},
+
+	// Example:
+	// srv: http-server ":8080"
+	// go does { sleep 60000 srv .Shutdown 5000 }
+	// srv .Serve
+	// Args:
+	// * server: Native Go-server object that is serving
+	// * timeout: Integer milliseconds to wait for in-flight requests to finish
+	// Returns:
+	// * the server object, or error if connections didn't drain in time
+	"Go-server//Shutdown": {
+		Argsn: 2,
+		Doc:   "Gracefully stops the HTTP server, no new connections are accepted and in-flight requests are given the timeout (in milliseconds) to finish.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			switch server := arg0.(type) {
+			case env.Native:
+				srv, ok := server.Value.(*http.Server)
+				if !ok {
+					return evaldo.MakeBuiltinError(ps, "Expected Go-server.", "Go-server//Shutdown")
+				}
+				switch ms := arg1.(type) {
+				case env.Integer:
+					ctx, cancel := context.WithTimeout(context.Background(), time.Duration(ms.Value)*time.Millisecond)
+					defer cancel()
+					if err := srv.Shutdown(ctx); err != nil {
+						return evaldo.MakeBuiltinError(ps, err.Error(), "Go-server//Shutdown")
+					}
+					return arg0
+				default:
+					return evaldo.MakeArgError(ps, 2, []env.Type{env.IntegerType}, "Go-server//Shutdown")
+				}
+			default:
+				ps.FailureFlag = true
+				return evaldo.MakeArgError(ps, 1, []env.Type{env.NativeType}, "Go-server//Shutdown")
+			}
+		},
+	},
+
 	/* "Go-server//serve\\port": {
 		Argsn: 1,
 		Doc:   "Listen and serve with port.",
@@ -489,6 +658,306 @@ var Builtins_http = map[string]*env.Builtin{
 		},
 	},*/
 
+	//
+	// ##### HTTP Router ##### "Routing with methods, path parameters, groups and middleware."
+	//
+	// Example:
+	// router: http-router
+	// router .Use middleware\recover
+	// router .Handle "GET /users/{id}" fn { w req } { id: req .Path-value? "id" , w .Write ( "User " ++ id ) }
+	// api: router .Group "/api"
+	// api .Use middleware\basic-auth "admin" "secret"
+	// api .Handle "POST /items" fn { w req } { w .Write-header 201 }
+	// http-server ":8080" |Set-handler router |Serve
+	// Tests:
+	// equal { http-router |type? } 'native
+	// equal { http-router |kind? } 'Go-router
+	// Returns:
+	// * native Go-router object
+	"http-router": {
+		Argsn: 0,
+		Doc:   "Creates a new HTTP router that matches request methods and paths with {name} parameters.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			return *env.NewNative(ps.Idx, newHttpRouter(), "Go-router")
+		},
+	},
+
+	// Example:
+	// router .Handle "GET /users/{id}" fn { w req } { id: req .Path-value? "id" , w .Write id }
+	// router .Handle "/static/" new-static-handler %public
+	// Args:
+	// * router: Native Go-router object
+	// * pattern: String with optional method and path, like "GET /users/{id}" or "/files/{path...}"
+	// * handler: String (simple response), Function or curried caller accepting { w req }, or native Http-handler
+	// Returns:
+	// * the router object to allow method chaining
+	"Go-router//Handle": {
+		Argsn: 3,
+		Doc:   "Registers a handler for a route pattern, wrapped in the middleware added to the router so far.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			switch router := arg0.(type) {
+			case env.Native:
+				rt, ok := router.Value.(*httpRouter)
+				if !ok {
+					return evaldo.MakeNativeArgError(ps, 1, []string{"Go-router"}, "Go-router//Handle")
+				}
+				switch pattern := arg1.(type) {
+				case env.String:
+					var h http.Handler
+					switch handler := arg2.(type) {
+					case env.String:
+						h = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+							io.WriteString(w, handler.Value)
+						})
+					case env.Function, env.CurriedCaller:
+						h = httpRyeHandler(ps, handler)
+					case env.Native:
+						nh, ok := handler.Value.(http.Handler)
+						if !ok {
+							return evaldo.MakeNativeArgError(ps, 3, []string{"Http-handler"}, "Go-router//Handle")
+						}
+						h = nh
+					default:
+						return evaldo.MakeArgError(ps, 3, []env.Type{env.StringType, env.FunctionType, env.CurriedCallerType, env.NativeType}, "Go-router//Handle")
+					}
+					// ServeMux panics on invalid or conflicting patterns
+					var perr any
+					func() {
+						defer func() { perr = recover() }()
+						rt.handle(pattern.Value, h)
+					}()
+					if perr != nil {
+						return evaldo.MakeBuiltinError(ps, fmt.Sprint(perr), "Go-router//Handle")
+					}
+					return arg0
+				default:
+					return evaldo.MakeArgError(ps, 2, []env.Type{env.StringType}, "Go-router//Handle")
+				}
+			default:
+				return evaldo.MakeArgError(ps, 1, []env.Type{env.NativeType}, "Go-router//Handle")
+			}
+		},
+	},
+
+	// Example:
+	// api: router .Group "/api/v1"
+	// api .Handle "GET /status" "ok"
+	// Args:
+	// * router: Native Go-router object
+	// * prefix: String path prefix for all routes of the group
+	// Returns:
+	// * new Go-router that registers into the same router under the prefix, with a copy of its middleware
+	"Go-router//Group": {
+		Argsn: 2,
+		Doc:   "Creates a route group with a path prefix. Middleware added to the group doesn't affect the parent router.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			switch router := arg0.(type) {
+			case env.Native:
+				rt, ok := router.Value.(*httpRouter)
+				if !ok {
+					return evaldo.MakeNativeArgError(ps, 1, []string{"Go-router"}, "Go-router//Group")
+				}
+				switch prefix := arg1.(type) {
+				case env.String:
+					return *env.NewNative(ps.Idx, rt.group(prefix.Value), "Go-router")
+				default:
+					return evaldo.MakeArgError(ps, 2, []env.Type{env.StringType}, "Go-router//Group")
+				}
+			default:
+				return evaldo.MakeArgError(ps, 1, []env.Type{env.NativeType}, "Go-router//Group")
+			}
+		},
+	},
+
+	// Example:
+	// router .Use middleware\logging
+	// router .Use fn { w req next } { w .Set-header 'X-Served-by "rye" , next .Serve w req }
+	// Args:
+	// * router: Native Go-router object
+	// * middleware: native Http-middleware or a Function accepting { w req next }
+	// Returns:
+	// * the router object to allow method chaining
+	"Go-router//Use": {
+		Argsn: 2,
+		Doc:   "Adds a middleware to the router. It wraps the handlers registered after it, the first middleware added is the outermost.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			switch router := arg0.(type) {
+			case env.Native:
+				rt, ok := router.Value.(*httpRouter)
+				if !ok {
+					return evaldo.MakeNativeArgError(ps, 1, []string{"Go-router"}, "Go-router//Use")
+				}
+				switch mw := arg1.(type) {
+				case env.Native:
+					m, ok := mw.Value.(func(http.Handler) http.Handler)
+					if !ok {
+						return evaldo.MakeNativeArgError(ps, 2, []string{"Http-middleware"}, "Go-router//Use")
+					}
+					rt.middleware = append(rt.middleware, m)
+					return arg0
+				case env.Function:
+					if mw.Argsn != 3 {
+						return evaldo.MakeBuiltinError(ps, "Middleware function must accept 3 arguments { w req next }.", "Go-router//Use")
+					}
+					rt.middleware = append(rt.middleware, httpRyeMiddleware(ps, mw))
+					return arg0
+				default:
+					return evaldo.MakeArgError(ps, 2, []env.Type{env.NativeType, env.FunctionType}, "Go-router//Use")
+				}
+			default:
+				return evaldo.MakeArgError(ps, 1, []env.Type{env.NativeType}, "Go-router//Use")
+			}
+		},
+	},
+
+	// Example:
+	// srv: http-server ":8080"
+	// srv .Set-handler router
+	// Args:
+	// * server: Native Go-server object
+	// * handler: native Go-router or Http-handler
+	// Returns:
+	// * the server object to allow method chaining
+	"Go-server//Set-handler": {
+		Argsn: 2,
+		Doc:   "Sets the router or handler that serves all requests of the server, instead of the global handlers registered with Handle.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			switch server := arg0.(type) {
+			case env.Native:
+				srv, ok := server.Value.(*http.Server)
+				if !ok {
+					return evaldo.MakeNativeArgError(ps, 1, []string{"Go-server"}, "Go-server//Set-handler")
+				}
+				switch handler := arg1.(type) {
+				case env.Native:
+					switch h := handler.Value.(type) {
+					case *httpRouter:
+						srv.Handler = h.mux
+					case http.Handler:
+						srv.Handler = h
+					default:
+						return evaldo.MakeNativeArgError(ps, 2, []string{"Go-router", "Http-handler"}, "Go-server//Set-handler")
+					}
+					return arg0
+				default:
+					return evaldo.MakeArgError(ps, 2, []env.Type{env.NativeType}, "Go-server//Set-handler")
+				}
+			default:
+				return evaldo.MakeArgError(ps, 1, []env.Type{env.NativeType}, "Go-server//Set-handler")
+			}
+		},
+	},
+
+	// Example:
+	// router .Use fn { w req next } { print req .Method? , next .Serve w req }
+	// Args:
+	// * handler: native Http-handler, like the next handler passed to a middleware function
+	// * writer: Native Go-server-response-writer
+	// * request: Native Go-server-request
+	// Returns:
+	// * the handler object
+	"Http-handler//Serve": {
+		Argsn: 3,
+		Doc:   "Calls the HTTP handler with a response writer and request, used to continue the chain in middleware functions.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			switch handler := arg0.(type) {
+			case env.Native:
+				h, ok := handler.Value.(http.Handler)
+				if !ok {
+					return evaldo.MakeNativeArgError(ps, 1, []string{"Http-handler"}, "Http-handler//Serve")
+				}
+				w, ok := arg1.(env.Native)
+				if !ok {
+					return evaldo.MakeArgError(ps, 2, []env.Type{env.NativeType}, "Http-handler//Serve")
+				}
+				r, ok := arg2.(env.Native)
+				if !ok {
+					return evaldo.MakeArgError(ps, 3, []env.Type{env.NativeType}, "Http-handler//Serve")
+				}
+				writer, ok := w.Value.(http.ResponseWriter)
+				if !ok {
+					return evaldo.MakeNativeArgError(ps, 2, []string{"Go-server-response-writer"}, "Http-handler//Serve")
+				}
+				req, ok := r.Value.(*http.Request)
+				if !ok {
+					return evaldo.MakeNativeArgError(ps, 3, []string{"Go-server-request"}, "Http-handler//Serve")
+				}
+				h.ServeHTTP(writer, req)
+				return arg0
+			default:
+				return evaldo.MakeArgError(ps, 1, []env.Type{env.NativeType}, "Http-handler//Serve")
+			}
+		},
+	},
+
+	// Tests:
+	// equal { middleware\logging |kind? } 'Http-middleware
+	// Returns:
+	// * native Http-middleware that prints method, path, status and duration of each request
+	"middleware\\logging": {
+		Argsn: 0,
+		Doc:   "Creates a middleware that logs each request.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			return *env.NewNative(ps.Idx, httpLoggingMiddleware, "Http-middleware")
+		},
+	},
+
+	// Tests:
+	// equal { middleware\recover |kind? } 'Http-middleware
+	// Returns:
+	// * native Http-middleware that turns failures of Rye handlers and panics into 500 responses
+	"middleware\\recover": {
+		Argsn: 0,
+		Doc:   "Creates a middleware that responds with 500 Internal Server Error when a handler fails.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			return *env.NewNative(ps.Idx, httpRecoverMiddleware, "Http-middleware")
+		},
+	},
+
+	// Tests:
+	// equal { middleware\cors "*" |kind? } 'Http-middleware
+	// Args:
+	// * origin: String allowed origin, or "*" for any
+	// Returns:
+	// * native Http-middleware that sets CORS headers and answers preflight requests
+	"middleware\\cors": {
+		Argsn: 1,
+		Doc:   "Creates a middleware that allows cross-origin requests from the given origin.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			switch origin := arg0.(type) {
+			case env.String:
+				return *env.NewNative(ps.Idx, httpCorsMiddleware(origin.Value), "Http-middleware")
+			default:
+				return evaldo.MakeArgError(ps, 1, []env.Type{env.StringType}, "middleware\\cors")
+			}
+		},
+	},
+
+	// Tests:
+	// equal { middleware\basic-auth "admin" "secret" |kind? } 'Http-middleware
+	// Args:
+	// * username: String required username
+	// * password: String required password
+	// Returns:
+	// * native Http-middleware that responds with 401 Unauthorized unless the credentials match
+	"middleware\\basic-auth": {
+		Argsn: 2,
+		Doc:   "Creates a middleware that requires HTTP basic authentication.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			switch username := arg0.(type) {
+			case env.String:
+				switch password := arg1.(type) {
+				case env.String:
+					return *env.NewNative(ps.Idx, httpBasicAuthMiddleware(username.Value, password.Value), "Http-middleware")
+				default:
+					return evaldo.MakeArgError(ps, 2, []env.Type{env.StringType}, "middleware\\basic-auth")
+				}
+			default:
+				return evaldo.MakeArgError(ps, 1, []env.Type{env.StringType}, "middleware\\basic-auth")
+			}
+		},
+	},
+
 	//
 	// ##### HTTP Request Functions ##### "Extracting data from HTTP requests."
 	//
@@ -612,6 +1081,39 @@ var Builtins_http = map[string]*env.Builtin{
 		},
 	},
 
+	// Example:
+	// ; Inside a handler registered with router .Handle "GET /users/{id}" ...
+	// ; equal { req .Path-value? "id" } "42"
+	// Args:
+	// * request: Native Go-server-request object from HTTP handler
+	// * name: String name of the path parameter
+	// Returns:
+	// * string value of the path parameter, or failure if the route has no such parameter
+	"Go-server-request//Path-value?": {
+		Argsn: 2,
+		Doc:   "Gets the value of a {name} path parameter of the route that matched the request.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			switch req := arg0.(type) {
+			case env.Native:
+				switch name := arg1.(type) {
+				case env.String:
+					r := req.Value.(*http.Request)
+					val := r.PathValue(name.Value)
+					// the pattern has the parameter as {name} or {name...}, {names} is another parameter
+					inRoute := strings.Contains(r.Pattern, "{"+name.Value+"}") || strings.Contains(r.Pattern, "{"+name.Value+"...}")
+					if val == "" && r.Pattern != "" && !inRoute {
+						return evaldo.MakeBuiltinError(ps, "Path parameter "+name.Value+" is not in the route.", "Go-server-request//Path-value?")
+					}
+					return *env.NewString(val)
+				default:
+					return evaldo.MakeArgError(ps, 2, []env.Type{env.StringType}, "Go-server-request//Path-value?")
+				}
+			default:
+				return evaldo.MakeArgError(ps, 1, []env.Type{env.NativeType}, "Go-server-request//Path-value?")
+			}
+		},
+	},
+
 	// Example:
 	// ; Inside a handler: body: req .Read-body
 	// ; Inside a handler: print body
@@ -1234,4 +1736,501 @@ var Builtins_http = map[string]*env.Builtin{
 			}
 		},
 	},
+	//
+	// ##### HTTP client ##### "Requests with a client of their own: timeouts, pooling, cookies, proxies, redirects and retries"
+	//
+	// Example:
+	//  client: http-client |Timeout 5000 |Retry 3 200 |Cookie-jar
+	//  client .Header "User-Agent" "rye"
+	//  client .Get https://example.com/api/items |parse-json
+	//  req: http-request 'POST https://example.com/api/items
+	//  req .Json! dict { "name" "Jim" }
+	//  client .Call req |Status?
+	//  client .Open https://example.com/big.csv |Copy stdout
+	//
+	// Tests:
+	// equal { http-client |type? } 'native
+	// equal { http-client |kind? } 'http-client
+	// Args:
+	// Returns:
+	// * native http-client with a 30 second timeout that follows up to 10 redirects and doesn't retry
+	"http-client": {
+		Argsn: 0,
+		Doc:   "Creates an HTTP client with a connection pool of its own. It's configured with its methods before it's used.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			return *env.NewNative(ps.Idx, newHTTPClient(), "http-client")
+		},
+	},
+
+	// Tests:
+	// equal { http-client |Timeout 1000 |kind? } 'http-client
+	// error { http-client |Timeout "1s" }
+	// Args:
+	// * client: native http-client
+	// * timeout: Integer, milliseconds for the whole request including reading the body, 0 for none
+	// Returns:
+	// * the client
+	"http-client//Timeout": {
+		Argsn: 2,
+		Doc:   "Sets the time limit of the client's requests, it includes reading the body of the response.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			c, errObj := httpClientArg(ps, arg0, "http-client//Timeout")
+			if errObj != nil {
+				return errObj
+			}
+			ms, ok := arg1.(env.Integer)
+			if !ok {
+				ps.FailureFlag = true
+				return evaldo.MakeArgError(ps, 2, []env.Type{env.IntegerType}, "http-client//Timeout")
+			}
+			c.client.Timeout = time.Duration(ms.Value) * time.Millisecond
+			return arg0
+		},
+	},
+
+	// Tests:
+	// equal { http-client |Max-idle-conns 20 |kind? } 'http-client
+	// Args:
+	// * client: native http-client
+	// * count: Integer, idle connections kept open per host
+	// Returns:
+	// * the client
+	"http-client//Max-idle-conns": {
+		Argsn: 2,
+		Doc:   "Sets how many idle connections to each host the client keeps open for the next requests, the default is 2.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			c, errObj := httpClientArg(ps, arg0, "http-client//Max-idle-conns")
+			if errObj != nil {
+				return errObj
+			}
+			n, ok := arg1.(env.Integer)
+			if !ok {
+				ps.FailureFlag = true
+				return evaldo.MakeArgError(ps, 2, []env.Type{env.IntegerType}, "http-client//Max-idle-conns")
+			}
+			c.transport.MaxIdleConnsPerHost = int(n.Value)
+			c.transport.MaxIdleConns = max(c.transport.MaxIdleConns, int(n.Value))
+			return arg0
+		},
+	},
+
+	// Tests:
+	// equal { http-client |Max-conns 8 |kind? } 'http-client
+	// Args:
+	// * client: native http-client
+	// * count: Integer, connections per host, 0 for no limit
+	// Returns:
+	// * the client
+	"http-client//Max-conns": {
+		Argsn: 2,
+		Doc:   "Limits the connections to each host, requests over the limit wait for a connection.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			c, errObj := httpClientArg(ps, arg0, "http-client//Max-conns")
+			if errObj != nil {
+				return errObj
+			}
+			n, ok := arg1.(env.Integer)
+			if !ok {
+				ps.FailureFlag = true
+				return evaldo.MakeArgError(ps, 2, []env.Type{env.IntegerType}, "http-client//Max-conns")
+			}
+			c.transport.MaxConnsPerHost = int(n.Value)
+			return arg0
+		},
+	},
+
+	// Tests:
+	// equal { http-client |Idle-timeout 30000 |kind? } 'http-client
+	// Args:
+	// * client: native http-client
+	// * timeout: Integer, milliseconds an idle connection is kept open
+	// Returns:
+	// * the client
+	"http-client//Idle-timeout": {
+		Argsn: 2,
+		Doc:   "Sets how long idle connections are kept open, the default is 90 seconds.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			c, errObj := httpClientArg(ps, arg0, "http-client//Idle-timeout")
+			if errObj != nil {
+				return errObj
+			}
+			ms, ok := arg1.(env.Integer)
+			if !ok {
+				ps.FailureFlag = true
+				return evaldo.MakeArgError(ps, 2, []env.Type{env.IntegerType}, "http-client//Idle-timeout")
+			}
+			c.transport.IdleConnTimeout = time.Duration(ms.Value) * time.Millisecond
+			return arg0
+		},
+	},
+
+	// Tests:
+	// equal { http-client |Cookie-jar |kind? } 'http-client
+	// Args:
+	// * client: native http-client
+	// Returns:
+	// * the client
+	"http-client//Cookie-jar": {
+		Argsn: 1,
+		Doc:   "Gives the client a cookie jar, the cookies servers set are sent back to them on the next requests.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			c, errObj := httpClientArg(ps, arg0, "http-client//Cookie-jar")
+			if errObj != nil {
+				return errObj
+			}
+			c.client.Jar = newHTTPCookieJar()
+			return arg0
+		},
+	},
+
+	// Tests:
+	// equal { http-client |Cookie-jar |Cookies? https://example.com |length? } 0
+	// error { http-client |Cookies? https://example.com }
+	// Args:
+	// * client: native http-client with a cookie jar
+	// * url: URI or String
+	// Returns:
+	// * Dict of the names and values of the cookies the client would send to the URL
+	"http-client//Cookies?": {
+		Argsn: 2,
+		Doc:   "Returns the cookies in the client's jar that are sent to the URL.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			c, errObj := httpClientArg(ps, arg0, "http-client//Cookies?")
+			if errObj != nil {
+				return errObj
+			}
+			rawURL, errObj := httpURL(ps, arg1, 2, "http-client//Cookies?")
+			if errObj != nil {
+				return errObj
+			}
+			if c.client.Jar == nil {
+				return evaldo.MakeBuiltinError(ps, "The client has no cookie jar, use Cookie-jar", "http-client//Cookies?")
+			}
+			u, err := url.Parse(rawURL)
+			if err != nil {
+				return evaldo.MakeBuiltinError(ps, err.Error(), "http-client//Cookies?")
+			}
+			cookies := make(map[string]any)
+			for _, cookie := range c.client.Jar.Cookies(u) {
+				cookies[cookie.Name] = *env.NewString(cookie.Value)
+			}
+			return *env.NewDict(cookies)
+		},
+	},
+
+	// Tests:
+	// equal { http-client |Proxy http://localhost:3128 |kind? } 'http-client
+	// error { http-client |Proxy 3128 }
+	// Args:
+	// * client: native http-client
+	// * proxy: URI or String of the proxy, like http://proxy:3128 or socks5://proxy:1080
+	// Returns:
+	// * the client
+	"http-client//Proxy": {
+		Argsn: 2,
+		Doc:   "Sends the client's requests through a proxy, without one the HTTP_PROXY, HTTPS_PROXY and NO_PROXY variables are used.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			c, errObj := httpClientArg(ps, arg0, "http-client//Proxy")
+			if errObj != nil {
+				return errObj
+			}
+			rawURL, errObj := httpURL(ps, arg1, 2, "http-client//Proxy")
+			if errObj != nil {
+				return errObj
+			}
+			u, err := url.Parse(rawURL)
+			if err != nil {
+				return evaldo.MakeBuiltinError(ps, err.Error(), "http-client//Proxy")
+			}
+			c.transport.Proxy = http.ProxyURL(u)
+			return arg0
+		},
+	},
+
+	// Tests:
+	// equal { http-client |Redirects 0 |kind? } 'http-client
+	// Args:
+	// * client: native http-client
+	// * count: Integer, redirects to follow, with 0 the redirect response is returned
+	// Returns:
+	// * the client
+	"http-client//Redirects": {
+		Argsn: 2,
+		Doc:   "Sets how many redirects the client follows before the request fails, the default is 10.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			c, errObj := httpClientArg(ps, arg0, "http-client//Redirects")
+			if errObj != nil {
+				return errObj
+			}
+			n, ok := arg1.(env.Integer)
+			if !ok || n.Value < 0 {
+				ps.FailureFlag = true
+				return evaldo.MakeArgError(ps, 2, []env.Type{env.IntegerType}, "http-client//Redirects")
+			}
+			c.maxRedirects = int(n.Value)
+			return arg0
+		},
+	},
+
+	// Tests:
+	// equal { http-client |Retry 3 100 |kind? } 'http-client
+	// Args:
+	// * client: native http-client
+	// * retries: Integer, how many times a request is sent again
+	// * backoff: Integer, milliseconds before the first retry, the wait doubles after each
+	// Returns:
+	// * the client
+	"http-client//Retry": {
+		Argsn: 3,
+		Doc:   "Retries requests when the connection fails or the server answers 429, 502, 503 or 504, waiting as long as Retry-After says if it's set. Only methods that can be repeated safely are retried, POST and PATCH aren't.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			c, errObj := httpClientArg(ps, arg0, "http-client//Retry")
+			if errObj != nil {
+				return errObj
+			}
+			retries, ok := arg1.(env.Integer)
+			if !ok || retries.Value < 0 {
+				ps.FailureFlag = true
+				return evaldo.MakeArgError(ps, 2, []env.Type{env.IntegerType}, "http-client//Retry")
+			}
+			backoff, ok := arg2.(env.Integer)
+			if !ok {
+				ps.FailureFlag = true
+				return evaldo.MakeArgError(ps, 3, []env.Type{env.IntegerType}, "http-client//Retry")
+			}
+			c.retries = int(retries.Value)
+			c.backoff = time.Duration(backoff.Value) * time.Millisecond
+			return arg0
+		},
+	},
+
+	// Tests:
+	// equal { http-client |Header "User-Agent" "rye" |kind? } 'http-client
+	// Args:
+	// * client: native http-client
+	// * name: String, the header name
+	// * value: String, the header value
+	// Returns:
+	// * the client
+	"http-client//Header": {
+		Argsn: 3,
+		Doc:   "Sets a header that's sent with all requests of the client, unless the request sets it itself.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			c, errObj := httpClientArg(ps, arg0, "http-client//Header")
+			if errObj != nil {
+				return errObj
+			}
+			name, ok := arg1.(env.String)
+			if !ok {
+				ps.FailureFlag = true
+				return evaldo.MakeArgError(ps, 2, []env.Type{env.StringType}, "http-client//Header")
+			}
+			value, ok := arg2.(env.String)
+			if !ok {
+				ps.FailureFlag = true
+				return evaldo.MakeArgError(ps, 3, []env.Type{env.StringType}, "http-client//Header")
+			}
+			c.headers.Set(name.Value, value.Value)
+			return arg0
+		},
+	},
+
+	// Tests:
+	// ; equal { http-client |Get http://localhost:8080/hello } "Hello"
+	// Args:
+	// * client: native http-client
+	// * url: URI or String
+	// Returns:
+	// * String with the body of the response, failure with the status as code unless it's 2xx
+	"http-client//Get": {
+		Argsn: 2,
+		Doc:   "Makes a GET request with the client and returns the body of the response as a string.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			c, errObj := httpClientArg(ps, arg0, "http-client//Get")
+			if errObj != nil {
+				return errObj
+			}
+			rawURL, errObj := httpURL(ps, arg1, 2, "http-client//Get")
+			if errObj != nil {
+				return errObj
+			}
+			resp, err := c.fetch(ps.GoContext(), rawURL)
+			if err != nil {
+				return httpClientFailure(ps, err, "http-client//Get")
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				return evaldo.MakeBuiltinError(ps, err.Error(), "http-client//Get")
+			}
+			return *env.NewString(string(body))
+		},
+	},
+
+	// Tests:
+	// ; equal { http-client |Open http://localhost:8080/hello |Read\string } "Hello"
+	// Args:
+	// * client: native http-client
+	// * url: URI or String
+	// Returns:
+	// * reader of the body of the response as it arrives, failure with the status as code unless it's 2xx
+	"http-client//Open": {
+		Argsn: 2,
+		Doc:   "Makes a GET request with the client and returns a reader that streams the body of the response, it should be closed when it's not read to the end.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			c, errObj := httpClientArg(ps, arg0, "http-client//Open")
+			if errObj != nil {
+				return errObj
+			}
+			rawURL, errObj := httpURL(ps, arg1, 2, "http-client//Open")
+			if errObj != nil {
+				return errObj
+			}
+			resp, err := c.fetch(ps.GoContext(), rawURL)
+			if err != nil {
+				return httpClientFailure(ps, err, "http-client//Open")
+			}
+			return *env.NewNative(ps.Idx, resp.Body, "reader")
+		},
+	},
+
+	// Tests:
+	// ; equal { http-client |Call http-request 'GET http://localhost:8080/hello |Status? } 200
+	// Args:
+	// * client: native http-client
+	// * request: native https-request
+	// Returns:
+	// * native https-response, whatever its status, its body should be read or closed
+	"http-client//Call": {
+		Argsn: 2,
+		Doc:   "Sends a request with the client and returns the response, use Status?, Read-body and Reader on it.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			c, errObj := httpClientArg(ps, arg0, "http-client//Call")
+			if errObj != nil {
+				return errObj
+			}
+			req, errObj := httpRequestArg(ps, arg1, 2, "http-client//Call")
+			if errObj != nil {
+				return errObj
+			}
+			resp, err := c.do(ps.GoContext(), req)
+			if err != nil {
+				return evaldo.MakeBuiltinError(ps, err.Error(), "http-client//Call")
+			}
+			return *env.NewNative(ps.Idx, resp, "https-response")
+		},
+	},
+
+	// Tests:
+	// equal { http-request 'PATCH https://example.com/items/1 |kind? } 'https-request
+	// error { http-request 'GET 123 }
+	// Args:
+	// * method: Word or String, the HTTP method
+	// * url: URI or String
+	// Returns:
+	// * native https-request without a body
+	"http-request": {
+		Argsn: 2,
+		Doc:   "Creates a request to send with an http-client, the body is set with Json!, Form! or Multipart!.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			var method string
+			switch m := arg0.(type) {
+			case env.Word:
+				method = ps.Idx.GetWord(m.Index)
+			case env.String:
+				method = m.Value
+			default:
+				ps.FailureFlag = true
+				return evaldo.MakeArgError(ps, 1, []env.Type{env.WordType, env.StringType}, "http-request")
+			}
+			rawURL, errObj := httpURL(ps, arg1, 2, "http-request")
+			if errObj != nil {
+				return errObj
+			}
+			req, err := http.NewRequest(strings.ToUpper(method), rawURL, nil)
+			if err != nil {
+				return evaldo.MakeBuiltinError(ps, err.Error(), "http-request")
+			}
+			return *env.NewNative(ps.Idx, req, "https-request")
+		},
+	},
+
+	// Tests:
+	// equal { http-request 'POST https://example.com |Json! dict { "a" 1 } |kind? } 'https-request
+	// Args:
+	// * request: native https-request
+	// * value: Rye value to send as JSON
+	// Returns:
+	// * the request
+	"https-request//Json!": {
+		Argsn: 2,
+		Doc:   "Sets the body of a request to the value as JSON.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			req, errObj := httpRequestArg(ps, arg0, 1, "https-request//Json!")
+			if errObj != nil {
+				return errObj
+			}
+			httpSetBody(req, []byte(RyeToJSON(arg1)), "application/json")
+			return arg0
+		},
+	},
+
+	// Tests:
+	// equal { http-request 'POST https://example.com |Form! dict { "q" "rye" "page" 2 } |kind? } 'https-request
+	// error { http-request 'POST https://example.com |Form! dict { "q" { 1 } } }
+	// Args:
+	// * request: native https-request
+	// * fields: Dict of strings and numbers
+	// Returns:
+	// * the request
+	"https-request//Form!": {
+		Argsn: 2,
+		Doc:   "Sets the body of a request to the fields as an urlencoded form.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			req, errObj := httpRequestArg(ps, arg0, 1, "https-request//Form!")
+			if errObj != nil {
+				return errObj
+			}
+			fields, ok := arg1.(env.Dict)
+			if !ok {
+				ps.FailureFlag = true
+				return evaldo.MakeArgError(ps, 2, []env.Type{env.DictType}, "https-request//Form!")
+			}
+			form, err := httpForm(ps, fields)
+			if err != nil {
+				return evaldo.MakeBuiltinError(ps, err.Error(), "https-request//Form!")
+			}
+			httpSetBody(req, []byte(form), "application/x-www-form-urlencoded")
+			return arg0
+		},
+	},
+
+	// Tests:
+	// equal { http-request 'POST https://example.com |Multipart! dict [ "name" "report" "file" reader "a,b" ] |kind? } 'https-request
+	// Args:
+	// * request: native https-request
+	// * fields: Dict of strings and numbers, and file URIs and readers that are sent as files
+	// Returns:
+	// * the request
+	"https-request//Multipart!": {
+		Argsn: 2,
+		Doc:   "Sets the body of a request to the fields as multipart/form-data, files and readers are read when it's set.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			req, errObj := httpRequestArg(ps, arg0, 1, "https-request//Multipart!")
+			if errObj != nil {
+				return errObj
+			}
+			fields, ok := arg1.(env.Dict)
+			if !ok {
+				ps.FailureFlag = true
+				return evaldo.MakeArgError(ps, 2, []env.Type{env.DictType}, "https-request//Multipart!")
+			}
+			body, contentType, err := httpMultipart(ps, fields)
+			if err != nil {
+				return evaldo.MakeBuiltinError(ps, err.Error(), "https-request//Multipart!")
+			}
+			httpSetBody(req, body, contentType)
+			return arg0
+		},
+	},
 }
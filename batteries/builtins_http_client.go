@@ -0,0 +1,278 @@
+//go:build !no_http
+// +build !no_http
+
+package batteries
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/refaktor/rye/env"
+	"github.com/refaktor/rye/evaldo"
+)
+
+// httpClient is an HTTP client with a connection pool of its own, it's configured before it's used
+type httpClient struct {
+	client       *http.Client
+	transport    *http.Transport
+	headers      http.Header
+	maxRedirects int
+	retries      int
+	backoff      time.Duration
+}
+
+func newHTTPClient() *httpClient {
+	c := &httpClient{
+		transport:    http.DefaultTransport.(*http.Transport).Clone(),
+		headers:      make(http.Header),
+		maxRedirects: 10,
+		backoff:      500 * time.Millisecond,
+	}
+	c.client = &http.Client{Transport: c.transport, Timeout: 30 * time.Second, CheckRedirect: c.checkRedirect}
+	return c
+}
+
+func (c *httpClient) checkRedirect(req *http.Request, via []*http.Request) error {
+	if c.maxRedirects == 0 {
+		return http.ErrUseLastResponse
+	}
+	if len(via) >= c.maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", c.maxRedirects)
+	}
+	return nil
+}
+
+// do sends the request, idempotent requests are retried with a growing wait when the connection
+// fails or the server is overloaded or unavailable
+func (c *httpClient) do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	for name, values := range c.headers {
+		if _, ok := req.Header[name]; !ok {
+			req.Header[name] = values
+		}
+	}
+	retries := c.retries
+	if !httpIdempotent(req.Method) || (req.Body != nil && req.Body != http.NoBody && req.GetBody == nil) {
+		retries = 0
+	}
+	wait := c.backoff
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+		resp, err := c.client.Do(req.WithContext(ctx))
+		if attempt >= retries || ctx.Err() != nil || !httpRetryable(resp, err) {
+			return resp, err
+		}
+		delay := wait
+		if resp != nil {
+			if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+				delay = time.Duration(seconds) * time.Second
+			}
+			// the connection is reused when the body is read to the end
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, context.Cause(ctx)
+		}
+		wait *= 2
+	}
+}
+
+// fetch sends a GET request, the response is a failure unless its status is 2xx
+func (c *httpClient) fetch(ctx context.Context, uri string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+		return nil, httpStatusError{resp.StatusCode, string(body)}
+	}
+	return resp, nil
+}
+
+// httpStatusError is a response that isn't 2xx, it becomes a failure with the status as code
+type httpStatusError struct {
+	status int
+	body   string
+}
+
+func (e httpStatusError) Error() string {
+	return fmt.Sprintf("Status Code: %d", e.status)
+}
+
+func httpIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+func httpRetryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// httpSetBody sets a body that can be sent again on retries and redirects
+func httpSetBody(req *http.Request, body []byte, contentType string) {
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(body)), nil }
+	req.ContentLength = int64(len(body))
+	req.Header.Set("Content-Type", contentType)
+}
+
+// httpFieldValue returns the text of a form field, strings are sent as they are
+func httpFieldValue(ps *env.ProgramState, value any) (string, bool) {
+	switch v := value.(type) {
+	case env.String:
+		return v.Value, true
+	case env.Integer, env.Decimal, env.Boolean:
+		return v.(env.Object).Print(*ps.Idx), true
+	}
+	return "", false
+}
+
+// httpForm encodes the dict as an urlencoded form
+func httpForm(ps *env.ProgramState, fields env.Dict) (string, error) {
+	form := url.Values{}
+	for name, value := range fields.Data {
+		text, ok := httpFieldValue(ps, value)
+		if !ok {
+			return "", fmt.Errorf("field %s must be a string or a number", name)
+		}
+		form.Set(name, text)
+	}
+	return form.Encode(), nil
+}
+
+// httpMultipart encodes the dict as multipart/form-data, file URIs and readers become files
+func httpMultipart(ps *env.ProgramState, fields env.Dict) ([]byte, string, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	names := make([]string, 0, len(fields.Data))
+	for name := range fields.Data {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		var err error
+		switch v := fields.Data[name].(type) {
+		case env.Uri:
+			err = httpMultipartFile(w, name, v.GetPath())
+		case env.Native:
+			r, ok := v.Value.(io.Reader)
+			if !ok {
+				return nil, "", fmt.Errorf("field %s must be a string, number, file or reader", name)
+			}
+			var part io.Writer
+			if part, err = w.CreateFormFile(name, name); err == nil {
+				_, err = io.Copy(part, r)
+			}
+		default:
+			text, ok := httpFieldValue(ps, v)
+			if !ok {
+				return nil, "", fmt.Errorf("field %s must be a string, number, file or reader", name)
+			}
+			err = w.WriteField(name, text)
+		}
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), w.FormDataContentType(), nil
+}
+
+func httpMultipartFile(w *multipart.Writer, name string, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	part, err := w.CreateFormFile(name, filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(part, f)
+	return err
+}
+
+// httpClientFailure makes the failure of a request, responses that aren't 2xx keep the status as code
+func httpClientFailure(ps *env.ProgramState, err error, fnName string) env.Object {
+	var status httpStatusError
+	if errors.As(err, &status) {
+		ps.FailureFlag = true
+		return env.NewError2(status.status, status.body)
+	}
+	return evaldo.MakeBuiltinError(ps, err.Error(), fnName)
+}
+
+func httpClientArg(ps *env.ProgramState, arg env.Object, fnName string) (*httpClient, env.Object) {
+	if native, ok := arg.(env.Native); ok {
+		if c, ok := native.Value.(*httpClient); ok {
+			return c, nil
+		}
+	}
+	ps.FailureFlag = true
+	return nil, evaldo.MakeArgError(ps, 1, []env.Type{env.NativeType}, fnName)
+}
+
+func httpRequestArg(ps *env.ProgramState, arg env.Object, pos int, fnName string) (*http.Request, env.Object) {
+	if native, ok := arg.(env.Native); ok {
+		if req, ok := native.Value.(*http.Request); ok {
+			return req, nil
+		}
+	}
+	ps.FailureFlag = true
+	return nil, evaldo.MakeArgError(ps, pos, []env.Type{env.NativeType}, fnName)
+}
+
+// httpURL returns the URL of a URI or a string
+func httpURL(ps *env.ProgramState, arg env.Object, pos int, fnName string) (string, env.Object) {
+	switch u := arg.(type) {
+	case env.Uri:
+		return u.GetFullUri(*ps.Idx), nil
+	case env.String:
+		return u.Value, nil
+	}
+	ps.FailureFlag = true
+	return "", evaldo.MakeArgError(ps, pos, []env.Type{env.UriType, env.StringType}, fnName)
+}
+
+func newHTTPCookieJar() http.CookieJar {
+	jar, _ := cookiejar.New(nil) // it only fails with a broken public suffix list option
+	return jar
+}
@@ -0,0 +1,134 @@
+//go:build !no_http
+// +build !no_http
+
+package batteries
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// httpTestServer serves the paths the tests use, it counts the requests to /flaky
+func httpTestServer(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var flaky atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hello", func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "Hello") })
+	mux.HandleFunc("/flaky", func(w http.ResponseWriter, r *http.Request) {
+		if flaky.Add(1) < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			io.WriteString(w, "busy")
+			return
+		}
+		io.WriteString(w, "ok")
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(2 * time.Second):
+		case <-r.Context().Done():
+		}
+	})
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "sid", Value: "abc", Path: "/"})
+	})
+	mux.HandleFunc("/me", func(w http.ResponseWriter, r *http.Request) {
+		if c, err := r.Cookie("sid"); err == nil {
+			io.WriteString(w, c.Value)
+		}
+	})
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) { http.Redirect(w, r, "/hello", http.StatusFound) })
+	mux.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s %s %s", r.Method, r.Header.Get("X-Test"), r.Header.Get("Content-Type"), body)
+	})
+	mux.HandleFunc("/big", func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, strings.Repeat("0123456789", 1000)) })
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server, &flaky
+}
+
+func TestHttpClientRetry(t *testing.T) {
+	server, flaky := httpTestServer(t)
+	ps := testState()
+	url := server.URL + "/flaky"
+
+	res := testEval(t, ps, fmt.Sprintf(`http-client |Get %q |fix { |status? }`, url), false)
+	if res.Print(*ps.Idx) != "503" {
+		t.Errorf("expected a failure with the status without retries, got %s", res.Print(*ps.Idx))
+	}
+
+	flaky.Store(0)
+	res = testEval(t, ps, fmt.Sprintf(`http-client |Retry 3 10 |Get %q`, url), false)
+	if res.Print(*ps.Idx) != "ok" || flaky.Load() != 3 {
+		t.Errorf("expected ok after 3 requests, got %s after %d", res.Print(*ps.Idx), flaky.Load())
+	}
+
+	// a POST could have been handled, it isn't sent again
+	flaky.Store(0)
+	res = testEval(t, ps, fmt.Sprintf(`http-client |Retry 3 10 |Call http-request 'POST %q |Status?`, url), false)
+	if res.Print(*ps.Idx) != "503" || flaky.Load() != 1 {
+		t.Errorf("expected one POST with 503, got %s after %d", res.Print(*ps.Idx), flaky.Load())
+	}
+}
+
+func TestHttpClientOptions(t *testing.T) {
+	server, _ := httpTestServer(t)
+	ps := testState()
+
+	testEval(t, ps, fmt.Sprintf(`http-client |Timeout 50 |Get %q`, server.URL+"/slow"), true)
+
+	testEval(t, ps, `c: http-client |Cookie-jar`, false)
+	testEval(t, ps, fmt.Sprintf(`c .Get %q`, server.URL+"/login"), false)
+	if res := testEval(t, ps, fmt.Sprintf(`c .Get %q`, server.URL+"/me"), false); res.Print(*ps.Idx) != "abc" {
+		t.Errorf("expected the cookie to be sent back, got %s", res.Print(*ps.Idx))
+	}
+	if res := testEval(t, ps, fmt.Sprintf(`http-client |Get %q`, server.URL+"/me"), false); res.Print(*ps.Idx) != "" {
+		t.Errorf("expected no cookie without a jar, got %s", res.Print(*ps.Idx))
+	}
+
+	moved := server.URL + "/moved"
+	if res := testEval(t, ps, fmt.Sprintf(`http-client |Get %q`, moved), false); res.Print(*ps.Idx) != "Hello" {
+		t.Errorf("expected the redirect to be followed, got %s", res.Print(*ps.Idx))
+	}
+	if res := testEval(t, ps, fmt.Sprintf(`http-client |Redirects 0 |Call http-request 'GET %q |Status?`, moved), false); res.Print(*ps.Idx) != "302" {
+		t.Errorf("expected the redirect itself, got %s", res.Print(*ps.Idx))
+	}
+}
+
+func TestHttpClientBodies(t *testing.T) {
+	server, _ := httpTestServer(t)
+	ps := testState()
+	echo := server.URL + "/echo"
+
+	testEval(t, ps, fmt.Sprintf(`c: http-client |Header "X-Test" "rye" , req: http-request 'PUT %q |Json! dict { "name" "Jim" }`, echo), false)
+	// the same request can be sent again with its body
+	for i := 0; i < 2; i++ {
+		res := testEval(t, ps, `c .Call req |Read-body`, false)
+		if strings.TrimSpace(res.Print(*ps.Idx)) != `PUT rye application/json {"name": "Jim"}` {
+			t.Errorf("expected the JSON body, got %s", res.Print(*ps.Idx))
+		}
+	}
+
+	res := testEval(t, ps, fmt.Sprintf(`c .Call ( http-request 'POST %q |Form! dict { "q" "rye lang" } ) |Read-body`, echo), false)
+	if res.Print(*ps.Idx) != "POST rye application/x-www-form-urlencoded q=rye+lang" {
+		t.Errorf("expected the form body, got %s", res.Print(*ps.Idx))
+	}
+
+	res = testEval(t, ps, fmt.Sprintf(`c .Call ( http-request 'POST %q |Multipart! dict [ "name" "notes" "file" reader "a,b,c" ] ) |Read-body`, echo), false)
+	if out := res.Print(*ps.Idx); !strings.Contains(out, "multipart/form-data") || !strings.Contains(out, `name="file"`) || !strings.Contains(out, "a,b,c") {
+		t.Errorf("expected the multipart body, got %s", out)
+	}
+
+	res = testEval(t, ps, fmt.Sprintf(`c .Open %q |Read\string |length?`, server.URL+"/big"), false)
+	if res.Print(*ps.Idx) != "10000" {
+		t.Errorf("expected the streamed body, got %s", res.Print(*ps.Idx))
+	}
+	testEval(t, ps, fmt.Sprintf(`c .Open %q`, server.URL+"/missing"), true)
+}
@@ -0,0 +1,218 @@
+//go:build !no_http
+// +build !no_http
+
+package batteries
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/refaktor/rye/env"
+	"github.com/refaktor/rye/evaldo"
+
+	"github.com/jinzhu/copier"
+)
+
+// httpRouter is the value of the Go-router native. Groups share the mux with their parent,
+// but have their own path prefix and middleware chain.
+type httpRouter struct {
+	mux        *http.ServeMux
+	prefix     string
+	middleware []func(http.Handler) http.Handler
+}
+
+func newHttpRouter() *httpRouter {
+	return &httpRouter{mux: http.NewServeMux()}
+}
+
+// group creates a child router. Middleware is copied, so adding middleware to the group
+// doesn't change the parent.
+func (rt *httpRouter) group(prefix string) *httpRouter {
+	return &httpRouter{
+		mux:        rt.mux,
+		prefix:     rt.prefix + strings.TrimSuffix(prefix, "/"),
+		middleware: slices.Clone(rt.middleware),
+	}
+}
+
+// pattern adds the group prefix to a "METHOD /path/{param}" route pattern
+func (rt *httpRouter) pattern(pattern string) string {
+	method, path, found := strings.Cut(pattern, " ")
+	if !found {
+		return rt.prefix + pattern
+	}
+	return method + " " + rt.prefix + strings.TrimSpace(path)
+}
+
+// handle registers the handler wrapped in the current middleware chain. The first
+// middleware added is the outermost one.
+func (rt *httpRouter) handle(pattern string, h http.Handler) {
+	for i := len(rt.middleware) - 1; i >= 0; i-- {
+		h = rt.middleware[i](h)
+	}
+	rt.mux.Handle(rt.pattern(pattern), h)
+}
+
+// httpFailureKey is the request context key under which the recover middleware
+// leaves a holder for failures of Rye handlers
+type httpFailureKey struct{}
+
+type httpFailure struct {
+	msg string
+}
+
+// httpStatusWriter remembers the status code and whether anything was written yet
+type httpStatusWriter struct {
+	http.ResponseWriter
+	status  int
+	written bool
+}
+
+func (sw *httpStatusWriter) WriteHeader(code int) {
+	if !sw.written {
+		sw.status = code
+		sw.written = true
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *httpStatusWriter) Write(b []byte) (int, error) {
+	if !sw.written {
+		sw.status = http.StatusOK
+		sw.written = true
+	}
+	return sw.ResponseWriter.Write(b)
+}
+
+func (sw *httpStatusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// httpRyeHandler turns a Rye function or curried caller accepting { w req } into a http.Handler.
+// Each request gets its own copy of the program state, so flags of one request don't leak into another.
+func httpRyeHandler(ps *env.ProgramState, handler env.Object) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		psTemp := env.ProgramState{}
+		if err := copier.Copy(&psTemp, &ps); err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		psTemp.FailureFlag = false
+		psTemp.ErrorFlag = false
+		psTemp.ReturnFlag = false
+		wn := *env.NewNative(psTemp.Idx, w, "Go-server-response-writer")
+		rn := *env.NewNative(psTemp.Idx, r, "Go-server-request")
+		switch h := handler.(type) {
+		case env.Function:
+			evaldo.CallFunctionArgs2(h, &psTemp, wn, rn, nil)
+		case env.CurriedCaller:
+			evaldo.CallCurriedCallerArgsN(h, &psTemp, wn, rn)
+		}
+		if psTemp.FailureFlag || psTemp.ErrorFlag {
+			if f, ok := r.Context().Value(httpFailureKey{}).(*httpFailure); ok {
+				f.msg = psTemp.Res.Print(*psTemp.Idx)
+				return
+			}
+			fmt.Println("Error in HTTP handler: " + psTemp.Res.Inspect(*psTemp.Idx))
+		}
+	})
+}
+
+// httpRyeMiddleware turns a Rye function accepting { w req next } into a middleware. The next
+// handler is passed as Http-handler native and can be called with Serve.
+func httpRyeMiddleware(ps *env.ProgramState, fn env.Function) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			psTemp := env.ProgramState{}
+			if err := copier.Copy(&psTemp, &ps); err != nil {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			psTemp.FailureFlag = false
+			psTemp.ErrorFlag = false
+			psTemp.ReturnFlag = false
+			evaldo.CallFunctionArgsN(fn, &psTemp, nil,
+				*env.NewNative(psTemp.Idx, w, "Go-server-response-writer"),
+				*env.NewNative(psTemp.Idx, r, "Go-server-request"),
+				*env.NewNative(psTemp.Idx, next, "Http-handler"))
+			if psTemp.FailureFlag || psTemp.ErrorFlag {
+				if f, ok := r.Context().Value(httpFailureKey{}).(*httpFailure); ok {
+					f.msg = psTemp.Res.Print(*psTemp.Idx)
+					return
+				}
+				fmt.Println("Error in HTTP middleware: " + psTemp.Res.Inspect(*psTemp.Idx))
+			}
+		})
+	}
+}
+
+// httpLoggingMiddleware prints method, path, status and duration of each request
+func httpLoggingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &httpStatusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		fmt.Printf("%s %s %s %d %s\n", start.Format(time.RFC3339), r.Method, r.URL.Path, sw.status, time.Since(start))
+	})
+}
+
+// httpRecoverMiddleware turns Rye failures and Go panics in the handlers below it into
+// 500 responses, if the handler hasn't written a response already
+func httpRecoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failure := &httpFailure{}
+		sw := &httpStatusWriter{ResponseWriter: w}
+		defer func() {
+			if rec := recover(); rec != nil {
+				failure.msg = fmt.Sprint(rec)
+			}
+			if failure.msg != "" {
+				fmt.Println("Error in HTTP handler: " + failure.msg)
+				if !sw.written {
+					http.Error(sw, "Internal Server Error", http.StatusInternalServerError)
+				}
+			}
+		}()
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), httpFailureKey{}, failure)))
+	})
+}
+
+// httpCorsMiddleware sets CORS headers for the given origin and answers preflight requests
+func httpCorsMiddleware(origin string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h := w.Header()
+			h.Set("Access-Control-Allow-Origin", origin)
+			h.Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+			h.Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+			if origin != "*" {
+				h.Add("Vary", "Origin")
+			}
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// httpBasicAuthMiddleware requires the given username and password
+func httpBasicAuthMiddleware(username string, password string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u, p, ok := r.BasicAuth()
+			if !ok || subtle.ConstantTimeCompare([]byte(u), []byte(username)) != 1 || subtle.ConstantTimeCompare([]byte(p), []byte(password)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="restricted"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,132 @@
+//go:build !no_http
+// +build !no_http
+
+package batteries
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/refaktor/rye/env"
+)
+
+// routerServe sends a request to the router that the code sets to router, straight to its mux
+func routerServe(t *testing.T, ps *env.ProgramState, req *http.Request) *httptest.ResponseRecorder {
+	t.Helper()
+	rt, ok := testEval(t, ps, `router`, false).(env.Native).Value.(*httpRouter)
+	if !ok {
+		t.Fatal("router isn't a Go-router")
+	}
+	rec := httptest.NewRecorder()
+	rt.mux.ServeHTTP(rec, req)
+	return rec
+}
+
+// routerExpect checks the status and body of a response
+func routerExpect(t *testing.T, rec *httptest.ResponseRecorder, status int, body string) {
+	t.Helper()
+	if rec.Code != status || rec.Body.String() != body {
+		t.Errorf("expected %d %q, got %d %q", status, body, rec.Code, rec.Body.String())
+	}
+}
+
+func TestHttpRouterPathsAndGroups(t *testing.T) {
+	ps := testState()
+	testEval(t, ps, `
+router: http-router
+router .Handle "GET /users/{id}" fn { w req } { w .Write ( "User " ++ req .Path-value? "id" ) }
+router .Handle "GET /files/{path...}" fn { w req } { w .Write ( req .Path-value? "path" ) }
+router .Handle "GET /names/{names}" fn { w req } { w .Write ( req .Path-value? "name" |fix { "no name" } ) }
+api: router .Group "/api/"
+api .Use fn { w req next } { w .Set-header 'X-Api "1" , next .Serve w req }
+api .Handle "GET /items" "items"
+`, false)
+
+	routerExpect(t, routerServe(t, ps, httptest.NewRequest("GET", "/users/42", nil)), 200, "User 42")
+	routerExpect(t, routerServe(t, ps, httptest.NewRequest("GET", "/files/a/b.txt", nil)), 200, "a/b.txt")
+	// a parameter whose name only starts with the asked name is another parameter
+	routerExpect(t, routerServe(t, ps, httptest.NewRequest("GET", "/names/x", nil)), 200, "no name")
+	if rec := routerServe(t, ps, httptest.NewRequest("POST", "/users/42", nil)); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405 for a method without a route, got %d", rec.Code)
+	}
+	if rec := routerServe(t, ps, httptest.NewRequest("GET", "/other", nil)); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a path without a route, got %d", rec.Code)
+	}
+
+	// the group's prefix and middleware apply only to its routes
+	rec := routerServe(t, ps, httptest.NewRequest("GET", "/api/items", nil))
+	routerExpect(t, rec, 200, "items")
+	if rec.Header().Get("X-Api") != "1" {
+		t.Error("expected the group's middleware on its route")
+	}
+	if rec := routerServe(t, ps, httptest.NewRequest("GET", "/users/1", nil)); rec.Header().Get("X-Api") != "" {
+		t.Error("expected the group's middleware not to change the parent")
+	}
+}
+
+func TestHttpRouterMiddlewareOrder(t *testing.T) {
+	ps := testState()
+	testEval(t, ps, `
+router: http-router
+router .Handle "GET /before" "before"
+router .Use fn { w req next } { w .Write "[1" , next .Serve w req , w .Write "1]" }
+router .Use fn { w req next } { w .Write "[2" , next .Serve w req , w .Write "2]" }
+router .Handle "GET /after" "h"
+router .Use fn { w req next } { w .Write "stopped" }
+router .Handle "GET /stopped" "never"
+`, false)
+
+	// the first middleware added is the outermost
+	routerExpect(t, routerServe(t, ps, httptest.NewRequest("GET", "/after", nil)), 200, "[1[2h2]1]")
+	// middleware wraps only the routes added after it
+	routerExpect(t, routerServe(t, ps, httptest.NewRequest("GET", "/before", nil)), 200, "before")
+	// a middleware that doesn't call next ends the request, the outer ones already wrote the status
+	routerExpect(t, routerServe(t, ps, httptest.NewRequest("GET", "/stopped", nil)), 200, "[1[2stopped2]1]")
+}
+
+func TestHttpRouterBuiltinMiddleware(t *testing.T) {
+	ps := testState()
+	ps.Ctx.Set(ps.Idx.IndexWord("panicking"), *env.NewNative(ps.Idx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("go panic")
+	}), "Http-handler"))
+	testEval(t, ps, `
+router: http-router
+router .Use middleware\recover
+router .Handle "GET /fail" fn { w req } { fail "broken" }
+router .Handle "GET /late" fn { w req } { w .Write "partial" , fail "broken" }
+router .Handle "GET /panic" panicking
+cors: router .Group "/cors"
+cors .Use middleware\cors "https://example.com"
+cors .Handle "/data" "data"
+admin: router .Group "/admin"
+admin .Use middleware\basic-auth "admin" "secret"
+admin .Handle "GET /" "welcome"
+`, false)
+
+	// failures and panics become 500, unless the handler already responded
+	routerExpect(t, routerServe(t, ps, httptest.NewRequest("GET", "/fail", nil)), 500, "Internal Server Error\n")
+	routerExpect(t, routerServe(t, ps, httptest.NewRequest("GET", "/panic", nil)), 500, "Internal Server Error\n")
+	routerExpect(t, routerServe(t, ps, httptest.NewRequest("GET", "/late", nil)), 200, "partial")
+
+	rec := routerServe(t, ps, httptest.NewRequest("GET", "/cors/data", nil))
+	routerExpect(t, rec, 200, "data")
+	if rec.Header().Get("Access-Control-Allow-Origin") != "https://example.com" || rec.Header().Get("Vary") != "Origin" {
+		t.Errorf("expected the CORS headers, got %v", rec.Header())
+	}
+	preflight := httptest.NewRequest("OPTIONS", "/cors/data", nil)
+	preflight.Header.Set("Access-Control-Request-Method", "POST")
+	routerExpect(t, routerServe(t, ps, preflight), 204, "")
+
+	rec = routerServe(t, ps, httptest.NewRequest("GET", "/admin/", nil))
+	routerExpect(t, rec, 401, "Unauthorized\n")
+	if rec.Header().Get("WWW-Authenticate") == "" {
+		t.Error("expected a basic auth challenge")
+	}
+	wrong := httptest.NewRequest("GET", "/admin/", nil)
+	wrong.SetBasicAuth("admin", "guess")
+	routerExpect(t, routerServe(t, ps, wrong), 401, "Unauthorized\n")
+	right := httptest.NewRequest("GET", "/admin/", nil)
+	right.SetBasicAuth("admin", "secret")
+	routerExpect(t, routerServe(t, ps, right), 200, "welcome")
+}
@@ -0,0 +1,208 @@
+//go:build !no_http
+// +build !no_http
+
+package batteries
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/copier"
+	"github.com/refaktor/rye/env"
+)
+
+// httpFreeAddr returns a local address that nothing listens on, for builtins that listen themselves
+func httpFreeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// httpTestCert makes a self-signed certificate for 127.0.0.1, it's written to the directory as
+// cert.pem and key.pem
+func httpTestCert(t *testing.T, dir string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(dir, "cert.pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(filepath.Join(dir, "key.pem"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600)
+	return cert, key
+}
+
+// httpServe runs the code in a goroutine with a fork of the program state, the result is sent on
+// the channel when the code returns
+func httpServe(ps *env.ProgramState, code string) chan env.Object {
+	fork := httpFork(ps)
+	done := make(chan env.Object, 1)
+	go func() {
+		res, _ := testRun(fork, code)
+		done <- res
+	}()
+	return done
+}
+
+// httpFork returns a program state for another goroutine, it sees the words of ps and shares its index
+func httpFork(ps *env.ProgramState) *env.ProgramState {
+	fork := env.ProgramState{}
+	copier.Copy(&fork, ps)
+	fork.Ctx = env.NewEnv(ps.Ctx)
+	return &fork
+}
+
+// httpWaitUp waits until the server accepts connections
+func httpWaitUp(t *testing.T, addr string) {
+	t.Helper()
+	for i := 0; i < 200; i++ {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("the server at %s didn't start", addr)
+}
+
+func TestHttpServerServeTls(t *testing.T) {
+	dir := t.TempDir()
+	cert, key := httpTestCert(t, dir)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+
+	http.HandleFunc("/tls-hello", func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "Hello TLS") })
+	for _, args := range []string{"files", "natives"} {
+		addr := httpFreeAddr(t)
+		ps := testState()
+		testEval(t, ps, fmt.Sprintf(`srv: http-server %q`, addr), false)
+		ps.Ctx.Set(ps.Idx.IndexWord("cert"), *env.NewNative(ps.Idx, cert, "x509-certificate"))
+		ps.Ctx.Set(ps.Idx.IndexWord("key"), *env.NewNative(ps.Idx, key, "ecdsa-private-key"))
+		code := fmt.Sprintf(`srv .Serve-tls %q %q`, filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"))
+		if args == "natives" {
+			code = `srv .Serve-tls cert key`
+		}
+		done := httpServe(ps, code)
+		httpWaitUp(t, addr)
+
+		resp, err := client.Get("https://" + addr + "/tls-hello")
+		if err != nil {
+			t.Fatalf("%s: %v", args, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "Hello TLS" || resp.TLS == nil {
+			t.Errorf("%s: expected the page over TLS, got %q", args, body)
+		}
+
+		// Serve-tls returns the server once it's shut down
+		testEval(t, ps, `srv .Shutdown 1000`, false)
+		select {
+		case res := <-done:
+			if _, ok := res.(env.Native); !ok {
+				t.Errorf("%s: expected Serve-tls to return the server, got %s", args, res.Inspect(*ps.Idx))
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: Serve-tls didn't return after Shutdown", args)
+		}
+	}
+
+	// a native that isn't a private key is refused before serving
+	ps := testState()
+	ps.Ctx.Set(ps.Idx.IndexWord("cert"), *env.NewNative(ps.Idx, cert, "x509-certificate"))
+	testEval(t, ps, fmt.Sprintf(`srv: http-server %q`, httpFreeAddr(t)), false)
+	if res := testEval(t, ps, `srv .Serve-tls cert srv`, true); !strings.Contains(res.(*env.Error).Message, "rsa-private-key") {
+		t.Errorf("expected the kinds of keys in the failure, got %s", res.Inspect(*ps.Idx))
+	}
+	testEval(t, ps, `srv .Serve-tls cert "key.pem"`, true)
+}
+
+func TestHttpServerShutdownDeadline(t *testing.T) {
+	addr := httpFreeAddr(t)
+	ps := testState()
+	testEval(t, ps, fmt.Sprintf(`srv: http-server %q`, addr), false)
+	srv := ps.Res
+	started := make(chan bool)
+	release := make(chan bool)
+	// the server has a handler of its own, so the test doesn't depend on the paths of the default mux
+	srv.(env.Native).Value.(*http.Server).Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- true
+		<-release
+		io.WriteString(w, "done")
+	})
+	done := httpServe(ps, `srv .Serve`)
+	httpWaitUp(t, addr)
+
+	go http.Get("http://" + addr + "/slow")
+	<-started
+	// the request is still running when the deadline passes
+	res := testEval(t, ps, `srv .Shutdown 50`, true)
+	if !strings.Contains(res.(*env.Error).Message, "deadline exceeded") {
+		t.Errorf("expected the deadline to pass, got %s", res.Inspect(*ps.Idx))
+	}
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve didn't return after Shutdown")
+	}
+	if _, err := net.Dial("tcp", addr); err == nil {
+		t.Error("expected the server to stop listening")
+	}
+	testEval(t, ps, `srv .Shutdown "soon"`, true)
+}
+
+func TestHttpServerSetTimeouts(t *testing.T) {
+	ps := testState()
+	testEval(t, ps, `srv: http-server "127.0.0.1:0" |Set-timeouts dict { "read" 5000 "read-header" 1000 "write" 10000 "idle" 60000 }`, false)
+	srv := ps.Res.(env.Native).Value.(*http.Server)
+	if srv.ReadTimeout != 5*time.Second || srv.ReadHeaderTimeout != time.Second || srv.WriteTimeout != 10*time.Second || srv.IdleTimeout != time.Minute {
+		t.Errorf("unexpected timeouts %v %v %v %v", srv.ReadTimeout, srv.ReadHeaderTimeout, srv.WriteTimeout, srv.IdleTimeout)
+	}
+	testEval(t, ps, `srv .Set-timeouts { read: 100 }`, false)
+	if srv.ReadTimeout != 100*time.Millisecond {
+		t.Errorf("expected the timeout from the block, got %v", srv.ReadTimeout)
+	}
+	testEval(t, ps, `srv .Set-timeouts dict { "read" "5s" }`, true)
+	testEval(t, ps, `srv .Set-timeouts dict { "connect" 100 }`, true)
+}
@@ -1058,7 +1058,7 @@ var Builtins_io = map[string]*env.Builtin{
 				// defer cancel()
 				proto := ps.Idx.GetWord(f.GetProtocol().Index)
 				// req, err := http.NewRequestWithContext(ctx, http.MethodGet, proto+"://"+f.GetPath(), nil)
-				req, err := http.NewRequest(http.MethodGet, proto+"://"+f.GetPath(), nil)
+				req, err := http.NewRequestWithContext(ps.GoContext(), http.MethodGet, proto+"://"+f.GetPath(), nil)
 				if err != nil {
 					ps.FailureFlag = true
 					return *env.NewError(err.Error())
@@ -1098,7 +1098,7 @@ var Builtins_io = map[string]*env.Builtin{
 			switch f := arg0.(type) {
 			case env.Uri:
 				proto := ps.Idx.GetWord(f.GetProtocol().Index)
-				req, err := http.NewRequest(http.MethodGet, proto+"://"+f.GetPath(), nil)
+				req, err := http.NewRequestWithContext(ps.GoContext(), http.MethodGet, proto+"://"+f.GetPath(), nil)
 				if err != nil {
 					ps.FailureFlag = true
 					return *env.NewError(err.Error())
@@ -1170,7 +1170,7 @@ var Builtins_io = map[string]*env.Builtin{
 					}
 
 					proto := ps.Idx.GetWord(f.GetProtocol().Index)
-					req, err := http.NewRequest(http.MethodPost, proto+"://"+f.GetPath(), strings.NewReader(d.Value))
+					req, err := http.NewRequestWithContext(ps.GoContext(), http.MethodPost, proto+"://"+f.GetPath(), strings.NewReader(d.Value))
 					if err != nil {
 						ps.FailureFlag = true
 						return *env.NewError(err.Error())
@@ -1216,7 +1216,7 @@ var Builtins_io = map[string]*env.Builtin{
 			switch f := arg0.(type) {
 			case env.Uri:
 				proto := ps.Idx.GetWord(f.GetProtocol().Index)
-				req, err := http.NewRequest(http.MethodGet, proto+"://"+f.GetPath(), nil)
+				req, err := http.NewRequestWithContext(ps.GoContext(), http.MethodGet, proto+"://"+f.GetPath(), nil)
 				if err != nil {
 					ps.FailureFlag = true
 					return *env.NewError(err.Error())
@@ -1272,7 +1272,7 @@ var Builtins_io = map[string]*env.Builtin{
 						}
 
 						proto := ps.Idx.GetWord(f.GetProtocol().Index)
-						req, err := http.NewRequest(http.MethodPost, proto+"://"+f.GetPath(), strings.NewReader(d.Value))
+						req, err := http.NewRequestWithContext(ps.GoContext(), http.MethodPost, proto+"://"+f.GetPath(), strings.NewReader(d.Value))
 						if err != nil {
 							ps.FailureFlag = true
 							return *env.NewError(err.Error())
@@ -1334,7 +1334,7 @@ var Builtins_io = map[string]*env.Builtin{
 					case env.String:
 						data1 := strings.NewReader(data.Value)
 						proto := ps.Idx.GetWord(uri.GetProtocol().Index)
-						req, err := http.NewRequest(method1, proto+"://"+uri.GetPath(), data1)
+						req, err := http.NewRequestWithContext(ps.GoContext(), method1, proto+"://"+uri.GetPath(), data1)
 						if err != nil {
 							ps.FailureFlag = true
 							return evaldo.MakeBuiltinError(ps, err.Error(), "https-uri//Request")
@@ -1427,7 +1427,7 @@ var Builtins_io = map[string]*env.Builtin{
 			switch req := arg0.(type) {
 			case env.Native:
 				client := &http.Client{}
-				resp, err := client.Do(req.Value.(*http.Request))
+				resp, err := client.Do(req.Value.(*http.Request).WithContext(ps.GoContext()))
 				// defer resp.Body.Close() // TODO -- comment this and figure out goling bodyclose
 				if err != nil {
 					return evaldo.MakeBuiltinError(ps, err.Error(), "https-request//Call")
@@ -4,414 +4,1243 @@
 package batteries
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
 	"sync"
 
 	"github.com/refaktor/rye/env"
 	"github.com/refaktor/rye/evaldo"
+
+	"github.com/jinzhu/copier"
 )
 
-// Global variables to store MCP servers and clients
-var (
-	mcpServerMutex sync.RWMutex
+// mcpProtocolVersions are the Model Context Protocol versions the server speaks, the latest first
+var mcpProtocolVersions = []string{"2025-06-18", "2025-03-26", "2024-11-05"}
+
+// JSON-RPC 2.0 error codes
+const (
+	jsonrpcParseError     = -32700
+	jsonrpcInvalidRequest = -32600
+	jsonrpcMethodNotFound = -32601
+	jsonrpcInvalidParams  = -32602
+	mcpResourceNotFound   = -32002
 )
 
+type jsonrpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *jsonrpcError   `json:"error,omitempty"`
+}
+
+type jsonrpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *jsonrpcError   `json:"error,omitempty"`
+}
+
+type jsonrpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *jsonrpcError) Error() string {
+	return fmt.Sprintf("%s (%d)", e.Message, e.Code)
+}
+
+// mcpServer answers MCP requests with the Rye functions and blocks registered as its tools,
+// resources and prompts. Each request runs in its own copy of the program state.
+type mcpServer struct {
+	name      string
+	version   string
+	ps        *env.ProgramState
+	mu        sync.RWMutex
+	tools     []mcpTool
+	resources []mcpResource
+	prompts   []mcpPrompt
+}
+
+type mcpTool struct {
+	name   string
+	doc    string
+	params []string
+	fn     env.Function
+}
+
+type mcpResource struct {
+	uri      string
+	name     string
+	mimeType string
+	code     env.Object
+}
+
+type mcpPrompt struct {
+	name   string
+	doc    string
+	params []string
+	code   env.Object
+}
+
+func newMcpServer(ps *env.ProgramState, name, version string) (*mcpServer, error) {
+	psCopy := &env.ProgramState{}
+	if err := copier.Copy(psCopy, ps); err != nil {
+		return nil, err
+	}
+	return &mcpServer{name: name, version: version, ps: psCopy}, nil
+}
+
+// fnParams returns the names of the parameters of a function, its spec can end with a doc string
+func fnParams(ps *env.ProgramState, fn env.Function) []string {
+	params := make([]string, 0, fn.Argsn)
+	for i := 0; i < fn.Argsn; i++ {
+		if w, ok := fn.Spec.Series.Get(i).(env.Word); ok {
+			params = append(params, ps.Idx.GetWord(w.Index))
+		}
+	}
+	return params
+}
+
+// inputSchema is the JSON schema of the arguments of a tool, an object with the parameters as
+// required properties of any type
+func (t mcpTool) inputSchema() map[string]any {
+	props := make(map[string]any)
+	for _, p := range t.params {
+		props[p] = map[string]any{}
+	}
+	return map[string]any{"type": "object", "properties": props, "required": t.params}
+}
+
+// handle answers a JSON-RPC message or batch, it returns nil if there's nothing to answer
+func (s *mcpServer) handle(ctx context.Context, data []byte) []byte {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(data, &batch); err != nil {
+			return mustMarshal(jsonrpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &jsonrpcError{jsonrpcParseError, err.Error()}})
+		}
+		var responses []*jsonrpcResponse
+		for _, msg := range batch {
+			if resp := s.handleMessage(ctx, msg); resp != nil {
+				responses = append(responses, resp)
+			}
+		}
+		if len(responses) == 0 {
+			return nil
+		}
+		return mustMarshal(responses)
+	}
+	if resp := s.handleMessage(ctx, data); resp != nil {
+		return mustMarshal(resp)
+	}
+	return nil
+}
+
+func mustMarshal(v any) []byte {
+	res, err := json.Marshal(v)
+	if err != nil {
+		res, _ = json.Marshal(jsonrpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &jsonrpcError{-32603, err.Error()}})
+	}
+	return res
+}
+
+func (s *mcpServer) handleMessage(ctx context.Context, data []byte) *jsonrpcResponse {
+	var msg jsonrpcMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return &jsonrpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &jsonrpcError{jsonrpcParseError, err.Error()}}
+	}
+	if msg.Method == "" {
+		if msg.Result != nil || msg.Error != nil {
+			// a response, the server doesn't send requests
+			return nil
+		}
+		return &jsonrpcResponse{JSONRPC: "2.0", ID: idOrNull(msg.ID), Error: &jsonrpcError{jsonrpcInvalidRequest, "method is missing"}}
+	}
+	result, err := s.call(ctx, msg.Method, msg.Params)
+	if msg.ID == nil {
+		// notifications aren't answered
+		return nil
+	}
+	if err != nil {
+		var rpcErr *jsonrpcError
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &jsonrpcError{jsonrpcInvalidParams, err.Error()}
+		}
+		return &jsonrpcResponse{JSONRPC: "2.0", ID: msg.ID, Error: rpcErr}
+	}
+	return &jsonrpcResponse{JSONRPC: "2.0", ID: msg.ID, Result: result}
+}
+
+func idOrNull(id json.RawMessage) json.RawMessage {
+	if id == nil {
+		return json.RawMessage("null")
+	}
+	return id
+}
+
+func (s *mcpServer) call(ctx context.Context, method string, params json.RawMessage) (any, error) {
+	// the handlers run without the lock, so they can add tools too
+	s.mu.RLock()
+	tools, resources, prompts := s.tools, s.resources, s.prompts
+	s.mu.RUnlock()
+	switch method {
+	case "initialize":
+		var p struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		}
+		if err := unmarshalParams(params, &p); err != nil {
+			return nil, err
+		}
+		version := mcpProtocolVersions[0]
+		for _, v := range mcpProtocolVersions {
+			if v == p.ProtocolVersion {
+				version = v
+			}
+		}
+		return map[string]any{
+			"protocolVersion": version,
+			"capabilities": map[string]any{
+				"tools":     map[string]any{"listChanged": false},
+				"resources": map[string]any{"subscribe": false, "listChanged": false},
+				"prompts":   map[string]any{"listChanged": false},
+			},
+			"serverInfo": map[string]any{"name": s.name, "version": s.version},
+		}, nil
+	case "ping":
+		return map[string]any{}, nil
+	case "notifications/initialized", "notifications/cancelled":
+		return nil, nil
+	case "tools/list":
+		list := make([]any, 0, len(tools))
+		for _, t := range tools {
+			list = append(list, map[string]any{"name": t.name, "description": t.doc, "inputSchema": t.inputSchema()})
+		}
+		return map[string]any{"tools": list}, nil
+	case "tools/call":
+		var p struct {
+			Name      string         `json:"name"`
+			Arguments map[string]any `json:"arguments"`
+		}
+		if err := unmarshalParams(params, &p); err != nil {
+			return nil, err
+		}
+		for _, t := range tools {
+			if t.name == p.Name {
+				args, err := mcpArgs(t.params, p.Arguments)
+				if err != nil {
+					return nil, err
+				}
+				res, err := s.run(ctx, t.fn, args)
+				if err != nil {
+					return map[string]any{"content": []any{textContent(err.Error())}, "isError": true}, nil
+				}
+				return map[string]any{"content": []any{textContent(mcpText(res))}, "isError": false}, nil
+			}
+		}
+		return nil, &jsonrpcError{jsonrpcInvalidParams, "Unknown tool: " + p.Name}
+	case "resources/list":
+		list := make([]any, 0, len(resources))
+		for _, r := range resources {
+			list = append(list, map[string]any{"uri": r.uri, "name": r.name, "mimeType": r.mimeType})
+		}
+		return map[string]any{"resources": list}, nil
+	case "resources/templates/list":
+		return map[string]any{"resourceTemplates": []any{}}, nil
+	case "resources/read":
+		var p struct {
+			URI string `json:"uri"`
+		}
+		if err := unmarshalParams(params, &p); err != nil {
+			return nil, err
+		}
+		for _, r := range resources {
+			if r.uri == p.URI {
+				res, err := s.run(ctx, r.code, nil)
+				if err != nil {
+					return nil, &jsonrpcError{-32603, err.Error()}
+				}
+				return map[string]any{"contents": []any{map[string]any{"uri": r.uri, "mimeType": r.mimeType, "text": mcpText(res)}}}, nil
+			}
+		}
+		return nil, &jsonrpcError{mcpResourceNotFound, "Resource not found: " + p.URI}
+	case "prompts/list":
+		list := make([]any, 0, len(prompts))
+		for _, pr := range prompts {
+			args := make([]any, 0, len(pr.params))
+			for _, name := range pr.params {
+				args = append(args, map[string]any{"name": name, "required": true})
+			}
+			list = append(list, map[string]any{"name": pr.name, "description": pr.doc, "arguments": args})
+		}
+		return map[string]any{"prompts": list}, nil
+	case "prompts/get":
+		var p struct {
+			Name      string         `json:"name"`
+			Arguments map[string]any `json:"arguments"`
+		}
+		if err := unmarshalParams(params, &p); err != nil {
+			return nil, err
+		}
+		for _, pr := range prompts {
+			if pr.name == p.Name {
+				args, err := mcpArgs(pr.params, p.Arguments)
+				if err != nil {
+					return nil, err
+				}
+				res, err := s.run(ctx, pr.code, args)
+				if err != nil {
+					return nil, &jsonrpcError{-32603, err.Error()}
+				}
+				message := map[string]any{"role": "user", "content": textContent(mcpText(res))}
+				return map[string]any{"description": pr.doc, "messages": []any{message}}, nil
+			}
+		}
+		return nil, &jsonrpcError{jsonrpcInvalidParams, "Unknown prompt: " + p.Name}
+	}
+	return nil, &jsonrpcError{jsonrpcMethodNotFound, "Method not found: " + method}
+}
+
+func unmarshalParams(params json.RawMessage, v any) error {
+	if len(params) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return &jsonrpcError{jsonrpcInvalidParams, err.Error()}
+	}
+	return nil
+}
+
+// mcpArgs returns the arguments in the order of the parameters, all of them are required
+func mcpArgs(params []string, arguments map[string]any) ([]env.Object, error) {
+	args := make([]env.Object, len(params))
+	for i, name := range params {
+		val, ok := arguments[name]
+		if !ok {
+			return nil, &jsonrpcError{jsonrpcInvalidParams, "Missing argument: " + name}
+		}
+		args[i] = env.ToRyeValue(val)
+	}
+	return args, nil
+}
+
+func textContent(text string) map[string]any {
+	return map[string]any{"type": "text", "text": text}
+}
+
+// mcpText returns strings as they are and other values as JSON
+func mcpText(res env.Object) string {
+	if s, ok := res.(env.String); ok {
+		return s.Value
+	}
+	return RyeToJSON(res)
+}
+
+// run calls a function with the arguments or evaluates a block in a copy of the program state
+func (s *mcpServer) run(ctx context.Context, code env.Object, args []env.Object) (res env.Object, err error) {
+	psTemp := env.ProgramState{}
+	if err := copier.Copy(&psTemp, s.ps); err != nil {
+		return nil, err
+	}
+	psTemp.FailureFlag = false
+	psTemp.ErrorFlag = false
+	psTemp.ReturnFlag = false
+	psTemp.InErrHandler = true // the failure is returned to the client
+	psTemp.TaskCtx = ctx
+	psTemp.Ctx = env.NewEnv(s.ps.Ctx)
+	defer func() {
+		if r := recover(); r != nil {
+			res, err = nil, fmt.Errorf("panic: %v", r)
+		}
+	}()
+	switch code := code.(type) {
+	case env.Function:
+		evaldo.CallFunctionArgsN(code, &psTemp, psTemp.Ctx, args...)
+	case env.Block:
+		psTemp.Ser = code.Series
+		evaldo.EvalBlockInj(&psTemp, nil, false)
+	}
+	if psTemp.ErrorFlag || psTemp.FailureFlag {
+		switch e := psTemp.Res.(type) {
+		case *env.Error:
+			return nil, errors.New(e.Message)
+		case env.Error:
+			return nil, errors.New(e.Message)
+		}
+		return nil, errors.New(psTemp.Res.Inspect(*s.ps.Idx))
+	}
+	return psTemp.Res, nil
+}
+
+// serveStdio answers the newline delimited messages from in until it ends
+func (s *mcpServer) serveStdio(ctx context.Context, in io.Reader, out io.Writer) error {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		if resp := s.handle(ctx, scanner.Bytes()); resp != nil {
+			if _, err := out.Write(append(resp, '\n')); err != nil {
+				return err
+			}
+		}
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+	}
+	return scanner.Err()
+}
+
+// ServeHTTP implements the streamable HTTP transport, the responses are sent as JSON and the
+// server doesn't open SSE streams
+func (s *mcpServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !allowedOrigin(r) {
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 64*1024*1024))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp := s.handle(r.Context(), body)
+	if resp == nil {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resp)
+}
+
+// allowedOrigin rejects requests from web pages of other hosts, against DNS rebinding
+func allowedOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	return u.Hostname() == host || u.Hostname() == "localhost" || u.Hostname() == "127.0.0.1"
+}
+
+// mcpStringArgs returns the values of the arguments, or the failure for the first one that isn't a
+// string
+func mcpStringArgs(ps *env.ProgramState, fnName string, args ...env.Object) ([]string, env.Object) {
+	strs := make([]string, len(args))
+	for i, arg := range args {
+		str, ok := arg.(env.String)
+		if !ok {
+			ps.FailureFlag = true
+			return nil, evaldo.MakeArgError(ps, i+1, []env.Type{env.StringType}, fnName)
+		}
+		strs[i] = str.Value
+	}
+	return strs, nil
+}
+
+// mcpGet returns the string that get reads from the value of the native, or a failure if the
+// native doesn't hold the value get expects
+func mcpGet(ps *env.ProgramState, arg env.Object, fnName string, get func(v any) (string, bool)) env.Object {
+	if native, ok := arg.(env.Native); ok {
+		if val, ok := get(native.Value); ok {
+			return *env.NewString(val)
+		}
+	}
+	ps.FailureFlag = true
+	return evaldo.MakeArgError(ps, 1, []env.Type{env.NativeType}, fnName)
+}
+
+func mcpServerArg(ps *env.ProgramState, arg env.Object, fnName string) (*mcpServer, env.Object) {
+	if native, ok := arg.(env.Native); ok {
+		if s, ok := native.Value.(*mcpServer); ok {
+			return s, nil
+		}
+	}
+	ps.FailureFlag = true
+	return nil, evaldo.MakeArgError(ps, 1, []env.Type{env.NativeType}, fnName)
+}
+
 var Builtins_mcp = map[string]*env.Builtin{
-	// Server functions
-	"mcp-server//Create": {
+
+	//
+	// ##### MCP ##### "Model Context Protocol server with Rye tools, resources and prompts"
+	//
+	// Example:
+	//  s: mcp/server "scripts" "1.0"
+	//  s .Tool "add" fn { a b "Adds two numbers" } { a + b }
+	//  s .Resource "file:///notes" "notes" "text/plain" { read %notes.txt }
+	//  s .Prompt "review" fn { code "Asks for a review of the code" } { "Review this code: " ++ code }
+	//  s .Serve-stdio
+	//
+	// Tests:
+	// equal { mcp/server "scripts" "1.0" |type? } 'native
+	// Args:
+	// * name: String, the name the server reports to clients
+	// * version: String, the version the server reports to clients
+	// Returns:
+	// * the MCP server
+	"server": {
 		Argsn: 2,
-		Doc:   "Creates a new MCP server with the given name and version.",
+		Doc:   "Creates an MCP server, tools, resources and prompts are added to it before it's served.",
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
-			switch name := arg0.(type) {
-			case env.String:
-				switch version := arg1.(type) {
-				case env.String:
-					mcpServerMutex.Lock()
-					defer mcpServerMutex.Unlock()
-
-					// Create a simple object to represent the server
-					serverInfo := struct {
-						Name    string
-						Version string
-					}{
-						Name:    name.Value,
-						Version: version.Value,
-					}
-
-					// Return the server as a native object
-					return *env.NewNative(ps.Idx, serverInfo, "Rye-mcp-server")
-				default:
+			name, ok := arg0.(env.String)
+			if !ok {
+				ps.FailureFlag = true
+				return evaldo.MakeArgError(ps, 1, []env.Type{env.StringType}, "mcp/server")
+			}
+			version, ok := arg1.(env.String)
+			if !ok {
+				ps.FailureFlag = true
+				return evaldo.MakeArgError(ps, 2, []env.Type{env.StringType}, "mcp/server")
+			}
+			s, err := newMcpServer(ps, name.Value, version.Value)
+			if err != nil {
+				return evaldo.MakeBuiltinError(ps, fmt.Sprintf("failed to copy program state: %s", err), "mcp/server")
+			}
+			return *env.NewNative(ps.Idx, s, "Rye-mcp-server")
+		},
+	},
+
+	// Tests:
+	// equal { mcp/protocol-version } "2025-06-18"
+	// Args:
+	// Returns:
+	// * the latest MCP protocol version the server speaks
+	"protocol-version": {
+		Argsn: 0,
+		Doc:   "Returns the latest MCP protocol version.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			return *env.NewString(mcpProtocolVersions[0])
+		},
+	},
+
+	// Tests:
+	// equal { s: mcp/server "t" "1" , s .Tool "add" fn { a b "Adds" } { a + b } , s .Handle `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"add","arguments":{"a":1,"b":2}}}` } `{"jsonrpc":"2.0","id":1,"result":{"content":[{"text":"3","type":"text"}],"isError":false}}`
+	// equal { s: mcp/server "t" "1" , s .Tool "add" fn { a b "Adds" } { a + b } , s .Handle `{"jsonrpc":"2.0","id":1,"method":"tools/list"}` } `{"jsonrpc":"2.0","id":1,"result":{"tools":[{"description":"Adds","inputSchema":{"properties":{"a":{},"b":{}},"required":["a","b"],"type":"object"},"name":"add"}]}}`
+	// Args:
+	// * server: MCP server
+	// * name: String, name of the tool
+	// * function: Function the tool calls, its parameters are the arguments of the tool and its doc string the description
+	// Returns:
+	// * the server
+	"Rye-mcp-server//Tool": {
+		Argsn: 3,
+		Doc:   "Adds a tool that calls a Rye function to the MCP server, a string result is returned as text and other values as JSON.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			s, errObj := mcpServerArg(ps, arg0, "Rye-mcp-server//Tool")
+			if errObj != nil {
+				return errObj
+			}
+			name, ok := arg1.(env.String)
+			if !ok {
+				ps.FailureFlag = true
+				return evaldo.MakeArgError(ps, 2, []env.Type{env.StringType}, "Rye-mcp-server//Tool")
+			}
+			fn, ok := arg2.(env.Function)
+			if !ok {
+				ps.FailureFlag = true
+				return evaldo.MakeArgError(ps, 3, []env.Type{env.FunctionType}, "Rye-mcp-server//Tool")
+			}
+			tool := mcpTool{name: name.Value, doc: fn.Doc, params: fnParams(ps, fn), fn: fn}
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			// a new slice, so requests that are being answered keep their list
+			tools := make([]mcpTool, 0, len(s.tools)+1)
+			for _, t := range s.tools {
+				if t.name != tool.name {
+					tools = append(tools, t)
+				}
+			}
+			s.tools = append(tools, tool)
+			return arg0
+		},
+	},
+
+	// Tests:
+	// equal { s: mcp/server "t" "1" , s .Resource "mem://a" "a" "text/plain" { "hello" } , s .Handle `{"jsonrpc":"2.0","id":2,"method":"resources/read","params":{"uri":"mem://a"}}` } `{"jsonrpc":"2.0","id":2,"result":{"contents":[{"mimeType":"text/plain","text":"hello","uri":"mem://a"}]}}`
+	// Args:
+	// * server: MCP server
+	// * uri: String, URI of the resource
+	// * name: String, name of the resource
+	// * mime-type: String, MIME type of the content
+	// * code: Block or function without arguments that returns the content
+	// Returns:
+	// * the server
+	"Rye-mcp-server//Resource": {
+		Argsn: 5,
+		Doc:   "Adds a resource to the MCP server, its content is the result of the code each time it's read.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			s, errObj := mcpServerArg(ps, arg0, "Rye-mcp-server//Resource")
+			if errObj != nil {
+				return errObj
+			}
+			var strs [3]string
+			for i, arg := range []env.Object{arg1, arg2, arg3} {
+				str, ok := arg.(env.String)
+				if !ok {
 					ps.FailureFlag = true
-					return evaldo.MakeArgError(ps, 2, []env.Type{env.StringType}, "mcp-server//create")
+					return evaldo.MakeArgError(ps, i+2, []env.Type{env.StringType}, "Rye-mcp-server//Resource")
+				}
+				strs[i] = str.Value
+			}
+			switch code := arg4.(type) {
+			case env.Block, env.Function:
+				if fn, ok := code.(env.Function); ok && fn.Argsn != 0 {
+					return evaldo.MakeBuiltinError(ps, "function of a resource can't have arguments", "Rye-mcp-server//Resource")
 				}
+				s.mu.Lock()
+				defer s.mu.Unlock()
+				s.resources = append(s.resources, mcpResource{uri: strs[0], name: strs[1], mimeType: strs[2], code: code})
+				return arg0
 			default:
 				ps.FailureFlag = true
-				return evaldo.MakeArgError(ps, 1, []env.Type{env.StringType}, "mcp-server//create")
+				return evaldo.MakeArgError(ps, 5, []env.Type{env.BlockType, env.FunctionType}, "Rye-mcp-server//Resource")
 			}
 		},
 	},
 
-	"mcp//Create-resource": {
+	// Tests:
+	// equal { s: mcp/server "t" "1" , s .Prompt "greet" fn { who "Greets" } { "Say hi to " ++ who } , s .Handle `{"jsonrpc":"2.0","id":3,"method":"prompts/get","params":{"name":"greet","arguments":{"who":"Jim"}}}` } `{"jsonrpc":"2.0","id":3,"result":{"description":"Greets","messages":[{"content":{"text":"Say hi to Jim","type":"text"},"role":"user"}]}}`
+	// Args:
+	// * server: MCP server
+	// * name: String, name of the prompt
+	// * code: Block, or function whose parameters are the arguments of the prompt and its doc string the description
+	// Returns:
+	// * the server
+	"Rye-mcp-server//Prompt": {
 		Argsn: 3,
-		Doc:   "Creates a new MCP resource with the given URI, name, and MIME type.",
+		Doc:   "Adds a prompt to the MCP server, the text of its message is the result of the code.",
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
-			switch uri := arg0.(type) {
-			case env.String:
-				switch name := arg1.(type) {
-				case env.String:
-					switch mimeType := arg2.(type) {
-					case env.String:
-						// Create a simple object to represent the resource
-						resourceInfo := struct {
-							URI      string
-							Name     string
-							MIMEType string
-						}{
-							URI:      uri.Value,
-							Name:     name.Value,
-							MIMEType: mimeType.Value,
-						}
-
-						// Return the resource as a native object
-						return *env.NewNative(ps.Idx, resourceInfo, "Rye-mcp-resource")
-					default:
-						ps.FailureFlag = true
-						return evaldo.MakeArgError(ps, 3, []env.Type{env.StringType}, "mcp-resource//create")
-					}
-				default:
-					ps.FailureFlag = true
-					return evaldo.MakeArgError(ps, 2, []env.Type{env.StringType}, "mcp-resource//create")
-				}
+			s, errObj := mcpServerArg(ps, arg0, "Rye-mcp-server//Prompt")
+			if errObj != nil {
+				return errObj
+			}
+			name, ok := arg1.(env.String)
+			if !ok {
+				ps.FailureFlag = true
+				return evaldo.MakeArgError(ps, 2, []env.Type{env.StringType}, "Rye-mcp-server//Prompt")
+			}
+			prompt := mcpPrompt{name: name.Value, code: arg2}
+			switch code := arg2.(type) {
+			case env.Block:
+			case env.Function:
+				prompt.doc = code.Doc
+				prompt.params = fnParams(ps, code)
 			default:
 				ps.FailureFlag = true
-				return evaldo.MakeArgError(ps, 1, []env.Type{env.StringType}, "mcp-resource//create")
+				return evaldo.MakeArgError(ps, 3, []env.Type{env.BlockType, env.FunctionType}, "Rye-mcp-server//Prompt")
+			}
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.prompts = append(s.prompts, prompt)
+			return arg0
+		},
+	},
+
+	// Tests:
+	// equal { mcp/server "t" "1" |Handle `{"jsonrpc":"2.0","id":1,"method":"ping"}` } `{"jsonrpc":"2.0","id":1,"result":{}}`
+	// equal { mcp/server "t" "1" |Handle `{"jsonrpc":"2.0","method":"notifications/initialized"}` |type? } 'void
+	// equal { mcp/server "t" "1" |Handle `{"jsonrpc":"2.0","id":1,"method":"nope"}` } `{"jsonrpc":"2.0","id":1,"error":{"code":-32601,"message":"Method not found: nope"}}`
+	// Args:
+	// * server: MCP server
+	// * message: String with a JSON-RPC message or batch
+	// Returns:
+	// * string with the JSON-RPC response, or void for notifications
+	"Rye-mcp-server//Handle": {
+		Argsn: 2,
+		Doc:   "Answers one JSON-RPC message, for custom transports and testing.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			s, errObj := mcpServerArg(ps, arg0, "Rye-mcp-server//Handle")
+			if errObj != nil {
+				return errObj
+			}
+			msg, ok := arg1.(env.String)
+			if !ok {
+				ps.FailureFlag = true
+				return evaldo.MakeArgError(ps, 2, []env.Type{env.StringType}, "Rye-mcp-server//Handle")
+			}
+			resp := s.handle(ps.GoContext(), []byte(msg.Value))
+			if resp == nil {
+				return env.Void{}
 			}
+			return *env.NewString(string(resp))
 		},
 	},
 
+	// Args:
+	// * server: MCP server
+	// Returns:
+	// * the server, after stdin is closed. While it serves, what Rye code prints goes to stderr.
+	"Rye-mcp-server//Serve-stdio": {
+		Argsn: 1,
+		Doc:   "Serves MCP over stdin and stdout with newline delimited JSON-RPC messages.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			s, errObj := mcpServerArg(ps, arg0, "Rye-mcp-server//Serve-stdio")
+			if errObj != nil {
+				return errObj
+			}
+			// stdout is for the protocol, so the output of the handlers goes to stderr
+			out := os.Stdout
+			os.Stdout = os.Stderr
+			err := s.serveStdio(ps.GoContext(), os.Stdin, out)
+			os.Stdout = out
+			if err != nil {
+				return evaldo.MakeBuiltinError(ps, err.Error(), "Rye-mcp-server//Serve-stdio")
+			}
+			return arg0
+		},
+	},
+
+	// Args:
+	// * server: MCP server
+	// * address: String, address to listen on, like ":8080", the endpoint is /mcp
+	// Returns:
+	// * the server, after the task it runs in is cancelled, or a failure if it can't listen
+	"Rye-mcp-server//Serve-http": {
+		Argsn: 2,
+		Doc:   "Serves MCP over streamable HTTP on the /mcp endpoint.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			s, errObj := mcpServerArg(ps, arg0, "Rye-mcp-server//Serve-http")
+			if errObj != nil {
+				return errObj
+			}
+			addr, ok := arg1.(env.String)
+			if !ok {
+				ps.FailureFlag = true
+				return evaldo.MakeArgError(ps, 2, []env.Type{env.StringType}, "Rye-mcp-server//Serve-http")
+			}
+			mux := http.NewServeMux()
+			mux.Handle("/mcp", s)
+			server := &http.Server{Addr: addr.Value, Handler: mux}
+			done := make(chan struct{})
+			defer close(done)
+			go func() {
+				select {
+				case <-ps.GoContext().Done():
+					server.Close()
+				case <-done:
+				}
+			}()
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				return evaldo.MakeBuiltinError(ps, err.Error(), "Rye-mcp-server//Serve-http")
+			}
+			return arg0
+		},
+	},
+
+	//
+	// ##### MCP compatibility ##### "Builtins of the first version of the MCP module"
+	//
+	// mcp-server//Create makes the same server as mcp/server. The tools, resources and prompts
+	// of the Create-* builtins only hold the names and descriptions that their getters return,
+	// they have no code, so they can't be served. Tool, Resource and Prompt add them to a server.
+	//
+	// Args:
+	// * name: String, the name the server reports to clients
+	// * version: String, the version the server reports to clients
+	// Returns:
+	// * the MCP server
+	"mcp-server//Create": {
+		Argsn: 2,
+		Doc:   "Creates a new MCP server with the given name and version, the same as mcp/server.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			strs, errObj := mcpStringArgs(ps, "mcp-server//Create", arg0, arg1)
+			if errObj != nil {
+				return errObj
+			}
+			s, err := newMcpServer(ps, strs[0], strs[1])
+			if err != nil {
+				return evaldo.MakeBuiltinError(ps, fmt.Sprintf("failed to copy program state: %s", err), "mcp-server//Create")
+			}
+			return *env.NewNative(ps.Idx, s, "Rye-mcp-server")
+		},
+	},
+
+	// Args:
+	// * uri: String, URI of the resource
+	// * name: String, name of the resource
+	// * mime-type: String, MIME type of the content
+	// Returns:
+	// * the description of the resource
+	"mcp//Create-resource": {
+		Argsn: 3,
+		Doc:   "Creates a new MCP resource with the given URI, name, and MIME type.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			strs, errObj := mcpStringArgs(ps, "mcp//Create-resource", arg0, arg1, arg2)
+			if errObj != nil {
+				return errObj
+			}
+			return *env.NewNative(ps.Idx, mcpResource{uri: strs[0], name: strs[1], mimeType: strs[2]}, "Rye-mcp-resource")
+		},
+	},
+
+	// Args:
+	// * name: String, name of the tool
+	// * description: String, description of the tool
+	// Returns:
+	// * the description of the tool
 	"mcp//Create-tool": {
 		Argsn: 2,
 		Doc:   "Creates a new MCP tool with the given name and description.",
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
-			switch name := arg0.(type) {
-			case env.String:
-				switch desc := arg1.(type) {
-				case env.String:
-					// Create a simple object to represent the tool
-					toolInfo := struct {
-						Name        string
-						Description string
-					}{
-						Name:        name.Value,
-						Description: desc.Value,
-					}
-
-					// Return the tool as a native object
-					return *env.NewNative(ps.Idx, toolInfo, "Rye-mcp-tool")
-				default:
-					ps.FailureFlag = true
-					return evaldo.MakeArgError(ps, 2, []env.Type{env.StringType}, "mcp-tool//create")
-				}
-			default:
-				ps.FailureFlag = true
-				return evaldo.MakeArgError(ps, 1, []env.Type{env.StringType}, "mcp-tool//create")
+			strs, errObj := mcpStringArgs(ps, "mcp//Create-tool", arg0, arg1)
+			if errObj != nil {
+				return errObj
 			}
+			return *env.NewNative(ps.Idx, mcpTool{name: strs[0], doc: strs[1]}, "Rye-mcp-tool")
 		},
 	},
 
+	// Args:
+	// * name: String, name of the prompt
+	// * description: String, description of the prompt
+	// Returns:
+	// * the description of the prompt
 	"mcp//Create-prompt": {
 		Argsn: 2,
 		Doc:   "Creates a new MCP prompt with the given name and description.",
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
-			switch name := arg0.(type) {
-			case env.String:
-				switch desc := arg1.(type) {
-				case env.String:
-					// Create a simple object to represent the prompt
-					promptInfo := struct {
-						Name        string
-						Description string
-					}{
-						Name:        name.Value,
-						Description: desc.Value,
-					}
-
-					// Return the prompt as a native object
-					return *env.NewNative(ps.Idx, promptInfo, "Rye-mcp-prompt")
-				default:
-					ps.FailureFlag = true
-					return evaldo.MakeArgError(ps, 2, []env.Type{env.StringType}, "mcp-prompt//create")
-				}
-			default:
-				ps.FailureFlag = true
-				return evaldo.MakeArgError(ps, 1, []env.Type{env.StringType}, "mcp-prompt//create")
+			strs, errObj := mcpStringArgs(ps, "mcp//Create-prompt", arg0, arg1)
+			if errObj != nil {
+				return errObj
 			}
+			return *env.NewNative(ps.Idx, mcpPrompt{name: strs[0], doc: strs[1]}, "Rye-mcp-prompt")
 		},
 	},
 
+	// Args:
+	// Returns:
+	// * the latest MCP protocol version the server speaks
 	"mcp//Protocol-version": {
 		Argsn: 0,
-		Doc:   "Returns the latest MCP protocol version.",
+		Doc:   "Returns the latest MCP protocol version, the same as mcp/protocol-version.",
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
-			return env.String{Value: "2024-11-05"} // Hardcoded latest protocol version
+			return *env.NewString(mcpProtocolVersions[0])
 		},
 	},
 
-	// Server methods
+	// Tests:
+	// equal { mcp/server "scripts" "1.0" |Get-name } "scripts"
+	// Args:
+	// * server: MCP server
+	// Returns:
+	// * the name of the server
 	"Rye-mcp-server//Get-name": {
 		Argsn: 1,
 		Doc:   "Gets the name of the MCP server.",
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
-			switch srv := arg0.(type) {
-			case env.Native:
-				if srv.Type() != env.NativeType {
-					ps.FailureFlag = true
-					return evaldo.MakeBuiltinError(ps, "Expected native type", "Rye-mcp-server//get-name")
-				}
-
-				serverInfo, ok := srv.Value.(struct {
-					Name    string
-					Version string
-				})
+			return mcpGet(ps, arg0, "Rye-mcp-server//Get-name", func(v any) (string, bool) {
+				s, ok := v.(*mcpServer)
 				if !ok {
-					ps.FailureFlag = true
-					return evaldo.MakeBuiltinError(ps, "Failed to cast to server info", "Rye-mcp-server//get-name")
+					return "", false
 				}
-
-				return env.String{Value: serverInfo.Name}
-			default:
-				ps.FailureFlag = true
-				return evaldo.MakeArgError(ps, 1, []env.Type{env.NativeType}, "Rye-mcp-server//get-name")
-			}
+				return s.name, true
+			})
 		},
 	},
 
+	// Tests:
+	// equal { mcp/server "scripts" "1.0" |Get-version } "1.0"
+	// Args:
+	// * server: MCP server
+	// Returns:
+	// * the version of the server
 	"Rye-mcp-server//Get-version": {
 		Argsn: 1,
 		Doc:   "Gets the version of the MCP server.",
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
-			switch srv := arg0.(type) {
-			case env.Native:
-				if srv.Type() != env.NativeType {
-					ps.FailureFlag = true
-					return evaldo.MakeBuiltinError(ps, "Expected native type", "Rye-mcp-server//get-version")
-				}
-
-				serverInfo, ok := srv.Value.(struct {
-					Name    string
-					Version string
-				})
+			return mcpGet(ps, arg0, "Rye-mcp-server//Get-version", func(v any) (string, bool) {
+				s, ok := v.(*mcpServer)
 				if !ok {
-					ps.FailureFlag = true
-					return evaldo.MakeBuiltinError(ps, "Failed to cast to server info", "Rye-mcp-server//get-version")
+					return "", false
 				}
-
-				return env.String{Value: serverInfo.Version}
-			default:
-				ps.FailureFlag = true
-				return evaldo.MakeArgError(ps, 1, []env.Type{env.NativeType}, "Rye-mcp-server//get-version")
-			}
+				return s.version, true
+			})
 		},
 	},
 
-	// Resource methods
+	// Args:
+	// * resource: description of an MCP resource
+	// Returns:
+	// * the URI of the resource
 	"Rye-mcp-resource//Get-uri": {
 		Argsn: 1,
 		Doc:   "Gets the URI of the MCP resource.",
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
-			switch res := arg0.(type) {
-			case env.Native:
-				if res.Type() != env.NativeType {
-					ps.FailureFlag = true
-					return evaldo.MakeBuiltinError(ps, "Expected native type", "Rye-mcp-resource//get-uri")
-				}
-
-				resourceInfo, ok := res.Value.(struct {
-					URI      string
-					Name     string
-					MIMEType string
-				})
-				if !ok {
-					ps.FailureFlag = true
-					return evaldo.MakeBuiltinError(ps, "Failed to cast to resource info", "Rye-mcp-resource//get-uri")
-				}
-
-				return env.String{Value: resourceInfo.URI}
-			default:
-				ps.FailureFlag = true
-				return evaldo.MakeArgError(ps, 1, []env.Type{env.NativeType}, "Rye-mcp-resource//get-uri")
-			}
+			return mcpGet(ps, arg0, "Rye-mcp-resource//Get-uri", func(v any) (string, bool) {
+				r, ok := v.(mcpResource)
+				return r.uri, ok
+			})
 		},
 	},
 
+	// Args:
+	// * resource: description of an MCP resource
+	// Returns:
+	// * the name of the resource
 	"Rye-mcp-resource//Get-name": {
 		Argsn: 1,
 		Doc:   "Gets the name of the MCP resource.",
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
-			switch res := arg0.(type) {
-			case env.Native:
-				if res.Type() != env.NativeType {
-					ps.FailureFlag = true
-					return evaldo.MakeBuiltinError(ps, "Expected native type", "Rye-mcp-resource//get-name")
-				}
-
-				resourceInfo, ok := res.Value.(struct {
-					URI      string
-					Name     string
-					MIMEType string
-				})
-				if !ok {
-					ps.FailureFlag = true
-					return evaldo.MakeBuiltinError(ps, "Failed to cast to resource info", "Rye-mcp-resource//get-name")
-				}
-
-				return env.String{Value: resourceInfo.Name}
-			default:
-				ps.FailureFlag = true
-				return evaldo.MakeArgError(ps, 1, []env.Type{env.NativeType}, "Rye-mcp-resource//get-name")
-			}
+			return mcpGet(ps, arg0, "Rye-mcp-resource//Get-name", func(v any) (string, bool) {
+				r, ok := v.(mcpResource)
+				return r.name, ok
+			})
 		},
 	},
 
+	// Args:
+	// * resource: description of an MCP resource
+	// Returns:
+	// * the MIME type of the resource
 	"Rye-mcp-resource//Get-mime-type": {
 		Argsn: 1,
 		Doc:   "Gets the MIME type of the MCP resource.",
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
-			switch res := arg0.(type) {
-			case env.Native:
-				if res.Type() != env.NativeType {
-					ps.FailureFlag = true
-					return evaldo.MakeBuiltinError(ps, "Expected native type", "Rye-mcp-resource//get-mime-type")
-				}
-
-				resourceInfo, ok := res.Value.(struct {
-					URI      string
-					Name     string
-					MIMEType string
-				})
-				if !ok {
-					ps.FailureFlag = true
-					return evaldo.MakeBuiltinError(ps, "Failed to cast to resource info", "Rye-mcp-resource//get-mime-type")
-				}
-
-				return env.String{Value: resourceInfo.MIMEType}
-			default:
-				ps.FailureFlag = true
-				return evaldo.MakeArgError(ps, 1, []env.Type{env.NativeType}, "Rye-mcp-resource//get-mime-type")
-			}
+			return mcpGet(ps, arg0, "Rye-mcp-resource//Get-mime-type", func(v any) (string, bool) {
+				r, ok := v.(mcpResource)
+				return r.mimeType, ok
+			})
 		},
 	},
 
-	// Tool methods
+	// Args:
+	// * tool: description of an MCP tool
+	// Returns:
+	// * the name of the tool
 	"Rye-mcp-tool//Get-name": {
 		Argsn: 1,
 		Doc:   "Gets the name of the MCP tool.",
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
-			switch tool := arg0.(type) {
-			case env.Native:
-				if tool.Type() != env.NativeType {
-					ps.FailureFlag = true
-					return evaldo.MakeBuiltinError(ps, "Expected native type", "Rye-mcp-tool//get-name")
-				}
-
-				toolInfo, ok := tool.Value.(struct {
-					Name        string
-					Description string
-				})
-				if !ok {
-					ps.FailureFlag = true
-					return evaldo.MakeBuiltinError(ps, "Failed to cast to tool info", "Rye-mcp-tool//get-name")
-				}
-
-				return env.String{Value: toolInfo.Name}
-			default:
-				ps.FailureFlag = true
-				return evaldo.MakeArgError(ps, 1, []env.Type{env.NativeType}, "Rye-mcp-tool//get-name")
-			}
+			return mcpGet(ps, arg0, "Rye-mcp-tool//Get-name", func(v any) (string, bool) {
+				t, ok := v.(mcpTool)
+				return t.name, ok
+			})
 		},
 	},
 
+	// Args:
+	// * tool: description of an MCP tool
+	// Returns:
+	// * the description of the tool
 	"Rye-mcp-tool//Get-description": {
 		Argsn: 1,
 		Doc:   "Gets the description of the MCP tool.",
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
-			switch tool := arg0.(type) {
-			case env.Native:
-				if tool.Type() != env.NativeType {
-					ps.FailureFlag = true
-					return evaldo.MakeBuiltinError(ps, "Expected native type", "Rye-mcp-tool//get-description")
-				}
-
-				toolInfo, ok := tool.Value.(struct {
-					Name        string
-					Description string
-				})
-				if !ok {
-					ps.FailureFlag = true
-					return evaldo.MakeBuiltinError(ps, "Failed to cast to tool info", "Rye-mcp-tool//get-description")
-				}
-
-				return env.String{Value: toolInfo.Description}
-			default:
-				ps.FailureFlag = true
-				return evaldo.MakeArgError(ps, 1, []env.Type{env.NativeType}, "Rye-mcp-tool//get-description")
-			}
+			return mcpGet(ps, arg0, "Rye-mcp-tool//Get-description", func(v any) (string, bool) {
+				t, ok := v.(mcpTool)
+				return t.doc, ok
+			})
 		},
 	},
 
-	// Prompt methods
+	// Args:
+	// * prompt: description of an MCP prompt
+	// Returns:
+	// * the name of the prompt
 	"Rye-mcp-prompt//Get-name": {
 		Argsn: 1,
 		Doc:   "Gets the name of the MCP prompt.",
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
-			switch prompt := arg0.(type) {
-			case env.Native:
-				if prompt.Type() != env.NativeType {
-					ps.FailureFlag = true
-					return evaldo.MakeBuiltinError(ps, "Expected native type", "Rye-mcp-prompt//get-name")
-				}
+			return mcpGet(ps, arg0, "Rye-mcp-prompt//Get-name", func(v any) (string, bool) {
+				p, ok := v.(mcpPrompt)
+				return p.name, ok
+			})
+		},
+	},
 
-				promptInfo, ok := prompt.Value.(struct {
-					Name        string
-					Description string
-				})
-				if !ok {
-					ps.FailureFlag = true
-					return evaldo.MakeBuiltinError(ps, "Failed to cast to prompt info", "Rye-mcp-prompt//get-name")
-				}
+	// Args:
+	// * prompt: description of an MCP prompt
+	// Returns:
+	// * the description of the prompt
+	"Rye-mcp-prompt//Get-description": {
+		Argsn: 1,
+		Doc:   "Gets the description of the MCP prompt.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			return mcpGet(ps, arg0, "Rye-mcp-prompt//Get-description", func(v any) (string, bool) {
+				p, ok := v.(mcpPrompt)
+				return p.doc, ok
+			})
+		},
+	},
 
-				return env.String{Value: promptInfo.Name}
+	//
+	// ##### MCP client ##### "Calling the tools of MCP servers"
+	//
+	// Example:
+	//  c: mcp/connect cmd { npx -y @modelcontextprotocol/server-filesystem /tmp }
+	//  c .Tools? |map { -> "name" } |print
+	//  c .Call "list_directory" dict { "path" "/tmp" } |print
+	//  c .Close
+	//
+	// Tests:
+	// equal { mcp/connect mcp/server "t" "1" |type? } 'native
+	// equal { mcp/connect mcp/server "t" "1" |Server-info? -> "name" } "t"
+	// Args:
+	// * server: Uri of a streamable HTTP endpoint, a command or block with the command of a stdio server, or an MCP server of this program
+	// Returns:
+	// * the MCP client, after the connection is initialized
+	"connect": {
+		Argsn: 1,
+		Doc:   "Connects to an MCP server over HTTP or by starting it as a subprocess that talks over stdio.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			var transport mcpTransport
+			switch target := arg0.(type) {
+			case env.Uri:
+				scheme := target.GetProtocol().Print(*ps.Idx)
+				if scheme != "http" && scheme != "https" {
+					return evaldo.MakeBuiltinError(ps, "URI must be http or https, got "+scheme, "mcp/connect")
+				}
+				transport = &mcpHTTPTransport{url: target.GetFullUri(*ps.Idx), client: &http.Client{}}
+			case env.Block, env.List:
+				// the block is the same as of cmd
+				c := Builtins_cmd["cmd"].Fn(ps, arg0, nil, nil, nil, nil)
+				if ps.FailureFlag || ps.ErrorFlag {
+					return c
+				}
+				return mcpConnectCommand(ps, c.(env.Native).Value.(*command))
+			case env.Native:
+				switch value := target.Value.(type) {
+				case *command:
+					return mcpConnectCommand(ps, value)
+				case *mcpServer:
+					transport = &mcpLocalTransport{server: value}
+				default:
+					return evaldo.MakeBuiltinError(ps, "native must be a command or an MCP server", "mcp/connect")
+				}
 			default:
 				ps.FailureFlag = true
-				return evaldo.MakeArgError(ps, 1, []env.Type{env.NativeType}, "Rye-mcp-prompt//get-name")
+				return evaldo.MakeArgError(ps, 1, []env.Type{env.UriType, env.BlockType, env.NativeType}, "mcp/connect")
 			}
+			return mcpConnect(ps, transport)
 		},
 	},
 
-	"Rye-mcp-prompt//Get-description": {
+	// Tests:
+	// equal { mcp/server "t" "1" |Tool "add" fn { a b "Adds" } { a + b } |mcp/connect |Tools? |first -> "name" } "add"
+	// equal { mcp/server "t" "1" |Tool "add" fn { a b "Adds" } { a + b } |mcp/connect |Tools? |first -> "inputSchema" -> "required" } list { "a" "b" }
+	// Args:
+	// * client: MCP client
+	// Returns:
+	// * list of dicts with the name, description and inputSchema of the tools
+	"Rye-mcp-client//Tools?": {
 		Argsn: 1,
-		Doc:   "Gets the description of the MCP prompt.",
+		Doc:   "Lists the tools of the MCP server.",
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
-			switch prompt := arg0.(type) {
-			case env.Native:
-				if prompt.Type() != env.NativeType {
-					ps.FailureFlag = true
-					return evaldo.MakeBuiltinError(ps, "Expected native type", "Rye-mcp-prompt//get-description")
-				}
+			return mcpClientList(ps, arg0, "tools/list", "tools", "Rye-mcp-client//Tools?")
+		},
+	},
 
-				promptInfo, ok := prompt.Value.(struct {
-					Name        string
-					Description string
-				})
-				if !ok {
-					ps.FailureFlag = true
-					return evaldo.MakeBuiltinError(ps, "Failed to cast to prompt info", "Rye-mcp-prompt//get-description")
-				}
+	// Tests:
+	// equal { mcp/server "t" "1" |Resource "mem://a" "a" "text/plain" { "hi" } |mcp/connect |Resources? |first -> "uri" } "mem://a"
+	// Args:
+	// * client: MCP client
+	// Returns:
+	// * list of dicts with the uri, name and mimeType of the resources
+	"Rye-mcp-client//Resources?": {
+		Argsn: 1,
+		Doc:   "Lists the resources of the MCP server.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			return mcpClientList(ps, arg0, "resources/list", "resources", "Rye-mcp-client//Resources?")
+		},
+	},
+
+	// Tests:
+	// equal { mcp/server "t" "1" |Prompt "greet" fn { who "Greets" } { "Hi " ++ who } |mcp/connect |Prompts? |first -> "arguments" |first -> "name" } "who"
+	// Args:
+	// * client: MCP client
+	// Returns:
+	// * list of dicts with the name, description and arguments of the prompts
+	"Rye-mcp-client//Prompts?": {
+		Argsn: 1,
+		Doc:   "Lists the prompts of the MCP server.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			return mcpClientList(ps, arg0, "prompts/list", "prompts", "Rye-mcp-client//Prompts?")
+		},
+	},
 
-				return env.String{Value: promptInfo.Description}
+	// Tests:
+	// equal { mcp/connect mcp/server "t" "1" |Server-info? -> "protocolVersion" } "2025-06-18"
+	// Args:
+	// * client: MCP client
+	// Returns:
+	// * dict with the name and version of the server, the negotiated protocolVersion and the capabilities
+	"Rye-mcp-client//Server-info?": {
+		Argsn: 1,
+		Doc:   "Returns what the MCP server told about itself when the client connected.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			c, errObj := mcpClientArg(ps, arg0, "Rye-mcp-client//Server-info?")
+			if errObj != nil {
+				return errObj
+			}
+			info := make(map[string]any)
+			for k, v := range c.serverInfo {
+				info[k] = v
+			}
+			info["protocolVersion"] = c.protocolVersion
+			info["capabilities"] = c.capabilities
+			return env.ToRyeValue(info)
+		},
+	},
+
+	// Tests:
+	// equal { mcp/server "t" "1" |Tool "add" fn { a b "Adds" } { a + b } |mcp/connect |Call "add" dict { "a" 1 "b" 2 } } "3"
+	// equal { mcp/server "t" "1" |Tool "hi" fn { "Says hi" } { "hi" } |mcp/connect |Call "hi" dict { } } "hi"
+	// equal { mcp/server "t" "1" |Tool "div" fn { a b "Divides" } { a / b } |mcp/connect |Call "div" dict { "a" 1 "b" 0 } |disarm |type? } 'error
+	// Args:
+	// * client: MCP client
+	// * name: String, name of the tool
+	// * arguments: Dict with the arguments of the tool
+	// Returns:
+	// * the text of the result, the structured content of the result as Rye values if the tool returns it, or a list of the content items. A failure if the tool reports an error.
+	"Rye-mcp-client//Call": {
+		Argsn: 3,
+		Doc:   "Calls a tool of the MCP server.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			c, errObj := mcpClientArg(ps, arg0, "Rye-mcp-client//Call")
+			if errObj != nil {
+				return errObj
+			}
+			name, ok := arg1.(env.String)
+			if !ok {
+				ps.FailureFlag = true
+				return evaldo.MakeArgError(ps, 2, []env.Type{env.StringType}, "Rye-mcp-client//Call")
+			}
+			args, ok := arg2.(env.Dict)
+			if !ok {
+				ps.FailureFlag = true
+				return evaldo.MakeArgError(ps, 3, []env.Type{env.DictType}, "Rye-mcp-client//Call")
+			}
+			params := map[string]any{"name": name.Value, "arguments": json.RawMessage(RyeToJSON(args))}
+			var res struct {
+				Content           []any `json:"content"`
+				StructuredContent any   `json:"structuredContent"`
+				IsError           bool  `json:"isError"`
+			}
+			if err := c.request(ps.GoContext(), "tools/call", params, &res); err != nil {
+				return evaldo.MakeBuiltinError(ps, err.Error(), "Rye-mcp-client//Call")
+			}
+			if res.IsError {
+				return evaldo.MakeBuiltinError(ps, mcpContentText(res.Content), "Rye-mcp-client//Call")
+			}
+			if res.StructuredContent != nil {
+				return env.ToRyeValue(res.StructuredContent)
+			}
+			return mcpContentValue(res.Content)
+		},
+	},
+
+	// Tests:
+	// equal { mcp/server "t" "1" |Resource "mem://a" "a" "text/plain" { "hello" } |mcp/connect |Read "mem://a" } "hello"
+	// equal { mcp/server "t" "1" |mcp/connect |Read "mem://b" |disarm |type? } 'error
+	// Args:
+	// * client: MCP client
+	// * uri: String, URI of the resource
+	// Returns:
+	// * the text of the resource, or a list of dicts if it has many contents or binary ones
+	"Rye-mcp-client//Read": {
+		Argsn: 2,
+		Doc:   "Reads a resource of the MCP server.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			c, errObj := mcpClientArg(ps, arg0, "Rye-mcp-client//Read")
+			if errObj != nil {
+				return errObj
+			}
+			var uri string
+			switch u := arg1.(type) {
+			case env.String:
+				uri = u.Value
+			case env.Uri:
+				uri = u.GetFullUri(*ps.Idx)
 			default:
 				ps.FailureFlag = true
-				return evaldo.MakeArgError(ps, 1, []env.Type{env.NativeType}, "Rye-mcp-prompt//get-description")
+				return evaldo.MakeArgError(ps, 2, []env.Type{env.StringType, env.UriType}, "Rye-mcp-client//Read")
+			}
+			var res struct {
+				Contents []any `json:"contents"`
+			}
+			if err := c.request(ps.GoContext(), "resources/read", map[string]any{"uri": uri}, &res); err != nil {
+				return evaldo.MakeBuiltinError(ps, err.Error(), "Rye-mcp-client//Read")
+			}
+			return mcpContentValue(res.Contents)
+		},
+	},
+
+	// Tests:
+	// equal { mcp/server "t" "1" |Prompt "greet" fn { who "Greets" } { "Hi " ++ who } |mcp/connect |Get-prompt "greet" dict { "who" "Jim" } |first -> "content" -> "text" } "Hi Jim"
+	// Args:
+	// * client: MCP client
+	// * name: String, name of the prompt
+	// * arguments: Dict with the arguments of the prompt
+	// Returns:
+	// * list of the messages of the prompt, dicts with a role and content
+	"Rye-mcp-client//Get-prompt": {
+		Argsn: 3,
+		Doc:   "Gets a prompt of the MCP server filled in with the arguments.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			c, errObj := mcpClientArg(ps, arg0, "Rye-mcp-client//Get-prompt")
+			if errObj != nil {
+				return errObj
+			}
+			name, ok := arg1.(env.String)
+			if !ok {
+				ps.FailureFlag = true
+				return evaldo.MakeArgError(ps, 2, []env.Type{env.StringType}, "Rye-mcp-client//Get-prompt")
+			}
+			args, ok := arg2.(env.Dict)
+			if !ok {
+				ps.FailureFlag = true
+				return evaldo.MakeArgError(ps, 3, []env.Type{env.DictType}, "Rye-mcp-client//Get-prompt")
+			}
+			var res struct {
+				Messages []any `json:"messages"`
+			}
+			params := map[string]any{"name": name.Value, "arguments": json.RawMessage(RyeToJSON(args))}
+			if err := c.request(ps.GoContext(), "prompts/get", params, &res); err != nil {
+				return evaldo.MakeBuiltinError(ps, err.Error(), "Rye-mcp-client//Get-prompt")
+			}
+			return env.ToRyeValue(res.Messages)
+		},
+	},
+
+	// Args:
+	// * client: MCP client
+	// Returns:
+	// * void, after a subprocess server exited
+	"Rye-mcp-client//Close": {
+		Argsn: 1,
+		Doc:   "Closes the connection to the MCP server, a server started as a subprocess is waited for.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			c, errObj := mcpClientArg(ps, arg0, "Rye-mcp-client//Close")
+			if errObj != nil {
+				return errObj
+			}
+			if err := c.transport.close(); err != nil {
+				return evaldo.MakeBuiltinError(ps, err.Error(), "Rye-mcp-client//Close")
 			}
+			return env.Void{}
 		},
 	},
 }
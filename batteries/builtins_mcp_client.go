@@ -0,0 +1,436 @@
+//go:build !no_mcp
+// +build !no_mcp
+
+package batteries
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/refaktor/rye/env"
+	"github.com/refaktor/rye/evaldo"
+)
+
+// mcpTransport sends JSON-RPC messages to an MCP server, call waits for the response with the same id
+type mcpTransport interface {
+	call(ctx context.Context, id int64, msg []byte) (json.RawMessage, error)
+	notify(ctx context.Context, msg []byte) error
+	close() error
+}
+
+// mcpClient talks to an MCP server over one of the transports
+type mcpClient struct {
+	transport       mcpTransport
+	nextID          atomic.Int64
+	protocolVersion string
+	serverInfo      map[string]any
+	capabilities    map[string]any
+}
+
+// request calls a method of the server and decodes the result into v
+func (c *mcpClient) request(ctx context.Context, method string, params any, v any) error {
+	id := c.nextID.Add(1)
+	msg, err := json.Marshal(map[string]any{"jsonrpc": "2.0", "id": id, "method": method, "params": params})
+	if err != nil {
+		return err
+	}
+	result, err := c.transport.call(ctx, id, msg)
+	if err != nil {
+		return err
+	}
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(result, v)
+}
+
+func (c *mcpClient) notify(ctx context.Context, method string) error {
+	msg, err := json.Marshal(map[string]any{"jsonrpc": "2.0", "method": method})
+	if err != nil {
+		return err
+	}
+	return c.transport.notify(ctx, msg)
+}
+
+// initialize negotiates the protocol version and capabilities with the server
+func (c *mcpClient) initialize(ctx context.Context) error {
+	params := map[string]any{
+		"protocolVersion": mcpProtocolVersions[0],
+		"capabilities":    map[string]any{},
+		"clientInfo":      map[string]any{"name": "rye", "version": "1.0"},
+	}
+	var res struct {
+		ProtocolVersion string         `json:"protocolVersion"`
+		Capabilities    map[string]any `json:"capabilities"`
+		ServerInfo      map[string]any `json:"serverInfo"`
+	}
+	if err := c.request(ctx, "initialize", params, &res); err != nil {
+		return err
+	}
+	supported := false
+	for _, v := range mcpProtocolVersions {
+		supported = supported || v == res.ProtocolVersion
+	}
+	if !supported {
+		return fmt.Errorf("unsupported protocol version: %s", res.ProtocolVersion)
+	}
+	c.protocolVersion = res.ProtocolVersion
+	c.capabilities = res.Capabilities
+	c.serverInfo = res.ServerInfo
+	if t, ok := c.transport.(*mcpHTTPTransport); ok {
+		t.protocolVersion = res.ProtocolVersion
+	}
+	return c.notify(ctx, "notifications/initialized")
+}
+
+// list returns all the items of a list method, following the pagination cursors
+func (c *mcpClient) list(ctx context.Context, method string, key string) ([]any, error) {
+	var items []any
+	params := map[string]any{}
+	for {
+		var res map[string]any
+		if err := c.request(ctx, method, params, &res); err != nil {
+			return nil, err
+		}
+		page, _ := res[key].([]any)
+		items = append(items, page...)
+		cursor, _ := res["nextCursor"].(string)
+		if cursor == "" {
+			return items, nil
+		}
+		params = map[string]any{"cursor": cursor}
+	}
+}
+
+// mcpResponse finds the result or error of a JSON-RPC response
+func mcpResponse(msg jsonrpcMessage) (json.RawMessage, error) {
+	if msg.Error != nil {
+		return nil, msg.Error
+	}
+	if msg.Result == nil {
+		return json.RawMessage("null"), nil
+	}
+	return msg.Result, nil
+}
+
+// mcpStdioTransport talks to a server process over its stdin and stdout
+type mcpStdioTransport struct {
+	in      io.WriteCloser
+	command *command
+	mu      sync.Mutex // guards writes to in and pending
+	pending map[string]chan jsonrpcMessage
+	done    chan struct{}
+	err     error
+}
+
+func newMcpStdioTransport(c *command) (*mcpStdioTransport, error) {
+	// the pipes replace the stdin and stdout set by cmd, stderr stays for the server's logs
+	c.cmd.Stdin = nil
+	c.cmd.Stdout = nil
+	in, err := c.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	out, err := c.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.cmd.Start(); err != nil {
+		return nil, err
+	}
+	t := &mcpStdioTransport{in: in, command: c, pending: make(map[string]chan jsonrpcMessage), done: make(chan struct{})}
+	go t.read(out)
+	return t, nil
+}
+
+// read dispatches the messages of the server until it closes its stdout
+func (t *mcpStdioTransport) read(out io.Reader) {
+	scanner := bufio.NewScanner(out)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var msg jsonrpcMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			continue
+		}
+		if msg.Method != "" {
+			if msg.ID != nil {
+				t.answer(msg)
+			}
+			continue
+		}
+		t.mu.Lock()
+		ch, ok := t.pending[string(msg.ID)]
+		delete(t.pending, string(msg.ID))
+		t.mu.Unlock()
+		if ok {
+			ch <- msg
+		}
+	}
+	t.err = scanner.Err()
+	if t.err == nil {
+		t.err = errors.New("server closed the connection")
+	}
+	close(t.done)
+}
+
+// answer replies to the requests of the server, the client supports only ping
+func (t *mcpStdioTransport) answer(msg jsonrpcMessage) {
+	resp := jsonrpcResponse{JSONRPC: "2.0", ID: msg.ID, Result: map[string]any{}}
+	if msg.Method != "ping" {
+		resp = jsonrpcResponse{JSONRPC: "2.0", ID: msg.ID, Error: &jsonrpcError{jsonrpcMethodNotFound, "Method not found: " + msg.Method}}
+	}
+	t.write(mustMarshal(resp))
+}
+
+func (t *mcpStdioTransport) write(msg []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, err := t.in.Write(append(msg, '\n'))
+	return err
+}
+
+func (t *mcpStdioTransport) call(ctx context.Context, id int64, msg []byte) (json.RawMessage, error) {
+	key := fmt.Sprint(id)
+	ch := make(chan jsonrpcMessage, 1)
+	t.mu.Lock()
+	t.pending[key] = ch
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		delete(t.pending, key)
+		t.mu.Unlock()
+	}()
+	if err := t.write(msg); err != nil {
+		return nil, err
+	}
+	select {
+	case resp := <-ch:
+		return mcpResponse(resp)
+	case <-t.done:
+		return nil, t.err
+	case <-ctx.Done():
+		return nil, context.Cause(ctx)
+	}
+}
+
+func (t *mcpStdioTransport) notify(ctx context.Context, msg []byte) error {
+	return t.write(msg)
+}
+
+// close closes the stdin of the server, which should make it exit, and waits for it
+func (t *mcpStdioTransport) close() error {
+	t.in.Close()
+	<-t.done
+	err := t.command.cmd.Wait()
+	if cerr := t.command.Close(); cerr != nil {
+		err = errors.Join(err, cerr)
+	}
+	return err
+}
+
+// mcpHTTPTransport posts the messages to the endpoint of a streamable HTTP server, the responses
+// can come as JSON or as an SSE stream
+type mcpHTTPTransport struct {
+	url             string
+	client          *http.Client
+	mu              sync.Mutex
+	sessionID       string
+	protocolVersion string
+}
+
+func (t *mcpHTTPTransport) post(ctx context.Context, msg []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(msg))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	t.mu.Lock()
+	if t.sessionID != "" {
+		req.Header.Set("Mcp-Session-Id", t.sessionID)
+	}
+	if t.protocolVersion != "" {
+		req.Header.Set("MCP-Protocol-Version", t.protocolVersion)
+	}
+	t.mu.Unlock()
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if id := resp.Header.Get("Mcp-Session-Id"); id != "" {
+		t.mu.Lock()
+		t.sessionID = id
+		t.mu.Unlock()
+	}
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+func (t *mcpHTTPTransport) call(ctx context.Context, id int64, msg []byte) (json.RawMessage, error) {
+	resp, err := t.post(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	key := fmt.Sprint(id)
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		// the events until the response can be notifications and requests of the server
+		var data strings.Builder
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if strings.HasPrefix(line, "data:") {
+				data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+				continue
+			}
+			if line != "" || data.Len() == 0 {
+				continue
+			}
+			var msg jsonrpcMessage
+			if err := json.Unmarshal([]byte(data.String()), &msg); err == nil && msg.Method == "" && string(msg.ID) == key {
+				return mcpResponse(msg)
+			}
+			data.Reset()
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("event stream ended without a response")
+	}
+	var res jsonrpcMessage
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, err
+	}
+	return mcpResponse(res)
+}
+
+func (t *mcpHTTPTransport) notify(ctx context.Context, msg []byte) error {
+	resp, err := t.post(ctx, msg)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// close ends the session, servers that don't keep sessions can refuse it
+func (t *mcpHTTPTransport) close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.sessionID == "" {
+		return nil
+	}
+	req, err := http.NewRequest(http.MethodDelete, t.url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Mcp-Session-Id", t.sessionID)
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	t.sessionID = ""
+	return resp.Body.Close()
+}
+
+// mcpLocalTransport calls an MCP server of the same program directly
+type mcpLocalTransport struct {
+	server *mcpServer
+}
+
+func (t *mcpLocalTransport) call(ctx context.Context, id int64, msg []byte) (json.RawMessage, error) {
+	var res jsonrpcMessage
+	if err := json.Unmarshal(t.server.handle(ctx, msg), &res); err != nil {
+		return nil, err
+	}
+	return mcpResponse(res)
+}
+
+func (t *mcpLocalTransport) notify(ctx context.Context, msg []byte) error {
+	t.server.handle(ctx, msg)
+	return nil
+}
+
+func (t *mcpLocalTransport) close() error {
+	return nil
+}
+
+func mcpConnect(ps *env.ProgramState, transport mcpTransport) env.Object {
+	c := &mcpClient{transport: transport}
+	if err := c.initialize(ps.GoContext()); err != nil {
+		transport.close()
+		return evaldo.MakeBuiltinError(ps, fmt.Sprintf("failed to initialize: %s", err), "mcp/connect")
+	}
+	return *env.NewNative(ps.Idx, c, "Rye-mcp-client")
+}
+
+func mcpConnectCommand(ps *env.ProgramState, c *command) env.Object {
+	transport, err := newMcpStdioTransport(c)
+	if err != nil {
+		return evaldo.MakeBuiltinError(ps, fmt.Sprintf("failed to start the server: %s", err), "mcp/connect")
+	}
+	return mcpConnect(ps, transport)
+}
+
+func mcpClientArg(ps *env.ProgramState, arg env.Object, fnName string) (*mcpClient, env.Object) {
+	if native, ok := arg.(env.Native); ok {
+		if c, ok := native.Value.(*mcpClient); ok {
+			return c, nil
+		}
+	}
+	ps.FailureFlag = true
+	return nil, evaldo.MakeArgError(ps, 1, []env.Type{env.NativeType}, fnName)
+}
+
+func mcpClientList(ps *env.ProgramState, arg env.Object, method string, key string, fnName string) env.Object {
+	c, errObj := mcpClientArg(ps, arg, fnName)
+	if errObj != nil {
+		return errObj
+	}
+	items, err := c.list(ps.GoContext(), method, key)
+	if err != nil {
+		return evaldo.MakeBuiltinError(ps, err.Error(), fnName)
+	}
+	return *env.NewList(items)
+}
+
+// mcpContentText joins the text items of a content list
+func mcpContentText(content []any) string {
+	var texts []string
+	for _, item := range content {
+		if m, ok := item.(map[string]any); ok {
+			if text, ok := m["text"].(string); ok {
+				texts = append(texts, text)
+			}
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// mcpContentValue returns the text of content with a single text item, otherwise the list of items
+func mcpContentValue(content []any) env.Object {
+	if len(content) == 1 {
+		if m, ok := content[0].(map[string]any); ok {
+			if text, ok := m["text"].(string); ok {
+				return *env.NewString(text)
+			}
+		}
+	}
+	if content == nil {
+		content = []any{}
+	}
+	return *env.NewList(content)
+}
@@ -0,0 +1,212 @@
+//go:build !no_mcp
+// +build !no_mcp
+
+package batteries
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/refaktor/rye/env"
+)
+
+// mcpTestServer evaluates the code with the server set to s and returns the server
+func mcpTestServer(t *testing.T, code string) *mcpServer {
+	t.Helper()
+	ps := testState()
+	return testEval(t, ps, `s: mcp/server "test" "1.0" `+code+` , s`, false).(env.Native).Value.(*mcpServer)
+}
+
+// mcpDecode decodes a response of the server
+func mcpDecode(t *testing.T, data []byte) (result map[string]any, rpcErr *jsonrpcError) {
+	t.Helper()
+	var resp struct {
+		JSONRPC string          `json:"jsonrpc"`
+		ID      json.RawMessage `json:"id"`
+		Result  map[string]any  `json:"result"`
+		Error   *jsonrpcError   `json:"error"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		t.Fatalf("%s: %v", data, err)
+	}
+	if resp.JSONRPC != "2.0" {
+		t.Errorf("expected a JSON-RPC 2.0 response, got %s", data)
+	}
+	return resp.Result, resp.Error
+}
+
+func TestMcpServerProtocol(t *testing.T) {
+	s := mcpTestServer(t, `
+s .Tool "add" fn { a b "Adds" } { a + b }
+s .Tool "greet" fn { who "Greets" } { "Hi " ++ who }
+s .Tool "broken" fn { "Fails" } { fail "it broke" }
+s .Resource "mem://a" "a" "text/plain" { "hello" }
+s .Prompt "review" fn { code "Reviews" } { "Review " ++ code }
+`)
+	ctx := context.Background()
+
+	// the version the client asks for is used if the server speaks it, otherwise the latest
+	for asked, want := range map[string]string{"2024-11-05": "2024-11-05", "1999-01-01": mcpProtocolVersions[0]} {
+		res, rpcErr := mcpDecode(t, s.handle(ctx, []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"`+asked+`","capabilities":{},"clientInfo":{"name":"c","version":"1"}}}`)))
+		if rpcErr != nil || res["protocolVersion"] != want {
+			t.Errorf("%s: expected version %s, got %v %v", asked, want, res, rpcErr)
+		}
+		if info := res["serverInfo"].(map[string]any); info["name"] != "test" || info["version"] != "1.0" {
+			t.Errorf("unexpected server info %v", info)
+		}
+	}
+	if resp := s.handle(ctx, []byte(`{"jsonrpc":"2.0","method":"notifications/initialized"}`)); resp != nil {
+		t.Errorf("expected no response to a notification, got %s", resp)
+	}
+
+	res, _ := mcpDecode(t, s.handle(ctx, []byte(`{"jsonrpc":"2.0","id":2,"method":"tools/list"}`)))
+	if tools := res["tools"].([]any); len(tools) != 3 || tools[0].(map[string]any)["name"] != "add" {
+		t.Errorf("unexpected tools %v", tools)
+	}
+	for call, want := range map[string]string{
+		`"name":"add","arguments":{"a":1,"b":2}`:  "3",
+		`"name":"greet","arguments":{"who":"Jo"}`: "Hi Jo",
+	} {
+		res, rpcErr := mcpDecode(t, s.handle(ctx, []byte(`{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{`+call+`}}`)))
+		if rpcErr != nil || res["isError"] != false || res["content"].([]any)[0].(map[string]any)["text"] != want {
+			t.Errorf("%s: expected %q, got %v %v", call, want, res, rpcErr)
+		}
+	}
+	// a tool that fails is a result with isError, not a protocol error
+	res, rpcErr := mcpDecode(t, s.handle(ctx, []byte(`{"jsonrpc":"2.0","id":4,"method":"tools/call","params":{"name":"broken","arguments":{}}}`)))
+	if rpcErr != nil || res["isError"] != true || !strings.Contains(res["content"].([]any)[0].(map[string]any)["text"].(string), "it broke") {
+		t.Errorf("expected the failure of the tool, got %v %v", res, rpcErr)
+	}
+
+	res, _ = mcpDecode(t, s.handle(ctx, []byte(`{"jsonrpc":"2.0","id":5,"method":"resources/read","params":{"uri":"mem://a"}}`)))
+	if text := res["contents"].([]any)[0].(map[string]any)["text"]; text != "hello" {
+		t.Errorf("expected the resource, got %v", res)
+	}
+	res, _ = mcpDecode(t, s.handle(ctx, []byte(`{"jsonrpc":"2.0","id":6,"method":"prompts/get","params":{"name":"review","arguments":{"code":"x"}}}`)))
+	if msg := res["messages"].([]any)[0].(map[string]any); msg["content"].(map[string]any)["text"] != "Review x" {
+		t.Errorf("expected the prompt, got %v", res)
+	}
+
+	errors := map[string]int{
+		`{"jsonrpc":"2.0","id":7,"method":"nope"}`:                                                   jsonrpcMethodNotFound,
+		`{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"name":"missing"}}`:                 jsonrpcInvalidParams,
+		`{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"name":"add","arguments":{"a":1}}}`: jsonrpcInvalidParams,
+		`{"jsonrpc":"2.0","id":7,"method":"tools/call","params":"add"}`:                              jsonrpcInvalidParams,
+		`{"jsonrpc":"2.0","id":7,"method":"resources/read","params":{"uri":"mem://b"}}`:              mcpResourceNotFound,
+		`{"jsonrpc":"2.0","id":7,"method":"prompts/get","params":{"name":"review","arguments":{}}}`:  jsonrpcInvalidParams,
+		`{"jsonrpc":"2.0","id":7}`: jsonrpcInvalidRequest,
+		`{"jsonrpc":"2.0","id":7,`: jsonrpcParseError,
+	}
+	for msg, code := range errors {
+		if _, rpcErr := mcpDecode(t, s.handle(ctx, []byte(msg))); rpcErr == nil || rpcErr.Code != code {
+			t.Errorf("%s: expected error %d, got %v", msg, code, rpcErr)
+		}
+	}
+
+	// a batch is answered with the responses to its requests, notifications have none
+	var batch []json.RawMessage
+	resp := s.handle(ctx, []byte(`[{"jsonrpc":"2.0","id":1,"method":"ping"},{"jsonrpc":"2.0","method":"notifications/initialized"},{"jsonrpc":"2.0","id":2,"method":"nope"}]`))
+	if err := json.Unmarshal(resp, &batch); err != nil || len(batch) != 2 {
+		t.Errorf("expected two responses, got %s", resp)
+	}
+	if resp := s.handle(ctx, []byte(`[{"jsonrpc":"2.0","method":"notifications/initialized"}]`)); resp != nil {
+		t.Errorf("expected no response to a batch of notifications, got %s", resp)
+	}
+}
+
+func TestMcpServerTransports(t *testing.T) {
+	s := mcpTestServer(t, `s .Tool "add" fn { a b "Adds" } { a + b }`)
+
+	// stdio: newline delimited messages, empty lines and notifications get no response
+	var out bytes.Buffer
+	in := strings.NewReader("{\"jsonrpc\":\"2.0\",\"id\":1,\"method\":\"ping\"}\n\n{\"jsonrpc\":\"2.0\",\"method\":\"notifications/initialized\"}\n{\"jsonrpc\":\"2.0\",\"id\":2,\"method\":\"tools/list\"}\n")
+	if err := s.serveStdio(context.Background(), in, &out); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `"id":1`) || !strings.Contains(lines[1], `"add"`) {
+		t.Errorf("unexpected responses %q", lines)
+	}
+
+	// streamable HTTP: POST only, notifications are accepted, pages of other hosts are refused
+	post := func(body string, origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "http://127.0.0.1:8080/mcp", strings.NewReader(body))
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec
+	}
+	rec := post(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"add","arguments":{"a":2,"b":3}}}`, "")
+	if rec.Code != 200 || rec.Header().Get("Content-Type") != "application/json" || !strings.Contains(rec.Body.String(), `"text":"5"`) {
+		t.Errorf("unexpected response %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := post(`{"jsonrpc":"2.0","method":"notifications/initialized"}`, ""); rec.Code != http.StatusAccepted {
+		t.Errorf("expected 202 for a notification, got %d", rec.Code)
+	}
+	if rec := post(`{"jsonrpc":"2.0","id":1,"method":"ping"}`, "http://localhost:3000"); rec.Code != 200 {
+		t.Errorf("expected a local origin to be allowed, got %d", rec.Code)
+	}
+	if rec := post(`{"jsonrpc":"2.0","id":1,"method":"ping"}`, "https://evil.example"); rec.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for another origin, got %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("GET", "http://127.0.0.1:8080/mcp", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405 for GET, got %d", rec.Code)
+	}
+}
+
+func TestMcpCompatibilityBuiltins(t *testing.T) {
+	ps := env.NewProgramState()
+	call := func(name string, args ...env.Object) env.Object {
+		for len(args) < 5 {
+			args = append(args, nil)
+		}
+		return Builtins_mcp[name].Fn(ps, args[0], args[1], args[2], args[3], args[4])
+	}
+	str := func(s string) env.Object { return *env.NewString(s) }
+	get := func(getter string, obj env.Object) string {
+		res, ok := call(getter, obj).(env.String)
+		if !ok {
+			t.Fatalf("%s: expected a string, got %v", getter, res)
+		}
+		return res.Value
+	}
+
+	srv := call("mcp-server//Create", str("old"), str("0.1"))
+	if _, ok := srv.(env.Native).Value.(*mcpServer); !ok || get("Rye-mcp-server//Get-name", srv) != "old" || get("Rye-mcp-server//Get-version", srv) != "0.1" {
+		t.Errorf("unexpected server %v", srv)
+	}
+	tool := call("mcp//Create-tool", str("add"), str("Adds"))
+	if get("Rye-mcp-tool//Get-name", tool) != "add" || get("Rye-mcp-tool//Get-description", tool) != "Adds" {
+		t.Error("unexpected tool")
+	}
+	res := call("mcp//Create-resource", str("mem://a"), str("a"), str("text/plain"))
+	if get("Rye-mcp-resource//Get-uri", res) != "mem://a" || get("Rye-mcp-resource//Get-name", res) != "a" || get("Rye-mcp-resource//Get-mime-type", res) != "text/plain" {
+		t.Error("unexpected resource")
+	}
+	prompt := call("mcp//Create-prompt", str("review"), str("Reviews"))
+	if get("Rye-mcp-prompt//Get-name", prompt) != "review" || get("Rye-mcp-prompt//Get-description", prompt) != "Reviews" {
+		t.Error("unexpected prompt")
+	}
+	if get("mcp//Protocol-version", nil) != mcpProtocolVersions[0] {
+		t.Error("unexpected protocol version")
+	}
+
+	// a getter of one kind doesn't read another
+	ps.FailureFlag = false
+	if _, ok := call("Rye-mcp-tool//Get-name", res).(*env.Error); !ok || !ps.FailureFlag {
+		t.Error("expected a failure for a resource given to a tool getter")
+	}
+	ps.FailureFlag = false
+	if _, ok := call("mcp//Create-tool", str("add"), *env.NewInteger(1)).(*env.Error); !ok || !ps.FailureFlag {
+		t.Error("expected a failure for a description that isn't a string")
+	}
+}
@@ -1903,7 +1903,9 @@ var Builtins_os = map[string]*env.Builtin{
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
 			switch seconds := arg0.(type) {
 			case env.Integer:
-				time.Sleep(time.Duration(seconds.Value) * time.Second)
+				if err := evaldo.SleepTask(ps, time.Duration(seconds.Value)*time.Second); err != nil {
+					return evaldo.MakeBuiltinError(ps, "Sleep cancelled: "+err.Error(), "sleep")
+				}
 				return arg0
 			default:
 				return evaldo.MakeArgError(ps, 1, []env.Type{env.IntegerType}, "sleep")
@@ -0,0 +1,542 @@
+//go:build add_parquet
+// +build add_parquet
+
+package batteries
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet"
+	"github.com/apache/arrow-go/v18/parquet/compress"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+
+	"github.com/refaktor/rye/env"
+	"github.com/refaktor/rye/evaldo"
+)
+
+// Parquet and Arrow IPC files are columnar, each column of a table gets one type:
+//
+//	Integer -> int64        Decimal -> float64     String -> utf8
+//	Boolean -> bool         Date    -> date32      Time   -> timestamp[ns]
+//
+// Times keep their time zone: times in the local zone are saved as wall clock times (a timestamp
+// without a zone), other zones by name and a column of times in different zones as UTC.
+// Void values are nulls. A column of integers and decimals is saved as float64. When loading,
+// other integer and floating point types, decimals, dictionaries, large strings and binary
+// columns are also read.
+
+// arrowBatchRows is the number of rows written in one record batch (row group in parquet)
+const arrowBatchRows = 64 * 1024
+
+// tableToArrowSchema returns the schema of the table's columns from the types of their values
+func tableToArrowSchema(spr *env.Table) (*arrow.Schema, error) {
+	fields := make([]arrow.Field, len(spr.Cols))
+	for c, col := range spr.Cols {
+		var typ arrow.DataType
+		for _, row := range spr.Rows {
+			if c >= len(row.Values) {
+				continue
+			}
+			var vt arrow.DataType
+			switch row.Values[c].(type) {
+			case env.Integer, int64, int:
+				vt = arrow.PrimitiveTypes.Int64
+			case env.Decimal, float64:
+				vt = arrow.PrimitiveTypes.Float64
+			case env.String, string:
+				vt = arrow.BinaryTypes.String
+			case env.Boolean, bool:
+				vt = arrow.FixedWidthTypes.Boolean
+			case env.Date:
+				vt = arrow.FixedWidthTypes.Date32
+			case env.Time:
+				vt = &arrow.TimestampType{Unit: arrow.Nanosecond, TimeZone: arrowTimeZone(row.Values[c].(env.Time).Value.Location())}
+			case env.Void, nil:
+				continue
+			default:
+				return nil, fmt.Errorf("column %s has a value of unsupported type %T", col, row.Values[c])
+			}
+			switch {
+			case typ == nil:
+				typ = vt
+			case arrow.TypeEqual(typ, vt):
+			case typ.ID() == arrow.TIMESTAMP && vt.ID() == arrow.TIMESTAMP:
+				typ = &arrow.TimestampType{Unit: arrow.Nanosecond, TimeZone: "UTC"}
+			case typ.ID() == arrow.INT64 && vt.ID() == arrow.FLOAT64:
+				typ = vt
+			case typ.ID() == arrow.FLOAT64 && vt.ID() == arrow.INT64:
+			default:
+				return nil, fmt.Errorf("column %s mixes %s and %s values", col, typ, vt)
+			}
+		}
+		if typ == nil {
+			typ = arrow.BinaryTypes.String
+		}
+		fields[c] = arrow.Field{Name: col, Type: typ, Nullable: true}
+	}
+	return arrow.NewSchema(fields, nil), nil
+}
+
+// arrowTimeZone returns the zone of a timestamp column for times in the location, empty for local times
+func arrowTimeZone(loc *time.Location) string {
+	switch loc {
+	case time.Local:
+		return ""
+	case time.UTC:
+		return "UTC"
+	default:
+		return loc.String()
+	}
+}
+
+// arrowDate32 returns the days since the epoch to the time's calendar date, in the time's zone
+func arrowDate32(t time.Time) arrow.Date32 {
+	return arrow.Date32FromTime(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC))
+}
+
+// arrowTimestamp returns the timestamp of a time, for a column without a zone it's the local wall clock time
+func arrowTimestamp(t time.Time, typ *arrow.TimestampType) arrow.Timestamp {
+	if typ.TimeZone == "" {
+		t = t.In(time.Local)
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	}
+	return arrow.Timestamp(t.UnixNano())
+}
+
+// arrowTime returns the time of a timestamp, in the zone of the column
+func arrowTime(ts arrow.Timestamp, typ *arrow.TimestampType) time.Time {
+	t := ts.ToTime(typ.Unit)
+	if typ.TimeZone == "" {
+		// a timestamp without a zone is a wall clock time, it's read as local time
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.Local)
+	}
+	if loc, err := typ.GetZone(); err == nil {
+		return t.In(loc)
+	}
+	return t
+}
+
+func appendArrowValue(b array.Builder, val any) {
+	switch v := val.(type) {
+	case env.Void, nil:
+		b.AppendNull()
+		return
+	case env.Integer:
+		val = v.Value
+	case int:
+		val = int64(v)
+	case env.Decimal:
+		val = v.Value
+	case env.String:
+		val = v.Value
+	case env.Boolean:
+		val = v.Value
+	}
+	switch b := b.(type) {
+	case *array.Int64Builder:
+		b.Append(val.(int64))
+	case *array.Float64Builder:
+		switch v := val.(type) {
+		case int64:
+			b.Append(float64(v))
+		case float64:
+			b.Append(v)
+		}
+	case *array.StringBuilder:
+		b.Append(val.(string))
+	case *array.BooleanBuilder:
+		b.Append(val.(bool))
+	case *array.Date32Builder:
+		b.Append(arrowDate32(val.(env.Date).Value))
+	case *array.TimestampBuilder:
+		b.Append(arrowTimestamp(val.(env.Time).Value, b.Type().(*arrow.TimestampType)))
+	}
+}
+
+// tableToArrowRecords calls write with the rows of the table in record batches
+func tableToArrowRecords(spr *env.Table, schema *arrow.Schema, write func(rec arrow.RecordBatch) error) error {
+	b := array.NewRecordBuilder(memory.DefaultAllocator, schema)
+	defer b.Release()
+	for start := 0; start < len(spr.Rows) || start == 0; start += arrowBatchRows {
+		end := min(start+arrowBatchRows, len(spr.Rows))
+		for _, row := range spr.Rows[start:end] {
+			for c := range spr.Cols {
+				var val any
+				if c < len(row.Values) {
+					val = row.Values[c]
+				}
+				appendArrowValue(b.Field(c), val)
+			}
+		}
+		rec := b.NewRecordBatch()
+		err := write(rec)
+		rec.Release()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// arrowValue returns the Rye value of the i-th element of an arrow array
+func arrowValue(arr arrow.Array, i int) env.Object {
+	if arr.IsNull(i) {
+		return *env.NewVoid()
+	}
+	switch a := arr.(type) {
+	case *array.Int8:
+		return *env.NewInteger(int64(a.Value(i)))
+	case *array.Int16:
+		return *env.NewInteger(int64(a.Value(i)))
+	case *array.Int32:
+		return *env.NewInteger(int64(a.Value(i)))
+	case *array.Int64:
+		return *env.NewInteger(a.Value(i))
+	case *array.Uint8:
+		return *env.NewInteger(int64(a.Value(i)))
+	case *array.Uint16:
+		return *env.NewInteger(int64(a.Value(i)))
+	case *array.Uint32:
+		return *env.NewInteger(int64(a.Value(i)))
+	case *array.Uint64:
+		return *env.NewInteger(int64(a.Value(i)))
+	case *array.Float16:
+		return *env.NewDecimal(float64(a.Value(i).Float32()))
+	case *array.Float32:
+		return *env.NewDecimal(float64(a.Value(i)))
+	case *array.Float64:
+		return *env.NewDecimal(a.Value(i))
+	case *array.Decimal128:
+		return *env.NewDecimal(a.Value(i).ToFloat64(a.DataType().(*arrow.Decimal128Type).Scale))
+	case *array.Decimal256:
+		return *env.NewDecimal(a.Value(i).ToFloat64(a.DataType().(*arrow.Decimal256Type).Scale))
+	case *array.String:
+		return *env.NewString(a.Value(i))
+	case *array.LargeString:
+		return *env.NewString(a.Value(i))
+	case *array.StringView:
+		return *env.NewString(a.Value(i))
+	case *array.Binary:
+		return *env.NewBytes(bytes.Clone(a.Value(i)))
+	case *array.LargeBinary:
+		return *env.NewBytes(bytes.Clone(a.Value(i)))
+	case *array.Boolean:
+		return *env.NewBoolean(a.Value(i))
+	case *array.Date32:
+		return *env.NewDate(a.Value(i).ToTime())
+	case *array.Date64:
+		return *env.NewDate(a.Value(i).ToTime())
+	case *array.Timestamp:
+		return *env.NewTime(arrowTime(a.Value(i), a.DataType().(*arrow.TimestampType)))
+	case *array.Dictionary:
+		return arrowValue(a.Dictionary(), a.GetValueIndex(i))
+	default:
+		return *env.NewString(arr.ValueStr(i))
+	}
+}
+
+// appendArrowRecord adds the rows of the record batch to the table
+func appendArrowRecord(spr *env.Table, rec arrow.RecordBatch) {
+	cols := rec.Columns()
+	for i := 0; i < int(rec.NumRows()); i++ {
+		vals := make([]any, len(cols))
+		for c, col := range cols {
+			vals[c] = arrowValue(col, i)
+		}
+		spr.AddRow(*env.NewTableRow(vals, spr))
+	}
+}
+
+func arrowSchemaColumns(schema *arrow.Schema) []string {
+	cols := make([]string, schema.NumFields())
+	for i, f := range schema.Fields() {
+		cols[i] = f.Name
+	}
+	return cols
+}
+
+// LoadParquet reads a parquet file into a table
+func LoadParquet(path string) (*env.Table, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	mem := memory.DefaultAllocator
+	tbl, err := pqarrow.ReadTable(context.Background(), f, parquet.NewReaderProperties(mem), pqarrow.ArrowReadProperties{}, mem)
+	if err != nil {
+		return nil, err
+	}
+	defer tbl.Release()
+	spr := env.NewTable(arrowSchemaColumns(tbl.Schema()))
+	tr := array.NewTableReader(tbl, arrowBatchRows)
+	defer tr.Release()
+	for tr.Next() {
+		appendArrowRecord(spr, tr.RecordBatch())
+	}
+	return spr, nil
+}
+
+// SaveParquet writes the table to a snappy compressed parquet file
+func SaveParquet(spr *env.Table, path string) error {
+	schema, err := tableToArrowSchema(spr)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	props := parquet.NewWriterProperties(parquet.WithCompression(compress.Codecs.Snappy))
+	w, err := pqarrow.NewFileWriter(schema, f, props, pqarrow.NewArrowWriterProperties(pqarrow.WithStoreSchema()))
+	if err != nil {
+		return err
+	}
+	err = tableToArrowRecords(spr, schema, func(rec arrow.RecordBatch) error {
+		return w.Write(rec)
+	})
+	if err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// LoadArrow reads an Arrow IPC file, in the file (Feather v2) or the stream format
+func LoadArrow(path string) (*env.Table, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	magic := make([]byte, 6)
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return nil, fmt.Errorf("not an arrow file: %w", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var schema *arrow.Schema
+	var read func() (arrow.RecordBatch, error)
+	if string(magic) == "ARROW1" {
+		r, err := ipc.NewFileReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		schema, read = r.Schema(), r.Read
+	} else {
+		r, err := ipc.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Release()
+		schema, read = r.Schema(), r.Read
+	}
+	spr := env.NewTable(arrowSchemaColumns(schema))
+	for {
+		rec, err := read()
+		if err == io.EOF {
+			return spr, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		appendArrowRecord(spr, rec)
+	}
+}
+
+// SaveArrow writes the table to an Arrow IPC file (Feather v2)
+func SaveArrow(spr *env.Table, path string) error {
+	schema, err := tableToArrowSchema(spr)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w, err := ipc.NewFileWriter(f, ipc.WithSchema(schema))
+	if err != nil {
+		return err
+	}
+	err = tableToArrowRecords(spr, schema, func(rec arrow.RecordBatch) error {
+		return w.Write(rec)
+	})
+	if err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// tableArg returns the table of an argument, lazy tables are materialized
+func tableArg(ps *env.ProgramState, arg env.Object, argn int, fnName string) (*env.Table, env.Object) {
+	switch spr := arg.(type) {
+	case env.Table:
+		return &spr, nil
+	case *env.Table:
+		return spr, nil
+	case *env.LazyTable:
+		t, err := spr.Materialize()
+		if err != nil {
+			return nil, evaldo.MakeBuiltinError(ps, err.Error(), fnName)
+		}
+		return t, nil
+	default:
+		return nil, evaldo.MakeArgError(ps, argn, []env.Type{env.TableType, env.LazyTableType}, fnName)
+	}
+}
+
+var Builtins_parquet = map[string]*env.Builtin{
+
+	//
+	// ##### Parquet and Arrow ##### "Loading and saving tables in columnar formats"
+	//
+	// Example:
+	//  Load\parquet %sales.parquet |where-greater 'amount 100.0
+	// Tests:
+	//  equal {
+	//	 cc os
+	//   f:: mktmp ++ "/test.parquet"
+	//   spr1:: table { "a" "b" "c" } { 1 1.1 "a" 2 2.2 "b" 3 _ "c" }
+	//   f .Save\parquet spr1
+	//   spr2:: Load\parquet f
+	//   spr1 = spr2
+	//  } true
+	//  equal {
+	//	 cc os
+	//   f:: mktmp ++ "/types.parquet"
+	//   spr1:: table { "t" "b" } { } |add-row [ now true ] |add-row [ _ false ]
+	//   f .Save\parquet spr1
+	//   spr1 = Load\parquet f
+	//  } true
+	// Args:
+	// * file-uri - location of the parquet file
+	// Returns:
+	// * table with Integer, Decimal, String, Boolean, Date and Time values, nulls are void
+	// Tags: #table #loading #parquet
+	"file-uri//Load\\parquet": {
+		Argsn: 1,
+		Doc:   "Loads a .parquet file to a table.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			switch file := arg0.(type) {
+			case env.Uri:
+				spr, err := LoadParquet(file.GetPath())
+				if err != nil {
+					return evaldo.MakeBuiltinError(ps, "Unable to load parquet file: "+err.Error(), "Load\\parquet")
+				}
+				return *spr
+			default:
+				return evaldo.MakeArgError(ps, 1, []env.Type{env.UriType}, "Load\\parquet")
+			}
+		},
+	},
+
+	// Example:
+	//  Load\csv %sales.csv |autotype 1.0 |Save\parquet* %sales.parquet
+	// Tests:
+	//  equal {
+	//	 cc os
+	//   f:: mktmp ++ "/test2.parquet"
+	//   spr1:: table { "a" "b" } { 1 "x" 2 "y" }
+	//   f .Save\parquet spr1 |length?
+	//  } 2
+	// Args:
+	// * file-uri - where to save the table as a .parquet file
+	// * table - the table to save, every column must have values of one type (integers and decimals can be mixed)
+	// Returns:
+	// * the table
+	// Tags: #table #saving #parquet
+	"file-uri//Save\\parquet": {
+		Argsn: 2,
+		Doc:   "Saves a table to a snappy compressed .parquet file.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			switch file := arg0.(type) {
+			case env.Uri:
+				spr, errObj := tableArg(ps, arg1, 2, "Save\\parquet")
+				if errObj != nil {
+					return errObj
+				}
+				if err := SaveParquet(spr, file.GetPath()); err != nil {
+					return evaldo.MakeBuiltinError(ps, "Unable to save parquet file: "+err.Error(), "Save\\parquet")
+				}
+				return *spr
+			default:
+				return evaldo.MakeArgError(ps, 1, []env.Type{env.UriType}, "Save\\parquet")
+			}
+		},
+	},
+
+	// Example:
+	//  Load\arrow %events.arrow |group-by 'kind { 'id count }
+	// Tests:
+	//  equal {
+	//	 cc os
+	//   f:: mktmp ++ "/test.arrow"
+	//   spr1:: table { "a" "b" "c" } { 1 1.1 "a" 2 2 "b" 3 3.3 _ }
+	//   f .Save\arrow spr1
+	//   Load\arrow f |column? 'b
+	//  } { 1.1 2.0 3.3 }
+	// Args:
+	// * file-uri - location of the Arrow IPC file, in the file (Feather v2) or the stream format
+	// Returns:
+	// * table with Integer, Decimal, String, Boolean, Date and Time values, nulls are void
+	// Tags: #table #loading #arrow
+	"file-uri//Load\\arrow": {
+		Argsn: 1,
+		Doc:   "Loads an Arrow IPC file to a table.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			switch file := arg0.(type) {
+			case env.Uri:
+				spr, err := LoadArrow(file.GetPath())
+				if err != nil {
+					return evaldo.MakeBuiltinError(ps, "Unable to load arrow file: "+err.Error(), "Load\\arrow")
+				}
+				return *spr
+			default:
+				return evaldo.MakeArgError(ps, 1, []env.Type{env.UriType}, "Load\\arrow")
+			}
+		},
+	},
+
+	// Example:
+	//  %events.arrow .Save\arrow events
+	// Args:
+	// * file-uri - where to save the table as an Arrow IPC (Feather v2) file
+	// * table - the table to save, every column must have values of one type (integers and decimals can be mixed)
+	// Returns:
+	// * the table
+	// Tags: #table #saving #arrow
+	"file-uri//Save\\arrow": {
+		Argsn: 2,
+		Doc:   "Saves a table to an Arrow IPC file.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			switch file := arg0.(type) {
+			case env.Uri:
+				spr, errObj := tableArg(ps, arg1, 2, "Save\\arrow")
+				if errObj != nil {
+					return errObj
+				}
+				if err := SaveArrow(spr, file.GetPath()); err != nil {
+					return evaldo.MakeBuiltinError(ps, "Unable to save arrow file: "+err.Error(), "Save\\arrow")
+				}
+				return *spr
+			default:
+				return evaldo.MakeArgError(ps, 1, []env.Type{env.UriType}, "Save\\arrow")
+			}
+		},
+	},
+}
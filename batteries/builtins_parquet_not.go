@@ -0,0 +1,10 @@
+//go:build !add_parquet
+// +build !add_parquet
+
+package batteries
+
+import (
+	"github.com/refaktor/rye/env"
+)
+
+var Builtins_parquet = map[string]*env.Builtin{}
@@ -0,0 +1,94 @@
+//go:build add_parquet
+// +build add_parquet
+
+package batteries
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/refaktor/rye/env"
+	"github.com/refaktor/rye/evaldo"
+)
+
+// parquetRoundTrip saves the table as parquet and as an arrow file and returns both tables loaded back
+func parquetRoundTrip(t *testing.T, spr *env.Table) []env.Table {
+	t.Helper()
+	ps := env.NewProgramState()
+	evaldo.RegisterBuiltins(ps)
+	RegisterBatteries(ps)
+	dir := t.TempDir()
+	ps.Ctx.Set(ps.Idx.IndexWord("spr"), *spr)
+	ps.Ctx.Set(ps.Idx.IndexWord("pq"), *env.NewFileUri(ps.Idx, filepath.Join(dir, "t.parquet")))
+	ps.Ctx.Set(ps.Idx.IndexWord("ar"), *env.NewFileUri(ps.Idx, filepath.Join(dir, "t.arrow")))
+	testEval(t, ps, `pq .Save\parquet spr , ar .Save\arrow spr`, false)
+	return []env.Table{testEval(t, ps, `Load\parquet pq`, false).(env.Table), testEval(t, ps, `Load\arrow ar`, false).(env.Table)}
+}
+
+func TestParquetDateTimeBooleanRoundTrip(t *testing.T) {
+	// the local zone of the test is fixed, so a local time isn't also UTC
+	local := time.Local
+	time.Local = time.FixedZone("CEST", 2*60*60)
+	defer func() { time.Local = local }()
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no time zone data")
+	}
+
+	day, _ := time.Parse("2006-01-02", "2024-02-29")
+	spr := env.NewTable([]string{"date", "local", "utc", "zone", "mixed", "ok"})
+	spr.AddRow(*env.NewTableRow([]any{
+		*env.NewDate(day),
+		*env.NewTime(time.Date(2024, 3, 31, 1, 30, 15, 123456789, time.Local)),
+		*env.NewTime(time.Date(2024, 1, 2, 3, 4, 5, 999999999, time.UTC)),
+		*env.NewTime(time.Date(2024, 7, 1, 12, 0, 0, 1, ny)),
+		*env.NewTime(time.Date(2024, 1, 1, 0, 0, 0, 5, ny)),
+		*env.NewBoolean(true),
+	}, spr))
+	spr.AddRow(*env.NewTableRow([]any{
+		*env.NewDate(day.AddDate(0, 0, -1)),
+		*env.NewTime(time.Date(1969, 12, 31, 23, 59, 59, 1, time.Local)),
+		*env.NewVoid(),
+		*env.NewTime(time.Date(2024, 12, 1, 12, 0, 0, 0, ny)),
+		*env.NewTime(time.Date(2024, 1, 1, 0, 0, 0, 5, time.UTC)),
+		*env.NewBoolean(false),
+	}, spr))
+
+	for i, loaded := range parquetRoundTrip(t, spr) {
+		format := []string{"parquet", "arrow"}[i]
+		if !spr.Equal(loaded) {
+			t.Fatalf("%s: expected the same table, got %s", format, loaded.Inspect(*env.NewIdxs()))
+		}
+		for r, row := range loaded.Rows {
+			for c, val := range row.Values {
+				orig := spr.Rows[r].Values[c]
+				switch v := val.(type) {
+				case env.Date:
+					if v.Value != orig.(env.Date).Value {
+						t.Errorf("%s: expected date %v, got %v", format, orig.(env.Date).Value, v.Value)
+					}
+				case env.Time:
+					want := orig.(env.Time).Value
+					if !v.Value.Equal(want) || v.Value.Nanosecond() != want.Nanosecond() {
+						t.Errorf("%s: expected time %v, got %v", format, want, v.Value)
+					}
+					// the zone is kept, except in the column with times in different zones
+					if spr.Cols[c] != "mixed" && v.Value.Location().String() != want.Location().String() {
+						t.Errorf("%s: expected the zone of %v, got %v", format, want, v.Value)
+					}
+				case env.Boolean:
+					if v.Value != orig.(env.Boolean).Value {
+						t.Errorf("%s: expected boolean %v, got %v", format, orig.(env.Boolean).Value, v.Value)
+					}
+				case env.Void:
+					if _, ok := orig.(env.Void); !ok {
+						t.Errorf("%s: expected %v, got void", format, orig)
+					}
+				default:
+					t.Errorf("%s: unexpected value %T in column %s", format, val, spr.Cols[c])
+				}
+			}
+		}
+	}
+}
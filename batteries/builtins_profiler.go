@@ -0,0 +1,72 @@
+package batteries
+
+import (
+	"os"
+
+	"github.com/refaktor/rye/env"
+	"github.com/refaktor/rye/evaldo"
+	"github.com/refaktor/rye/profiler"
+)
+
+// profileBlock profiles the code of the block and returns the profiler and the result of the code
+func profileBlock(ps *env.ProgramState, arg env.Object, argn int, fnName string) (*profiler.Profiler, env.Object) {
+	block, ok := arg.(env.Block)
+	if !ok {
+		return nil, evaldo.MakeArgError(ps, argn, []env.Type{env.BlockType}, fnName)
+	}
+	if evaldo.CallHook != nil {
+		return nil, evaldo.MakeBuiltinError(ps, "The profiler is already running.", fnName)
+	}
+	prof := profiler.Profile(ps, block, 0)
+	return prof, ps.Res
+}
+
+var Builtins_profiler = map[string]*env.Builtin{
+
+	//
+	// ##### Profiler ##### "Sampling profiler for Rye code"
+	//
+
+	// Tests:
+	// equal { profile { 1 + 2 } } 3
+	// Args:
+	// * code: Block of code to profile
+	// Returns:
+	// * the result of the code, the functions and builtins that took the most time are printed
+	"profile": {
+		Argsn: 1,
+		Doc:   "Evaluates the block with the sampling profiler and prints the functions and builtins it spent the most time in.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			prof, res := profileBlock(ps, arg0, 1, "profile")
+			if prof == nil || ps.ErrorFlag {
+				return res
+			}
+			prof.WriteTop(os.Stdout, 15)
+			return res
+		},
+	},
+
+	// Args:
+	// * file: File URI the profile is written to, folded stacks if it ends with .folded or .txt, pprof otherwise
+	// * code: Block of code to profile
+	// Returns:
+	// * the result of the code
+	"profile\\to": {
+		Argsn: 2,
+		Doc:   "Evaluates the block with the sampling profiler and writes the profile to a file, as folded stacks for flame graphs (.folded, .txt) or in the pprof format.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			uri, ok := arg0.(env.Uri)
+			if !ok {
+				return evaldo.MakeArgError(ps, 1, []env.Type{env.UriType}, "profile\\to")
+			}
+			prof, res := profileBlock(ps, arg1, 2, "profile\\to")
+			if prof == nil || ps.ErrorFlag {
+				return res
+			}
+			if err := prof.WriteFile(uri.GetPath()); err != nil {
+				return evaldo.MakeBuiltinError(ps, err.Error(), "profile\\to")
+			}
+			return res
+		},
+	},
+}
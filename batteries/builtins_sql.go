@@ -4,11 +4,13 @@
 package batteries
 
 import (
+	"database/sql"
 	"fmt"
 	"strconv"
 	"strings"
 
 	"github.com/refaktor/rye/env"
+	"github.com/refaktor/rye/evaldo"
 )
 
 // SQL mode constants for different database parameter styles
@@ -101,3 +103,309 @@ func sqlResultToJS(res env.Object) any {
 		return fmt.Sprintf("%v", res)
 	}
 }
+
+// sqlRunner is the subset of methods shared by *sql.DB and *sql.Tx, so the same
+// code can execute statements either directly or inside a transaction
+type sqlRunner interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	Prepare(query string) (*sql.Stmt, error)
+}
+
+// SQL_BuildQuery turns a SQL string or SQL-DSL block into a query string and its parameters
+func SQL_BuildQuery(ps *env.ProgramState, arg env.Object, mode int) (string, []any, bool) {
+	switch str := arg.(type) {
+	case env.Block:
+		ser := ps.Ser
+		ps.Ser = str.Series
+		values := make([]any, 0, 2)
+		_, vals := SQL_EvalBlock(ps, mode, values)
+		sqlstr := ps.Res.(env.String).Value
+		ps.Ser = ser
+		return sqlstr, vals, true
+	case env.String:
+		return str.Value, nil, true
+	default:
+		return "", nil, false
+	}
+}
+
+// SQL_ParamsFromBlock converts a block of values into statement parameters. Words and
+// get-words are looked up in the current context, commas are skipped.
+func SQL_ParamsFromBlock(ps *env.ProgramState, blk env.Block, fnName string) ([]any, env.Object) {
+	vals := make([]any, 0, blk.Series.Len())
+	for _, p := range blk.Series.S {
+		switch v := p.(type) {
+		case env.Comma:
+			continue
+		case env.Word:
+			val, found := ps.Ctx.Get(v.Index)
+			if !found {
+				return nil, evaldo.MakeBuiltinError(ps, "Word not found in context: "+ps.Idx.GetWord(v.Index), fnName)
+			}
+			vals = append(vals, sqlResultToJS(val))
+		case env.Getword:
+			val, found := ps.Ctx.Get(v.Index)
+			if !found {
+				return nil, evaldo.MakeBuiltinError(ps, "Word not found in context: "+ps.Idx.GetWord(v.Index), fnName)
+			}
+			vals = append(vals, sqlResultToJS(val))
+		default:
+			vals = append(vals, sqlResultToJS(p))
+		}
+	}
+	return vals, nil
+}
+
+// SQL_RowsToTable reads all rows into a table, converting SQL NULL values to Void{}
+func SQL_RowsToTable(rows *sql.Rows) (*env.Table, error) {
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	spr := env.NewTable(cols)
+	for rows.Next() {
+		var sr env.TableRow
+		columns := make([]any, len(cols))
+		columnPointers := make([]any, len(cols))
+		for i := range columns {
+			columnPointers[i] = &columns[i]
+		}
+		if err := rows.Scan(columnPointers...); err != nil {
+			return nil, err
+		}
+		for i := range cols {
+			val := columnPointers[i].(*any)
+			if *val == nil {
+				sr.Values = append(sr.Values, env.Void{})
+			} else {
+				sr.Values = append(sr.Values, env.ToRyeValue(*val))
+			}
+		}
+		sr.Uplink = spr
+		spr.AddRow(sr)
+	}
+	return spr, rows.Err()
+}
+
+// SQL_Begin starts a transaction on a database connection and wraps it into a native of txKind
+func SQL_Begin(ps *env.ProgramState, arg0 env.Object, txKind string, fnName string) env.Object {
+	switch db := arg0.(type) {
+	case env.Native:
+		sqlDB, ok := db.Value.(*sql.DB)
+		if !ok {
+			return evaldo.MakeBuiltinError(ps, "Invalid database connection.", fnName)
+		}
+		tx, err := sqlDB.Begin()
+		if err != nil {
+			return evaldo.MakeBuiltinError(ps, fmt.Sprintf("Error starting transaction: %v", err.Error()), fnName)
+		}
+		return *env.NewNative(ps.Idx, tx, txKind)
+	default:
+		return evaldo.MakeArgError(ps, 1, []env.Type{env.NativeType}, fnName)
+	}
+}
+
+// SQL_Transaction begins a transaction, does the block with the transaction injected and
+// commits it. If the block fails or errors the transaction is rolled back and the failure
+// is returned to the caller unchanged, so it can be handled with fix, try and friends.
+func SQL_Transaction(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, txKind string, fnName string) env.Object {
+	switch db := arg0.(type) {
+	case env.Native:
+		switch bloc := arg1.(type) {
+		case env.Block:
+			sqlDB, ok := db.Value.(*sql.DB)
+			if !ok {
+				return evaldo.MakeBuiltinError(ps, "Invalid database connection.", fnName)
+			}
+			tx, err := sqlDB.Begin()
+			if err != nil {
+				return evaldo.MakeBuiltinError(ps, fmt.Sprintf("Error starting transaction: %v", err.Error()), fnName)
+			}
+			ser := ps.Ser
+			// if the block panics the transaction is rolled back, so it doesn't keep the connection,
+			// after a commit or rollback this does nothing
+			defer func() {
+				ps.Ser = ser
+				tx.Rollback()
+			}()
+			ps.Ser = bloc.Series
+			evaldo.EvalBlockInj(ps, *env.NewNative(ps.Idx, tx, txKind), true)
+			if ps.FailureFlag || ps.ErrorFlag {
+				res := ps.Res
+				if err := tx.Rollback(); err != nil {
+					return evaldo.MakeBuiltinError(ps, fmt.Sprintf("Error rolling back transaction: %v", err.Error()), fnName)
+				}
+				evaldo.MaybeDisplayFailureOrError(ps, ps.Idx, fnName)
+				return res
+			}
+			res := ps.Res
+			if err := tx.Commit(); err != nil {
+				return evaldo.MakeBuiltinError(ps, fmt.Sprintf("Error committing transaction: %v", err.Error()), fnName)
+			}
+			return res
+		default:
+			return evaldo.MakeArgError(ps, 2, []env.Type{env.BlockType}, fnName)
+		}
+	default:
+		return evaldo.MakeArgError(ps, 1, []env.Type{env.NativeType}, fnName)
+	}
+}
+
+// SQL_EndTransaction commits or rolls back a transaction native
+func SQL_EndTransaction(ps *env.ProgramState, arg0 env.Object, commit bool, fnName string) env.Object {
+	switch txn := arg0.(type) {
+	case env.Native:
+		tx, ok := txn.Value.(*sql.Tx)
+		if !ok {
+			return evaldo.MakeBuiltinError(ps, "Invalid transaction.", fnName)
+		}
+		var err error
+		if commit {
+			err = tx.Commit()
+		} else {
+			err = tx.Rollback()
+		}
+		if err != nil {
+			return evaldo.MakeBuiltinError(ps, err.Error(), fnName)
+		}
+		return *env.NewInteger(1)
+	default:
+		return evaldo.MakeArgError(ps, 1, []env.Type{env.NativeType}, fnName)
+	}
+}
+
+// SQL_RunnerExec executes a statement on a database or transaction native and returns the number of affected rows
+func SQL_RunnerExec(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, mode int, fnName string) env.Object {
+	switch conn := arg0.(type) {
+	case env.Native:
+		runner, ok := conn.Value.(sqlRunner)
+		if !ok {
+			return evaldo.MakeBuiltinError(ps, "Invalid database connection.", fnName)
+		}
+		sqlstr, vals, ok := SQL_BuildQuery(ps, arg1, mode)
+		if !ok {
+			return evaldo.MakeArgError(ps, 2, []env.Type{env.BlockType, env.StringType}, fnName)
+		}
+		if sqlstr == "" {
+			return evaldo.MakeBuiltinError(ps, "Sql string is empty.", fnName)
+		}
+		res, err := runner.Exec(sqlstr, vals...)
+		if err != nil {
+			return evaldo.MakeBuiltinError(ps, err.Error(), fnName)
+		}
+		num, _ := res.RowsAffected()
+		return *env.NewInteger(num)
+	default:
+		return evaldo.MakeArgError(ps, 1, []env.Type{env.NativeType}, fnName)
+	}
+}
+
+// SQL_RunnerQuery runs a query on a database or transaction native and returns a table
+func SQL_RunnerQuery(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, mode int, fnName string) env.Object {
+	switch conn := arg0.(type) {
+	case env.Native:
+		runner, ok := conn.Value.(sqlRunner)
+		if !ok {
+			return evaldo.MakeBuiltinError(ps, "Invalid database connection.", fnName)
+		}
+		sqlstr, vals, ok := SQL_BuildQuery(ps, arg1, mode)
+		if !ok {
+			return evaldo.MakeArgError(ps, 2, []env.Type{env.BlockType, env.StringType}, fnName)
+		}
+		if sqlstr == "" {
+			return evaldo.MakeBuiltinError(ps, "Empty SQL.", fnName)
+		}
+		rows, err := runner.Query(sqlstr, vals...)
+		if err != nil {
+			return evaldo.MakeBuiltinError(ps, err.Error(), fnName)
+		}
+		spr, err := SQL_RowsToTable(rows)
+		if err != nil {
+			return evaldo.MakeBuiltinError(ps, "Error scanning row: "+err.Error(), fnName)
+		}
+		return *spr
+	default:
+		return evaldo.MakeArgError(ps, 1, []env.Type{env.NativeType}, fnName)
+	}
+}
+
+// SQL_Prepare prepares a statement on a database or transaction native and wraps it into a native of stmtKind
+func SQL_Prepare(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, stmtKind string, fnName string) env.Object {
+	switch conn := arg0.(type) {
+	case env.Native:
+		runner, ok := conn.Value.(sqlRunner)
+		if !ok {
+			return evaldo.MakeBuiltinError(ps, "Invalid database connection.", fnName)
+		}
+		switch str := arg1.(type) {
+		case env.String:
+			stmt, err := runner.Prepare(str.Value)
+			if err != nil {
+				return evaldo.MakeBuiltinError(ps, fmt.Sprintf("Error preparing statement: %v", err.Error()), fnName)
+			}
+			return *env.NewNative(ps.Idx, stmt, stmtKind)
+		default:
+			return evaldo.MakeArgError(ps, 2, []env.Type{env.StringType}, fnName)
+		}
+	default:
+		return evaldo.MakeArgError(ps, 1, []env.Type{env.NativeType}, fnName)
+	}
+}
+
+// SQL_StmtRun executes (or queries, if query is true) a prepared statement with a block of parameters
+func SQL_StmtRun(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, query bool, fnName string) env.Object {
+	switch st := arg0.(type) {
+	case env.Native:
+		stmt, ok := st.Value.(*sql.Stmt)
+		if !ok {
+			return evaldo.MakeBuiltinError(ps, "Invalid prepared statement.", fnName)
+		}
+		switch params := arg1.(type) {
+		case env.Block:
+			vals, errObj := SQL_ParamsFromBlock(ps, params, fnName)
+			if errObj != nil {
+				return errObj
+			}
+			if query {
+				rows, err := stmt.Query(vals...)
+				if err != nil {
+					return evaldo.MakeBuiltinError(ps, err.Error(), fnName)
+				}
+				spr, err := SQL_RowsToTable(rows)
+				if err != nil {
+					return evaldo.MakeBuiltinError(ps, "Error scanning row: "+err.Error(), fnName)
+				}
+				return *spr
+			}
+			res, err := stmt.Exec(vals...)
+			if err != nil {
+				return evaldo.MakeBuiltinError(ps, err.Error(), fnName)
+			}
+			num, _ := res.RowsAffected()
+			return *env.NewInteger(num)
+		default:
+			return evaldo.MakeArgError(ps, 2, []env.Type{env.BlockType}, fnName)
+		}
+	default:
+		return evaldo.MakeArgError(ps, 1, []env.Type{env.NativeType}, fnName)
+	}
+}
+
+// SQL_StmtClose closes a prepared statement native
+func SQL_StmtClose(ps *env.ProgramState, arg0 env.Object, fnName string) env.Object {
+	switch st := arg0.(type) {
+	case env.Native:
+		stmt, ok := st.Value.(*sql.Stmt)
+		if !ok {
+			return evaldo.MakeBuiltinError(ps, "Invalid prepared statement.", fnName)
+		}
+		if err := stmt.Close(); err != nil {
+			return evaldo.MakeBuiltinError(ps, fmt.Sprintf("Error closing statement: %v", err.Error()), fnName)
+		}
+		return *env.NewInteger(1)
+	default:
+		return evaldo.MakeArgError(ps, 1, []env.Type{env.NativeType}, fnName)
+	}
+}
@@ -0,0 +1,111 @@
+//go:build !no_sqlite
+// +build !no_sqlite
+
+package batteries
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/refaktor/rye/env"
+)
+
+// sqlState opens an in-memory database as db with a table t, it has one connection so an open
+// transaction blocks any other statement
+func sqlState(t *testing.T) *env.ProgramState {
+	t.Helper()
+	ps := testState()
+	db := testEval(t, ps, `db: Open sqlite://:memory:`, false)
+	sqlDB := db.(env.Native).Value.(*sql.DB)
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	testEval(t, ps, `db .Exec "CREATE TABLE t (id INTEGER, name TEXT)"`, false)
+	return ps
+}
+
+// sqlCount returns the number of rows in t, it fails the test when the connection stays taken
+func sqlCount(t *testing.T, ps *env.ProgramState) string {
+	t.Helper()
+	done := make(chan string, 1)
+	go func() {
+		res, _ := testRun(ps, `db .Query "SELECT id FROM t" |length?`)
+		done <- res.Print(*ps.Idx)
+	}()
+	select {
+	case n := <-done:
+		return n
+	case <-time.After(5 * time.Second):
+		t.Fatal("the connection is still held by a transaction")
+		return ""
+	}
+}
+
+func TestSqliteTransaction(t *testing.T) {
+	ps := sqlState(t)
+
+	res := testEval(t, ps, `db .Transaction { .Exec "INSERT INTO t VALUES (1, 'a')" , .Exec "INSERT INTO t VALUES (2, 'b')" , 42 }`, false)
+	if res.Print(*ps.Idx) != "42" || sqlCount(t, ps) != "2" {
+		t.Errorf("expected both rows committed and the block's result, got %s", res.Print(*ps.Idx))
+	}
+
+	// a failure rolls back and is returned as it is
+	res = testEval(t, ps, `db .Transaction { .Exec "INSERT INTO t VALUES (3, 'c')" , fail "undo" }`, true)
+	if err, ok := res.(*env.Error); !ok || err.Message != "undo" {
+		t.Errorf("expected the block's failure, got %s", res.Inspect(*ps.Idx))
+	}
+	if n := sqlCount(t, ps); n != "2" {
+		t.Errorf("expected the insert to be rolled back, got %s rows", n)
+	}
+
+	// a panic in the block rolls back too and doesn't keep the connection
+	ps.Ctx.Set(ps.Idx.IndexWord("go-panic"), *env.NewBuiltin(func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+		panic("go panic")
+	}, 0, false, false, "panics"))
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Error("expected the panic to reach the caller")
+			}
+		}()
+		testEval(t, ps, `db .Transaction { .Exec "INSERT INTO t VALUES (4, 'd')" , go-panic }`, false)
+	}()
+	if n := sqlCount(t, ps); n != "2" {
+		t.Errorf("expected the insert to be rolled back after the panic, got %s rows", n)
+	}
+
+	// Begin, Commit and Rollback by hand
+	testEval(t, ps, `tx: db .Begin , tx .Exec "INSERT INTO t VALUES (5, 'e')" , tx .Rollback`, false)
+	testEval(t, ps, `tx2: db .Begin , tx2 .Exec "INSERT INTO t VALUES (6, 'f')" , tx2 .Commit`, false)
+	if n := sqlCount(t, ps); n != "3" {
+		t.Errorf("expected only the committed insert, got %s rows", n)
+	}
+}
+
+func TestSqlitePrepared(t *testing.T) {
+	ps := sqlState(t)
+
+	testEval(t, ps, `ins: db .Prepare "INSERT INTO t VALUES (?, ?)"`, false)
+	for _, code := range []string{`ins .Exec { 1 "one" }`, `ins .Exec { 2 "two" }`, `ins .Exec { 3 "three" }`} {
+		if res := testEval(t, ps, code, false); res.Print(*ps.Idx) != "1" {
+			t.Errorf("%s: expected one row affected, got %s", code, res.Print(*ps.Idx))
+		}
+	}
+	testEval(t, ps, `ins .Close`, false)
+	testEval(t, ps, `ins .Exec { 4 "four" }`, true)
+
+	testEval(t, ps, `sel: db .Prepare "SELECT name FROM t WHERE id > ? ORDER BY id"`, false)
+	if res := testEval(t, ps, `sel .Query { 1 } |column? "name" |join\with " "`, false); res.Print(*ps.Idx) != "two three" {
+		t.Errorf("expected the names after the first, got %s", res.Print(*ps.Idx))
+	}
+	if res := testEval(t, ps, `sel .Query { 3 } |length?`, false); res.Print(*ps.Idx) != "0" {
+		t.Errorf("expected no rows, got %s", res.Print(*ps.Idx))
+	}
+	testEval(t, ps, `sel .Close`, false)
+
+	// a statement prepared in a transaction runs in it
+	testEval(t, ps, `db .Transaction { :tx , st: tx .Prepare "DELETE FROM t WHERE id = ?" , st .Exec { 1 } , st .Close , fail "undo" }`, true)
+	if n := sqlCount(t, ps); n != "3" {
+		t.Errorf("expected the delete to be rolled back, got %s rows", n)
+	}
+}
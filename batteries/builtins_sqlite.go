@@ -29,7 +29,7 @@ var Builtins_sqlite = map[string]*env.Builtin{
 	//  db .Exec { INSERT INTO users ( name , age ) VALUES ( ?name , 42 ) }
 	//
 	// Tests:
-	// equal { Open sqlite://test.db |type? } 'native
+	// equal { Open sqlite://:memory: |type? } 'native
 	// Args:
 	// * uri: path to SQLite database file
 	// Returns:
@@ -77,7 +77,7 @@ var Builtins_sqlite = map[string]*env.Builtin{
 	},
 
 	// Tests:
-	// equal { Open sqlite://test.db |Exec "CREATE TABLE IF NOT EXISTS test (id INTEGER, name TEXT)" |type? } 'native
+	// equal { Open sqlite://:memory: |Exec "CREATE TABLE IF NOT EXISTS test (id INTEGER, name TEXT)" |type? } 'native
 	// Args:
 	// * db: SQLite database connection
 	// * sql: SQL statement as string or block
@@ -122,9 +122,9 @@ var Builtins_sqlite = map[string]*env.Builtin{
 		},
 	},
 
-	// Tests:
-	// equal { Open sqlite://test.db |Query "SELECT * FROM test" |type? } 'table
 	// Also supports: db .Query { "SELECT * FROM test WHERE id = ?" 123 }
+	// Tests:
+	// equal { Open sqlite://:memory: |Exec "CREATE TABLE test (id INTEGER, name TEXT)" |Query "SELECT * FROM test" |type? } 'table
 	// Args:
 	// * db: SQLite database connection
 	// * sql: SQL query as string, SQL-DSL block, or a block where first value is SQL string and the rest are parameters
@@ -269,7 +269,7 @@ var Builtins_sqlite = map[string]*env.Builtin{
 	},
 
 	// Tests:
-	// equal { id: 123 Open sqlite://test.db |Show-SQL { SELECT * FROM test WHERE id = ?id } |type? } 'string
+	// equal { id: 123 Open sqlite://:memory: |Show-SQL { SELECT * FROM test WHERE id = ?id } |type? } 'string
 	// Args:
 	// * db: SQLite database connection
 	// * sql: SQL query as string or block
@@ -319,7 +319,7 @@ var Builtins_sqlite = map[string]*env.Builtin{
 	},
 
 	// Tests:
-	// equal { db: Open sqlite://test.db db .Close |type? } 'integer
+	// equal { db: Open sqlite://:memory: db .Close |type? } 'integer
 	// Args:
 	// * db: SQLite database connection
 	// Returns:
@@ -348,4 +348,155 @@ var Builtins_sqlite = map[string]*env.Builtin{
 			}
 		},
 	},
+
+	// Tests:
+	// equal { db: Open sqlite://:memory: tx: db .Begin tx .Rollback } 1
+	// Args:
+	// * db: SQLite database connection
+	// Returns:
+	// * native SQLite transaction (kind: "Rye-sqlite-tx")
+	"Rye-sqlite//Begin": {
+		Argsn: 1,
+		Doc:   "Starts a new transaction on the SQLite database connection.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			return SQL_Begin(ps, arg0, "Rye-sqlite-tx", "Rye-sqlite//Begin")
+		},
+	},
+
+	// Tests:
+	// equal { db: Open sqlite://:memory: db .Transaction { .Exec "CREATE TABLE tx_test (id INTEGER)" , 42 } } 42
+	// equal { db: Open sqlite://:memory: db .Exec "CREATE TABLE tx_test (id INTEGER)" try { db .Transaction { .Exec "INSERT INTO tx_test VALUES (1)" , fail "undo" } } |message? } "undo"
+	// equal { db: Open sqlite://:memory: db .Exec "CREATE TABLE tx_test (id INTEGER)" try { db .Transaction { .Exec "INSERT INTO tx_test VALUES (2)" , fail "undo" } } db .Query "SELECT * FROM tx_test WHERE id = 2" |length? } 0
+	// Args:
+	// * db: SQLite database connection
+	// * block: code to do with the transaction injected, use commas to inject it again
+	// Returns:
+	// * result of the block, the transaction is committed on success
+	// * the failure of the block, after the transaction is rolled back
+	"Rye-sqlite//Transaction": {
+		Argsn: 2,
+		Doc:   "Does a block inside a transaction. Commits if the block succeeds and rolls back if it fails.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			return SQL_Transaction(ps, arg0, arg1, "Rye-sqlite-tx", "Rye-sqlite//Transaction")
+		},
+	},
+
+	// Tests:
+	// equal { db: Open sqlite://:memory: st: db .Prepare "SELECT ? AS x" st .Query { 7 } |length? } 1
+	// Args:
+	// * db: SQLite database connection
+	// * sql: SQL string with ? placeholders
+	// Returns:
+	// * native prepared statement (kind: "Rye-sqlite-stmt")
+	"Rye-sqlite//Prepare": {
+		Argsn: 2,
+		Doc:   "Prepares a SQL statement for repeated execution.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			return SQL_Prepare(ps, arg0, arg1, "Rye-sqlite-stmt", "Rye-sqlite//Prepare")
+		},
+	},
+
+	// Args:
+	// * tx: SQLite transaction
+	// * sql: SQL statement as string or block
+	// Returns:
+	// * integer number of rows affected
+	"Rye-sqlite-tx//Exec": {
+		Argsn: 2,
+		Doc:   "Executes a SQL statement inside the transaction.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			return SQL_RunnerExec(ps, arg0, arg1, MODE_SQLITE, "Rye-sqlite-tx//Exec")
+		},
+	},
+
+	// Args:
+	// * tx: SQLite transaction
+	// * sql: SQL query as string or block
+	// Returns:
+	// * table containing query results
+	"Rye-sqlite-tx//Query": {
+		Argsn: 2,
+		Doc:   "Executes a SQL query inside the transaction and returns results as a table.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			return SQL_RunnerQuery(ps, arg0, arg1, MODE_SQLITE, "Rye-sqlite-tx//Query")
+		},
+	},
+
+	// Args:
+	// * tx: SQLite transaction
+	// * sql: SQL string with ? placeholders
+	// Returns:
+	// * native prepared statement bound to the transaction
+	"Rye-sqlite-tx//Prepare": {
+		Argsn: 2,
+		Doc:   "Prepares a SQL statement that runs inside the transaction.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			return SQL_Prepare(ps, arg0, arg1, "Rye-sqlite-stmt", "Rye-sqlite-tx//Prepare")
+		},
+	},
+
+	// Args:
+	// * tx: SQLite transaction
+	// Returns:
+	// * integer 1 on success
+	"Rye-sqlite-tx//Commit": {
+		Argsn: 1,
+		Doc:   "Commits the transaction.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			return SQL_EndTransaction(ps, arg0, true, "Rye-sqlite-tx//Commit")
+		},
+	},
+
+	// Args:
+	// * tx: SQLite transaction
+	// Returns:
+	// * integer 1 on success
+	"Rye-sqlite-tx//Rollback": {
+		Argsn: 1,
+		Doc:   "Rolls back the transaction.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			return SQL_EndTransaction(ps, arg0, false, "Rye-sqlite-tx//Rollback")
+		},
+	},
+
+	// Args:
+	// * stmt: SQLite prepared statement
+	// * params: block of parameter values
+	// Returns:
+	// * integer number of rows affected
+	"Rye-sqlite-stmt//Exec": {
+		Argsn: 2,
+		Doc:   "Executes the prepared statement with the given parameters.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			return SQL_StmtRun(ps, arg0, arg1, false, "Rye-sqlite-stmt//Exec")
+		},
+	},
+
+	// Tests:
+	// equal { x: 7 db: Open sqlite://:memory: st: db .Prepare "SELECT ? AS x" st .Query { x } |first -> "x" } 7
+	// equal { db: Open sqlite://:memory: st: db .Prepare "SELECT ? AS x" try { st .Query { unbound-x } } |message? |contains "unbound-x" } true
+	// Args:
+	// * stmt: SQLite prepared statement
+	// * params: block of parameter values, words are looked up in the current context
+	// Returns:
+	// * table containing query results
+	"Rye-sqlite-stmt//Query": {
+		Argsn: 2,
+		Doc:   "Runs the prepared query with the given parameters and returns results as a table.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			return SQL_StmtRun(ps, arg0, arg1, true, "Rye-sqlite-stmt//Query")
+		},
+	},
+
+	// Args:
+	// * stmt: SQLite prepared statement
+	// Returns:
+	// * integer 1 on successful close
+	"Rye-sqlite-stmt//Close": {
+		Argsn: 1,
+		Doc:   "Closes the prepared statement.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			return SQL_StmtClose(ps, arg0, "Rye-sqlite-stmt//Close")
+		},
+	},
 }
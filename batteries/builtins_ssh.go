@@ -4,13 +4,17 @@
 package batteries
 
 import (
-	"io"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/gliderlabs/ssh"
 	"github.com/jinzhu/copier"
+	"github.com/refaktor/rye/console"
 	"github.com/refaktor/rye/env"
 	"github.com/refaktor/rye/evaldo"
 	"github.com/refaktor/rye/util"
+	gossh "golang.org/x/crypto/ssh"
 )
 
 var Builtins_ssh = map[string]*env.Builtin{
@@ -27,6 +31,15 @@ var Builtins_ssh = map[string]*env.Builtin{
 	//  }
 	//  server |Serve
 	//
+	// An admin console with public keys, the REPL over the session and SFTP:
+	//  server: ssh-server "localhost:2222"
+	//  server |Host-key-file %host_key |Authorized-keys %authorized_keys |Sftp
+	//  server |Handle fn { s } {
+	//    s .Write ( "Hello " ++ ( s .User? ) ++ "\n" )
+	//    s .Repl
+	//  }
+	//  server |Serve
+	//
 	// Tests:
 	// ; equal { ssh-server "localhost:2222" |type? } 'native
 	// Args:
@@ -64,16 +77,7 @@ var Builtins_ssh = map[string]*env.Builtin{
 				switch handler := arg1.(type) {
 				case env.Function:
 					server.Value.(*ssh.Server).Handle(func(s ssh.Session) {
-						ps.FailureFlag = false
-						ps.ErrorFlag = false
-						ps.ReturnFlag = false
-						psTemp := env.ProgramState{}
-						copier.Copy(&psTemp, &ps)
-						evaldo.CallFunctionWithArgs(handler, ps, nil, *env.NewNative(ps.Idx, s, "ssh-session"))
-						// Check for errors after calling handler and print to server console
-						if ps.FailureFlag || ps.ErrorFlag {
-							println("Error in SSH handler: " + ps.Res.Inspect(*ps.Idx))
-						}
+						sshHandler(ps, s.Context(), handler, *env.NewNative(ps.Idx, newSshSession(s), "ssh-session"))
 					})
 					return arg0
 				default:
@@ -103,13 +107,7 @@ var Builtins_ssh = map[string]*env.Builtin{
 				switch handler := arg1.(type) {
 				case env.Function:
 					pwda := ssh.PasswordAuth(func(ctx ssh.Context, pass string) bool {
-						ps.FailureFlag = false
-						ps.ErrorFlag = false
-						ps.ReturnFlag = false
-						psTemp := env.ProgramState{}
-						copier.Copy(&psTemp, &ps)
-						evaldo.CallFunctionWithArgs(handler, ps, nil, *env.NewString(pass))
-						return util.IsTruthy(ps.Res)
+						return util.IsTruthy(sshHandler(ps, ctx, handler, *env.NewString(pass)).Res)
 					})
 					server.Value.(*ssh.Server).SetOption(pwda)
 					return arg0
@@ -125,26 +123,174 @@ var Builtins_ssh = map[string]*env.Builtin{
 	},
 
 	// Tests:
-	// ; equal { server: ssh-server "localhost:2222" , server |Serve |type? } 'native
+	// ; equal { ssh-server "localhost:2222" |Host-key-file "host_key" |type? } 'native
 	// Args:
 	// * server: SSH server object
+	// * path: String or file URI of a private key in PEM format
 	// Returns:
-	// * the SSH server object, or error if unable to serve
-	"ssh-server//Serve": {
-		Argsn: 1,
-		Doc:   "Starts the SSH server, listening for connections.",
+	// * the SSH server object
+	"ssh-server//Host-key-file": {
+		Argsn: 2,
+		Doc:   "Sets the host key of the server from a file, without one a new key is generated on each start and clients can't check it in known_hosts.",
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
-			switch server := arg0.(type) {
-			case env.Native:
-				err := server.Value.(*ssh.Server).ListenAndServe()
+			server, errObj := sshServerArg(ps, arg0, "ssh-server//Host-key-file")
+			if errObj != nil {
+				return errObj
+			}
+			path, errObj := sshPath(ps, arg1, 2, "ssh-server//Host-key-file")
+			if errObj != nil {
+				return errObj
+			}
+			if err := server.SetOption(ssh.HostKeyFile(path)); err != nil {
+				return evaldo.MakeBuiltinError(ps, err.Error(), "ssh-server//Host-key-file")
+			}
+			return arg0
+		},
+	},
+
+	// Tests:
+	// ; equal { ssh-server "localhost:2222" |Authorized-keys %.ssh/authorized_keys |type? } 'native
+	// Args:
+	// * server: SSH server object
+	// * path: String or file URI of a file in the authorized_keys format
+	// Returns:
+	// * the SSH server object
+	"ssh-server//Authorized-keys": {
+		Argsn: 2,
+		Doc:   "Lets clients log in with the public keys in an authorized_keys file, it's read on each login so keys can be changed while the server runs.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			server, errObj := sshServerArg(ps, arg0, "ssh-server//Authorized-keys")
+			if errObj != nil {
+				return errObj
+			}
+			path, errObj := sshPath(ps, arg1, 2, "ssh-server//Authorized-keys")
+			if errObj != nil {
+				return errObj
+			}
+			server.SetOption(ssh.PublicKeyAuth(func(ctx ssh.Context, key ssh.PublicKey) bool {
+				ok, err := sshAuthorizedKeys(path, key)
 				if err != nil {
-					return evaldo.MakeError(ps, err.Error())
+					println("Failed to read authorized keys: " + err.Error())
 				}
-				return arg0
-			default:
+				return ok
+			}))
+			return arg0
+		},
+	},
+
+	// Tests:
+	// ; equal { ssh-server "localhost:2222" |Public-key-auth fn { user key } { user = "admin" } |type? } 'native
+	// Args:
+	// * server: SSH server object
+	// * handler: Function that receives the user and the key in the authorized_keys format and returns true/false
+	// Returns:
+	// * the SSH server object
+	"ssh-server//Public-key-auth": {
+		Argsn: 2,
+		Doc:   "Sets a public key authentication handler for the SSH server.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			server, errObj := sshServerArg(ps, arg0, "ssh-server//Public-key-auth")
+			if errObj != nil {
+				return errObj
+			}
+			handler, ok := arg1.(env.Function)
+			if !ok {
+				ps.FailureFlag = true
+				return evaldo.MakeArgError(ps, 2, []env.Type{env.FunctionType}, "ssh-server//Public-key-auth")
+			}
+			server.SetOption(ssh.PublicKeyAuth(func(ctx ssh.Context, key ssh.PublicKey) bool {
+				authorized := strings.TrimSpace(string(gossh.MarshalAuthorizedKey(key)))
+				return util.IsTruthy(sshHandler(ps, ctx, handler, *env.NewString(ctx.User()), *env.NewString(authorized)).Res)
+			}))
+			return arg0
+		},
+	},
+
+	// Tests:
+	// ; equal { ssh-server "localhost:2222" |Subsystem "echo" fn { s } { s .Write ( s .Read-line ) } |type? } 'native
+	// Args:
+	// * server: SSH server object
+	// * name: String, the name of the subsystem clients ask for
+	// * handler: Function that receives an SSH session object
+	// Returns:
+	// * the SSH server object
+	"ssh-server//Subsystem": {
+		Argsn: 3,
+		Doc:   "Sets a handler for a subsystem, like the session handler but for the clients that ask for the subsystem by name.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			server, errObj := sshServerArg(ps, arg0, "ssh-server//Subsystem")
+			if errObj != nil {
+				return errObj
+			}
+			name, ok := arg1.(env.String)
+			if !ok {
+				ps.FailureFlag = true
+				return evaldo.MakeArgError(ps, 2, []env.Type{env.StringType}, "ssh-server//Subsystem")
+			}
+			handler, ok := arg2.(env.Function)
+			if !ok {
 				ps.FailureFlag = true
-				return evaldo.MakeArgError(ps, 1, []env.Type{env.NativeType}, "ssh-server//Serve")
+				return evaldo.MakeArgError(ps, 3, []env.Type{env.FunctionType}, "ssh-server//Subsystem")
+			}
+			if server.SubsystemHandlers == nil {
+				server.SubsystemHandlers = map[string]ssh.SubsystemHandler{}
+			}
+			server.SubsystemHandlers[name.Value] = func(s ssh.Session) {
+				sshHandler(ps, s.Context(), handler, *env.NewNative(ps.Idx, newSshSession(s), "ssh-session"))
+			}
+			return arg0
+		},
+	},
+
+	// Tests:
+	// ; equal { ssh-server "localhost:2222" |Password-auth fn { pass } { pass = "secret" } |Sftp |type? } 'native
+	// ; error { ssh-server "localhost:2222" |Sftp }
+	// Args:
+	// * server: SSH server object
+	// Returns:
+	// * the SSH server object
+	"ssh-server//Sftp": {
+		Argsn: 1,
+		Doc:   "Adds the sftp subsystem, clients can then read and write the files of the server's user, relative paths start in the directory the server runs in. Authentication must be set first.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			server, errObj := sshServerArg(ps, arg0, "ssh-server//Sftp")
+			if errObj != nil {
+				return errObj
+			}
+			if err := sshCheckAuth(server); err != nil {
+				return evaldo.MakeBuiltinError(ps, err.Error(), "ssh-server//Sftp")
+			}
+			if server.SubsystemHandlers == nil {
+				server.SubsystemHandlers = map[string]ssh.SubsystemHandler{}
+			}
+			server.SubsystemHandlers["sftp"] = sshServeSftp
+			return arg0
+		},
+	},
+
+	// Tests:
+	// ; equal { server: ssh-server "localhost:2222" |Password-auth fn { pass } { pass = "secret" } , server |Serve |type? } 'native
+	// ; error { ssh-server "localhost:2222" |Serve }
+	// Args:
+	// * server: SSH server object
+	// Returns:
+	// * the SSH server object, or error if unable to serve or no authentication is set
+	"ssh-server//Serve": {
+		Argsn: 1,
+		Doc:   "Starts the SSH server, listening for connections. It fails without Password-auth, Public-key-auth or Authorized-keys, as the server would let anyone in. Scripts that served without authentication before must now set one of them.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			server, errObj := sshServerArg(ps, arg0, "ssh-server//Serve")
+			if errObj != nil {
+				return errObj
 			}
+			if err := sshCheckAuth(server); err != nil {
+				return evaldo.MakeBuiltinError(ps, err.Error(), "ssh-server//Serve")
+			}
+			err := server.ListenAndServe()
+			if err != nil {
+				return evaldo.MakeError(ps, err.Error())
+			}
+			return arg0
 		},
 	},
 
@@ -163,7 +309,13 @@ var Builtins_ssh = map[string]*env.Builtin{
 			case env.Native:
 				switch val := arg1.(type) {
 				case env.String:
-					io.WriteString(session.Value.(ssh.Session), val.Value)
+					sess, errObj := sshSessionArg(ps, session, "ssh-session//Write")
+					if errObj != nil {
+						return errObj
+					}
+					if err := sess.writeText(val.Value); err != nil {
+						return evaldo.MakeBuiltinError(ps, err.Error(), "ssh-session//Write")
+					}
 					return arg0
 				default:
 					ps.FailureFlag = true
@@ -175,4 +327,712 @@ var Builtins_ssh = map[string]*env.Builtin{
 			}
 		},
 	},
+
+	// Tests:
+	// ; equal { session: ssh-session-mock , session |Read-line |type? } 'string
+	// Args:
+	// * session: SSH session object
+	// Returns:
+	// * String, the line without the newline, failure when the client closed the input
+	"ssh-session//Read-line": {
+		Argsn: 1,
+		Doc:   "Reads a line from an SSH session, with a PTY it's edited and echoed to the client like in a shell.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			sess, errObj := sshSessionArg(ps, arg0, "ssh-session//Read-line")
+			if errObj != nil {
+				return errObj
+			}
+			line, err := sess.readLine()
+			if err != nil {
+				return evaldo.MakeBuiltinError(ps, err.Error(), "ssh-session//Read-line")
+			}
+			return *env.NewString(line)
+		},
+	},
+
+	// Tests:
+	// ; equal { session: ssh-session-mock , session |Read |type? } 'string
+	// Args:
+	// * session: SSH session object
+	// Returns:
+	// * String with what the client has sent, failure when it closed the input
+	"ssh-session//Read": {
+		Argsn: 1,
+		Doc:   "Reads what the client has sent so far, waiting for at least one byte. With a PTY keys come one by one and aren't echoed.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			sess, errObj := sshSessionArg(ps, arg0, "ssh-session//Read")
+			if errObj != nil {
+				return errObj
+			}
+			data, err := sess.read(4096)
+			if err != nil {
+				return evaldo.MakeBuiltinError(ps, err.Error(), "ssh-session//Read")
+			}
+			return *env.NewString(data)
+		},
+	},
+
+	// Tests:
+	// ; equal { session: ssh-session-mock , session |User? } "admin"
+	// Args:
+	// * session: SSH session object
+	// Returns:
+	// * String, the user the client logged in as
+	"ssh-session//User?": {
+		Argsn: 1,
+		Doc:   "Returns the user of an SSH session.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			sess, errObj := sshSessionArg(ps, arg0, "ssh-session//User?")
+			if errObj != nil {
+				return errObj
+			}
+			return *env.NewString(sess.User())
+		},
+	},
+
+	// Tests:
+	// ; equal { session: ssh-session-mock , session |Command? } "uptime"
+	// Args:
+	// * session: SSH session object
+	// Returns:
+	// * String, the command the client asked to run, empty for a shell
+	"ssh-session//Command?": {
+		Argsn: 1,
+		Doc:   "Returns the command of an SSH session, or an empty string when the client asked for a shell.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			sess, errObj := sshSessionArg(ps, arg0, "ssh-session//Command?")
+			if errObj != nil {
+				return errObj
+			}
+			return *env.NewString(sess.RawCommand())
+		},
+	},
+
+	// Tests:
+	// ; equal { session: ssh-session-mock , session |Pty? } true
+	// Args:
+	// * session: SSH session object
+	// Returns:
+	// * Boolean, true if the client asked for a PTY
+	"ssh-session//Pty?": {
+		Argsn: 1,
+		Doc:   "Returns whether the client of an SSH session asked for a PTY, which it does for interactive sessions.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			sess, errObj := sshSessionArg(ps, arg0, "ssh-session//Pty?")
+			if errObj != nil {
+				return errObj
+			}
+			return *env.NewBoolean(sess.isPty)
+		},
+	},
+
+	// Tests:
+	// ; equal { session: ssh-session-mock , session |Term? } "xterm-256color"
+	// Args:
+	// * session: SSH session object
+	// Returns:
+	// * String, the TERM of the client's PTY, failure without a PTY
+	"ssh-session//Term?": {
+		Argsn: 1,
+		Doc:   "Returns the terminal type of the client's PTY.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			sess, errObj := sshSessionArg(ps, arg0, "ssh-session//Term?")
+			if errObj != nil {
+				return errObj
+			}
+			if !sess.isPty {
+				return evaldo.MakeBuiltinError(ps, "The session has no PTY", "ssh-session//Term?")
+			}
+			return *env.NewString(sess.pty.Term)
+		},
+	},
+
+	// Tests:
+	// ; equal { session: ssh-session-mock , session |Window-size? |-> "width" } 80
+	// Args:
+	// * session: SSH session object
+	// Returns:
+	// * Dict with width and height of the client's PTY, failure without a PTY
+	"ssh-session//Window-size?": {
+		Argsn: 1,
+		Doc:   "Returns the current size of the client's PTY in columns and rows.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			sess, errObj := sshSessionArg(ps, arg0, "ssh-session//Window-size?")
+			if errObj != nil {
+				return errObj
+			}
+			if !sess.isPty {
+				return evaldo.MakeBuiltinError(ps, "The session has no PTY", "ssh-session//Window-size?")
+			}
+			width, height := sess.window()
+			return *env.NewDict(map[string]any{
+				"width":  *env.NewInteger(int64(width)),
+				"height": *env.NewInteger(int64(height)),
+			})
+		},
+	},
+
+	// Tests:
+	// ; equal { session: ssh-session-mock , session |On-resize fn { w h } { print w } |type? } 'native
+	// Args:
+	// * session: SSH session object
+	// * handler: Function that receives the new width and height
+	// Returns:
+	// * the SSH session object
+	"ssh-session//On-resize": {
+		Argsn: 2,
+		Doc:   "Calls the function when the client's window changes size, only sessions with a PTY get these events.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			sess, errObj := sshSessionArg(ps, arg0, "ssh-session//On-resize")
+			if errObj != nil {
+				return errObj
+			}
+			handler, ok := arg1.(env.Function)
+			if !ok {
+				ps.FailureFlag = true
+				return evaldo.MakeArgError(ps, 2, []env.Type{env.FunctionType}, "ssh-session//On-resize")
+			}
+			sess.mu.Lock()
+			sess.onResize = append(sess.onResize, func(width, height int) {
+				sshHandler(ps, sess.Context(), handler, *env.NewInteger(int64(width)), *env.NewInteger(int64(height)))
+			})
+			sess.mu.Unlock()
+			return arg0
+		},
+	},
+
+	// Tests:
+	// ; equal { session: ssh-session-mock , session |Exit 1 |type? } 'native
+	// Args:
+	// * session: SSH session object
+	// * status: Integer, the exit status the client gets
+	// Returns:
+	// * the SSH session object
+	"ssh-session//Exit": {
+		Argsn: 2,
+		Doc:   "Ends an SSH session with an exit status, without it the session ends with 0 when the handler returns.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			sess, errObj := sshSessionArg(ps, arg0, "ssh-session//Exit")
+			if errObj != nil {
+				return errObj
+			}
+			status, ok := arg1.(env.Integer)
+			if !ok {
+				ps.FailureFlag = true
+				return evaldo.MakeArgError(ps, 2, []env.Type{env.IntegerType}, "ssh-session//Exit")
+			}
+			if err := sess.Exit(int(status.Value)); err != nil {
+				return evaldo.MakeBuiltinError(ps, err.Error(), "ssh-session//Exit")
+			}
+			return arg0
+		},
+	},
+
+	// Tests:
+	// ; equal { session: ssh-session-mock , session |Repl |type? } 'native
+	// Args:
+	// * session: SSH session object with a PTY
+	// Returns:
+	// * the SSH session object, when the client ends the REPL with Ctrl-D
+	"ssh-session//Repl": {
+		Argsn: 1,
+		Doc:   "Runs the Rye REPL on an SSH session, in a context of its own under the handler's. What the code prints goes to the client.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			sess, errObj := sshSessionArg(ps, arg0, "ssh-session//Repl")
+			if errObj != nil {
+				return errObj
+			}
+			if !sess.isPty {
+				return evaldo.MakeBuiltinError(ps, "The REPL needs a PTY, connect with ssh -t", "ssh-session//Repl")
+			}
+			// the REPL runs any code, it's only given to clients that logged in
+			server, ok := sess.Context().Value(ssh.ContextKeyServer).(*ssh.Server)
+			if !ok {
+				return evaldo.MakeBuiltinError(ps, "The session has no server", "ssh-session//Repl")
+			}
+			if err := sshCheckAuth(server); err != nil {
+				return evaldo.MakeBuiltinError(ps, err.Error(), "ssh-session//Repl")
+			}
+			psRepl := env.ProgramState{}
+			copier.Copy(&psRepl, ps)
+			psRepl.Ctx = env.NewEnv(ps.Ctx)
+			psRepl.TaskCtx = sess.Context()
+			if err := console.DoRyeReplSession(&psRepl, sess.term(), "×> "); err != nil {
+				return evaldo.MakeBuiltinError(ps, err.Error(), "ssh-session//Repl")
+			}
+			return arg0
+		},
+	},
+
+	//
+	// ##### SSH client ##### "Running commands, copying files and forwarding ports over SSH"
+	//
+	// Example:
+	//  conn: ssh-client "example.com:22" "deploy" |Agent |Connect
+	//  conn .Output "uptime" |print
+	//  conn .Upload %build/app.tar.gz "/tmp/app.tar.gz"
+	//  conn .Run "tar xzf /tmp/app.tar.gz -C /srv" |-> "exit-status" |print
+	//  tunnel: conn .Forward-local "localhost:5433" "localhost:5432"
+	//  ; ... use the database on localhost:5433
+	//  tunnel .Close
+	//  conn .Close
+	//
+	// Tests:
+	// ; equal { ssh-client "localhost:2222" "user" |type? } 'native
+	// Args:
+	// * address: String with the host:port of the server
+	// * user: String, the user to log in as
+	// Returns:
+	// * native SSH client object, authentication and host key checking are set on it before it connects
+	"ssh-client": {
+		Argsn: 2,
+		Doc:   "Creates an SSH client for the address and user, host keys are checked against ~/.ssh/known_hosts by default.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			addr, ok := arg0.(env.String)
+			if !ok {
+				ps.FailureFlag = true
+				return evaldo.MakeArgError(ps, 1, []env.Type{env.StringType}, "ssh-client")
+			}
+			user, ok := arg1.(env.String)
+			if !ok {
+				ps.FailureFlag = true
+				return evaldo.MakeArgError(ps, 2, []env.Type{env.StringType}, "ssh-client")
+			}
+			return *env.NewNative(ps.Idx, &sshClientConfig{addr: addr.Value, user: user.Value, timeout: 15 * time.Second}, "ssh-client")
+		},
+	},
+
+	// Args:
+	// * client: SSH client object
+	// * password: String
+	// Returns:
+	// * the SSH client object
+	"ssh-client//Password": {
+		Argsn: 2,
+		Doc:   "Authenticates the SSH client with a password.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			c, errObj := sshClientArg(ps, arg0, "ssh-client//Password")
+			if errObj != nil {
+				return errObj
+			}
+			strs, errObj := sshStringArgs(ps, "ssh-client//Password", arg1)
+			if errObj != nil {
+				return errObj
+			}
+			c.auth = append(c.auth, gossh.Password(strs[0]))
+			return arg0
+		},
+	},
+
+	// Args:
+	// * client: SSH client object
+	// * path: Uri or String with the path of a private key file
+	// Returns:
+	// * the SSH client object
+	"ssh-client//Key-file": {
+		Argsn: 2,
+		Doc:   "Authenticates the SSH client with a private key file.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			c, errObj := sshClientArg(ps, arg0, "ssh-client//Key-file")
+			if errObj != nil {
+				return errObj
+			}
+			path, errObj := sshPath(ps, arg1, 2, "ssh-client//Key-file")
+			if errObj != nil {
+				return errObj
+			}
+			auth, err := sshKeyFileAuth(path, nil)
+			if err != nil {
+				return evaldo.MakeBuiltinError(ps, err.Error(), "ssh-client//Key-file")
+			}
+			c.auth = append(c.auth, auth)
+			return arg0
+		},
+	},
+
+	// Args:
+	// * client: SSH client object
+	// * path: Uri or String with the path of an encrypted private key file
+	// * passphrase: String that decrypts the key
+	// Returns:
+	// * the SSH client object
+	"ssh-client//Key-file\\passphrase": {
+		Argsn: 3,
+		Doc:   "Authenticates the SSH client with a private key file encrypted with a passphrase.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			c, errObj := sshClientArg(ps, arg0, "ssh-client//Key-file\\passphrase")
+			if errObj != nil {
+				return errObj
+			}
+			path, errObj := sshPath(ps, arg1, 2, "ssh-client//Key-file\\passphrase")
+			if errObj != nil {
+				return errObj
+			}
+			passphrase, ok := arg2.(env.String)
+			if !ok {
+				ps.FailureFlag = true
+				return evaldo.MakeArgError(ps, 3, []env.Type{env.StringType}, "ssh-client//Key-file\\passphrase")
+			}
+			auth, err := sshKeyFileAuth(path, &passphrase.Value)
+			if err != nil {
+				return evaldo.MakeBuiltinError(ps, err.Error(), "ssh-client//Key-file\\passphrase")
+			}
+			c.auth = append(c.auth, auth)
+			return arg0
+		},
+	},
+
+	// Args:
+	// * client: SSH client object
+	// Returns:
+	// * the SSH client object, or a failure if SSH_AUTH_SOCK doesn't lead to an agent
+	"ssh-client//Agent": {
+		Argsn: 1,
+		Doc:   "Authenticates the SSH client with the keys of the SSH agent.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			c, errObj := sshClientArg(ps, arg0, "ssh-client//Agent")
+			if errObj != nil {
+				return errObj
+			}
+			auth, conn, err := sshAgentAuth()
+			if err != nil {
+				return evaldo.MakeBuiltinError(ps, err.Error(), "ssh-client//Agent")
+			}
+			c.auth = append(c.auth, auth)
+			c.agentConn = conn
+			return arg0
+		},
+	},
+
+	// Args:
+	// * client: SSH client object
+	// * path: Uri or String with the path of a known_hosts file, can be called for more files
+	// Returns:
+	// * the SSH client object
+	"ssh-client//Known-hosts": {
+		Argsn: 2,
+		Doc:   "Checks the host key of the server against a known_hosts file instead of ~/.ssh/known_hosts.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			c, errObj := sshClientArg(ps, arg0, "ssh-client//Known-hosts")
+			if errObj != nil {
+				return errObj
+			}
+			path, errObj := sshPath(ps, arg1, 2, "ssh-client//Known-hosts")
+			if errObj != nil {
+				return errObj
+			}
+			c.knownHosts = append(c.knownHosts, path)
+			return arg0
+		},
+	},
+
+	// Args:
+	// * client: SSH client object
+	// Returns:
+	// * the SSH client object
+	"ssh-client//Insecure-ignore-host-key": {
+		Argsn: 1,
+		Doc:   "Accepts any host key of the server, only for testing as it allows man-in-the-middle attacks.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			c, errObj := sshClientArg(ps, arg0, "ssh-client//Insecure-ignore-host-key")
+			if errObj != nil {
+				return errObj
+			}
+			c.insecure = true
+			return arg0
+		},
+	},
+
+	// Args:
+	// * client: SSH client object
+	// * timeout: Integer, milliseconds to connect and authenticate in, 15 seconds by default
+	// Returns:
+	// * the SSH client object
+	"ssh-client//Timeout": {
+		Argsn: 2,
+		Doc:   "Sets how long the SSH client waits for the server when connecting.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			c, errObj := sshClientArg(ps, arg0, "ssh-client//Timeout")
+			if errObj != nil {
+				return errObj
+			}
+			timeout, ok := arg1.(env.Integer)
+			if !ok {
+				ps.FailureFlag = true
+				return evaldo.MakeArgError(ps, 2, []env.Type{env.IntegerType}, "ssh-client//Timeout")
+			}
+			c.timeout = time.Duration(timeout.Value) * time.Millisecond
+			return arg0
+		},
+	},
+
+	// Args:
+	// * client: SSH client object
+	// Returns:
+	// * native SSH connection object
+	"ssh-client//Connect": {
+		Argsn: 1,
+		Doc:   "Connects the SSH client to the server.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			c, errObj := sshClientArg(ps, arg0, "ssh-client//Connect")
+			if errObj != nil {
+				return errObj
+			}
+			conn, err := c.connect(ps.GoContext())
+			if err != nil {
+				return evaldo.MakeBuiltinError(ps, err.Error(), "ssh-client//Connect")
+			}
+			return *env.NewNative(ps.Idx, conn, "ssh-connection")
+		},
+	},
+
+	// Args:
+	// * connection: SSH connection object
+	// * command: String with the command to run on the server
+	// Returns:
+	// * dict with the "stdout" and "stderr" strings and the "exit-status" integer, -1 if the server didn't report it
+	"ssh-connection//Run": {
+		Argsn: 2,
+		Doc:   "Runs a command on the SSH server and waits for it to finish.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			c, errObj := sshConnectionArg(ps, arg0, "ssh-connection//Run")
+			if errObj != nil {
+				return errObj
+			}
+			strs, errObj := sshStringArgs(ps, "ssh-connection//Run", arg1)
+			if errObj != nil {
+				return errObj
+			}
+			stdout, stderr, status, err := c.run(ps.GoContext(), strs[0])
+			if err != nil {
+				return evaldo.MakeBuiltinError(ps, err.Error(), "ssh-connection//Run")
+			}
+			return *env.NewDict(map[string]any{
+				"stdout":      *env.NewString(string(stdout)),
+				"stderr":      *env.NewString(string(stderr)),
+				"exit-status": *env.NewInteger(int64(status)),
+			})
+		},
+	},
+
+	// Args:
+	// * connection: SSH connection object
+	// * command: String with the command to run on the server
+	// Returns:
+	// * string with the standard output of the command, or a failure with its standard error if it exits with a non-zero status
+	"ssh-connection//Output": {
+		Argsn: 2,
+		Doc:   "Runs a command on the SSH server and returns its standard output.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			c, errObj := sshConnectionArg(ps, arg0, "ssh-connection//Output")
+			if errObj != nil {
+				return errObj
+			}
+			strs, errObj := sshStringArgs(ps, "ssh-connection//Output", arg1)
+			if errObj != nil {
+				return errObj
+			}
+			stdout, stderr, status, err := c.run(ps.GoContext(), strs[0])
+			if err != nil {
+				return evaldo.MakeBuiltinError(ps, err.Error(), "ssh-connection//Output")
+			}
+			if status != 0 {
+				return evaldo.MakeBuiltinError(ps, fmt.Sprintf("exit status %d: %s", status, strings.TrimSpace(string(stderr))), "ssh-connection//Output")
+			}
+			return *env.NewString(string(stdout))
+		},
+	},
+
+	// Args:
+	// * connection: SSH connection object
+	// * local: Uri or String with the path of the local file
+	// * remote: String with the path on the server
+	// Returns:
+	// * the SSH connection object
+	"ssh-connection//Upload": {
+		Argsn: 3,
+		Doc:   "Copies a local file to the SSH server over SFTP.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			c, errObj := sshConnectionArg(ps, arg0, "ssh-connection//Upload")
+			if errObj != nil {
+				return errObj
+			}
+			local, errObj := sshPath(ps, arg1, 2, "ssh-connection//Upload")
+			if errObj != nil {
+				return errObj
+			}
+			remote, errObj := sshPath(ps, arg2, 3, "ssh-connection//Upload")
+			if errObj != nil {
+				return errObj
+			}
+			if err := c.upload(local, remote); err != nil {
+				return evaldo.MakeBuiltinError(ps, err.Error(), "ssh-connection//Upload")
+			}
+			return arg0
+		},
+	},
+
+	// Args:
+	// * connection: SSH connection object
+	// * remote: String with the path on the server
+	// * local: Uri or String with the path of the local file
+	// Returns:
+	// * the SSH connection object
+	"ssh-connection//Download": {
+		Argsn: 3,
+		Doc:   "Copies a file from the SSH server to a local file over SFTP.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			c, errObj := sshConnectionArg(ps, arg0, "ssh-connection//Download")
+			if errObj != nil {
+				return errObj
+			}
+			remote, errObj := sshPath(ps, arg1, 2, "ssh-connection//Download")
+			if errObj != nil {
+				return errObj
+			}
+			local, errObj := sshPath(ps, arg2, 3, "ssh-connection//Download")
+			if errObj != nil {
+				return errObj
+			}
+			if err := c.download(remote, local); err != nil {
+				return evaldo.MakeBuiltinError(ps, err.Error(), "ssh-connection//Download")
+			}
+			return arg0
+		},
+	},
+
+	// Args:
+	// * connection: SSH connection object
+	// * path: String with the directory on the server
+	// Returns:
+	// * list of dicts with the name, size, mode, modified time and is-dir of the files in the directory
+	"ssh-connection//List": {
+		Argsn: 2,
+		Doc:   "Lists a directory on the SSH server over SFTP.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			c, errObj := sshConnectionArg(ps, arg0, "ssh-connection//List")
+			if errObj != nil {
+				return errObj
+			}
+			path, errObj := sshPath(ps, arg1, 2, "ssh-connection//List")
+			if errObj != nil {
+				return errObj
+			}
+			items, err := c.list(path)
+			if err != nil {
+				return evaldo.MakeBuiltinError(ps, err.Error(), "ssh-connection//List")
+			}
+			return *env.NewList(items)
+		},
+	},
+
+	// Args:
+	// * connection: SSH connection object
+	// * local: String with the local address to listen on, like "localhost:8080"
+	// * remote: String with the address the server connects to, like "localhost:80"
+	// Returns:
+	// * native SSH forward object
+	"ssh-connection//Forward-local": {
+		Argsn: 3,
+		Doc:   "Forwards the connections to a local address to an address reached from the SSH server.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			c, errObj := sshConnectionArg(ps, arg0, "ssh-connection//Forward-local")
+			if errObj != nil {
+				return errObj
+			}
+			strs, errObj := sshStringArgs(ps, "ssh-connection//Forward-local", arg1, arg2)
+			if errObj != nil {
+				return errObj
+			}
+			f, err := c.forwardLocal(strs[0], strs[1])
+			if err != nil {
+				return evaldo.MakeBuiltinError(ps, err.Error(), "ssh-connection//Forward-local")
+			}
+			return *env.NewNative(ps.Idx, f, "ssh-forward")
+		},
+	},
+
+	// Args:
+	// * connection: SSH connection object
+	// * remote: String with the address the SSH server listens on, like "localhost:8080"
+	// * local: String with the local address the connections go to, like "localhost:3000"
+	// Returns:
+	// * native SSH forward object
+	"ssh-connection//Forward-remote": {
+		Argsn: 3,
+		Doc:   "Forwards the connections to an address on the SSH server to a local address.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			c, errObj := sshConnectionArg(ps, arg0, "ssh-connection//Forward-remote")
+			if errObj != nil {
+				return errObj
+			}
+			strs, errObj := sshStringArgs(ps, "ssh-connection//Forward-remote", arg1, arg2)
+			if errObj != nil {
+				return errObj
+			}
+			f, err := c.forwardRemote(strs[0], strs[1])
+			if err != nil {
+				return evaldo.MakeBuiltinError(ps, err.Error(), "ssh-connection//Forward-remote")
+			}
+			return *env.NewNative(ps.Idx, f, "ssh-forward")
+		},
+	},
+
+	// Args:
+	// * connection: SSH connection object
+	// Returns:
+	// * void
+	"ssh-connection//Close": {
+		Argsn: 1,
+		Doc:   "Closes the SSH connection.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			c, errObj := sshConnectionArg(ps, arg0, "ssh-connection//Close")
+			if errObj != nil {
+				return errObj
+			}
+			if err := c.close(); err != nil {
+				return evaldo.MakeBuiltinError(ps, err.Error(), "ssh-connection//Close")
+			}
+			return env.Void{}
+		},
+	},
+
+	// Args:
+	// * forward: SSH forward object
+	// Returns:
+	// * string with the address the forward listens on, useful when the port was 0
+	"ssh-forward//Addr?": {
+		Argsn: 1,
+		Doc:   "Returns the address an SSH forward listens on.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			if native, ok := arg0.(env.Native); ok {
+				if f, ok := native.Value.(*sshForward); ok {
+					return *env.NewString(f.listener.Addr().String())
+				}
+			}
+			ps.FailureFlag = true
+			return evaldo.MakeArgError(ps, 1, []env.Type{env.NativeType}, "ssh-forward//Addr?")
+		},
+	},
+
+	// Args:
+	// * forward: SSH forward object
+	// Returns:
+	// * void, after the forwarded connections are closed
+	"ssh-forward//Close": {
+		Argsn: 1,
+		Doc:   "Stops an SSH forward.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			if native, ok := arg0.(env.Native); ok {
+				if f, ok := native.Value.(*sshForward); ok {
+					if err := f.close(); err != nil {
+						return evaldo.MakeBuiltinError(ps, err.Error(), "ssh-forward//Close")
+					}
+					return env.Void{}
+				}
+			}
+			ps.FailureFlag = true
+			return evaldo.MakeArgError(ps, 1, []env.Type{env.NativeType}, "ssh-forward//Close")
+		},
+	},
 }
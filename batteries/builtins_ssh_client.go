@@ -0,0 +1,391 @@
+//go:build add_ssh
+// +build add_ssh
+
+package batteries
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/refaktor/rye/env"
+	"github.com/refaktor/rye/evaldo"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sshClientConfig collects the settings of an SSH connection before it's made
+type sshClientConfig struct {
+	addr       string
+	user       string
+	auth       []gossh.AuthMethod
+	knownHosts []string
+	insecure   bool
+	timeout    time.Duration
+	agentConn  net.Conn
+}
+
+// sshConnection is a connected SSH client, the SFTP session is opened when it's first needed
+type sshConnection struct {
+	client    *gossh.Client
+	agentConn net.Conn
+	mu        sync.Mutex
+	sftp      *sftp.Client
+}
+
+// sshForward is a port forwarded over an SSH connection until it's closed
+type sshForward struct {
+	listener net.Listener
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	conns    map[net.Conn]struct{}
+	closed   bool
+}
+
+func (c *sshClientConfig) hostKeyCallback() (gossh.HostKeyCallback, error) {
+	if c.insecure {
+		return gossh.InsecureIgnoreHostKey(), nil
+	}
+	files := c.knownHosts
+	if len(files) == 0 {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		files = []string{filepath.Join(home, ".ssh", "known_hosts")}
+	}
+	return knownhosts.New(files...)
+}
+
+func (c *sshClientConfig) connect(ctx context.Context) (*sshConnection, error) {
+	if len(c.auth) == 0 {
+		return nil, errors.New("no authentication method, use Password, Key-file or Agent")
+	}
+	hostKeyCallback, err := c.hostKeyCallback()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load known hosts: %w", err)
+	}
+	config := &gossh.ClientConfig{User: c.user, Auth: c.auth, HostKeyCallback: hostKeyCallback, Timeout: c.timeout}
+	dialer := net.Dialer{Timeout: c.timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, err
+	}
+	// the handshake can hang too, the deadline is removed when it's done
+	if c.timeout > 0 {
+		conn.SetDeadline(time.Now().Add(c.timeout))
+	}
+	sshConn, chans, reqs, err := gossh.NewClientConn(conn, c.addr, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return &sshConnection{client: gossh.NewClient(sshConn, chans, reqs), agentConn: c.agentConn}, nil
+}
+
+// run runs a command on the server, the exit status is -1 if the server didn't send one
+func (c *sshConnection) run(ctx context.Context, command string) (stdout []byte, stderr []byte, status int, err error) {
+	session, err := c.client.NewSession()
+	if err != nil {
+		return nil, nil, -1, err
+	}
+	defer session.Close()
+	var outBuf, errBuf bytes.Buffer
+	session.Stdout = &outBuf
+	session.Stderr = &errBuf
+	if err := session.Start(command); err != nil {
+		return nil, nil, -1, err
+	}
+	done := make(chan error, 1)
+	go func() { done <- session.Wait() }()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		session.Signal(gossh.SIGKILL)
+		session.Close()
+		// the session copies the output until Wait returns, the buffers can be read after that
+		<-done
+		return outBuf.Bytes(), errBuf.Bytes(), -1, context.Cause(ctx)
+	}
+	var exitErr *gossh.ExitError
+	var missingErr *gossh.ExitMissingError
+	switch {
+	case err == nil:
+		return outBuf.Bytes(), errBuf.Bytes(), 0, nil
+	case errors.As(err, &exitErr):
+		return outBuf.Bytes(), errBuf.Bytes(), exitErr.ExitStatus(), nil
+	case errors.As(err, &missingErr):
+		return outBuf.Bytes(), errBuf.Bytes(), -1, nil
+	}
+	return outBuf.Bytes(), errBuf.Bytes(), -1, err
+}
+
+func (c *sshConnection) sftpClient() (*sftp.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sftp == nil {
+		client, err := sftp.NewClient(c.client)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start SFTP: %w", err)
+		}
+		c.sftp = client
+	}
+	return c.sftp, nil
+}
+
+func (c *sshConnection) upload(local string, remote string) error {
+	client, err := c.sftpClient()
+	if err != nil {
+		return err
+	}
+	src, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := client.Create(remote)
+	if err != nil {
+		return err
+	}
+	if _, err := dst.ReadFrom(src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+func (c *sshConnection) download(remote string, local string) error {
+	client, err := c.sftpClient()
+	if err != nil {
+		return err
+	}
+	src, err := client.Open(remote)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(local)
+	if err != nil {
+		return err
+	}
+	if _, err := src.WriteTo(dst); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+func (c *sshConnection) list(path string) ([]any, error) {
+	client, err := c.sftpClient()
+	if err != nil {
+		return nil, err
+	}
+	infos, err := client.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]any, len(infos))
+	for i, info := range infos {
+		items[i] = *env.NewDict(map[string]any{
+			"name":     *env.NewString(info.Name()),
+			"size":     *env.NewInteger(info.Size()),
+			"mode":     *env.NewString(info.Mode().String()),
+			"modified": *env.NewTime(info.ModTime()),
+			"is-dir":   *env.NewBoolean(info.IsDir()),
+		})
+	}
+	return items, nil
+}
+
+func (c *sshConnection) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var errs []error
+	if c.sftp != nil {
+		errs = append(errs, c.sftp.Close())
+	}
+	errs = append(errs, c.client.Close())
+	if c.agentConn != nil {
+		errs = append(errs, c.agentConn.Close())
+	}
+	// closing an SFTP session after its connection reports that it's closed
+	if errors.Is(errs[0], io.EOF) || errors.Is(errs[0], net.ErrClosed) {
+		errs[0] = nil
+	}
+	return errors.Join(errs...)
+}
+
+// forwardConns accepts connections on the listener and connects each to the address with dial
+func forwardConns(listener net.Listener, dial func() (net.Conn, error)) *sshForward {
+	f := &sshForward{listener: listener, conns: make(map[net.Conn]struct{})}
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			f.track(conn, true)
+			f.wg.Add(1)
+			go func() {
+				defer f.wg.Done()
+				defer f.track(conn, false)
+				target, err := dial()
+				if err != nil {
+					fmt.Fprintln(os.Stderr, "SSH forwarding failed: "+err.Error())
+					return
+				}
+				f.track(target, true)
+				defer f.track(target, false)
+				pipeConns(conn, target)
+			}()
+		}
+	}()
+	return f
+}
+
+// pipeConns copies both ways until one side is done
+func pipeConns(a net.Conn, b net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() { io.Copy(a, b); done <- struct{}{} }()
+	go func() { io.Copy(b, a); done <- struct{}{} }()
+	<-done
+}
+
+// forwardLocal listens on a local address and connects to the remote address from the server
+func (c *sshConnection) forwardLocal(local string, remote string) (*sshForward, error) {
+	listener, err := net.Listen("tcp", local)
+	if err != nil {
+		return nil, err
+	}
+	return forwardConns(listener, func() (net.Conn, error) { return c.client.Dial("tcp", remote) }), nil
+}
+
+// forwardRemote asks the server to listen on the remote address and connects to the local one
+func (c *sshConnection) forwardRemote(remote string, local string) (*sshForward, error) {
+	listener, err := c.client.Listen("tcp", remote)
+	if err != nil {
+		return nil, err
+	}
+	return forwardConns(listener, func() (net.Conn, error) { return net.Dial("tcp", local) }), nil
+}
+
+// track adds an open connection of the forward, or closes and removes it
+func (f *sshForward) track(conn net.Conn, open bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if open && f.closed {
+		conn.Close()
+		return
+	}
+	if open {
+		f.conns[conn] = struct{}{}
+		return
+	}
+	if _, ok := f.conns[conn]; ok {
+		delete(f.conns, conn)
+		conn.Close()
+	}
+}
+
+func (f *sshForward) close() error {
+	err := f.listener.Close()
+	f.mu.Lock()
+	f.closed = true
+	for conn := range f.conns {
+		conn.Close()
+	}
+	f.mu.Unlock()
+	f.wg.Wait()
+	return err
+}
+
+func sshAgentAuth() (gossh.AuthMethod, net.Conn, error) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, nil, errors.New("SSH_AUTH_SOCK is not set, is the agent running?")
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, nil, err
+	}
+	return gossh.PublicKeysCallback(agent.NewClient(conn).Signers), conn, nil
+}
+
+func sshKeyFileAuth(path string, passphrase *string) (gossh.AuthMethod, error) {
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var signer gossh.Signer
+	if passphrase != nil {
+		signer, err = gossh.ParsePrivateKeyWithPassphrase(key, []byte(*passphrase))
+	} else {
+		signer, err = gossh.ParsePrivateKey(key)
+	}
+	var missing *gossh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		return nil, errors.New("the key is encrypted, use Key-file\\passphrase")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return gossh.PublicKeys(signer), nil
+}
+
+// sshPath returns the path of a file URI or a string
+func sshPath(ps *env.ProgramState, arg env.Object, pos int, fnName string) (string, env.Object) {
+	switch p := arg.(type) {
+	case env.String:
+		return p.Value, nil
+	case env.Uri:
+		return p.GetPath(), nil
+	}
+	ps.FailureFlag = true
+	return "", evaldo.MakeArgError(ps, pos, []env.Type{env.StringType, env.UriType}, fnName)
+}
+
+func sshClientArg(ps *env.ProgramState, arg env.Object, fnName string) (*sshClientConfig, env.Object) {
+	if native, ok := arg.(env.Native); ok {
+		if c, ok := native.Value.(*sshClientConfig); ok {
+			return c, nil
+		}
+	}
+	ps.FailureFlag = true
+	return nil, evaldo.MakeArgError(ps, 1, []env.Type{env.NativeType}, fnName)
+}
+
+func sshConnectionArg(ps *env.ProgramState, arg env.Object, fnName string) (*sshConnection, env.Object) {
+	if native, ok := arg.(env.Native); ok {
+		if c, ok := native.Value.(*sshConnection); ok {
+			return c, nil
+		}
+	}
+	ps.FailureFlag = true
+	return nil, evaldo.MakeArgError(ps, 1, []env.Type{env.NativeType}, fnName)
+}
+
+// sshStringArgs returns the string arguments of a builtin, starting at position 2
+func sshStringArgs(ps *env.ProgramState, fnName string, args ...env.Object) ([]string, env.Object) {
+	strs := make([]string, len(args))
+	for i, arg := range args {
+		s, ok := arg.(env.String)
+		if !ok {
+			ps.FailureFlag = true
+			return nil, evaldo.MakeArgError(ps, i+2, []env.Type{env.StringType}, fnName)
+		}
+		strs[i] = s.Value
+	}
+	return strs, nil
+}
@@ -0,0 +1,219 @@
+//go:build add_ssh
+// +build add_ssh
+
+package batteries
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gliderlabs/ssh"
+	"github.com/jinzhu/copier"
+	"github.com/pkg/sftp"
+	"github.com/refaktor/rye/env"
+	"github.com/refaktor/rye/evaldo"
+	gossh "golang.org/x/crypto/ssh"
+	xterm "golang.org/x/term"
+)
+
+// sshSession is a session on the SSH server, the size of its PTY is kept up to date
+type sshSession struct {
+	ssh.Session
+	mu       sync.Mutex
+	isPty    bool
+	pty      ssh.Pty
+	onResize []func(width, height int)
+	terminal *xterm.Terminal
+	reader   *bufio.Reader
+}
+
+func newSshSession(s ssh.Session) *sshSession {
+	sess := &sshSession{Session: s}
+	pty, winCh, isPty := s.Pty()
+	sess.isPty = isPty
+	sess.pty = pty
+	if isPty {
+		// the first size is the one of the request, it's not a resize
+		select {
+		case <-winCh:
+		default:
+		}
+		// the channel must be drained or the session stops handling requests
+		go func() {
+			for win := range winCh {
+				sess.mu.Lock()
+				sess.pty.Window = win
+				if sess.terminal != nil && win.Width > 0 && win.Height > 0 {
+					sess.terminal.SetSize(win.Width, win.Height)
+				}
+				handlers := sess.onResize
+				sess.mu.Unlock()
+				for _, handler := range handlers {
+					handler(win.Width, win.Height)
+				}
+			}
+		}()
+	}
+	return sess
+}
+
+// Columns returns the width of the PTY, or 80 without one
+func (sess *sshSession) Columns() int {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if !sess.isPty || sess.pty.Window.Width <= 0 {
+		return 80
+	}
+	return sess.pty.Window.Width
+}
+
+func (sess *sshSession) window() (int, int) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	return sess.pty.Window.Width, sess.pty.Window.Height
+}
+
+// term returns the line editor of a session with a PTY, the client leaves the echo to the server
+func (sess *sshSession) term() *xterm.Terminal {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.terminal == nil {
+		sess.terminal = xterm.NewTerminal(sess.Session, "")
+		// clients without a terminal of their own report 0x0, the editor then keeps its 80x24
+		if sess.pty.Window.Width > 0 && sess.pty.Window.Height > 0 {
+			sess.terminal.SetSize(sess.pty.Window.Width, sess.pty.Window.Height)
+		}
+	}
+	return sess.terminal
+}
+
+// writeText writes text to the session, on a PTY the newlines also return the cursor like a
+// terminal's driver would
+func (sess *sshSession) writeText(text string) error {
+	if sess.isPty {
+		text = strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\n", "\r\n")
+	}
+	_, err := io.WriteString(sess.Session, text)
+	return err
+}
+
+// readLine reads a line, edited and echoed on a PTY
+func (sess *sshSession) readLine() (string, error) {
+	if sess.isPty {
+		return sess.term().ReadLine()
+	}
+	sess.mu.Lock()
+	if sess.reader == nil {
+		sess.reader = bufio.NewReader(sess.Session)
+	}
+	reader := sess.reader
+	sess.mu.Unlock()
+	line, err := reader.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+// read reads what the client has sent, at most size bytes
+func (sess *sshSession) read(size int) (string, error) {
+	buf := make([]byte, size)
+	sess.mu.Lock()
+	reader := sess.reader
+	sess.mu.Unlock()
+	var n int
+	var err error
+	if reader != nil {
+		n, err = reader.Read(buf)
+	} else {
+		n, err = sess.Session.Read(buf)
+	}
+	if n > 0 {
+		err = nil
+	}
+	return string(buf[:n]), err
+}
+
+// sshHandler calls a handler of the server in its own copy of the program state, as sessions are
+// handled concurrently, it's canceled when the connection ends
+func sshHandler(ps *env.ProgramState, ctx context.Context, handler env.Function, args ...env.Object) *env.ProgramState {
+	psTemp := env.ProgramState{}
+	copier.Copy(&psTemp, ps)
+	psTemp.FailureFlag = false
+	psTemp.ErrorFlag = false
+	psTemp.ReturnFlag = false
+	psTemp.TaskCtx = ctx
+	evaldo.CallFunctionArgsN(handler, &psTemp, nil, args...)
+	if psTemp.FailureFlag || psTemp.ErrorFlag {
+		println("Error in SSH handler: " + psTemp.Res.Inspect(*ps.Idx))
+	}
+	return &psTemp
+}
+
+// sshAuthorizedKeys returns whether the key is in an authorized_keys file, it's read on each
+// login so keys can be added and removed while the server runs
+func sshAuthorizedKeys(path string, key ssh.PublicKey) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	for len(data) > 0 {
+		authorized, _, _, rest, err := gossh.ParseAuthorizedKey(data)
+		if err != nil {
+			// no more keys, the parser skips the lines that aren't keys
+			return false, nil
+		}
+		if ssh.KeysEqual(authorized, key) {
+			return true, nil
+		}
+		data = rest
+	}
+	return false, nil
+}
+
+// sshServeSftp serves the files of the server over an SFTP subsystem
+func sshServeSftp(s ssh.Session) {
+	server, err := sftp.NewServer(s)
+	if err != nil {
+		println("SFTP failed: " + err.Error())
+		return
+	}
+	if err := server.Serve(); err != nil && !errors.Is(err, io.EOF) {
+		println("SFTP failed: " + err.Error())
+	}
+	server.Close()
+}
+
+// sshCheckAuth fails when the server has no authentication handler, gliderlabs/ssh would then let
+// any client log in
+func sshCheckAuth(server *ssh.Server) error {
+	if server.PasswordHandler == nil && server.PublicKeyHandler == nil && server.KeyboardInteractiveHandler == nil {
+		return errors.New("the server has no authentication, set Password-auth, Public-key-auth or Authorized-keys first")
+	}
+	return nil
+}
+
+func sshServerArg(ps *env.ProgramState, arg env.Object, fnName string) (*ssh.Server, env.Object) {
+	if native, ok := arg.(env.Native); ok {
+		if s, ok := native.Value.(*ssh.Server); ok {
+			return s, nil
+		}
+	}
+	ps.FailureFlag = true
+	return nil, evaldo.MakeArgError(ps, 1, []env.Type{env.NativeType}, fnName)
+}
+
+func sshSessionArg(ps *env.ProgramState, arg env.Object, fnName string) (*sshSession, env.Object) {
+	if native, ok := arg.(env.Native); ok {
+		if s, ok := native.Value.(*sshSession); ok {
+			return s, nil
+		}
+	}
+	ps.FailureFlag = true
+	return nil, evaldo.MakeArgError(ps, 1, []env.Type{env.NativeType}, fnName)
+}
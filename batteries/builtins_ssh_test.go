@@ -0,0 +1,406 @@
+//go:build add_ssh
+// +build add_ssh
+
+package batteries
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+	"github.com/pkg/sftp"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sshKey writes a new private key to the directory and returns its path and public key
+func sshKey(t *testing.T, dir string, name string) (string, gossh.PublicKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := gossh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	sshPub, err := gossh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, sshPub
+}
+
+// sshServe serves the ssh-server that the code sets to srv on a free local port until the test ends,
+// the handlers copy the program state so it's only used by the server
+func sshServe(t *testing.T, code string) string {
+	t.Helper()
+	ps := testState()
+	testEval(t, ps, code, false)
+	srv, errObj := sshServerArg(ps, testEval(t, ps, "srv", false), "srv")
+	if errObj != nil {
+		t.Fatal("srv isn't an ssh-server")
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(listener)
+	t.Cleanup(func() { srv.Close() })
+	return listener.Addr().String()
+}
+
+// sshKnownHosts writes a known_hosts file with the key for the address
+func sshKnownHosts(t *testing.T, dir string, addr string, key gossh.PublicKey) string {
+	t.Helper()
+	path := filepath.Join(dir, "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(addr)}, key) + "\n"
+	if err := os.WriteFile(path, []byte(line), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// sshTestServer serves commands, sftp and a sleep command with password auth and a fixed host key,
+// other commands write what they ran and exit with 3
+func sshTestServer(t *testing.T, dir string) (string, gossh.PublicKey) {
+	t.Helper()
+	hostKey, hostPub := sshKey(t, dir, "host_key")
+	srv := &ssh.Server{
+		Handler: func(s ssh.Session) {
+			cmd := strings.Join(s.Command(), " ")
+			if cmd == "sleep" {
+				<-s.Context().Done()
+				return
+			}
+			io.WriteString(s, "ran "+cmd)
+			s.Exit(3)
+		},
+		PasswordHandler: func(ctx ssh.Context, pass string) bool { return pass == "secret" },
+		SubsystemHandlers: map[string]ssh.SubsystemHandler{
+			"sftp": func(s ssh.Session) {
+				server, err := sftp.NewServer(s)
+				if err != nil {
+					return
+				}
+				server.Serve()
+				server.Close()
+			},
+		},
+	}
+	if err := srv.SetOption(ssh.HostKeyFile(hostKey)); err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(listener)
+	t.Cleanup(func() { srv.Close() })
+	return listener.Addr().String(), hostPub
+}
+
+func TestSshClientExec(t *testing.T) {
+	dir := t.TempDir()
+	ps := testState()
+	addr, hostPub := sshTestServer(t, dir)
+	knownHosts := sshKnownHosts(t, dir, addr, hostPub)
+
+	testEval(t, ps, fmt.Sprintf(`conn: ssh-client %q "admin" |Password "secret" |Known-hosts %q |Connect`, addr, knownHosts), false)
+	res := testEval(t, ps, `r: conn .Run "uptime" , [ r -> "stdout" r -> "exit-status" ]`, false)
+	if got := res.Print(*ps.Idx); got != "ran uptime 3 " {
+		t.Errorf("unexpected result of Run %s", got)
+	}
+	// Output fails when the command exits with a non-zero status
+	if msg := testFailure(t, ps, `conn .Output "uptime"`); !strings.Contains(msg, "exit status 3") {
+		t.Errorf("expected the exit status in the failure, got %s", msg)
+	}
+	// a cancelled command returns when its session is closed
+	if msg := testFailure(t, ps, `with-timeout 100 { conn .Run "sleep" }`); !strings.Contains(msg, "timed out after 100 ms") {
+		t.Errorf("expected the command to time out, got %s", msg)
+	}
+	testEval(t, ps, `conn .Close`, false)
+
+	if msg := testFailure(t, ps, fmt.Sprintf(`ssh-client %q "admin" |Password "wrong" |Known-hosts %q |Connect`, addr, knownHosts)); !strings.Contains(msg, "unable to authenticate") {
+		t.Errorf("expected the wrong password to be rejected, got %s", msg)
+	}
+}
+
+func TestSshClientUploadDownload(t *testing.T) {
+	dir := t.TempDir()
+	ps := testState()
+	addr, _ := sshTestServer(t, dir)
+	local := filepath.Join(dir, "local.txt")
+	if err := os.WriteFile(local, []byte("over sftp"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	remoteDir := filepath.Join(dir, "remote")
+	if err := os.Mkdir(remoteDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	remote := filepath.Join(remoteDir, "uploaded.txt")
+	back := filepath.Join(dir, "back.txt")
+
+	testEval(t, ps, fmt.Sprintf(`conn: ssh-client %q "admin" |Password "secret" |Insecure-ignore-host-key |Connect`, addr), false)
+	testEval(t, ps, fmt.Sprintf(`conn .Upload %q %q`, local, remote), false)
+	if data, err := os.ReadFile(remote); err != nil || string(data) != "over sftp" {
+		t.Fatalf("expected the file on the server, got %q %v", data, err)
+	}
+	testEval(t, ps, fmt.Sprintf(`conn .Download %q %q`, remote, back), false)
+	if data, err := os.ReadFile(back); err != nil || string(data) != "over sftp" {
+		t.Fatalf("expected the downloaded file, got %q %v", data, err)
+	}
+	res := testEval(t, ps, fmt.Sprintf(`conn .List %q |map { -> "name" }`, remoteDir), false)
+	if got := res.Print(*ps.Idx); !strings.Contains(got, "uploaded.txt") {
+		t.Errorf("expected the uploaded file in the listing, got %s", got)
+	}
+	if msg := testFailure(t, ps, fmt.Sprintf(`conn .Download %q %q`, filepath.Join(remoteDir, "missing.txt"), back)); msg == "" {
+		t.Error("expected downloading a missing file to fail")
+	}
+	testEval(t, ps, `conn .Close`, false)
+}
+
+func TestSshClientKnownHostsRejection(t *testing.T) {
+	dir := t.TempDir()
+	ps := testState()
+	addr, _ := sshTestServer(t, dir)
+
+	// the known_hosts file has another key for the server
+	_, otherPub := sshKey(t, dir, "other_key")
+	knownHosts := sshKnownHosts(t, dir, addr, otherPub)
+	if msg := testFailure(t, ps, fmt.Sprintf(`ssh-client %q "admin" |Password "secret" |Known-hosts %q |Connect`, addr, knownHosts)); !strings.Contains(msg, "key mismatch") {
+		t.Errorf("expected a host key mismatch, got %s", msg)
+	}
+
+	// the server isn't in the known_hosts file
+	empty := filepath.Join(dir, "empty_known_hosts")
+	if err := os.WriteFile(empty, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if msg := testFailure(t, ps, fmt.Sprintf(`ssh-client %q "admin" |Password "secret" |Known-hosts %q |Connect`, addr, empty)); !strings.Contains(msg, "key is unknown") {
+		t.Errorf("expected an unknown host key, got %s", msg)
+	}
+}
+
+// sshDial connects to the server with the user and auth methods
+func sshDial(t *testing.T, addr string, user string, auth ...gossh.AuthMethod) *gossh.Client {
+	t.Helper()
+	client, err := gossh.Dial("tcp", addr, &gossh.ClientConfig{User: user, Auth: auth, HostKeyCallback: gossh.InsecureIgnoreHostKey()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// sshOutput collects what a session writes, so the test can wait for it
+type sshOutput struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (o *sshOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.buf.Write(p)
+}
+
+func (o *sshOutput) waitFor(t *testing.T, text string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		o.mu.Lock()
+		out := o.buf.String()
+		o.mu.Unlock()
+		if strings.Contains(out, text) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	t.Fatalf("expected %q in the output, got %q", text, o.buf.String())
+}
+
+// sshShell opens a session with a PTY and a shell
+func sshShell(t *testing.T, client *gossh.Client) (*gossh.Session, io.Writer, *sshOutput) {
+	t.Helper()
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { session.Close() })
+	if err := session.RequestPty("xterm-256color", 24, 100, gossh.TerminalModes{}); err != nil {
+		t.Fatal(err)
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &sshOutput{}
+	session.Stdout = out
+	if err := session.Shell(); err != nil {
+		t.Fatal(err)
+	}
+	return session, stdin, out
+}
+
+func TestSshServerNeedsAuth(t *testing.T) {
+	ps := testState()
+	for _, code := range []string{`ssh-server "127.0.0.1:0" |Serve`, `ssh-server "127.0.0.1:0" |Sftp`} {
+		if msg := testFailure(t, ps, code); !strings.Contains(msg, "no authentication") {
+			t.Errorf("%s: expected a failure without authentication, got %s", code, msg)
+		}
+	}
+	testEval(t, ps, `ssh-server "127.0.0.1:0" |Password-auth fn { pass } { pass = "secret" } |Sftp`, false)
+
+	// a server started without the Serve builtin still doesn't give the REPL to anyone
+	addr := sshServe(t, `
+srv: ssh-server "127.0.0.1:0"
+srv .Handle fn { s } { m: try { s .Repl } |message? , s .Write m }
+`)
+	_, _, out := sshShell(t, sshDial(t, addr, "admin"))
+	out.waitFor(t, "no authentication")
+}
+
+func TestSshServerPublicKeyAuth(t *testing.T) {
+	dir := t.TempDir()
+	keyFile, pub := sshKey(t, dir, "id_ed25519")
+	otherKeyFile, _ := sshKey(t, dir, "other_key")
+	authorized := strings.TrimSpace(string(gossh.MarshalAuthorizedKey(pub)))
+	authorizedKeys := filepath.Join(dir, "authorized_keys")
+	if err := os.WriteFile(authorizedKeys, []byte("# keys of the admins\n"+authorized+" admin@example\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	handler := `srv .Handle fn { s } { s .Write ( "hello " ++ ( s .User? ) ) }`
+	byHandler := sshServe(t, fmt.Sprintf(`
+srv: ssh-server "127.0.0.1:0"
+srv .Public-key-auth fn { user key } { either user = "admin" { key = %q } { false } }
+%s
+`, authorized, handler))
+	byFile := sshServe(t, fmt.Sprintf(`
+srv: ssh-server "127.0.0.1:0"
+srv .Authorized-keys %q
+%s
+`, authorizedKeys, handler))
+
+	ps := testState()
+	connect := `ssh-client %q %q |Key-file %q |Insecure-ignore-host-key |Connect`
+	for _, addr := range []string{byHandler, byFile} {
+		testEval(t, ps, fmt.Sprintf(`conn:: `+connect, addr, "admin", keyFile), false)
+		if res := testEval(t, ps, `conn .Output "whoami"`, false).Print(*ps.Idx); res != "hello admin" {
+			t.Errorf("expected the handler's output, got %s", res)
+		}
+		testEval(t, ps, `conn .Close`, false)
+		if msg := testFailure(t, ps, fmt.Sprintf(connect, addr, "admin", otherKeyFile)); !strings.Contains(msg, "unable to authenticate") {
+			t.Errorf("expected a key that isn't authorized to be rejected, got %s", msg)
+		}
+	}
+	if msg := testFailure(t, ps, fmt.Sprintf(connect, byHandler, "guest", keyFile)); !strings.Contains(msg, "unable to authenticate") {
+		t.Errorf("expected the handler to reject the user, got %s", msg)
+	}
+}
+
+func TestSshServerPty(t *testing.T) {
+	addr := sshServe(t, `
+srv: ssh-server "127.0.0.1:0"
+srv .Password-auth fn { pass } { pass = "secret" }
+srv .Handle fn { s } {
+	s .On-resize fn { w h } { s .Write ( "resized " ++ ( w |string ) ++ "x" ++ ( h |string ) ++ "\n" ) }
+	size: s .Window-size?
+	s .Write ( "term " ++ ( s .Term? ) ++ " " ++ ( size -> "width" |string ) ++ "x" ++ ( size -> "height" |string ) ++ "\n" )
+	line: s .Read-line
+	s .Write ( "got " ++ line ++ "\n" )
+}
+`)
+	session, stdin, out := sshShell(t, sshDial(t, addr, "admin", gossh.Password("secret")))
+	// newlines are written with a carriage return on a PTY
+	out.waitFor(t, "term xterm-256color 100x24\r\n")
+	if err := session.WindowChange(30, 120); err != nil {
+		t.Fatal(err)
+	}
+	out.waitFor(t, "resized 120x30\r\n")
+	// the line is echoed as it's typed and edited
+	io.WriteString(stdin, "helo\x7flo\r")
+	out.waitFor(t, "got hello\r\n")
+	if err := session.Wait(); err != nil {
+		t.Errorf("expected the session to end with 0, got %v", err)
+	}
+
+	// without a PTY
+	ps := testState()
+	testEval(t, ps, fmt.Sprintf(`conn: ssh-client %q "admin" |Password "secret" |Insecure-ignore-host-key |Connect`, addr), false)
+	if res := testEval(t, ps, `conn .Run "x" |-> "exit-status"`, false).Print(*ps.Idx); res != "0" {
+		t.Errorf("expected the session to end with 0, got %s", res)
+	}
+}
+
+func TestSshServerRepl(t *testing.T) {
+	addr := sshServe(t, `
+srv: ssh-server "127.0.0.1:0"
+srv .Password-auth fn { pass } { pass = "secret" }
+srv .Handle fn { s } { s .Repl , s .Write "bye\n" }
+`)
+	session, stdin, out := sshShell(t, sshDial(t, addr, "admin", gossh.Password("secret")))
+	io.WriteString(stdin, "print 40 + 2\r")
+	out.waitFor(t, "42")
+	// Ctrl-D ends the REPL, the handler goes on
+	io.WriteString(stdin, "\x04")
+	out.waitFor(t, "bye")
+	session.Wait()
+}
+
+func TestSshServerSubsystem(t *testing.T) {
+	addr := sshServe(t, `
+srv: ssh-server "127.0.0.1:0"
+srv .Password-auth fn { pass } { pass = "secret" }
+srv .Subsystem "echo" fn { s } { s .Write ( "echo " ++ ( s .Read-line ) ) }
+`)
+	client := sshDial(t, addr, "admin", gossh.Password("secret"))
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	// a subsystem doesn't copy Stdin and Stdout like Shell does, it's used through the pipes
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := session.RequestSubsystem("echo"); err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(stdin, "hi\n")
+	if out, err := io.ReadAll(stdout); err != nil || string(out) != "echo hi" {
+		t.Errorf("expected the subsystem's output, got %q %v", out, err)
+	}
+
+	// the server refuses subsystems it doesn't have
+	other, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if err := other.RequestSubsystem("sftp"); err == nil {
+		t.Error("expected the sftp subsystem to be refused")
+	}
+}
@@ -5,10 +5,12 @@ package batteries
 
 import (
 	"fmt"
+	"io"
 	"regexp"
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/mattn/go-runewidth"
 	"github.com/refaktor/keyboard"
@@ -875,6 +877,9 @@ type TuiApp struct {
 	height         int         // Lines rendered
 	prevLines      []string    // Previous output for diff rendering
 	focusedInput   *TuiInput   // Currently focused input widget
+	out            io.Writer   // Terminal the app renders to when it's not the program's own
+	keys           chan string // Keys read from that terminal
+	columns        func() int  // Width of that terminal
 	mu             sync.Mutex
 }
 
@@ -928,6 +933,8 @@ func (app *TuiApp) Render() {
 	theme := app.theme
 	prevHeight := app.height
 	ps := app.ps
+	out := app.out
+	columns := app.columns
 	app.mu.Unlock()
 
 	if view == nil || ps == nil {
@@ -936,6 +943,9 @@ func (app *TuiApp) Render() {
 	}
 
 	width := term.GetTerminalColumns()
+	if columns != nil {
+		width = columns()
+	}
 	if width < 20 {
 		width = 80
 	}
@@ -983,18 +993,32 @@ func (app *TuiApp) Render() {
 	// Render the widget tree
 	lines := tuiRenderWidget(ps, widgetBlock, width, theme)
 
-	// Move cursor up if we've rendered before
-	if prevHeight > 0 {
-		term.CurUp(prevHeight)
-	}
+	if out != nil {
+		// Another terminal, in raw mode, so lines end with \r\n
+		var b strings.Builder
+		if prevHeight > 0 {
+			fmt.Fprintf(&b, "\x1b[%dA", prevHeight)
+		}
+		b.WriteString("\x1b[?2026h")
+		for _, line := range lines {
+			b.WriteString("\x1b[0K" + line + "\r\n")
+		}
+		b.WriteString("\x1b[?2026l")
+		io.WriteString(out, b.String())
+	} else {
+		// Move cursor up if we've rendered before
+		if prevHeight > 0 {
+			term.CurUp(prevHeight)
+		}
 
-	// Print lines with sync for flicker-free rendering
-	fmt.Print("\x1b[?2026h") // Sync start
-	for _, line := range lines {
-		term.ClearLine()
-		fmt.Println(line)
+		// Print lines with sync for flicker-free rendering
+		fmt.Print("\x1b[?2026h") // Sync start
+		for _, line := range lines {
+			term.ClearLine()
+			fmt.Println(line)
+		}
+		fmt.Print("\x1b[?2026l") // Sync end
 	}
-	fmt.Print("\x1b[?2026l") // Sync end
 
 	app.mu.Lock()
 	app.height = len(lines)
@@ -1018,6 +1042,16 @@ func (app *TuiApp) OnKeys(handler env.Object) {
 
 // Start starts the app
 func (app *TuiApp) Start(ps *env.ProgramState) error {
+	return app.start(ps, nil, nil, nil)
+}
+
+// StartOn starts the app on another terminal than the program's own, like an SSH session, the
+// keys are read from it and the app stops when it's closed
+func (app *TuiApp) StartOn(ps *env.ProgramState, rw io.ReadWriter, columns func() int) error {
+	return app.start(ps, rw, columns, make(chan string, 10))
+}
+
+func (app *TuiApp) start(ps *env.ProgramState, out io.ReadWriter, columns func() int, keys chan string) error {
 	app.mu.Lock()
 	if app.running {
 		app.mu.Unlock()
@@ -1027,14 +1061,23 @@ func (app *TuiApp) Start(ps *env.ProgramState) error {
 	app.ps = ps
 	app.ctx = ps.Ctx // Capture the current context (e.g. tui context) at Start time
 	app.stopChan = make(chan struct{})
+	app.out = out
+	app.columns = columns
+	app.keys = keys
+	app.height = 0
+	stopChan := app.stopChan
 	app.mu.Unlock()
 
 	// Initial render
 	app.Render()
 
 	// Start keyboard listener
-	if err := keyboard.Open(); err != nil {
-		return err
+	if keys == nil {
+		if err := keyboard.Open(); err != nil {
+			return err
+		}
+	} else {
+		go readTerminalKeys(out, keys, stopChan)
 	}
 
 	go app.eventLoop()
@@ -1050,8 +1093,11 @@ func (app *TuiApp) Stop() {
 	}
 	app.running = false
 	close(app.stopChan)
+	keys := app.keys
 	app.mu.Unlock()
-	keyboard.Close()
+	if keys == nil {
+		keyboard.Close()
+	}
 }
 
 // IsRunning returns whether the app is running
@@ -1115,31 +1161,86 @@ func keyToString(char rune, key keyboard.Key) string {
 	}
 }
 
+// readTerminalKeys reads the input of a terminal in raw mode and sends the names of the keys like
+// keyToString does, the channel is closed when the input ends or the app stops. The input read
+// while the app stops is lost.
+func readTerminalKeys(r io.Reader, keys chan<- string, stop <-chan struct{}) {
+	defer close(keys)
+	sequences := map[string]string{
+		"\x1b[A": "up", "\x1b[B": "down", "\x1b[C": "right", "\x1b[D": "left",
+		"\x1b[H": "home", "\x1b[F": "end", "\x1b[1~": "home", "\x1b[4~": "end", "\x1b[3~": "delete",
+		"\x1bOA": "up", "\x1bOB": "down", "\x1bOC": "right", "\x1bOD": "left", "\x1bOH": "home", "\x1bOF": "end",
+	}
+	controls := map[rune]string{
+		'\r': "enter", '\n': "enter", '\t': "tab", ' ': " ", 0x7f: "backspace", 0x08: "backspace",
+		0x03: "ctrl-c", 0x01: "ctrl-a", 0x05: "ctrl-e", 0x17: "ctrl-w", 0x15: "ctrl-u", 0x0b: "ctrl-k",
+	}
+	buf := make([]byte, 256)
+	for {
+		n, err := r.Read(buf)
+		if err != nil {
+			return
+		}
+		input := string(buf[:n])
+		for len(input) > 0 {
+			var key string
+			if input[0] == 0x1b {
+				size := 1
+				key = "escape"
+				for seq, name := range sequences {
+					if strings.HasPrefix(input, seq) {
+						key, size = name, len(seq)
+						break
+					}
+				}
+				input = input[size:]
+			} else {
+				char, size := utf8.DecodeRuneInString(input)
+				input = input[size:]
+				if name, ok := controls[char]; ok {
+					key = name
+				} else if char >= 0x20 {
+					key = string(char)
+				} else {
+					continue
+				}
+			}
+			select {
+			case keys <- key:
+			case <-stop:
+				return
+			}
+		}
+	}
+}
+
 // eventLoop handles keyboard events and async messages
 func (app *TuiApp) eventLoop() {
 	keyChan := make(chan string, 10)
 
-	// Goroutine reads keyboard, sends to keyChan
-	go func() {
-		for {
-			char, key, err := keyboard.GetKey()
-			if err != nil {
-				// Check if app stopped
-				if !app.IsRunning() {
+	app.mu.Lock()
+	keys := app.keys
+	stopChan := app.stopChan
+	app.mu.Unlock()
+	if keys != nil {
+		// Keys of another terminal, the app stops when it's closed
+		go func() {
+			for key := range keys {
+				if key == "ctrl-c" {
+					break
+				}
+				select {
+				case keyChan <- key:
+				case <-stopChan:
 					return
 				}
-				continue
-			}
-			keyStr := keyToString(char, key)
-			if keyStr == "ctrl-c" {
-				app.Stop()
-				return
 			}
-			if keyStr != "" {
-				keyChan <- keyStr
-			}
-		}
-	}()
+			app.Stop()
+		}()
+	} else {
+		// Goroutine reads keyboard, sends to keyChan
+		go app.readKeyboard(keyChan)
+	}
 
 	for {
 		select {
@@ -1153,6 +1254,28 @@ func (app *TuiApp) eventLoop() {
 	}
 }
 
+// readKeyboard reads the keyboard of the program's terminal until the app stops
+func (app *TuiApp) readKeyboard(keyChan chan<- string) {
+	for {
+		char, key, err := keyboard.GetKey()
+		if err != nil {
+			// Check if app stopped
+			if !app.IsRunning() {
+				return
+			}
+			continue
+		}
+		keyStr := keyToString(char, key)
+		if keyStr == "ctrl-c" {
+			app.Stop()
+			return
+		}
+		if keyStr != "" {
+			keyChan <- keyStr
+		}
+	}
+}
+
 // handleMessage handles an async message from a goroutine
 func (app *TuiApp) handleMessage(msg env.Object) {
 	app.mu.Lock()
@@ -2443,6 +2566,44 @@ var Builtins_tui = map[string]*env.Builtin{
 		},
 	},
 
+	// tui-app//Start\on - start the app on another terminal
+	// Args:
+	// * app: TuiApp native
+	// * terminal: native that can be read and written, like an ssh-session
+	// Returns:
+	// * the app
+	"tui-app//Start\\on": {
+		Argsn: 2,
+		Doc:   "Starts the TUI app on another terminal than the program's own, like an SSH session with a PTY. Keys are read from it and the app stops when it's closed or on Ctrl-C.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			native, ok := arg0.(env.Native)
+			if !ok {
+				return evaldo.MakeArgError(ps, 1, []env.Type{env.NativeType}, "tui-app//Start\\on")
+			}
+			app, ok := native.Value.(*TuiApp)
+			if !ok {
+				return evaldo.MakeBuiltinError(ps, "Expected TuiApp", "tui-app//Start\\on")
+			}
+			terminal, ok := arg1.(env.Native)
+			if !ok {
+				return evaldo.MakeArgError(ps, 2, []env.Type{env.NativeType}, "tui-app//Start\\on")
+			}
+			rw, ok := terminal.Value.(io.ReadWriter)
+			if !ok {
+				return evaldo.MakeBuiltinError(ps, "Expected a native that can be read and written", "tui-app//Start\\on")
+			}
+			// terminals that know their size, like SSH sessions with a PTY
+			var columns func() int
+			if c, ok := terminal.Value.(interface{ Columns() int }); ok {
+				columns = c.Columns
+			}
+			if err := app.StartOn(ps, rw, columns); err != nil {
+				return evaldo.MakeBuiltinError(ps, err.Error(), "tui-app//Start\\on")
+			}
+			return arg0
+		},
+	},
+
 	// tui-app//Stop - stop the app
 	// Args:
 	// * app: TuiApp native
@@ -49,9 +49,12 @@ func RegisterBatteries(ps *env.ProgramState) {
 	evaldo.RegisterBuiltins2(Builtins_html, ps, "html")
 	evaldo.RegisterBuiltins2(Builtins_json, ps, "json")
 	evaldo.RegisterBuiltins2(Builtins_bson, ps, "bson")
+	evaldo.RegisterBuiltins2(Builtins_parquet, ps, "parquet")
 	evaldo.RegisterBuiltins2(Builtins_stackless, ps, "stackless")
 	evaldo.RegisterBuiltins2(Builtins_eyr, ps, "eyr")
 	evaldo.RegisterBuiltins2(Builtins_goroutines, ps, "goroutines")
+	evaldo.RegisterBuiltins2(Builtins_actors, ps, "actors")
+	evaldo.RegisterBuiltins2(Builtins_profiler, ps, "profiler")
 	evaldo.RegisterBuiltins2(Builtins_msgdispatcher, ps, "msgdispatcher")
 	evaldo.RegisterBuiltins2(Builtins_http, ps, "http")
 	evaldo.RegisterBuiltins2(Builtins_sqlite, ps, "sqlite")
@@ -209,67 +209,7 @@ func (r *Repl) evalLine(es *env.ProgramState, code string) string {
 	// and respects string boundaries (semicolons in strings are not comments)
 	lineReal := strings.Trim(code, "\t")
 
-	// More robust multiline input detection
-	// Check for explicit multiline indicators or incomplete syntax
-	multiline := false
-
-	// 1. Check for explicit continuation character at the end (backslash)
-	if len(lineReal) > 0 && strings.HasSuffix(strings.TrimSpace(lineReal), "\\") {
-		multiline = true
-		// Remove the continuation character for processing
-		lineReal = strings.TrimSuffix(strings.TrimSpace(lineReal), "\\")
-	}
-
-	// 2. Check for unbalanced brackets/braces/parentheses
-	if !multiline {
-		// Check the accumulated code (existing + current line) for balanced delimiters
-		accumulatedCode := r.fullCode + lineReal
-
-		openBraces := 0
-		openBrackets := 0
-		openParens := 0
-		inString := false
-		stringChar := ' '
-
-		for _, char := range accumulatedCode {
-			// Handle string literals to avoid counting brackets inside strings
-			if (char == '"' || char == '`') && (stringChar == ' ' || stringChar == char) {
-				if !inString {
-					inString = true
-					stringChar = char
-				} else {
-					inString = false
-					stringChar = ' '
-				}
-				continue
-			}
-
-			if !inString {
-				switch char {
-				case '{':
-					openBraces++
-				case '}':
-					openBraces--
-				case '[':
-					openBrackets++
-				case ']':
-					openBrackets--
-				case '(':
-					openParens++
-				case ')':
-					openParens--
-				}
-			}
-		}
-
-		// If any delimiters are unbalanced, consider it multiline
-		multiline = openBraces > 0 || openBrackets > 0 || openParens > 0
-	}
-
-	// 3. Check for incomplete block definitions that end with a colon
-	if !multiline && strings.HasSuffix(strings.TrimSpace(lineReal), ":") {
-		multiline = true
-	}
+	lineReal, multiline := continuedLine(r.fullCode, lineReal)
 
 	output := ""
 	if multiline {
@@ -427,6 +367,74 @@ func (r *Repl) evalLine(es *env.ProgramState, code string) string {
 	return output
 }
 
+// continuedLine checks if the code continues on the next line, because the line ends with a
+// backslash or a colon or the brackets of the code so far aren't closed. The backslash is removed.
+func continuedLine(fullCode string, lineReal string) (string, bool) {
+	// More robust multiline input detection
+	// Check for explicit multiline indicators or incomplete syntax
+	multiline := false
+
+	// 1. Check for explicit continuation character at the end (backslash)
+	if len(lineReal) > 0 && strings.HasSuffix(strings.TrimSpace(lineReal), "\\") {
+		multiline = true
+		// Remove the continuation character for processing
+		lineReal = strings.TrimSuffix(strings.TrimSpace(lineReal), "\\")
+	}
+
+	// 2. Check for unbalanced brackets/braces/parentheses
+	if !multiline {
+		// Check the accumulated code (existing + current line) for balanced delimiters
+		accumulatedCode := fullCode + lineReal
+
+		openBraces := 0
+		openBrackets := 0
+		openParens := 0
+		inString := false
+		stringChar := ' '
+
+		for _, char := range accumulatedCode {
+			// Handle string literals to avoid counting brackets inside strings
+			if (char == '"' || char == '`') && (stringChar == ' ' || stringChar == char) {
+				if !inString {
+					inString = true
+					stringChar = char
+				} else {
+					inString = false
+					stringChar = ' '
+				}
+				continue
+			}
+
+			if !inString {
+				switch char {
+				case '{':
+					openBraces++
+				case '}':
+					openBraces--
+				case '[':
+					openBrackets++
+				case ']':
+					openBrackets--
+				case '(':
+					openParens++
+				case ')':
+					openParens--
+				}
+			}
+		}
+
+		// If any delimiters are unbalanced, consider it multiline
+		multiline = openBraces > 0 || openBrackets > 0 || openParens > 0
+	}
+
+	// 3. Check for incomplete block definitions that end with a colon
+	if !multiline && strings.HasSuffix(strings.TrimSpace(lineReal), ":") {
+		multiline = true
+	}
+
+	return lineReal, multiline
+}
+
 // constructKeyEvent maps a rune and keyboard.Key to a util.KeyEvent, which uses javascript key event codes
 // only keys used in microliner are mapped
 func constructKeyEvent(r rune, k keyboard.Key) term.KeyEvent {
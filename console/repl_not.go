@@ -6,6 +6,7 @@ import (
 	"fmt"
 
 	"github.com/refaktor/rye/env"
+	xterm "golang.org/x/term"
 )
 
 func DoRyeRepl(es *env.ProgramState, dialect string, showResults bool, localHist bool, histFile string) {
@@ -15,3 +16,8 @@ func DoRyeRepl(es *env.ProgramState, dialect string, showResults bool, localHist
 func DoRyeDualRepl(leftPs *env.ProgramState, rightPs *env.ProgramState, dialect string, showResults bool) {
 	fmt.Println("Dual REPL not available in this build.")
 }
+
+func DoRyeReplSession(es *env.ProgramState, t *xterm.Terminal, prompt string) error {
+	t.Write([]byte("REPL not available in this build.\n"))
+	return nil
+}
@@ -0,0 +1,76 @@
+//go:build !b_norepl && !wasm && !js
+
+package console
+
+import (
+	"io"
+
+	"github.com/refaktor/rye/env"
+	"github.com/refaktor/rye/evaldo"
+	"github.com/refaktor/rye/loader"
+	xterm "golang.org/x/term"
+)
+
+// DoRyeReplSession runs the REPL on a terminal that isn't the program's own, like an SSH session.
+// Lines are edited by the x/term terminal and what the code prints goes to it too. It returns when
+// the terminal is closed or the user presses Ctrl-C or Ctrl-D.
+func DoRyeReplSession(es *env.ProgramState, t *xterm.Terminal, prompt string) error {
+	out := es.Out
+	es.Out = t
+	defer func() { es.Out = out }()
+
+	var prevResult env.Object
+	fullCode := ""
+	t.SetPrompt(prompt)
+	for {
+		line, err := t.ReadLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil && err != xterm.ErrPasteIndicator {
+			return err
+		}
+
+		lineReal, multiline := continuedLine(fullCode, line)
+		if multiline {
+			fullCode += lineReal + "\n"
+			t.SetPrompt(string(t.Escape.Yellow) + "  " + string(t.Escape.Reset))
+			continue
+		}
+		fullCode += lineReal
+		t.SetPrompt(prompt)
+
+		block, genv := loader.LoadStringNoPEG(fullCode, false)
+		fullCode = ""
+		if err, isError := block.(env.Error); isError {
+			t.Write([]byte("\033[31mParsing error: " + err.Message + "\033[0m\n"))
+			continue
+		}
+		block1 := block.(env.Block)
+		es = env.AddToProgramStateNEWWithLocation(es, &block1, genv)
+
+		// like the REPL, failures at top level aren't elevated to errors
+		es.InErrHandler = true
+		evaldo.EvalBlockInj(es, prevResult, true)
+		es.InErrHandler = false
+
+		switch {
+		case es.ErrorFlag:
+			t.Write([]byte("\033[31mError: " + es.Res.Print(*genv) + "\033[0m\n"))
+		case es.FailureFlag:
+			t.Write([]byte("\033[33mFailure: " + es.Res.Print(*genv) + "\033[0m\n"))
+			prevResult = es.Res
+		case es.Res != nil && es.Res.Type() != env.VoidType:
+			prevResult = es.Res
+			p := ""
+			if env.IsPointer(es.Res) {
+				p = "Ref"
+			}
+			t.Write([]byte("\033[38;5;37m" + p + es.Res.Inspect(*genv) + "\x1b[0m\n"))
+		}
+		es.ReturnFlag = false
+		es.ErrorFlag = false
+		es.FailureFlag = false
+		es.SkipFlag = false
+	}
+}
@@ -0,0 +1,282 @@
+// Package cover records which lines of Rye files are evaluated, for rye -cover and rye test -cover.
+// The evaluator calls evaldo.DebuggerHook before each expression, the profile maps the position of
+// the expression to its source line and counts it. The lines that could run are found when a file
+// is loaded: the lines of the blocks that hold words, except the lines that only continue the
+// expression of the line before (with an op-word, a pipe-word or a block argument, like the } { of
+// either), since the hook never stops there. Blocks of only literals are data, their lines are
+// counted only if they run.
+//
+// The profile is written in the format of go test -coverprofile, one line per source line:
+//
+//	mode: count
+//	lib.rye:3.1,3.24 1 5
+package cover
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/refaktor/rye/debugger"
+	"github.com/refaktor/rye/env"
+	"github.com/refaktor/rye/evaldo"
+)
+
+// Profile holds the counts of the lines of the files loaded while it was started
+type Profile struct {
+	mu    sync.Mutex
+	smap  *debugger.SourceMap
+	files map[string]map[int]int64 // the lines that could run and how many times they did
+	last  map[*env.Object]int      // the last hooked position of each series
+}
+
+func New() *Profile {
+	return &Profile{
+		smap:  debugger.NewSourceMap("", "", env.Block{}),
+		files: make(map[string]map[int]int64),
+		last:  make(map[*env.Object]int),
+	}
+}
+
+// Start installs the hooks, the files loaded from now on are mapped and their lines counted
+func (p *Profile) Start() {
+	evaldo.SourceLoadedHook = p.AddFile
+	evaldo.DebuggerHook = p.hook
+}
+
+// Stop removes the hooks
+func (p *Profile) Stop() {
+	evaldo.SourceLoadedHook = nil
+	evaldo.DebuggerHook = nil
+}
+
+// AddFile maps the blocks of a loaded file, like the main script, other files are added by the hook
+func (p *Profile) AddFile(file string, content string, block env.Block) {
+	file = displayName(file)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.smap.Add(file, content, block)
+	lines, ok := p.files[file]
+	if !ok {
+		lines = make(map[int]int64)
+		p.files[file] = lines
+	}
+	p.addBlock(lines, block)
+}
+
+func (p *Profile) addBlock(lines map[int]int64, block env.Block) {
+	if isCode(block) {
+		prev := -1
+		for i, obj := range block.Series.S {
+			_, line, ok := p.smap.Line(block.Series, i)
+			if !ok || line == prev {
+				continue
+			}
+			prev = line
+			switch obj.(type) {
+			case env.Opword, env.Pipeword, env.Block:
+				continue
+			}
+			if _, ok := lines[line]; !ok {
+				lines[line] = 0
+			}
+		}
+	}
+	for _, obj := range block.Series.S {
+		if b, ok := obj.(env.Block); ok {
+			p.addBlock(lines, b)
+		}
+	}
+}
+
+// isCode is true for blocks with words, blocks of only literals are data
+func isCode(block env.Block) bool {
+	for _, obj := range block.Series.S {
+		switch obj.(type) {
+		case env.Word, env.Setword, env.LSetword, env.Modword, env.LModword, env.Opword, env.Pipeword,
+			env.Getword, env.Genword, env.CPath:
+			return true
+		}
+	}
+	return false
+}
+
+// hook is installed as evaldo.DebuggerHook
+func (p *Profile) hook(ps *env.ProgramState) {
+	ser := ps.Ser
+	pos := ser.Pos()
+	if pos >= len(ser.S) {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	file, line, ok := p.smap.Line(ser, pos)
+	if !ok {
+		return
+	}
+	lines := p.files[file]
+	// the expression hooked last in this series ran up to here, the lines it continued on ran too
+	key := &ser.S[0]
+	if last, ok := p.last[key]; ok && last < pos {
+		_, prev, _ := p.smap.Line(ser, last)
+		for i := last + 1; i < pos; i++ {
+			if _, l, _ := p.smap.Line(ser, i); l != prev && l != line {
+				if _, ok := lines[l]; ok {
+					lines[l]++
+				}
+				prev = l
+			}
+		}
+	}
+	p.last[key] = pos
+	// a line is counted once per run, by the first expression on it
+	if pos > 0 {
+		if _, prev, _ := p.smap.Line(ser, pos-1); prev == line {
+			if lines[line] == 0 {
+				lines[line] = 1
+			}
+			return
+		}
+	}
+	lines[line]++
+}
+
+// FileCoverage is the coverage of a file, or of all of them
+type FileCoverage struct {
+	File    string
+	Lines   int // the lines that could run
+	Covered int // the lines that did
+}
+
+func (fc FileCoverage) Percent() float64 {
+	if fc.Lines == 0 {
+		return 100
+	}
+	return 100 * float64(fc.Covered) / float64(fc.Lines)
+}
+
+// Files returns the coverage of each file, sorted by name
+func (p *Profile) Files() []FileCoverage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	res := make([]FileCoverage, 0, len(p.files))
+	for _, file := range p.fileNames() {
+		fc := FileCoverage{File: file}
+		for _, count := range p.files[file] {
+			fc.Lines++
+			if count > 0 {
+				fc.Covered++
+			}
+		}
+		res = append(res, fc)
+	}
+	return res
+}
+
+// Total returns the coverage of all files
+func (p *Profile) Total() FileCoverage {
+	total := FileCoverage{File: "total"}
+	for _, fc := range p.Files() {
+		total.Lines += fc.Lines
+		total.Covered += fc.Covered
+	}
+	return total
+}
+
+func (p *Profile) fileNames() []string {
+	names := make([]string, 0, len(p.files))
+	for file := range p.files {
+		names = append(names, file)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func sortedLines(lines map[int]int64) []int {
+	res := make([]int, 0, len(lines))
+	for line := range lines {
+		res = append(res, line)
+	}
+	slices.Sort(res)
+	return res
+}
+
+// WriteProfile writes the counts in the format of go test -coverprofile, each source line is a
+// block of one statement
+func (p *Profile) WriteProfile(w io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "mode: count")
+	for _, file := range p.fileNames() {
+		src := p.smap.Source(file)
+		for _, line := range sortedLines(p.files[file]) {
+			end := 1
+			if line <= len(src) {
+				end = len(src[line-1]) + 1
+			}
+			fmt.Fprintf(bw, "%s:%d.1,%d.%d 1 %d\n", file, line, line, end, p.files[file][line])
+		}
+	}
+	return bw.Flush()
+}
+
+// WriteSummary writes the coverage of each file and the total
+func (p *Profile) WriteSummary(w io.Writer) {
+	for _, fc := range p.Files() {
+		fmt.Fprintf(w, "%s\t%d/%d lines\t%.1f%%\n", fc.File, fc.Covered, fc.Lines, fc.Percent())
+	}
+	total := p.Total()
+	fmt.Fprintf(w, "coverage: %.1f%% of lines\n", total.Percent())
+}
+
+// Report writes the profile and the HTML report to the files that are given and the summary to w.
+// It returns an error if the total coverage is below min.
+func (p *Profile) Report(w io.Writer, profilePath string, htmlPath string, min float64) error {
+	if profilePath != "" {
+		if err := writeFile(profilePath, p.WriteProfile); err != nil {
+			return err
+		}
+	}
+	if htmlPath != "" {
+		if err := writeFile(htmlPath, p.WriteHTML); err != nil {
+			return err
+		}
+	}
+	p.WriteSummary(w)
+	if total := p.Total(); total.Percent() < min {
+		return fmt.Errorf("coverage %.1f%% is below the required %.1f%%", total.Percent(), min)
+	}
+	return nil
+}
+
+func writeFile(path string, write func(io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// displayName makes the paths below the working directory relative, so the same file loaded with
+// a relative and an absolute path is one file in the profile
+func displayName(file string) string {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return file
+	}
+	if wd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(wd, abs); err == nil && filepath.IsLocal(rel) {
+			return rel
+		}
+	}
+	return abs
+}
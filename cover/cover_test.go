@@ -0,0 +1,70 @@
+package cover
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/refaktor/rye/env"
+	"github.com/refaktor/rye/evaldo"
+	"github.com/refaktor/rye/loader"
+)
+
+const script = `polarity: fn { x } {
+	either x > 0 {
+		print "positive"
+	} {
+		print "negative"
+	}
+}
+unused: fn { } {
+	print "never"
+}
+polarity 1
+`
+
+func TestProfile(t *testing.T) {
+	ps := env.NewProgramState()
+	evaldo.RegisterBuiltins(ps)
+	block, ok := loader.LoadString(script, false, ps).(env.Block)
+	if !ok {
+		t.Fatal("script didn't load")
+	}
+	prof := New()
+	prof.AddFile("polarity.rye", script, block)
+	prof.Start()
+	ps = env.AddToProgramStateNEWWithLocation(ps, &block, ps.Idx)
+	evaldo.EvalBlockInj(ps, nil, false)
+	prof.Stop()
+	if ps.ErrorFlag {
+		t.Fatal(ps.Res.Inspect(*ps.Idx))
+	}
+
+	var buf bytes.Buffer
+	if err := prof.WriteProfile(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"mode: count",
+		"polarity.rye:1.1,1.21 1 1",
+		"polarity.rye:2.1,2.16 1 1",
+		"polarity.rye:3.1,3.19 1 1",
+		"polarity.rye:5.1,5.19 1 0",
+		"polarity.rye:8.1,8.17 1 1",
+		"polarity.rye:9.1,9.15 1 0",
+		"polarity.rye:11.1,11.11 1 1",
+	}
+	if got := strings.TrimSpace(buf.String()); got != strings.Join(want, "\n") {
+		t.Errorf("unexpected profile:\n%s", got)
+	}
+	if total := prof.Total(); total.Lines != 7 || total.Covered != 5 {
+		t.Errorf("unexpected total %+v", total)
+	}
+	if err := prof.Report(&buf, "", "", 90); err == nil {
+		t.Error("a coverage below the minimum should be an error")
+	}
+	buf.Reset()
+	if err := prof.WriteHTML(&buf); err != nil || !strings.Contains(buf.String(), `<span class="line unc" title="0"><span class="n">9</span>`) {
+		t.Errorf("unexpected HTML report: %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+package cover
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+)
+
+type htmlLine struct {
+	N     int
+	Text  string
+	Class string // "" for lines that can't run, "cov" and "unc" for covered and uncovered lines
+	Count int64
+}
+
+type htmlFile struct {
+	ID      int
+	Name    string
+	Percent string
+	Lines   []htmlLine
+}
+
+// WriteHTML writes a report like go tool cover -html, the source of each file with the lines that
+// ran in green and the lines that didn't in red
+func (p *Profile) WriteHTML(w io.Writer) error {
+	coverage := make(map[string]FileCoverage)
+	for _, fc := range p.Files() {
+		coverage[fc.File] = fc
+	}
+	total := p.Total()
+
+	p.mu.Lock()
+	files := make([]htmlFile, 0, len(p.files))
+	for i, name := range p.fileNames() {
+		hf := htmlFile{ID: i, Name: name, Percent: fmt.Sprintf("%.1f%%", coverage[name].Percent())}
+		counts := p.files[name]
+		for n, text := range p.smap.Source(name) {
+			hl := htmlLine{N: n + 1, Text: text}
+			if count, ok := counts[n+1]; ok {
+				hl.Count = count
+				hl.Class = "unc"
+				if count > 0 {
+					hl.Class = "cov"
+				}
+			}
+			hf.Lines = append(hf.Lines, hl)
+		}
+		files = append(files, hf)
+	}
+	p.mu.Unlock()
+
+	return htmlTemplate.Execute(w, map[string]any{
+		"Files": files,
+		"Total": fmt.Sprintf("%.1f%%", total.Percent()),
+	})
+}
+
+var htmlTemplate = template.Must(template.New("cover").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Rye coverage</title>
+<style>
+body { background: #111; color: #ccc; font-family: sans-serif; margin: 0; }
+#topbar { background: #222; padding: 8px 12px; position: sticky; top: 0; }
+#topbar span { margin-left: 12px; }
+pre { font-family: Menlo, monospace; font-size: 13px; margin: 0; padding: 8px 0; }
+.line { display: block; white-space: pre; }
+.n { color: #555; display: inline-block; width: 5em; text-align: right; padding-right: 1em; user-select: none; }
+.cov { color: #2cc06c; }
+.unc { color: #e0504a; }
+.file { display: none; }
+</style>
+</head>
+<body>
+<div id="topbar">
+<select id="files" onchange="show(this.value)">
+{{range .Files}}<option value="file{{.ID}}">{{.Name}} ({{.Percent}})</option>
+{{end}}</select>
+<span>total {{.Total}}</span>
+<span class="cov">covered</span>
+<span class="unc">not covered</span>
+</div>
+{{range .Files}}<pre class="file" id="file{{.ID}}">{{range .Lines}}<span class="line {{.Class}}"{{if .Class}} title="{{.Count}}"{{end}}><span class="n">{{.N}}</span>{{.Text}}</span>{{end}}</pre>
+{{end}}<script>
+function show(id) {
+	for (const el of document.getElementsByClassName("file")) {
+		el.style.display = el.id == id ? "block" : "none";
+	}
+}
+const files = document.getElementById("files");
+if (files.value) { show(files.value); }
+</script>
+</body>
+</html>
+`))
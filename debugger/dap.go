@@ -0,0 +1,465 @@
+package debugger
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/refaktor/rye/env"
+)
+
+// ServeDAP runs a Debug Adapter Protocol server on stdin and stdout. Setup is called on launch and
+// returns the program state (with builtins registered) to run the program in. The script's output
+// is sent to the client as output events.
+func ServeDAP(setup func(program string) (*env.ProgramState, error)) error {
+	out := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	os.Stdout = w
+	defer func() { os.Stdout = out }()
+
+	s := &dapServer{in: bufio.NewReader(os.Stdin), out: out, setup: setup, breakpoints: make(map[string][]int)}
+	go s.forwardOutput(r)
+	return s.serve()
+}
+
+type dapMessage struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command,omitempty"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+}
+
+type dapServer struct {
+	in    *bufio.Reader
+	out   io.Writer
+	outMu sync.Mutex
+	seq   int
+	setup func(program string) (*env.ProgramState, error)
+
+	mu          sync.Mutex
+	d           *Debugger
+	block       env.Block
+	program     string
+	stopOnEntry bool
+	launched    bool
+	configured  bool
+	started     bool
+	stopped     bool
+	breakpoints map[string][]int // set before launch
+	refs        []any            // variable references of the current stop, *env.RyeCtx or env.Object
+}
+
+func (s *dapServer) serve() error {
+	for {
+		msg, err := s.read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if msg.Type != "request" {
+			continue
+		}
+		if quit := s.handle(msg); quit {
+			return nil
+		}
+	}
+}
+
+// read reads one Content-Length framed message
+func (s *dapServer) read() (*dapMessage, error) {
+	length := -1
+	for {
+		line, err := s.in.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			if length >= 0 {
+				break
+			}
+			continue
+		}
+		if v, ok := strings.CutPrefix(line, "Content-Length:"); ok {
+			length, err = strconv.Atoi(strings.TrimSpace(v))
+			if err != nil {
+				return nil, fmt.Errorf("invalid Content-Length: %s", v)
+			}
+		}
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(s.in, body); err != nil {
+		return nil, err
+	}
+	msg := &dapMessage{}
+	if err := json.Unmarshal(body, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (s *dapServer) write(msg map[string]any) {
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	s.seq++
+	msg["seq"] = s.seq
+	body, _ := json.Marshal(msg)
+	fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func (s *dapServer) respond(req *dapMessage, body any) {
+	msg := map[string]any{"type": "response", "request_seq": req.Seq, "command": req.Command, "success": true}
+	if body != nil {
+		msg["body"] = body
+	}
+	s.write(msg)
+}
+
+func (s *dapServer) fail(req *dapMessage, message string) {
+	s.write(map[string]any{"type": "response", "request_seq": req.Seq, "command": req.Command, "success": false, "message": message})
+}
+
+func (s *dapServer) event(name string, body any) {
+	msg := map[string]any{"type": "event", "event": name}
+	if body != nil {
+		msg["body"] = body
+	}
+	s.write(msg)
+}
+
+func (s *dapServer) forwardOutput(r io.Reader) {
+	buf := make([]byte, 4096)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			s.event("output", map[string]any{"category": "stdout", "output": string(buf[:n])})
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// handle answers a request and reports if the server should quit
+func (s *dapServer) handle(req *dapMessage) bool {
+	var args map[string]any
+	if len(req.Arguments) > 0 {
+		_ = json.Unmarshal(req.Arguments, &args)
+	}
+	switch req.Command {
+	case "initialize":
+		s.respond(req, map[string]any{
+			"supportsConfigurationDoneRequest": true,
+			"supportsEvaluateForHovers":        true,
+		})
+		s.event("initialized", nil)
+	case "launch":
+		program, _ := args["program"].(string)
+		if program == "" {
+			s.fail(req, "launch needs a program")
+			return false
+		}
+		ps, err := s.setup(program)
+		if err != nil {
+			s.fail(req, err.Error())
+			return false
+		}
+		d := New(ps)
+		block, err := d.Load(program)
+		if err != nil {
+			s.fail(req, err.Error())
+			return false
+		}
+		s.mu.Lock()
+		s.d, s.block, s.program, s.launched = d, block, program, true
+		s.stopOnEntry, _ = args["stopOnEntry"].(bool)
+		for file, lines := range s.breakpoints {
+			d.SetBreakpoints(file, lines)
+		}
+		s.mu.Unlock()
+		s.respond(req, nil)
+		s.maybeStart()
+	case "setBreakpoints":
+		s.setBreakpoints(req, args)
+	case "configurationDone":
+		s.mu.Lock()
+		s.configured = true
+		s.mu.Unlock()
+		s.respond(req, nil)
+		s.maybeStart()
+	case "threads":
+		s.respond(req, map[string]any{"threads": []any{map[string]any{"id": 1, "name": "main"}}})
+	case "stackTrace":
+		s.stackTrace(req)
+	case "scopes":
+		s.scopes(req, args)
+	case "variables":
+		s.variables(req, args)
+	case "continue":
+		s.resume(req, Continue)
+	case "next":
+		s.resume(req, StepOver)
+	case "stepIn":
+		s.resume(req, StepIn)
+	case "stepOut":
+		s.resume(req, StepOut)
+	case "pause":
+		if d := s.debugger(); d != nil {
+			d.RequestPause()
+		}
+		s.respond(req, nil)
+	case "evaluate":
+		s.evaluate(req, args)
+	case "disconnect", "terminate":
+		s.respond(req, nil)
+		return true
+	default:
+		s.fail(req, "unsupported request "+req.Command)
+	}
+	return false
+}
+
+func (s *dapServer) debugger() *Debugger {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.d
+}
+
+// maybeStart runs the program once it's launched and the client is done with configuration
+func (s *dapServer) maybeStart() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.launched || !s.configured || s.started {
+		return
+	}
+	s.started = true
+	go s.d.Run(s.block, s.stopOnEntry)
+	go s.events(s.d)
+}
+
+func (s *dapServer) events(d *Debugger) {
+	for ev := range d.Events() {
+		if ev.Exited {
+			code := 0
+			if ev.Failed {
+				code = 1
+			}
+			s.event("exited", map[string]any{"exitCode": code})
+			s.event("terminated", nil)
+			return
+		}
+		s.mu.Lock()
+		s.stopped = true
+		s.refs = nil
+		s.mu.Unlock()
+		body := map[string]any{"reason": ev.Stop.Reason, "threadId": 1, "allThreadsStopped": true}
+		if ev.Stop.Reason == "error" {
+			body["reason"] = "exception"
+		}
+		if ev.Stop.Reason == "watch" {
+			body["reason"] = "data breakpoint"
+		}
+		if ev.Stop.Message != "" {
+			body["text"] = ev.Stop.Message
+			body["description"] = ev.Stop.Message
+		}
+		s.event("stopped", body)
+	}
+}
+
+func (s *dapServer) resume(req *dapMessage, mode StepMode) {
+	s.mu.Lock()
+	d, stopped := s.d, s.stopped
+	s.stopped = false
+	s.mu.Unlock()
+	if d == nil || !stopped {
+		s.fail(req, "the program isn't stopped")
+		return
+	}
+	if req.Command == "continue" {
+		s.respond(req, map[string]any{"allThreadsContinued": true})
+	} else {
+		s.respond(req, nil)
+	}
+	d.Resume(mode)
+}
+
+func (s *dapServer) setBreakpoints(req *dapMessage, args map[string]any) {
+	source, _ := args["source"].(map[string]any)
+	path, _ := source["path"].(string)
+	lines := make([]int, 0)
+	result := make([]any, 0)
+	bps, _ := args["breakpoints"].([]any)
+	for _, b := range bps {
+		bp, _ := b.(map[string]any)
+		line, _ := bp["line"].(float64)
+		lines = append(lines, int(line))
+		result = append(result, map[string]any{"verified": true, "line": int(line)})
+	}
+	s.mu.Lock()
+	s.breakpoints[path] = lines
+	if s.d != nil {
+		s.d.SetBreakpoints(path, lines)
+	}
+	s.mu.Unlock()
+	s.respond(req, map[string]any{"breakpoints": result})
+}
+
+func (s *dapServer) stackTrace(req *dapMessage) {
+	d := s.debugger()
+	frames := make([]any, 0)
+	if d != nil {
+		for _, f := range d.Frames() {
+			frame := map[string]any{"id": f.ID, "name": f.Name, "line": f.Line, "column": 1}
+			if f.File != "" {
+				frame["source"] = map[string]any{"name": filepath.Base(f.File), "path": f.File}
+			}
+			frames = append(frames, frame)
+		}
+	}
+	s.respond(req, map[string]any{"stackFrames": frames, "totalFrames": len(frames)})
+}
+
+// scopes returns the context chain of a frame, the last context usually holds the builtins
+func (s *dapServer) scopes(req *dapMessage, args map[string]any) {
+	d := s.debugger()
+	id, _ := args["frameId"].(float64)
+	f, ok := Frame{}, false
+	if d != nil {
+		f, ok = d.Frame(int(id))
+	}
+	if !ok {
+		s.fail(req, "no such frame")
+		return
+	}
+	scopes := make([]any, 0)
+	chain := ContextChain(f)
+	for i, ctx := range chain {
+		name := "Locals"
+		if i > 0 {
+			name = "Parent " + strconv.Itoa(i)
+		}
+		if i == len(chain)-1 && i > 0 {
+			name = "Builtins"
+		}
+		scopes = append(scopes, map[string]any{
+			"name":               name,
+			"variablesReference": s.ref(ctx),
+			"namedVariables":     len(ctx.GetState()),
+			"expensive":          i == len(chain)-1 && i > 0,
+		})
+	}
+	s.respond(req, map[string]any{"scopes": scopes})
+}
+
+func (s *dapServer) variables(req *dapMessage, args map[string]any) {
+	d := s.debugger()
+	ref, _ := args["variablesReference"].(float64)
+	s.mu.Lock()
+	var target any
+	if int(ref) >= 1 && int(ref) <= len(s.refs) {
+		target = s.refs[int(ref)-1]
+	}
+	s.mu.Unlock()
+	vars := make([]any, 0)
+	if d == nil || target == nil {
+		s.respond(req, map[string]any{"variables": vars})
+		return
+	}
+	add := func(name string, obj env.Object) {
+		vars = append(vars, map[string]any{"name": name, "value": truncate(inspect(d.ps, obj), 200), "variablesReference": s.childRef(obj)})
+	}
+	switch t := target.(type) {
+	case *env.RyeCtx:
+		for _, v := range d.Vars(t) {
+			add(v.Name, v.Value)
+		}
+	case env.Block:
+		for i, obj := range t.Series.S {
+			add(strconv.Itoa(i), obj)
+		}
+	case env.List:
+		for i, v := range t.Data {
+			add(strconv.Itoa(i), env.ToRyeValue(v))
+		}
+	case env.Dict:
+		keys := make([]string, 0, len(t.Data))
+		for k := range t.Data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			add(k, env.ToRyeValue(t.Data[k]))
+		}
+	}
+	s.respond(req, map[string]any{"variables": vars})
+}
+
+// ref allocates a variables reference, they are valid until the program continues
+func (s *dapServer) ref(target any) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refs = append(s.refs, target)
+	return len(s.refs)
+}
+
+// childRef returns a reference for values that can be expanded, 0 for others
+func (s *dapServer) childRef(obj env.Object) int {
+	switch o := obj.(type) {
+	case *env.RyeCtx:
+		return s.ref(o)
+	case env.Block:
+		if len(o.Series.S) > 0 {
+			return s.ref(o)
+		}
+	case env.List:
+		if len(o.Data) > 0 {
+			return s.ref(o)
+		}
+	case env.Dict:
+		if len(o.Data) > 0 {
+			return s.ref(o)
+		}
+	}
+	return 0
+}
+
+func (s *dapServer) evaluate(req *dapMessage, args map[string]any) {
+	d := s.debugger()
+	expr, _ := args["expression"].(string)
+	id, _ := args["frameId"].(float64)
+	s.mu.Lock()
+	stopped := s.stopped
+	s.mu.Unlock()
+	if d == nil || !stopped {
+		s.fail(req, "the program isn't stopped")
+		return
+	}
+	f, ok := d.Frame(int(id))
+	if !ok {
+		frames := d.Frames()
+		if len(frames) == 0 {
+			s.fail(req, "no frame to evaluate in")
+			return
+		}
+		f = frames[0]
+	}
+	res, err := d.Evaluate(f, expr)
+	if err != nil {
+		s.fail(req, err.Error())
+		return
+	}
+	s.respond(req, map[string]any{"result": inspect(d.ps, res), "variablesReference": s.childRef(res)})
+}
@@ -0,0 +1,510 @@
+// Package debugger implements a step debugger for the Rye evaluator. It attaches to the evaluator
+// through evaldo.DebuggerHook, which is called before each expression of a block. Front-ends are
+// the terminal (RunTerminal) and the Debug Adapter Protocol (ServeDAP).
+package debugger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/refaktor/rye/env"
+	"github.com/refaktor/rye/evaldo"
+	"github.com/refaktor/rye/loader"
+)
+
+// StepMode tells the debugger where to stop next
+type StepMode int
+
+const (
+	Continue StepMode = iota // run to the next breakpoint
+	StepIn                   // stop at the next expression anywhere
+	StepOver                 // stop at the next expression at the same or lower call depth
+	StepOut                  // stop at the next expression at a lower call depth
+	Pause                    // stop as soon as possible
+)
+
+// Frame is a level of Rye function calls, as seen at its last evaluated expression
+type Frame struct {
+	ID   int // call depth, 0 is the script
+	Name string
+	File string // absolute path, empty if unknown
+	Line int
+	Ctx  *env.RyeCtx
+	ps   *env.ProgramState
+}
+
+// Stop tells the front-end where and why evaluation stopped
+type Stop struct {
+	Reason  string // entry, breakpoint, step, pause, watch, error
+	File    string
+	Line    int
+	Message string
+}
+
+// Event is sent to the front-end when evaluation stops or the script ends
+type Event struct {
+	Stop   *Stop
+	Exited bool
+	Failed bool // script ended with an error
+}
+
+// Var is a named value shown by the front-ends
+type Var struct {
+	Name  string
+	Value env.Object
+}
+
+type watch struct {
+	name     string
+	idx      int
+	last     env.Object
+	observer *env.RyeCtx // context the observer was registered in, nil if the word isn't a variable
+	block    env.Block
+	changed  bool
+}
+
+// Debugger holds breakpoints, watches and the stepping state of one program
+type Debugger struct {
+	ps   *env.ProgramState
+	smap *sourceMap
+
+	stopMu sync.Mutex // only one goroutine can be stopped at a time
+	mu     sync.Mutex // guards the fields below, front-ends change them while the script runs
+
+	breakpoints map[string]map[int]bool
+	mode        StepMode
+	stepDepth   int
+	entry       bool
+	frames      []Frame
+	watches     []*watch
+
+	evaluating atomic.Bool
+	events     chan Event
+	resume     chan StepMode
+}
+
+// New creates a debugger for a program state with builtins registered
+func New(ps *env.ProgramState) *Debugger {
+	return &Debugger{
+		ps:          ps,
+		smap:        newSourceMap(),
+		breakpoints: make(map[string]map[int]bool),
+		events:      make(chan Event),
+		resume:      make(chan StepMode),
+	}
+}
+
+// Events returns the channel on which stops and the end of the script are reported
+func (d *Debugger) Events() <-chan Event {
+	return d.events
+}
+
+// Load loads a Rye file and records the source lines of its blocks
+func (d *Debugger) Load(file string) (env.Block, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return env.Block{}, err
+	}
+	d.ps.ScriptPath = file
+	res := loader.LoadString(string(content), false, d.ps)
+	switch block := res.(type) {
+	case env.Block:
+		d.smap.add(absPath(file), string(content), block)
+		return block, nil
+	case env.Error:
+		d.ps.FailureFlag = false
+		return env.Block{}, fmt.Errorf("%s", block.Message)
+	}
+	return env.Block{}, fmt.Errorf("loading %s didn't return a block", file)
+}
+
+// Run evaluates the block with the debugger attached. If stopOnEntry is set it stops
+// before the first expression. It blocks until the script ends.
+func (d *Debugger) Run(block env.Block, stopOnEntry bool) {
+	d.mu.Lock()
+	d.entry = stopOnEntry
+	d.mu.Unlock()
+
+	ps := env.AddToProgramStateNEWWithLocation(d.ps, &block, d.ps.Idx)
+	ps.Ctx = env.NewEnv(ps.Ctx)
+	evaldo.DebuggerHook = d.hook
+	evaldo.EvalBlockInj(ps, nil, false)
+	evaldo.DebuggerHook = nil
+
+	failed := ps.ErrorFlag || ps.FailureFlag
+	if failed {
+		// stop where the error happened, the frames still hold the last positions
+		d.mu.Lock()
+		top := Frame{}
+		if len(d.frames) > 0 {
+			top = d.frames[len(d.frames)-1]
+		}
+		d.mu.Unlock()
+		d.events <- Event{Stop: &Stop{Reason: "error", File: top.File, Line: top.Line, Message: errorText(ps)}}
+		<-d.resume
+		evaldo.MaybeDisplayFailureOrError(ps, ps.Idx, "debugger")
+	}
+	d.events <- Event{Exited: true, Failed: failed}
+}
+
+// Resume continues a stopped script in the given mode
+func (d *Debugger) Resume(mode StepMode) {
+	d.resume <- mode
+}
+
+// RequestPause makes the running script stop at its next expression
+func (d *Debugger) RequestPause() {
+	d.mu.Lock()
+	d.mode = Pause
+	d.mu.Unlock()
+}
+
+// SetBreakpoints replaces the breakpoints of a file
+func (d *Debugger) SetBreakpoints(file string, lines []int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	bps := make(map[int]bool)
+	for _, l := range lines {
+		bps[l] = true
+	}
+	d.breakpoints[absPath(file)] = bps
+}
+
+// ToggleBreakpoint adds or removes a breakpoint and reports if it's set now
+func (d *Debugger) ToggleBreakpoint(file string, line int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	file = absPath(file)
+	if d.breakpoints[file] == nil {
+		d.breakpoints[file] = make(map[int]bool)
+	}
+	if d.breakpoints[file][line] {
+		delete(d.breakpoints[file], line)
+		return false
+	}
+	d.breakpoints[file][line] = true
+	return true
+}
+
+// Breakpoints returns all breakpoints as file:line strings
+func (d *Debugger) Breakpoints() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	res := make([]string, 0)
+	for file, lines := range d.breakpoints {
+		for line := range lines {
+			res = append(res, fmt.Sprintf("%s:%d", file, line))
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// hook is installed as evaldo.DebuggerHook
+func (d *Debugger) hook(ps *env.ProgramState) {
+	if d.evaluating.Load() {
+		return
+	}
+	pos := ps.Ser.Pos()
+	file, line, ok := d.smap.lookup(ps.Ser, pos)
+	if !ok {
+		return
+	}
+	// the first expression on a line, breakpoints stop only there
+	firstOnLine := true
+	if pos > 0 {
+		if _, prev, _ := d.smap.lookup(ps.Ser, pos-1); prev == line {
+			firstOnLine = false
+		}
+	}
+
+	d.stopMu.Lock()
+	defer d.stopMu.Unlock()
+
+	d.mu.Lock()
+	depth := ps.CallDepth
+	d.updateFrames(ps, depth, file, line)
+	reason, message := d.shouldStop(depth, file, line, firstOnLine)
+	d.mu.Unlock()
+	if reason == "" {
+		return
+	}
+
+	d.events <- Event{Stop: &Stop{Reason: reason, File: file, Line: line, Message: message}}
+	mode := <-d.resume
+
+	d.mu.Lock()
+	d.mode = mode
+	d.stepDepth = depth
+	d.mu.Unlock()
+}
+
+func (d *Debugger) shouldStop(depth int, file string, line int, firstOnLine bool) (string, string) {
+	for _, w := range d.watches {
+		if w.changed {
+			w.changed = false
+			val := d.lookupWord(w.observer, w.idx)
+			msg := w.name + ": " + inspect(d.ps, w.last) + " -> " + inspect(d.ps, val)
+			w.last = val
+			return "watch", msg
+		}
+	}
+	if d.entry {
+		d.entry = false
+		return "entry", ""
+	}
+	switch d.mode {
+	case StepIn:
+		return "step", ""
+	case StepOver:
+		if depth <= d.stepDepth {
+			return "step", ""
+		}
+	case StepOut:
+		if depth < d.stepDepth {
+			return "step", ""
+		}
+	case Pause:
+		return "pause", ""
+	}
+	if firstOnLine && d.breakpoints[file][line] {
+		return "breakpoint", ""
+	}
+	return "", ""
+}
+
+// updateFrames records the position of the current call depth and drops deeper frames
+func (d *Debugger) updateFrames(ps *env.ProgramState, depth int, file string, line int) {
+	if len(d.frames) > depth+1 {
+		d.frames = d.frames[:depth+1]
+	}
+	for len(d.frames) <= depth {
+		d.frames = append(d.frames, Frame{ID: len(d.frames), Name: "?"})
+	}
+	f := &d.frames[depth]
+	if f.ps != ps || f.Name == "?" {
+		f.Name = d.frameName(ps, depth)
+	}
+	f.File, f.Line, f.Ctx, f.ps = file, line, ps.Ctx, ps
+}
+
+// frameName finds the word the function was called by, looking for a function with
+// this body in the contexts visible from it
+func (d *Debugger) frameName(ps *env.ProgramState, depth int) string {
+	if depth == 0 {
+		return "main"
+	}
+	if len(ps.Ser.S) > 0 {
+		body := &ps.Ser.S[0]
+		for ctx := ps.Ctx; ctx != nil; ctx = ctx.Parent {
+			for idx, obj := range ctx.GetState() {
+				if fn, ok := obj.(env.Function); ok && len(fn.Body.Series.S) > 0 && &fn.Body.Series.S[0] == body {
+					return ps.Idx.GetWord(idx)
+				}
+			}
+		}
+	}
+	return "function"
+}
+
+// Frames returns the call frames, innermost first. Only valid while the script is stopped.
+func (d *Debugger) Frames() []Frame {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	res := make([]Frame, 0, len(d.frames))
+	for i := len(d.frames) - 1; i >= 0; i-- {
+		if d.frames[i].ps != nil {
+			res = append(res, d.frames[i])
+		}
+	}
+	return res
+}
+
+// Frame returns a frame by its ID
+func (d *Debugger) Frame(id int) (Frame, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if id < 0 || id >= len(d.frames) || d.frames[id].ps == nil {
+		return Frame{}, false
+	}
+	return d.frames[id], true
+}
+
+// ContextChain returns the context of a frame followed by its parents. The last one is
+// usually the context with the builtins.
+func ContextChain(f Frame) []*env.RyeCtx {
+	res := make([]*env.RyeCtx, 0)
+	for ctx := f.Ctx; ctx != nil; ctx = ctx.Parent {
+		res = append(res, ctx)
+	}
+	return res
+}
+
+// Vars returns the words of a context sorted by name
+func (d *Debugger) Vars(ctx *env.RyeCtx) []Var {
+	res := make([]Var, 0)
+	if ctx == nil {
+		return res
+	}
+	for idx, obj := range ctx.GetState() {
+		res = append(res, Var{Name: d.ps.Idx.GetWord(idx), Value: obj})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+// Evaluate loads and evaluates Rye code in the context of a frame, while the script is stopped.
+// The script's program state is restored afterwards.
+func (d *Debugger) Evaluate(f Frame, code string) (env.Object, error) {
+	ps := f.ps
+	if ps == nil {
+		return nil, fmt.Errorf("no frame to evaluate in")
+	}
+	ser, res, ctx := ps.Ser, ps.Res, ps.Ctx
+	failure, errflag, ret := ps.FailureFlag, ps.ErrorFlag, ps.ReturnFlag
+	defer func() {
+		ps.Ser, ps.Res, ps.Ctx = ser, res, ctx
+		ps.FailureFlag, ps.ErrorFlag, ps.ReturnFlag = failure, errflag, ret
+		d.evaluating.Store(false)
+	}()
+
+	block := loader.LoadString(code, false, ps)
+	blk, ok := block.(env.Block)
+	if !ok {
+		if e, ok := block.(env.Error); ok {
+			return nil, fmt.Errorf("%s", stripAnsi(e.Message))
+		}
+		return nil, fmt.Errorf("couldn't load the expression")
+	}
+	d.evaluating.Store(true)
+	ps.Ser = blk.Series
+	ps.Ctx = f.Ctx
+	ps.FailureFlag, ps.ErrorFlag, ps.ReturnFlag = false, false, false
+	evaldo.EvalBlockInj(ps, nil, false)
+	if ps.FailureFlag || ps.ErrorFlag {
+		return nil, fmt.Errorf("%s", errorText(ps))
+	}
+	return ps.Res, nil
+}
+
+// Watch adds a word to the watch list. If the word is a variable, an observer (see on-change)
+// makes the debugger stop when the variable changes.
+func (d *Debugger) Watch(f Frame, name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, w := range d.watches {
+		if w.name == name {
+			return
+		}
+	}
+	w := &watch{name: name, idx: d.ps.Idx.IndexWord(name)}
+	for ctx := f.Ctx; ctx != nil; ctx = ctx.Parent {
+		if val, ok := ctx.GetCurrent(w.idx); ok {
+			w.last = val
+			if ctx.IsVariable(w.idx) {
+				w.observer = ctx
+				w.block = d.observerBlock(w)
+				ctx.AddObserver(w.idx, w.block)
+			}
+			break
+		}
+	}
+	d.watches = append(d.watches, w)
+}
+
+// Unwatch removes a word from the watch list
+func (d *Debugger) Unwatch(name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, w := range d.watches {
+		if w.name == name {
+			if w.observer != nil {
+				w.observer.RemoveObserver(w.idx, w.block)
+			}
+			d.watches = append(d.watches[:i], d.watches[i+1:]...)
+			return
+		}
+	}
+}
+
+// Watches returns the watched words with their values in a frame
+func (d *Debugger) Watches(f Frame) []Var {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	res := make([]Var, 0, len(d.watches))
+	for _, w := range d.watches {
+		res = append(res, Var{Name: w.name, Value: d.lookupWord(f.Ctx, w.idx)})
+	}
+	return res
+}
+
+// observerBlock is the observer registered for a watch, a block with a builtin that only marks
+// the watch as changed, so the debugger stops before the next expression
+func (d *Debugger) observerBlock(w *watch) env.Block {
+	bu := env.NewBuiltin(func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+		d.mu.Lock()
+		w.changed = true
+		d.mu.Unlock()
+		return env.Void{}
+	}, 0, false, false, "debugger watch on "+w.name)
+	return *env.NewBlock(*env.NewTSeries([]env.Object{*bu}))
+}
+
+func (d *Debugger) lookupWord(ctx *env.RyeCtx, idx int) env.Object {
+	if ctx == nil {
+		return nil
+	}
+	if val, ok := ctx.Get(idx); ok {
+		return val
+	}
+	return nil
+}
+
+// SourceLine returns the text of a line of a loaded file
+func (d *Debugger) SourceLine(file string, line int) string {
+	return d.smap.sourceLine(file, line)
+}
+
+// Idx returns the word index of the debugged program
+func (d *Debugger) Idx() *env.Idxs {
+	return d.ps.Idx
+}
+
+func inspect(ps *env.ProgramState, obj env.Object) string {
+	if obj == nil {
+		return "(unset)"
+	}
+	return obj.Inspect(*ps.Idx)
+}
+
+func errorText(ps *env.ProgramState) string {
+	if ps.Res == nil {
+		return "error"
+	}
+	if e, ok := ps.Res.(env.Error); ok {
+		return e.Message
+	}
+	if e, ok := ps.Res.(*env.Error); ok {
+		return e.Message
+	}
+	return ps.Res.Print(*ps.Idx)
+}
+
+var ansiRegex = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+func stripAnsi(s string) string {
+	return strings.TrimSpace(ansiRegex.ReplaceAllString(s, ""))
+}
+
+func absPath(file string) string {
+	if abs, err := filepath.Abs(file); err == nil {
+		return abs
+	}
+	return file
+}
@@ -0,0 +1,175 @@
+package debugger
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/refaktor/rye/env"
+	"github.com/refaktor/rye/evaldo"
+)
+
+const debugScript = `add: fn { a b } {
+  c: a + b
+  c * 2
+}
+var 'x 1
+y: add x 2
+change! y + 1 'x
+z: x + 10
+`
+
+// startDebugger loads the script into a new debugger and runs it in the background
+func startDebugger(t *testing.T, script string, breakpoints []int, stopOnEntry bool) (*Debugger, string) {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "t.rye")
+	if err := os.WriteFile(file, []byte(script), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ps := env.NewProgramState()
+	evaldo.RegisterBuiltins(ps)
+	d := New(ps)
+	block, err := d.Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.SetBreakpoints(file, breakpoints)
+	go d.Run(block, stopOnEntry)
+	return d, file
+}
+
+// next waits for the debugger to stop or the script to end
+func next(t *testing.T, d *Debugger) Event {
+	t.Helper()
+	select {
+	case ev := <-d.Events():
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("the debugger didn't stop")
+	}
+	return Event{}
+}
+
+func expectStop(t *testing.T, d *Debugger, reason string, line int) *Stop {
+	t.Helper()
+	ev := next(t, d)
+	if ev.Stop == nil {
+		t.Fatalf("expected a stop at line %d, the script ended", line)
+	}
+	if ev.Stop.Reason != reason || ev.Stop.Line != line {
+		t.Fatalf("expected %s at line %d, got %s at line %d", reason, line, ev.Stop.Reason, ev.Stop.Line)
+	}
+	return ev.Stop
+}
+
+func expectExit(t *testing.T, d *Debugger) {
+	t.Helper()
+	if ev := next(t, d); !ev.Exited || ev.Failed {
+		t.Fatalf("expected the script to end without errors, got %+v", ev)
+	}
+}
+
+func evaluate(t *testing.T, d *Debugger, f Frame, code string) string {
+	t.Helper()
+	val, err := d.Evaluate(f, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return val.Inspect(*d.Idx())
+}
+
+func TestBreakpointAndStepping(t *testing.T) {
+	d, file := startDebugger(t, debugScript, []int{6}, false)
+
+	s := expectStop(t, d, "breakpoint", 6)
+	if s.File != file {
+		t.Errorf("expected file %s, got %s", file, s.File)
+	}
+	frames := d.Frames()
+	if len(frames) != 1 || frames[0].Name != "main" {
+		t.Fatalf("expected the main frame, got %+v", frames)
+	}
+	if v := evaluate(t, d, frames[0], "x"); v != "[Integer: 1]" {
+		t.Errorf("expected x to be 1, got %s", v)
+	}
+
+	// step into add, its arguments are in the frame's context
+	d.Resume(StepIn)
+	expectStop(t, d, "step", 2)
+	frames = d.Frames()
+	if len(frames) != 2 || frames[0].Name != "add" || frames[1].Name != "main" {
+		t.Fatalf("expected add called from main, got %+v", frames)
+	}
+	vars := map[string]string{}
+	for _, v := range d.Vars(frames[0].Ctx) {
+		vars[v.Name] = v.Value.Inspect(*d.Idx())
+	}
+	if vars["a"] != "[Integer: 1]" || vars["b"] != "[Integer: 2]" {
+		t.Errorf("expected a 1 and b 2, got %v", vars)
+	}
+
+	d.Resume(StepOver)
+	expectStop(t, d, "step", 3)
+	if v := evaluate(t, d, d.Frames()[0], "c"); v != "[Integer: 3]" {
+		t.Errorf("expected c to be 3, got %s", v)
+	}
+
+	// out of add, to the next expression of the script
+	d.Resume(StepOut)
+	expectStop(t, d, "step", 7)
+	if v := evaluate(t, d, d.Frames()[0], "y"); v != "[Integer: 6]" {
+		t.Errorf("expected y to be 6, got %s", v)
+	}
+
+	d.Resume(Continue)
+	expectExit(t, d)
+}
+
+func TestStepOverSkipsCalls(t *testing.T) {
+	d, _ := startDebugger(t, debugScript, nil, true)
+
+	expectStop(t, d, "entry", 1)
+	for _, line := range []int{5, 6, 7, 8} {
+		d.Resume(StepOver)
+		expectStop(t, d, "step", line)
+	}
+	d.Resume(StepOver)
+	expectExit(t, d)
+}
+
+func TestWatch(t *testing.T) {
+	d, _ := startDebugger(t, debugScript, []int{6}, false)
+
+	expectStop(t, d, "breakpoint", 6)
+	d.Watch(d.Frames()[0], "x")
+	d.Resume(Continue)
+
+	// the variable changes on line 7, the debugger stops before the next expression
+	s := expectStop(t, d, "watch", 8)
+	if s.Message != "x: [Integer: 1] -> [Integer: 7]" {
+		t.Errorf("unexpected watch message %q", s.Message)
+	}
+	watches := d.Watches(d.Frames()[0])
+	if len(watches) != 1 || watches[0].Name != "x" || watches[0].Value.Inspect(*d.Idx()) != "[Integer: 7]" {
+		t.Errorf("expected x to be watched with 7, got %+v", watches)
+	}
+
+	d.Unwatch("x")
+	d.Resume(Continue)
+	expectExit(t, d)
+}
+
+func TestStopOnError(t *testing.T) {
+	d, _ := startDebugger(t, "a: 1\nb: a / 0\n", nil, false)
+
+	s := expectStop(t, d, "error", 2)
+	if !strings.Contains(strings.ToLower(s.Message), "zero") {
+		t.Errorf("expected a division by zero error, got %q", s.Message)
+	}
+	d.Resume(Continue)
+	if ev := next(t, d); !ev.Exited || !ev.Failed {
+		t.Fatalf("expected the script to end with an error, got %+v", ev)
+	}
+}
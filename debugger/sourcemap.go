@@ -0,0 +1,179 @@
+package debugger
+
+import (
+	"strings"
+
+	"github.com/refaktor/rye/env"
+	"github.com/refaktor/rye/loader"
+)
+
+// blockLines holds the source line of each value of a loaded block
+type blockLines struct {
+	file  string
+	start int   // line of the opening brace
+	lines []int // 1-based line for each value in the series
+}
+
+// sourceMap maps the series of loaded blocks to source lines. Blocks only remember where they
+// start (Block.FileName, Line, Column), so the source is lexed again and the tokens directly
+// inside each block are matched with the values of the parsed block.
+type sourceMap struct {
+	blocks map[*env.Object]*blockLines
+	files  map[string][]string // source lines of each file, for display
+}
+
+func newSourceMap() *sourceMap {
+	return &sourceMap{blocks: make(map[*env.Object]*blockLines), files: make(map[string][]string)}
+}
+
+// add registers the blocks of a file loaded with loader.LoadString
+func (sm *sourceMap) add(file string, content string, block env.Block) {
+	sm.files[file] = strings.Split(content, "\n")
+	// LoadString drops the #! line and wraps the code in a block, so we do the same to get
+	// the same token positions as the parser
+	offset := 0
+	if strings.HasPrefix(strings.TrimSpace(content), "#!") {
+		if i := strings.Index(content, "\n"); i >= 0 {
+			content = content[i+1:]
+			offset = 1
+		} else {
+			content = ""
+		}
+	}
+	children := childTokenLines("{ " + content + "\n}")
+	sm.addBlock(file, block, children, offset)
+}
+
+func (sm *sourceMap) addBlock(file string, block env.Block, children map[[2]int][]int, offset int) {
+	if len(block.Series.S) == 0 {
+		return
+	}
+	key := &block.Series.S[0]
+	if _, ok := sm.blocks[key]; ok {
+		return
+	}
+	bl := &blockLines{file: file, start: block.Line + offset, lines: make([]int, len(block.Series.S))}
+	raw, ok := children[[2]int{block.Line, block.Column}]
+	for i := range bl.lines {
+		if ok && len(raw) == len(bl.lines) {
+			bl.lines[i] = raw[i] + offset
+		} else {
+			// the values don't match the tokens, the best we know is where the block starts
+			bl.lines[i] = bl.start
+		}
+	}
+	sm.blocks[key] = bl
+	for _, obj := range block.Series.S {
+		if b, ok := obj.(env.Block); ok {
+			sm.addBlock(file, b, children, offset)
+		}
+	}
+}
+
+// lookup returns the file and line of the value at position pos of a series
+func (sm *sourceMap) lookup(ser env.TSeries, pos int) (string, int, bool) {
+	if len(ser.S) == 0 {
+		return "", 0, false
+	}
+	bl, ok := sm.blocks[&ser.S[0]]
+	if !ok || pos < 0 {
+		return "", 0, false
+	}
+	if pos >= len(bl.lines) {
+		return bl.file, bl.lines[len(bl.lines)-1], true
+	}
+	return bl.file, bl.lines[pos], true
+}
+
+// sourceLine returns the text of a line of a loaded file
+func (sm *sourceMap) sourceLine(file string, line int) string {
+	lines := sm.files[file]
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+	return strings.TrimRight(lines[line-1], "\r")
+}
+
+// blockOpeners are the tokens that start a nested block, list, dict or group
+var blockOpeners = map[int]bool{
+	loader.NPEG_TOKEN_BLOCK_START: true, loader.NPEG_TOKEN_BBLOCK_START: true, loader.NPEG_TOKEN_GROUP_START: true,
+	loader.NPEG_TOKEN_OPBBLOCK_START: true, loader.NPEG_TOKEN_OPGROUP_START: true, loader.NPEG_TOKEN_OPBLOCK_START: true,
+	loader.NPEG_TOKEN_LIST_BLOCK_START: true, loader.NPEG_TOKEN_LIST_BBLOCK_START: true,
+	loader.NPEG_TOKEN_DICT_BLOCK_START: true, loader.NPEG_TOKEN_DICT_BBLOCK_START: true,
+}
+
+var blockClosers = map[int]bool{
+	loader.NPEG_TOKEN_BLOCK_END: true, loader.NPEG_TOKEN_BBLOCK_END: true, loader.NPEG_TOKEN_GROUP_END: true,
+}
+
+// childTokenLines lexes the input and returns, for each opening token (by its lexer line and
+// column, which is what the parser stores in Block.Line and Block.Column), the lines of the tokens
+// directly inside it. The lexer counts lines from 1, so these are 1-based lines of the input.
+func childTokenLines(input string) map[[2]int][]int {
+	res := make(map[[2]int][]int)
+	stack := make([][2]int, 0)
+	lex := loader.NewLexer(input)
+	for {
+		tk := lex.NextToken()
+		if tk.Type == loader.NPEG_TOKEN_EOF || tk.Type == loader.NPEG_TOKEN_ERROR {
+			return res
+		}
+		switch tk.Type {
+		case loader.NPEG_TOKEN_COMMENT, loader.NPEG_TOKEN_NONE, loader.NPEG_TOKEN_LOCATION_NODE:
+			continue
+		}
+		if blockClosers[tk.Type] {
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+			continue
+		}
+		if len(stack) > 0 {
+			parent := stack[len(stack)-1]
+			res[parent] = append(res[parent], tk.Line)
+		}
+		if blockOpeners[tk.Type] {
+			key := [2]int{tk.Line, tk.Col}
+			res[key] = make([]int, 0)
+			stack = append(stack, key)
+		}
+	}
+}
+
+// SourceMap gives the source locations of the values of a loaded file, for tools that report
+// where something happened, like the test runner
+type SourceMap struct {
+	sm *sourceMap
+}
+
+// NewSourceMap maps the blocks of a file loaded with loader.LoadString
+func NewSourceMap(file string, content string, block env.Block) *SourceMap {
+	sm := newSourceMap()
+	sm.add(file, content, block)
+	return &SourceMap{sm}
+}
+
+// Location returns the location of the value at position pos of a series of the file
+func (s *SourceMap) Location(ser env.TSeries, pos int) (*env.LocationNode, bool) {
+	file, line, ok := s.sm.lookup(ser, pos)
+	if !ok {
+		return nil, false
+	}
+	return env.NewLocationNode(file, line, 1, strings.TrimSpace(s.sm.sourceLine(file, line))), true
+}
+
+// Add maps the blocks of another loaded file
+func (s *SourceMap) Add(file string, content string, block env.Block) {
+	s.sm.add(file, content, block)
+}
+
+// Line returns the file and line of the value at position pos of a series, without the source
+// text Location returns
+func (s *SourceMap) Line(ser env.TSeries, pos int) (string, int, bool) {
+	return s.sm.lookup(ser, pos)
+}
+
+// Source returns the source lines of a mapped file
+func (s *SourceMap) Source(file string) []string {
+	return s.sm.files[file]
+}
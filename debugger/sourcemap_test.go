@@ -0,0 +1,33 @@
+package debugger
+
+import (
+	"testing"
+
+	"github.com/refaktor/rye/env"
+	"github.com/refaktor/rye/loader"
+)
+
+func TestSourceMapLines(t *testing.T) {
+	content := "#!/usr/bin/env rye\nx: 1\nadd: fn { a b } {\n  a + b\n}\nprint add x 2\n"
+	ps := env.NewProgramState()
+	block, ok := loader.LoadString(content, false, ps).(env.Block)
+	if !ok {
+		t.Fatal("expected a block")
+	}
+	sm := newSourceMap()
+	sm.add("t.rye", content, block)
+
+	expected := []int{2, 2, 3, 3, 3, 3, 6, 6, 6, 6}
+	for pos, line := range expected {
+		if _, l, ok := sm.lookup(block.Series, pos); !ok || l != line {
+			t.Errorf("value %d: expected line %d, got %d", pos, line, l)
+		}
+	}
+	body := block.Series.S[5].(env.Block)
+	if _, l, _ := sm.lookup(body.Series, 0); l != 4 {
+		t.Errorf("function body: expected line 4, got %d", l)
+	}
+	if sm.sourceLine("t.rye", 4) != "  a + b" {
+		t.Errorf("unexpected source line %q", sm.sourceLine("t.rye", 4))
+	}
+}
@@ -0,0 +1,273 @@
+package debugger
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/refaktor/rye/env"
+)
+
+const terminalHelp = `Commands:
+  c, continue        run to the next breakpoint
+  s, step            step into the next expression
+  n, next            step over function calls
+  o, out             run until the current function returns
+  b [file:]line      toggle a breakpoint (the current file if no file is given)
+  bl                 list breakpoints
+  w word             watch a word, stops when a variable changes
+  uw word            stop watching a word
+  l, locals          list the words of the current context
+  ctx [n]            list the context chain, or the words of the n-th parent context
+  bt                 show call frames
+  f n                select frame n
+  p code             evaluate Rye code in the selected frame
+  src                show source around the current line
+  q, quit            stop debugging and exit
+  h, help            show this help`
+
+// RunTerminal debugs a file in the terminal. Breakpoints are given as "file:line" or "line" (in
+// the debugged file). Without breakpoints it stops before the first expression.
+func RunTerminal(ps *env.ProgramState, file string, breakpoints []string) error {
+	d := New(ps)
+	block, err := d.Load(file)
+	if err != nil {
+		return err
+	}
+	for _, bp := range breakpoints {
+		bfile, line, err := parseLocation(bp, file)
+		if err != nil {
+			return err
+		}
+		d.ToggleBreakpoint(bfile, line)
+	}
+	go d.Run(block, len(breakpoints) == 0)
+
+	t := &terminal{d: d, in: bufio.NewScanner(os.Stdin)}
+	for ev := range d.Events() {
+		if ev.Exited {
+			if ev.Failed {
+				return fmt.Errorf("script ended with an error")
+			}
+			fmt.Println("\x1b[36m[script finished]\x1b[0m")
+			return nil
+		}
+		t.stopped(ev.Stop)
+	}
+	return nil
+}
+
+type terminal struct {
+	d     *Debugger
+	in    *bufio.Scanner
+	frame Frame
+	stop  *Stop
+}
+
+// stopped shows where the script stopped and reads commands until one resumes the script
+func (t *terminal) stopped(s *Stop) {
+	t.stop = s
+	frames := t.d.Frames()
+	if len(frames) > 0 {
+		t.frame = frames[0]
+	}
+	switch s.Reason {
+	case "error":
+		fmt.Printf("\x1b[1;31mError\x1b[0m at %s: %s\n", t.location(s.File, s.Line), s.Message)
+	case "watch":
+		fmt.Printf("\x1b[1;33mWatch\x1b[0m %s\n", s.Message)
+	}
+	t.showLine(s.File, s.Line)
+	t.showWatches()
+
+	for {
+		fmt.Print("\x1b[1;36m(rye-dbg)\x1b[0m ")
+		if !t.in.Scan() {
+			os.Exit(1)
+		}
+		line := strings.TrimSpace(t.in.Text())
+		cmd, arg, _ := strings.Cut(line, " ")
+		arg = strings.TrimSpace(arg)
+		switch cmd {
+		case "c", "continue":
+			t.d.Resume(Continue)
+			return
+		case "s", "step":
+			t.d.Resume(StepIn)
+			return
+		case "n", "next", "":
+			t.d.Resume(StepOver)
+			return
+		case "o", "out":
+			t.d.Resume(StepOut)
+			return
+		case "b", "break":
+			t.breakpoint(arg)
+		case "bl":
+			for _, bp := range t.d.Breakpoints() {
+				fmt.Println("  " + bp)
+			}
+		case "w", "watch":
+			if arg == "" {
+				t.showWatches()
+			} else {
+				t.d.Watch(t.frame, arg)
+				t.showWatches()
+			}
+		case "uw", "unwatch":
+			t.d.Unwatch(arg)
+		case "l", "locals":
+			t.listContext(t.frame.Ctx)
+		case "ctx":
+			t.contextChain(arg)
+		case "bt":
+			t.backtrace()
+		case "f", "frame":
+			t.selectFrame(arg)
+		case "p", "print":
+			res, err := t.d.Evaluate(t.frame, arg)
+			if err != nil {
+				fmt.Printf("\x1b[31m%s\x1b[0m\n", err)
+			} else {
+				fmt.Println(inspect(t.d.ps, res))
+			}
+		case "src":
+			t.showSource(t.frame.File, t.frame.Line, 5)
+		case "q", "quit":
+			os.Exit(1)
+		case "h", "help":
+			fmt.Println(terminalHelp)
+		default:
+			fmt.Printf("Unknown command %q, type h for help\n", cmd)
+		}
+	}
+}
+
+func (t *terminal) location(file string, line int) string {
+	if file == "" {
+		return "?"
+	}
+	if wd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(wd, file); err == nil && !strings.HasPrefix(rel, "..") {
+			file = rel
+		}
+	}
+	return file + ":" + strconv.Itoa(line)
+}
+
+func (t *terminal) showLine(file string, line int) {
+	fmt.Printf("\x1b[1m%s\x1b[0m  %s\n", t.location(file, line), strings.TrimSpace(t.d.SourceLine(file, line)))
+}
+
+func (t *terminal) showSource(file string, line int, around int) {
+	for l := max(line-around, 1); l <= line+around; l++ {
+		src := t.d.SourceLine(file, l)
+		if l > line && src == "" && t.d.SourceLine(file, l+1) == "" {
+			break
+		}
+		marker := "  "
+		if l == line {
+			marker = "\x1b[1;33m->\x1b[0m"
+		}
+		fmt.Printf("%s %4d %s\n", marker, l, src)
+	}
+}
+
+func (t *terminal) showWatches() {
+	for _, w := range t.d.Watches(t.frame) {
+		fmt.Printf("  \x1b[33m%s\x1b[0m = %s\n", w.Name, truncate(inspect(t.d.ps, w.Value), 70))
+	}
+}
+
+func (t *terminal) listContext(ctx *env.RyeCtx) {
+	vars := t.d.Vars(ctx)
+	if len(vars) == 0 {
+		fmt.Println("  (empty context)")
+		return
+	}
+	for _, v := range vars {
+		fmt.Printf("  \x1b[32m%s\x1b[0m: %s\n", v.Name, truncate(inspect(t.d.ps, v.Value), 70))
+	}
+}
+
+func (t *terminal) contextChain(arg string) {
+	chain := ContextChain(t.frame)
+	if arg != "" {
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 0 || n >= len(chain) {
+			fmt.Printf("No context %s, the chain has %d contexts\n", arg, len(chain))
+			return
+		}
+		t.listContext(chain[n])
+		return
+	}
+	for i, ctx := range chain {
+		desc := ""
+		if ctx.Doc != "" {
+			desc = " " + ctx.Doc
+		}
+		fmt.Printf("  %d: %d words%s\n", i, len(ctx.GetState()), desc)
+	}
+}
+
+func (t *terminal) backtrace() {
+	for _, f := range t.d.Frames() {
+		marker := "  "
+		if f.ID == t.frame.ID {
+			marker = "\x1b[1;33m->\x1b[0m"
+		}
+		fmt.Printf("%s #%d %s at %s\n", marker, f.ID, f.Name, t.location(f.File, f.Line))
+	}
+}
+
+func (t *terminal) selectFrame(arg string) {
+	n, err := strconv.Atoi(arg)
+	if err != nil {
+		fmt.Println("Usage: f <frame number>")
+		return
+	}
+	f, ok := t.d.Frame(n)
+	if !ok {
+		fmt.Printf("No frame #%d\n", n)
+		return
+	}
+	t.frame = f
+	t.showLine(f.File, f.Line)
+}
+
+func (t *terminal) breakpoint(arg string) {
+	file, line, err := parseLocation(arg, t.frame.File)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if t.d.ToggleBreakpoint(file, line) {
+		fmt.Printf("Breakpoint set at %s\n", t.location(absPath(file), line))
+	} else {
+		fmt.Printf("Breakpoint removed at %s\n", t.location(absPath(file), line))
+	}
+}
+
+// parseLocation parses "file:line" or "line", a plain line is in the default file
+func parseLocation(loc string, defaultFile string) (string, int, error) {
+	file := defaultFile
+	lineStr := loc
+	if i := strings.LastIndex(loc, ":"); i >= 0 {
+		file, lineStr = loc[:i], loc[i+1:]
+	}
+	line, err := strconv.Atoi(lineStr)
+	if err != nil || line < 1 {
+		return "", 0, fmt.Errorf("invalid breakpoint %q, use file:line or line", loc)
+	}
+	return file, line, nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n-3] + "..."
+	}
+	return s
+}
@@ -1,7 +1,10 @@
 package env
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"os"
 	"sort"
 	"strings"
 )
@@ -981,6 +984,7 @@ type ProgramState struct {
 	Dialect       DoDialect
 	Stack         *EyrStack
 	Embedded      bool
+	ScriptArgIdx  int          // index in os.Args of the first argument of the script, 0 when the runner didn't set it
 	EmbeddedFS    *interface{} // embed.FS for embedded script files (interface to avoid import cycle)
 	DeferBlocks   []Block      // blocks to be executed when function exits or program terminates
 	CaptureBlock  *Block       // block to be executed with injected error if an error bubbles to the top
@@ -993,6 +997,10 @@ type ProgramState struct {
 	MaxOps         int64                // 0 = unlimited; if > 0 expression evaluations exceeding this count return an error
 	OpsCount       int64                // number of expression evaluations performed so far
 	GetHistoryLast func(n int) []string // function to get last N history lines from REPL
+	Modules        *ModuleRegistry      // modules imported by the program, shared by all its program states
+	ImportChain    []string             // paths of the modules this state is importing, the innermost last
+	TaskCtx        context.Context      // cancellation and deadline of the task the state runs in, nil is never cancelled
+	Out            io.Writer            // where the print builtins write, nil is os.Stdout
 }
 
 type DoDialect int
@@ -1033,6 +1041,7 @@ func NewProgramStateOLD(ser TSeries, idx *Idxs) *ProgramState {
 		ContextStack:  make([]*RyeCtx, 0),
 		BlockFile:     "",
 		BlockLine:     -1,
+		Modules:       NewModuleRegistry(),
 	}
 	return &ps
 }
@@ -1066,6 +1075,7 @@ func NewProgramState() *ProgramState {
 		ContextStack:  make([]*RyeCtx, 0),
 		BlockFile:     "",
 		BlockLine:     -1,
+		Modules:       NewModuleRegistry(),
 	}
 	return &ps
 }
@@ -1095,6 +1105,24 @@ func (ps *ProgramState) PopContext() (*RyeCtx, bool) {
 	return ctx, true
 }
 
+// GoContext returns the Go context of the task the program state runs in, builtins that wait on
+// network, processes or timers pass it on so they stop when the task is cancelled
+func (ps *ProgramState) GoContext() context.Context {
+	if ps.TaskCtx == nil {
+		return context.Background()
+	}
+	return ps.TaskCtx
+}
+
+// Stdout returns the writer the print builtins write to, it's set when the program talks to
+// another terminal than its own, like an SSH session
+func (ps *ProgramState) Stdout() io.Writer {
+	if ps.Out == nil {
+		return os.Stdout
+	}
+	return ps.Out
+}
+
 // ContextStackSize returns the number of contexts in the stack
 func (ps *ProgramState) ContextStackSize() int {
 	return len(ps.ContextStack)
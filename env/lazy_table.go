@@ -0,0 +1,178 @@
+package env
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// LazyChunkSize is the number of rows read from the source and passed through the stages at once
+var LazyChunkSize = 1024
+
+// RowReader reads the rows of a lazy table source one by one. Next returns io.EOF after the last row.
+type RowReader interface {
+	Next() ([]any, error)
+	Close() error
+}
+
+// LazyStage is one step of a lazy table pipeline. Start is called at the beginning of each pass
+// over the rows, so stages that keep state (like distinct or head) start fresh every time. The
+// returned function gets a chunk of rows and returns the rows for the next stage, and true if the
+// pass can stop after this chunk.
+type LazyStage struct {
+	Name  string
+	Cols  []string // columns of the rows the stage returns
+	Start func() func(chunk *Table) (*Table, bool, error)
+}
+
+// LazyTable is a table whose rows are not in memory. Rows are read from the source in chunks and
+// passed through the stages each time the table is consumed, only Materialize keeps all of them.
+// Adding a stage returns a new lazy table, the source and the previous stages are shared.
+type LazyTable struct {
+	Cols    []string
+	Kind    Word
+	srcCols []string // columns of the rows the source reads
+	source  string
+	open    func() (RowReader, error)
+	stages  []LazyStage
+}
+
+func NewLazyTable(cols []string, source string, open func() (RowReader, error)) *LazyTable {
+	return &LazyTable{Cols: cols, srcCols: cols, source: source, open: open}
+}
+
+// With returns a lazy table with the stage added at the end of the pipeline
+func (lt *LazyTable) With(stage LazyStage) *LazyTable {
+	stages := make([]LazyStage, len(lt.stages), len(lt.stages)+1)
+	copy(stages, lt.stages)
+	return &LazyTable{Cols: stage.Cols, Kind: lt.Kind, srcCols: lt.srcCols, source: lt.source, open: lt.open, stages: append(stages, stage)}
+}
+
+// Each streams the rows through the stages and calls fn with each non-empty resulting chunk, until
+// the source is exhausted, a stage is done or fn returns true.
+func (lt *LazyTable) Each(fn func(chunk *Table) (bool, error)) error {
+	reader, err := lt.open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	steps := make([]func(chunk *Table) (*Table, bool, error), len(lt.stages))
+	for i, stage := range lt.stages {
+		steps[i] = stage.Start()
+	}
+	for {
+		chunk := NewTable(slices.Clone(lt.srcCols))
+		eof := false
+		for len(chunk.Rows) < LazyChunkSize {
+			vals, err := reader.Next()
+			if err == io.EOF {
+				eof = true
+				break
+			}
+			if err != nil {
+				return err
+			}
+			chunk.AddRow(*NewTableRow(vals, chunk))
+		}
+		done := eof
+		for _, step := range steps {
+			if len(chunk.Rows) == 0 {
+				break
+			}
+			var stop bool
+			chunk, stop, err = step(chunk)
+			if err != nil {
+				return err
+			}
+			done = done || stop
+		}
+		if len(chunk.Rows) > 0 {
+			stop, err := fn(chunk)
+			if err != nil {
+				return err
+			}
+			done = done || stop
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+// Count streams all rows and returns their number
+func (lt *LazyTable) Count() (int, error) {
+	n := 0
+	err := lt.Each(func(chunk *Table) (bool, error) {
+		n += len(chunk.Rows)
+		return false, nil
+	})
+	return n, err
+}
+
+// Materialize streams all rows into an in-memory table
+func (lt *LazyTable) Materialize() (*Table, error) {
+	t := NewTable(slices.Clone(lt.Cols))
+	err := lt.Each(func(chunk *Table) (bool, error) {
+		for _, row := range chunk.Rows {
+			t.AddRow(*NewTableRow(row.Values, t))
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (lt *LazyTable) GetColumns() List {
+	lst := make([]any, len(lt.Cols))
+	for i, v := range lt.Cols {
+		lst[i] = v
+	}
+	return *NewList(lst)
+}
+
+// Describe returns the source and the stages of the pipeline
+func (lt *LazyTable) Describe() string {
+	var b strings.Builder
+	b.WriteString(lt.source)
+	for _, stage := range lt.stages {
+		b.WriteString(" |" + stage.Name)
+	}
+	return b.String()
+}
+
+func (lt *LazyTable) Type() Type {
+	return LazyTableType
+}
+
+func (lt *LazyTable) GetKind() int {
+	return int(LazyTableType)
+}
+
+func (lt *LazyTable) Equal(o Object) bool {
+	return lt == o
+}
+
+func (lt *LazyTable) Inspect(e Idxs) string {
+	return "[LazyTable(" + fmt.Sprint(len(lt.Cols)) + ") " + lt.Describe() + "]"
+}
+
+// Print doesn't read the rows, a lazy table can be larger than the memory
+func (lt *LazyTable) Print(e Idxs) string {
+	return "lazy table " + strings.Join(lt.Cols, ", ") + " from " + lt.Describe()
+}
+
+func (lt *LazyTable) Trace(msg string) {
+	fmt.Print(msg + " (lazy table): ")
+}
+
+func (lt *LazyTable) Dump(e Idxs) string {
+	t, err := lt.Materialize()
+	if err != nil {
+		return ""
+	}
+	return t.Dump(e)
+}
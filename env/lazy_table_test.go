@@ -0,0 +1,69 @@
+package env
+
+import (
+	"io"
+	"testing"
+)
+
+type sliceRows struct {
+	rows [][]any
+	read int
+}
+
+func (r *sliceRows) Next() ([]any, error) {
+	if r.read == len(r.rows) {
+		return nil, io.EOF
+	}
+	r.read++
+	return r.rows[r.read-1], nil
+}
+
+func (r *sliceRows) Close() error { return nil }
+
+func TestLazyTableStages(t *testing.T) {
+	LazyChunkSize = 3
+	defer func() { LazyChunkSize = 1024 }()
+
+	src := &sliceRows{}
+	lt := NewLazyTable([]string{"n"}, "test", func() (RowReader, error) {
+		src.read = 0
+		src.rows = make([][]any, 10)
+		for i := range src.rows {
+			src.rows[i] = []any{*NewInteger(int64(i))}
+		}
+		return src, nil
+	})
+	even := lt.With(LazyStage{Name: "even", Cols: lt.Cols, Start: func() func(chunk *Table) (*Table, bool, error) {
+		return func(chunk *Table) (*Table, bool, error) {
+			res := NewTable(chunk.Cols)
+			for _, row := range chunk.Rows {
+				if row.Values[0].(Integer).Value%2 == 0 {
+					res.AddRow(row)
+				}
+			}
+			return res, false, nil
+		}
+	}})
+
+	n, err := even.Count()
+	if err != nil || n != 5 {
+		t.Fatalf("expected 5 even rows, got %d (%v)", n, err)
+	}
+	if n, _ := lt.Count(); n != 10 {
+		t.Fatalf("adding a stage changed the source table, it has %d rows", n)
+	}
+
+	first := even.With(LazyStage{Name: "first", Cols: lt.Cols, Start: func() func(chunk *Table) (*Table, bool, error) {
+		return func(chunk *Table) (*Table, bool, error) {
+			chunk.Rows = chunk.Rows[:1]
+			return chunk, true, nil
+		}
+	}})
+	t2, err := first.Materialize()
+	if err != nil || len(t2.Rows) != 1 {
+		t.Fatalf("expected one row, got %v (%v)", t2, err)
+	}
+	if src.read != 3 {
+		t.Fatalf("expected to stop after the first chunk, read %d rows", src.read)
+	}
+}
@@ -0,0 +1,141 @@
+package env
+
+import (
+	"slices"
+	"sync"
+)
+
+// ModuleRegistry holds the modules a program has imported. Each module file is evaluated once,
+// later imports of the same file get the same context. It's shared by all program states of the
+// program, so a module imported by two goroutines at once is evaluated by the first one and the
+// second one waits for it. The modules being imported by a program state are its import chain
+// (ProgramState.ImportChain), so circular imports are reported with the whole chain.
+type ModuleRegistry struct {
+	Root  string // project directory, the first place modules are searched for after the importing file's directory
+	state *moduleState
+}
+
+// moduleState is behind a pointer, so the copies of the registry copier makes when it copies a
+// program state for a goroutine share the lock and the modules
+type moduleState struct {
+	mu      sync.Mutex
+	modules map[string]*moduleEntry
+}
+
+type moduleEntry struct {
+	path    string
+	ctx     *RyeCtx
+	exports []int         // words the module exports, nil if it exports all of them
+	done    chan struct{} // closed when the evaluation ends
+	waits   *moduleEntry  // module whose evaluation (by another program state) this one waits for
+}
+
+func NewModuleRegistry() *ModuleRegistry {
+	return &ModuleRegistry{state: &moduleState{modules: make(map[string]*moduleEntry)}}
+}
+
+// Get returns the context of an already imported module
+func (m *ModuleRegistry) Get(path string) (*RyeCtx, bool) {
+	m.state.mu.Lock()
+	defer m.state.mu.Unlock()
+	if e, ok := m.state.modules[path]; ok && e.ctx != nil {
+		return e.ctx, true
+	}
+	return nil, false
+}
+
+// Begin is called before a module is imported with the import chain of the program state. It
+// returns the context if the module was already imported, or true if the caller should evaluate it
+// and then call End. If another program state is evaluating the module, Begin waits for it. If the
+// module is in the chain, or waiting for it would never end, it returns the circular chain.
+func (m *ModuleRegistry) Begin(chain []string, path string) (*RyeCtx, []string, bool) {
+	m.state.mu.Lock()
+	defer m.state.mu.Unlock()
+	for {
+		if i := slices.Index(chain, path); i >= 0 {
+			return nil, append(slices.Clone(chain[i:]), path), false
+		}
+		e, ok := m.state.modules[path]
+		if !ok {
+			m.state.modules[path] = &moduleEntry{path: path, done: make(chan struct{})}
+			return nil, nil, true
+		}
+		if e.ctx != nil {
+			return e.ctx, nil, false
+		}
+		// the module that is importing this one waits, unless the other evaluation waits for it
+		var waiter *moduleEntry
+		if len(chain) > 0 {
+			waiter = m.state.modules[chain[len(chain)-1]]
+		}
+		if waiter != nil {
+			cycle := []string{waiter.path}
+			for w := e; w != nil; w = w.waits {
+				cycle = append(cycle, w.path)
+				if w == waiter {
+					return nil, cycle, false
+				}
+			}
+			waiter.waits = e
+		}
+		m.state.mu.Unlock()
+		<-e.done
+		m.state.mu.Lock()
+		if waiter != nil {
+			waiter.waits = nil
+		}
+	}
+}
+
+// Export sets the words a module that is being evaluated exports
+func (m *ModuleRegistry) Export(path string, words []int) bool {
+	m.state.mu.Lock()
+	defer m.state.mu.Unlock()
+	e, ok := m.state.modules[path]
+	if !ok || e.ctx != nil {
+		return false
+	}
+	e.exports = append(e.exports, words...)
+	return true
+}
+
+// Exports returns the words a module exports, nil if it didn't call export
+func (m *ModuleRegistry) Exports(path string) []int {
+	m.state.mu.Lock()
+	defer m.state.mu.Unlock()
+	if e, ok := m.state.modules[path]; ok {
+		return slices.Clone(e.exports)
+	}
+	return nil
+}
+
+// End ends the evaluation of the module started with Begin and caches its context. A nil context
+// (the module failed) is not cached, so the next import tries again.
+func (m *ModuleRegistry) End(path string, ctx *RyeCtx) {
+	m.state.mu.Lock()
+	defer m.state.mu.Unlock()
+	e, ok := m.state.modules[path]
+	if !ok || e.ctx != nil {
+		return
+	}
+	if ctx != nil {
+		e.ctx = ctx
+	} else {
+		delete(m.state.modules, path)
+	}
+	close(e.done)
+}
+
+// Loaded returns the paths of all imported modules
+func (m *ModuleRegistry) Loaded() []string {
+	m.state.mu.Lock()
+	defer m.state.mu.Unlock()
+	paths := make([]string, 0, len(m.state.modules))
+	for p, e := range m.state.modules {
+		if e.ctx != nil {
+			paths = append(paths, p)
+		}
+	}
+	slices.Sort(paths)
+	return paths
+}
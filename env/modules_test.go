@@ -0,0 +1,69 @@
+package env
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestModuleRegistryCycles(t *testing.T) {
+	reg := NewModuleRegistry()
+	if _, _, first := reg.Begin(nil, "a.rye"); !first {
+		t.Fatal("first import of a.rye not evaluated")
+	}
+	if _, _, first := reg.Begin([]string{"a.rye"}, "b.rye"); !first {
+		t.Fatal("import of b.rye not evaluated")
+	}
+	_, chain, _ := reg.Begin([]string{"a.rye", "b.rye"}, "a.rye")
+	if !slices.Equal(chain, []string{"a.rye", "b.rye", "a.rye"}) {
+		t.Fatalf("expected circular import chain, got %v", chain)
+	}
+	reg.End("b.rye", NewEnv(nil))
+	reg.End("a.rye", nil)
+	if _, ok := reg.Get("a.rye"); ok {
+		t.Error("failed module a.rye was cached")
+	}
+	if _, ok := reg.Get("b.rye"); !ok {
+		t.Error("module b.rye was not cached")
+	}
+	if _, _, first := reg.Begin(nil, "a.rye"); !first {
+		t.Error("a.rye is still being imported")
+	}
+}
+
+func TestModuleRegistryWaits(t *testing.T) {
+	reg := NewModuleRegistry()
+	if _, _, first := reg.Begin(nil, "a.rye"); !first {
+		t.Fatal("first import of a.rye not evaluated")
+	}
+	// another import chain waits for the evaluation instead of seeing a circular import
+	got := make(chan *RyeCtx)
+	go func() {
+		ctx, _, _ := reg.Begin([]string{"main.rye"}, "a.rye")
+		got <- ctx
+	}()
+	time.Sleep(10 * time.Millisecond)
+	ctx := NewEnv(nil)
+	reg.End("a.rye", ctx)
+	if res := <-got; res != ctx {
+		t.Errorf("expected the waiting import to get the module, got %v", res)
+	}
+
+	// two chains that wait for each other's module are a circular import, not a deadlock
+	reg.Begin(nil, "b.rye")
+	reg.Begin(nil, "c.rye")
+	cycle := make(chan []string)
+	go func() {
+		_, chain, _ := reg.Begin([]string{"b.rye"}, "c.rye")
+		cycle <- chain
+	}()
+	time.Sleep(10 * time.Millisecond)
+	_, chain, _ := reg.Begin([]string{"c.rye"}, "b.rye")
+	if !slices.Equal(chain, []string{"c.rye", "b.rye", "c.rye"}) {
+		t.Errorf("expected circular import chain, got %v", chain)
+	}
+	reg.End("c.rye", nil)
+	if chain := <-cycle; chain != nil {
+		t.Errorf("expected the waiting import to evaluate c.rye, got %v", chain)
+	}
+}
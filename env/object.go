@@ -229,6 +229,10 @@ const (
 	// (internal)
 	// It represents a persistent table.
 	PersistentTableType Type = 54
+	// LazyTable is a constructed type
+	// Load\csv\lazy %big.csv
+	// It represents a table whose rows are streamed from a source through a pipeline of stages.
+	LazyTableType Type = 55
 )
 
 // after adding new type here, also add string to idxs.go
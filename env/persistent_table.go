@@ -0,0 +1,573 @@
+//go:build !no_persistent
+// +build !no_persistent
+
+package env
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// PersistentTable is a table stored in BadgerDB. Every change is written in its own synced
+// transaction before the in-memory copy of the rows is changed, so the table on disk is always
+// in a consistent state, also if the process crashes. Reads use the in-memory copy.
+//
+// Keys of a table named "users":
+//
+//	pt/users/meta           columns and indexed columns (JSON)
+//	pt/users/row/<id>       row values (JSON), id is a big endian uint64, so rows keep insertion order
+type PersistentTable struct {
+	Cols    []string
+	Kind    Word
+	Rows    []TableRow
+	Indexes map[string]map[any][]int
+	ids     []uint64 // badger id of each row in Rows
+	nextID  uint64
+	indexed []string
+	name    string
+	dbPath  string
+	db      *badger.DB
+	idx     *Idxs
+	mu      sync.RWMutex
+	// a write through AddRow, RemoveRowByIndex or SetCols that failed, they can't return it, Close does
+	writeErr error
+}
+
+type persistentTableMeta struct {
+	Cols    []string `json:"cols"`
+	Indexes []string `json:"indexes"`
+}
+
+// several tables can live in one database, badger allows only one open handle per directory
+var persistentDbs = struct {
+	sync.Mutex
+	dbs  map[string]*badger.DB
+	refs map[string]int
+}{dbs: make(map[string]*badger.DB), refs: make(map[string]int)}
+
+func openPersistentDb(path string) (*badger.DB, error) {
+	persistentDbs.Lock()
+	defer persistentDbs.Unlock()
+	if db, ok := persistentDbs.dbs[path]; ok {
+		persistentDbs.refs[path]++
+		return db, nil
+	}
+	opts := badger.DefaultOptions(path)
+	opts.Logger = nil
+	opts.SyncWrites = true // a write returns only when it's on disk
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, err
+	}
+	persistentDbs.dbs[path] = db
+	persistentDbs.refs[path] = 1
+	return db, nil
+}
+
+func closePersistentDb(path string) error {
+	persistentDbs.Lock()
+	defer persistentDbs.Unlock()
+	persistentDbs.refs[path]--
+	if persistentDbs.refs[path] > 0 {
+		return nil
+	}
+	db := persistentDbs.dbs[path]
+	delete(persistentDbs.dbs, path)
+	delete(persistentDbs.refs, path)
+	if db == nil {
+		return nil
+	}
+	return db.Close()
+}
+
+// NewPersistentTable opens a table in the database at dbPath, creating both if needed. An
+// existing table must have the same columns.
+func NewPersistentTable(idx *Idxs, cols []string, dbPath string, tableName string) (*PersistentTable, error) {
+	if tableName == "" || strings.Contains(tableName, "/") {
+		return nil, fmt.Errorf("invalid table name %q", tableName)
+	}
+	db, err := openPersistentDb(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	pt := &PersistentTable{Cols: cols, name: tableName, dbPath: dbPath, db: db, idx: idx, nextID: 1}
+	if err := pt.load(); err != nil {
+		closePersistentDb(dbPath)
+		return nil, err
+	}
+	return pt, nil
+}
+
+func (pt *PersistentTable) metaKey() []byte {
+	return []byte("pt/" + pt.name + "/meta")
+}
+
+func (pt *PersistentTable) rowPrefix() []byte {
+	return []byte("pt/" + pt.name + "/row/")
+}
+
+func (pt *PersistentTable) rowKey(id uint64) []byte {
+	return binary.BigEndian.AppendUint64(pt.rowPrefix(), id)
+}
+
+// load reads the meta data and rows, or stores the meta data of a new table
+func (pt *PersistentTable) load() error {
+	return pt.db.Update(func(txn *badger.Txn) error {
+		item, err := txn.Get(pt.metaKey())
+		if err == badger.ErrKeyNotFound {
+			return pt.writeMeta(txn)
+		}
+		if err != nil {
+			return err
+		}
+		var meta persistentTableMeta
+		if err := item.Value(func(val []byte) error { return json.Unmarshal(val, &meta) }); err != nil {
+			return err
+		}
+		if !slices.Equal(meta.Cols, pt.Cols) {
+			return fmt.Errorf("table %s exists with columns %s", pt.name, strings.Join(meta.Cols, ", "))
+		}
+		pt.indexed = meta.Indexes
+
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		prefix := pt.rowPrefix()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			id := binary.BigEndian.Uint64(it.Item().Key()[len(prefix):])
+			var vals []any
+			err := it.Item().Value(func(val []byte) error {
+				vals, err = pt.decodeRow(val)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("row %d of table %s: %w", id, pt.name, err)
+			}
+			pt.Rows = append(pt.Rows, TableRow{vals, pt})
+			pt.ids = append(pt.ids, id)
+			pt.nextID = id + 1
+		}
+		pt.rebuildIndexes()
+		return nil
+	})
+}
+
+func (pt *PersistentTable) writeMeta(txn *badger.Txn) error {
+	meta, err := json.Marshal(persistentTableMeta{Cols: pt.Cols, Indexes: pt.indexed})
+	if err != nil {
+		return err
+	}
+	return txn.Set(pt.metaKey(), meta)
+}
+
+// encodeRow stores each value as a type prefix and the value, for example "i:42" or "s:Jim"
+func (pt *PersistentTable) encodeRow(vals []any) ([]byte, error) {
+	enc := make([]string, len(pt.Cols))
+	for i := range pt.Cols {
+		var val any
+		if i < len(vals) {
+			val = vals[i]
+		}
+		switch v := val.(type) {
+		case nil, Void:
+			enc[i] = "_"
+		case Integer:
+			enc[i] = "i:" + strconv.FormatInt(v.Value, 10)
+		case Decimal:
+			enc[i] = "d:" + strconv.FormatFloat(v.Value, 'g', -1, 64)
+		case String:
+			enc[i] = "s:" + v.Value
+		case Boolean:
+			enc[i] = "b:" + strconv.FormatBool(v.Value)
+		case Time:
+			enc[i] = "t:" + v.Value.Format(time.RFC3339Nano)
+		case Date:
+			enc[i] = "D:" + v.Value.Format(time.DateOnly)
+		case Word:
+			enc[i] = "w:" + pt.idx.GetWord(v.Index)
+		case int64:
+			enc[i] = "i:" + strconv.FormatInt(v, 10)
+		case int:
+			enc[i] = "i:" + strconv.Itoa(v)
+		case float64:
+			enc[i] = "d:" + strconv.FormatFloat(v, 'g', -1, 64)
+		case string:
+			enc[i] = "s:" + v
+		case bool:
+			enc[i] = "b:" + strconv.FormatBool(v)
+		default:
+			name := fmt.Sprintf("%T", val)
+			if o, ok := val.(Object); ok {
+				name = o.Inspect(*pt.idx)
+			}
+			return nil, fmt.Errorf("column %s: can't store %s, persistent tables hold integers, decimals, strings, booleans, dates, datetimes, words and void", pt.Cols[i], name)
+		}
+	}
+	return json.Marshal(enc)
+}
+
+func (pt *PersistentTable) decodeRow(data []byte) ([]any, error) {
+	var enc []string
+	if err := json.Unmarshal(data, &enc); err != nil {
+		return nil, err
+	}
+	vals := make([]any, len(pt.Cols))
+	for i := range vals {
+		if i >= len(enc) || enc[i] == "_" {
+			vals[i] = *NewVoid()
+			continue
+		}
+		typ, val, _ := strings.Cut(enc[i], ":")
+		switch typ {
+		case "i":
+			n, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			vals[i] = *NewInteger(n)
+		case "d":
+			f, err := strconv.ParseFloat(val, 64)
+			if err != nil {
+				return nil, err
+			}
+			vals[i] = *NewDecimal(f)
+		case "s":
+			vals[i] = *NewString(val)
+		case "b":
+			vals[i] = *NewBoolean(val == "true")
+		case "t":
+			t, err := time.Parse(time.RFC3339Nano, val)
+			if err != nil {
+				return nil, err
+			}
+			vals[i] = *NewTime(t)
+		case "D":
+			t, err := time.Parse(time.DateOnly, val)
+			if err != nil {
+				return nil, err
+			}
+			vals[i] = *NewDate(t)
+		case "w":
+			vals[i] = *NewWord(pt.idx.IndexWord(val))
+		default:
+			return nil, fmt.Errorf("unknown value type %q", typ)
+		}
+	}
+	return vals, nil
+}
+
+// AppendRows stores rows in one transaction and adds them to the table
+func (pt *PersistentTable) AppendRows(rows [][]any) error {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	if pt.db == nil {
+		return fmt.Errorf("table %s is closed", pt.name)
+	}
+	ids := make([]uint64, len(rows))
+	err := pt.db.Update(func(txn *badger.Txn) error {
+		for i, vals := range rows {
+			data, err := pt.encodeRow(vals)
+			if err != nil {
+				return err
+			}
+			ids[i] = pt.nextID + uint64(i)
+			if err := txn.Set(pt.rowKey(ids[i]), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for i, vals := range rows {
+		row := make([]any, len(pt.Cols))
+		copy(row, vals)
+		pt.Rows = append(pt.Rows, TableRow{row, pt})
+		pt.ids = append(pt.ids, ids[i])
+		pt.indexRow(len(pt.Rows) - 1)
+	}
+	pt.nextID += uint64(len(rows))
+	return nil
+}
+
+// UpdateRow replaces the values of the row at a 0-based index
+func (pt *PersistentTable) UpdateRow(index int, vals []any) error {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	if pt.db == nil {
+		return fmt.Errorf("table %s is closed", pt.name)
+	}
+	if index < 0 || index >= len(pt.Rows) {
+		return fmt.Errorf("row index %d is out of range, table has %d rows", index+1, len(pt.Rows))
+	}
+	data, err := pt.encodeRow(vals)
+	if err != nil {
+		return err
+	}
+	err = pt.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(pt.rowKey(pt.ids[index]), data)
+	})
+	if err != nil {
+		return err
+	}
+	row := make([]any, len(pt.Cols))
+	copy(row, vals)
+	pt.Rows[index] = TableRow{row, pt}
+	pt.rebuildIndexes()
+	return nil
+}
+
+// RemoveRow deletes the row at a 0-based index
+func (pt *PersistentTable) RemoveRow(index int) error {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	if pt.db == nil {
+		return fmt.Errorf("table %s is closed", pt.name)
+	}
+	if index < 0 || index >= len(pt.Rows) {
+		return fmt.Errorf("row index %d is out of range, table has %d rows", index+1, len(pt.Rows))
+	}
+	err := pt.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(pt.rowKey(pt.ids[index]))
+	})
+	if err != nil {
+		return err
+	}
+	pt.Rows = slices.Delete(pt.Rows, index, index+1)
+	pt.ids = slices.Delete(pt.ids, index, index+1)
+	pt.rebuildIndexes()
+	return nil
+}
+
+// SetIndexes stores which columns are indexed and builds the indexes. They are rebuilt
+// when the table is opened again.
+func (pt *PersistentTable) SetIndexes(cols []string) error {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	if pt.db == nil {
+		return fmt.Errorf("table %s is closed", pt.name)
+	}
+	for _, col := range cols {
+		if !slices.Contains(pt.Cols, col) {
+			return fmt.Errorf("column %s not found", col)
+		}
+	}
+	old := pt.indexed
+	pt.indexed = cols
+	if err := pt.db.Update(pt.writeMeta); err != nil {
+		pt.indexed = old
+		return err
+	}
+	pt.rebuildIndexes()
+	return nil
+}
+
+func (pt *PersistentTable) rebuildIndexes() {
+	pt.Indexes = make(map[string]map[any][]int, len(pt.indexed))
+	for _, col := range pt.indexed {
+		pt.Indexes[col] = make(map[any][]int)
+	}
+	for i := range pt.Rows {
+		pt.indexRow(i)
+	}
+}
+
+func (pt *PersistentTable) indexRow(i int) {
+	for col, index := range pt.Indexes {
+		c := slices.Index(pt.Cols, col)
+		val := pt.Rows[i].Values[c]
+		index[val] = append(index[val], i)
+	}
+}
+
+// ToTable returns an in-memory table with the current rows and indexes, used by the functions
+// that query tables
+func (pt *PersistentTable) ToTable() *Table {
+	pt.mu.RLock()
+	defer pt.mu.RUnlock()
+	t := NewTable(slices.Clone(pt.Cols))
+	t.Rows = make([]TableRow, len(pt.Rows))
+	for i, row := range pt.Rows {
+		t.Rows[i] = TableRow{slices.Clone(row.Values), t}
+	}
+	t.Indexes = make(map[string]map[any][]int, len(pt.Indexes))
+	for col, index := range pt.Indexes {
+		t.Indexes[col] = index
+	}
+	return t
+}
+
+// IndexedColumns returns the names of indexed columns
+func (pt *PersistentTable) IndexedColumns() []string {
+	return slices.Clone(pt.indexed)
+}
+
+// Close closes the table, the database is closed when its last table is closed. It also
+// returns the error of a failed write made through AddRow, RemoveRowByIndex or SetCols.
+func (pt *PersistentTable) Close() error {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	writeErr := pt.writeErr
+	pt.writeErr = nil
+	if pt.db != nil {
+		pt.db = nil
+		if err := closePersistentDb(pt.dbPath); err != nil {
+			return err
+		}
+	}
+	if writeErr != nil {
+		return fmt.Errorf("a write to table %s failed: %w", pt.name, writeErr)
+	}
+	return nil
+}
+
+// AddRow adds a row. The TableInterface has no way to report errors, the builtins use
+// AppendRows. A failed write leaves the rows as they were and is returned by Close.
+func (pt *PersistentTable) AddRow(row TableRow) {
+	if err := pt.AppendRows([][]any{row.Values}); err != nil {
+		pt.keepWriteErr(err)
+	}
+}
+
+// RemoveRowByIndex removes a row, the builtins use RemoveRow to get the error
+func (pt *PersistentTable) RemoveRowByIndex(index int64) {
+	if err := pt.RemoveRow(int(index)); err != nil {
+		pt.keepWriteErr(err)
+	}
+}
+
+func (pt *PersistentTable) keepWriteErr(err error) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	if pt.writeErr == nil {
+		pt.writeErr = err
+	}
+}
+
+func (pt *PersistentTable) GetRows() []TableRow {
+	pt.mu.RLock()
+	defer pt.mu.RUnlock()
+	return slices.Clone(pt.Rows)
+}
+
+func (pt *PersistentTable) GetRow(ps *ProgramState, index int) TableRow {
+	pt.mu.RLock()
+	defer pt.mu.RUnlock()
+	return pt.Rows[index]
+}
+
+func (pt *PersistentTable) Length() int {
+	pt.mu.RLock()
+	defer pt.mu.RUnlock()
+	return len(pt.Rows)
+}
+
+func (pt *PersistentTable) NRows() int {
+	return pt.Length()
+}
+
+func (pt *PersistentTable) GetColumn(name string) Object {
+	return pt.ToTable().GetColumn(name)
+}
+
+func (pt *PersistentTable) GetColumns() List {
+	lst := make([]any, len(pt.Cols))
+	for i, v := range pt.Cols {
+		lst[i] = v
+	}
+	return *NewList(lst)
+}
+
+func (pt *PersistentTable) GetColumnIndex(column string) int {
+	return slices.Index(pt.Cols, column)
+}
+
+func (pt *PersistentTable) GetColumnNames() []string {
+	return pt.Cols
+}
+
+// SetCols refuses to rename the columns, the stored rows and the meta keep the names the table
+// was created with and opening it with them would fail. The refused rename is returned by Close.
+func (pt *PersistentTable) SetCols(vals []string) {
+	if !slices.Equal(vals, pt.Cols) {
+		pt.keepWriteErr(fmt.Errorf("columns of a persistent table can't be renamed to %v", vals))
+	}
+}
+
+func (pt *PersistentTable) GetRowValue(column string, rrow TableRow) (any, error) {
+	index := pt.GetColumnIndex(column)
+	if index < 0 {
+		return "", fmt.Errorf("column %s not found", column)
+	}
+	return rrow.Values[index], nil
+}
+
+func (pt *PersistentTable) Columns(ps *ProgramState, names []string) Object {
+	return pt.ToTable().Columns(ps, names)
+}
+
+func (pt *PersistentTable) Type() Type {
+	return PersistentTableType
+}
+
+func (pt *PersistentTable) GetKind() int {
+	return int(PersistentTableType)
+}
+
+func (pt *PersistentTable) Equal(o Object) bool {
+	other, ok := o.(*PersistentTable)
+	return ok && other.dbPath == pt.dbPath && other.name == pt.name
+}
+
+func (pt *PersistentTable) Inspect(e Idxs) string {
+	state := ""
+	if pt.db == nil {
+		state = " closed"
+	}
+	return "[PersistentTable(" + strconv.Itoa(len(pt.Rows)) + " " + strconv.Itoa(len(pt.Cols)) + ") " + pt.name + state + "]"
+}
+
+func (pt *PersistentTable) Print(e Idxs) string {
+	return pt.ToTxt()
+}
+
+func (pt *PersistentTable) ToHtml() string {
+	return pt.ToTable().ToHtml()
+}
+
+func (pt *PersistentTable) ToTxt() string {
+	return pt.ToTable().ToTxt()
+}
+
+func (pt *PersistentTable) Trace(msg string) {
+	fmt.Print(msg + " (persistent table): ")
+}
+
+func (pt *PersistentTable) Dump(e Idxs) string {
+	return pt.ToTable().Dump(e)
+}
+
+func (pt *PersistentTable) Get(i int) Object {
+	pt.mu.RLock()
+	defer pt.mu.RUnlock()
+	return pt.Rows[i]
+}
+
+// MakeNew returns an in-memory table, persistent tables are only created by persistent-table
+func (pt *PersistentTable) MakeNew(data []Object) Object {
+	t := NewTable(slices.Clone(pt.Cols))
+	for _, obj := range data {
+		if row, ok := obj.(TableRow); ok {
+			t.AddRow(TableRow{row.Values, t})
+		}
+	}
+	return *t
+}
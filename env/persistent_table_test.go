@@ -0,0 +1,104 @@
+//go:build !no_persistent
+// +build !no_persistent
+
+package env
+
+import (
+	"testing"
+)
+
+func TestPersistentTableReopen(t *testing.T) {
+	idx := NewIdxs()
+	dir := t.TempDir()
+	cols := []string{"name", "age"}
+
+	pt, err := NewPersistentTable(idx, cols, dir, "people")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := [][]any{{*NewString("Jim"), *NewInteger(30)}, {*NewString("Anne"), *NewInteger(25)}, {*NewString("Bob"), *NewVoid()}}
+	if err := pt.AppendRows(rows); err != nil {
+		t.Fatal(err)
+	}
+	if err := pt.SetIndexes([]string{"name"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pt.UpdateRow(1, []any{*NewString("Anne"), *NewInteger(26)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pt.RemoveRow(0); err != nil {
+		t.Fatal(err)
+	}
+	if err := pt.AppendRows([][]any{{*NewString("Eve"), *NewBlock(*NewTSeries(nil))}}); err == nil {
+		t.Error("expected an error storing a block")
+	}
+	if err := pt.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	pt, err = NewPersistentTable(idx, cols, dir, "people")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+	if pt.Length() != 2 {
+		t.Fatalf("expected 2 rows, got %d", pt.Length())
+	}
+	if age := pt.Rows[0].Values[1]; !NewInteger(26).Equal(age.(Object)) {
+		t.Errorf("expected age 26, got %v", age)
+	}
+	if _, ok := pt.Rows[1].Values[1].(Void); !ok {
+		t.Errorf("expected void, got %v", pt.Rows[1].Values[1])
+	}
+	if rows := pt.ToTable().Indexes["name"][*NewString("Bob")]; len(rows) != 1 || rows[0] != 1 {
+		t.Errorf("expected Bob indexed at row 1, got %v", rows)
+	}
+	if _, err := NewPersistentTable(idx, []string{"x"}, dir, "people"); err == nil {
+		t.Error("expected an error opening a table with other columns")
+	}
+}
+
+func TestPersistentTableFailedWrite(t *testing.T) {
+	pt, err := NewPersistentTable(NewIdxs(), []string{"name"}, t.TempDir(), "people")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a block can't be stored, the row isn't added and Close reports the write
+	pt.AddRow(TableRow{[]any{*NewBlock(*NewTSeries(nil))}, pt})
+	if pt.Length() != 0 {
+		t.Errorf("expected no rows, got %d", pt.Length())
+	}
+	if err := pt.Close(); err == nil {
+		t.Error("expected Close to return the failed write")
+	}
+	if err := pt.Close(); err != nil {
+		t.Errorf("the error should be returned once, got %v", err)
+	}
+	if err := pt.AppendRows([][]any{{*NewString("Jim")}}); err == nil {
+		t.Error("expected an error writing to a closed table")
+	}
+}
+
+func TestPersistentTableRename(t *testing.T) {
+	idx := NewIdxs()
+	dir := t.TempDir()
+	pt, err := NewPersistentTable(idx, []string{"name"}, dir, "people")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the rename is refused, so saving the meta keeps the stored names
+	pt.SetCols([]string{"nick"})
+	if err := pt.SetIndexes([]string{"name"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pt.Close(); err == nil {
+		t.Error("expected Close to return the refused rename")
+	}
+	pt, err = NewPersistentTable(idx, []string{"name"}, dir, "people")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pt.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -561,6 +561,34 @@ func getFrom(ps *env.ProgramState, data any, key any, posMode bool) env.Object {
 		default:
 			return makeError(ps, fmt.Sprintf("Table requires integer index, but got %s", getKeyDesc(key)))
 		}
+	case *env.LazyTable:
+		switch s2 := key.(type) {
+		case env.Integer:
+			idx := s2.Value
+			if posMode {
+				idx--
+			}
+			if idx < 0 {
+				return makeError(ps, fmt.Sprintf("Index %d is too low (minimum is %d)", s2.Value, func() int64 {
+					if posMode {
+						return 1
+					} else {
+						return 0
+					}
+				}()))
+			}
+			// rows are read until the one at the index
+			row, n, errObj := LazyRow(ps, s1, int(idx), "nth")
+			if errObj != nil {
+				return errObj
+			}
+			if row == nil {
+				return makeError(ps, fmt.Sprintf("Index %d is out of bounds (table length is %d)", s2.Value, n))
+			}
+			return row
+		default:
+			return makeError(ps, fmt.Sprintf("Table requires integer index, but got %s", getKeyDesc(key)))
+		}
 	case env.Bytes:
 		switch s2 := key.(type) {
 		case env.Integer:
@@ -852,6 +880,7 @@ func LoadScriptLocalFile(ps *env.ProgramState, s1 env.Uri) (env.Object, string)
 	script_ := ps.ScriptPath
 	ps.ScriptPath = fullpath
 	block_ := loader.LoadString(str, false, ps)
+	SourceLoaded(fullpath, str, block_)
 	return block_, script_
 }
 
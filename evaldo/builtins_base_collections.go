@@ -1050,6 +1050,16 @@ var builtins_collection = map[string]*env.Builtin{
 					return MakeBuiltinError(ps, "Table is empty.", "first")
 				}
 				return s1.GetRow(ps, int(0))
+			case *env.LazyTable:
+				// only the first chunk is read
+				row, _, errObj := LazyRow(ps, s1, 0, "first")
+				if errObj != nil {
+					return errObj
+				}
+				if row == nil {
+					return MakeBuiltinError(ps, "Table is empty.", "first")
+				}
+				return row
 			case env.Vector:
 				if len(s1.Value) == 0 {
 					return MakeBuiltinError(ps, "Vector is empty.", "first")
@@ -1070,7 +1080,7 @@ var builtins_collection = map[string]*env.Builtin{
 				}
 				return *env.NewInteger(int64(s1.Value[0]))
 			default:
-				return MakeArgError(ps, 1, []env.Type{env.TableType, env.BlockType, env.StringType, env.ListType, env.VectorType, env.MatrixType, env.BytesType}, "first")
+				return MakeArgError(ps, 1, []env.Type{env.TableType, env.LazyTableType, env.BlockType, env.StringType, env.ListType, env.VectorType, env.MatrixType, env.BytesType}, "first")
 			}
 		},
 	},
@@ -1683,6 +1693,8 @@ var builtins_collection = map[string]*env.Builtin{
 					nspr := env.NewTable(s1.Cols)
 					nspr.Rows = s1.Rows[0:numVal]
 					return *nspr
+				case *env.LazyTable:
+					return LazyHead(ps, s1, numVal)
 				case env.Vector:
 					if len(s1.Value) == 0 {
 						return *env.NewVector([]float64{})
@@ -1716,7 +1728,7 @@ var builtins_collection = map[string]*env.Builtin{
 					copy(newData, s1.Data[0:numVal*s1.Cols])
 					return *env.NewMatrixWithData(numVal, s1.Cols, newData)
 				default:
-					return MakeArgError(ps, 1, []env.Type{env.BlockType, env.ListType, env.StringType, env.TableType, env.VectorType, env.MatrixType}, "head")
+					return MakeArgError(ps, 1, []env.Type{env.BlockType, env.ListType, env.StringType, env.TableType, env.LazyTableType, env.VectorType, env.MatrixType}, "head")
 				}
 			default:
 				return MakeArgError(ps, 2, []env.Type{env.IntegerType}, "head")
@@ -2619,15 +2631,17 @@ var builtins_collection = map[string]*env.Builtin{
 				return *env.NewBoolean(len(s1.Rows) == 0)
 			case *env.Table:
 				return *env.NewBoolean(len(s1.Rows) == 0)
-			// case *env.PersistentTable: // TODO: PersistentTable removed temporarily
-			// 	return *env.NewBoolean(s1.Length() == 0)
+			case *env.PersistentTable:
+				return *env.NewBoolean(s1.Length() == 0)
+			case *env.LazyTable:
+				return LazyIsEmpty(ps, s1)
 			case *env.RyeCtx:
 				return *env.NewBoolean(s1.GetWords(*ps.Idx).Series.Len() == 0)
 			case env.Vector:
 				return *env.NewBoolean(s1.Value.Len() == 0)
 			default:
 				// fmt.Println(s1)
-				return MakeArgError(ps, 2, []env.Type{env.StringType, env.DictType, env.ListType, env.BlockType, env.TableType, /*env.PersistentTableType,*/ env.VectorType}, "is-empty")
+				return MakeArgError(ps, 2, []env.Type{env.StringType, env.DictType, env.ListType, env.BlockType, env.TableType, env.PersistentTableType, env.LazyTableType, env.VectorType}, "is-empty")
 			}
 		},
 	},
@@ -2660,8 +2674,10 @@ var builtins_collection = map[string]*env.Builtin{
 				return *env.NewInteger(int64(len(s1.Rows)))
 			case *env.Table:
 				return *env.NewInteger(int64(len(s1.Rows)))
-			// case *env.PersistentTable: // TODO: PersistentTable removed temporarily
-			// 	return *env.NewInteger(int64(s1.Length()))
+			case *env.PersistentTable:
+				return *env.NewInteger(int64(s1.Length()))
+			case *env.LazyTable:
+				return LazyLength(ps, s1)
 			case *env.RyeCtx:
 				return *env.NewInteger(int64(s1.GetWords(*ps.Idx).Series.Len()))
 			case env.Vector:
@@ -2670,7 +2686,7 @@ var builtins_collection = map[string]*env.Builtin{
 				return *env.NewInteger(int64(len(s1.Value)))
 			default:
 				// fmt.Println(s1)
-				return MakeArgError(ps, 2, []env.Type{env.StringType, env.DictType, env.ListType, env.BlockType, env.TableType, /*env.PersistentTableType,*/ env.VectorType, env.BytesType}, "length?")
+				return MakeArgError(ps, 2, []env.Type{env.StringType, env.DictType, env.ListType, env.BlockType, env.TableType, env.PersistentTableType, env.LazyTableType, env.VectorType, env.BytesType}, "length?")
 			}
 		},
 	},
@@ -2700,15 +2716,15 @@ var builtins_collection = map[string]*env.Builtin{
 				return *env.NewInteger(int64(len(s1.Rows) - 1))
 			case *env.Table:
 				return *env.NewInteger(int64(len(s1.Rows) - 1))
-			// case *env.PersistentTable: // TODO: PersistentTable removed temporarily
-			// 	return *env.NewInteger(int64(s1.Length() - 1))
+			case *env.PersistentTable:
+				return *env.NewInteger(int64(s1.Length() - 1))
 			case *env.RyeCtx:
 				return *env.NewInteger(int64(s1.GetWords(*ps.Idx).Series.Len() - 1))
 			case env.Vector:
 				return *env.NewInteger(int64(s1.Value.Len() - 1))
 			default:
 				// fmt.Println(s1)
-				return MakeArgError(ps, 2, []env.Type{env.StringType, env.DictType, env.ListType, env.BlockType, env.TableType, /*env.PersistentTableType,*/ env.VectorType}, "length?")
+				return MakeArgError(ps, 2, []env.Type{env.StringType, env.DictType, env.ListType, env.BlockType, env.TableType, env.PersistentTableType, env.VectorType}, "length?")
 			}
 		},
 	},
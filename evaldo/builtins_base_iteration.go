@@ -1,11 +1,16 @@
 package evaldo
 
 import (
+	"context"
+	"fmt"
 	"strings"
+	"sync"
 
 	"github.com/refaktor/rye/env"
 	"github.com/refaktor/rye/util"
 	// JM 20230825	"github.com/refaktor/rye/term"
+
+	"github.com/jinzhu/copier"
 )
 
 // resolveBuiltinsInBlock scans a block and replaces Words that refer to builtins
@@ -68,6 +73,131 @@ func resolveBuiltinsInBlock(ps *env.ProgramState, block *env.Block) {
 	}
 }
 
+// parallelEach evaluates the code for each item of the collection on at most workers goroutines
+// and returns the results in the order of the items. Each worker evaluates in its own copy of the
+// program state and each item in a new subcontext, so words set by the code don't leak. The first
+// item that fails cancels the others and is returned as a failure with its index.
+func parallelEach(ps *env.ProgramState, list env.Collection, workers env.Object, code env.Object, fnName string) ([]env.Object, env.Object) {
+	n, ok := workers.(env.Integer)
+	if !ok {
+		return nil, MakeArgError(ps, 2, []env.Type{env.IntegerType}, fnName)
+	}
+	if n.Value < 1 {
+		return nil, MakeBuiltinError(ps, "number of workers must be at least 1", fnName)
+	}
+	switch block := code.(type) {
+	case env.Block:
+		resolveBuiltinsInBlock(ps, &block)
+		code = block
+	case env.Builtin, env.Function:
+	default:
+		return nil, MakeArgError(ps, 3, []env.Type{env.BlockType, env.BuiltinType, env.FunctionType}, fnName)
+	}
+
+	l := list.Length()
+	results := make([]env.Object, l)
+	ctx, cancel := context.WithCancel(ps.GoContext())
+	defer cancel()
+	var (
+		mu        sync.Mutex
+		failed    = -1
+		failure   env.Object
+		wg        sync.WaitGroup
+		next      = make(chan int)
+		copyError error
+	)
+	for w := 0; w < int(n.Value) && w < l; w++ {
+		psW := env.ProgramState{}
+		if err := copier.Copy(&psW, &ps); err != nil {
+			copyError = err
+			break
+		}
+		psW.TaskCtx = ctx
+		psW.FailureFlag = false
+		psW.ErrorFlag = false
+		psW.ReturnFlag = false
+		psW.InErrHandler = true // the failure is displayed by the caller
+		wg.Add(1)
+		go func(psW *env.ProgramState) {
+			defer wg.Done()
+			for i := range next {
+				item := list.Get(i)
+				psW.Ctx = env.NewEnv(ps.Ctx)
+				func() {
+					defer func() {
+						if r := recover(); r != nil {
+							psW.ErrorFlag = true
+							psW.Res = env.NewError(fmt.Sprintf("panic: %v", r))
+						}
+					}()
+					switch code := code.(type) {
+					case env.Block:
+						psW.Ser = code.Series
+						psW.Ser.Reset()
+						EvalBlockInj(psW, item, true)
+					case env.Builtin:
+						psW.Res = DirectlyCallBuiltin(psW, code, item, nil)
+					case env.Function:
+						CallFunctionArgsN(code, psW, psW.Ctx, item)
+					}
+				}()
+				if psW.ErrorFlag || psW.FailureFlag {
+					mu.Lock()
+					// items that fail because an earlier failure cancelled them don't count
+					if failed == -1 && ctx.Err() == nil {
+						failed, failure = i, psW.Res
+					}
+					mu.Unlock()
+					cancel()
+					psW.ErrorFlag = false
+					psW.FailureFlag = false
+				}
+				psW.ReturnFlag = false
+				results[i] = psW.Res
+			}
+		}(&psW)
+	}
+	if copyError == nil {
+	dispatch:
+		for i := 0; i < l; i++ {
+			select {
+			case next <- i:
+			case <-ctx.Done():
+				break dispatch
+			}
+		}
+	}
+	close(next)
+	wg.Wait()
+
+	if copyError != nil {
+		return nil, MakeBuiltinError(ps, fmt.Sprintf("failed to copy program state: %s", copyError), fnName)
+	}
+	if failure != nil {
+		ps.FailureFlag = true
+		var parent *env.Error
+		switch err := failure.(type) {
+		case *env.Error:
+			parent = err
+		case env.Error:
+			parent = &err
+		}
+		msg := fmt.Sprintf("`%s`: item at index %d failed", fnName, failed)
+		if parent != nil {
+			msg += ": " + parent.Message
+		}
+		err := env.NewError4(0, msg, parent, map[string]env.Object{"index": *env.NewInteger(int64(failed))})
+		err.CodeBlock = ps.Ser
+		return nil, err
+	}
+	if ctx.Err() != nil {
+		// the caller's task was cancelled
+		ps.ErrorFlag = true
+		return nil, env.NewError("Task cancelled: " + context.Cause(ctx).Error())
+	}
+	return results, nil
+}
+
 var builtins_iteration = map[string]*env.Builtin{
 
 	//
@@ -630,6 +760,36 @@ var builtins_iteration = map[string]*env.Builtin{
 		},
 	},
 
+	// Tests:
+	// equal { for\par { 1 2 3 } 2 { + 1 } } 4
+	// equal { for\par { 1 2 3 } 3 { :x x * x } } 9
+	// equal { try { for\par { 1 0 2 } 2 fn { x } { 10 / x } } |message? } "`for\\par`: item at index 1 failed: `_/`: Can't divide by Zero."
+	// Args:
+	// * collection: Collection (Block, List, String, or Table) to iterate over
+	// * workers: Integer, the most values the code is done for at the same time
+	// * code: Block, Builtin, or Function to execute for each value, injecting the value
+	// Returns:
+	// * result of the code for the last value, or the failure of the first value that failed
+	"for\\par": {
+		Argsn: 3,
+		Doc:   "Like for, but does the code for the values in parallel on a bounded number of workers, each with its own copy of the program state.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			switch list := arg0.(type) {
+			case env.Collection:
+				results, err := parallelEach(ps, list, arg1, arg2, "for\\par")
+				if err != nil {
+					return err
+				}
+				if len(results) == 0 {
+					return ps.Res
+				}
+				return results[len(results)-1]
+			default:
+				return MakeArgError(ps, 1, []env.Type{env.StringType, env.BlockType, env.TableType}, "for\\par")
+			}
+		},
+	},
+
 	// Tests:
 	// stdout { { "a" "b" "c" } .for\pos 'i { i .prns , .prns } } "1 a 2 b 3 c "
 	// Args:
@@ -1150,6 +1310,35 @@ var builtins_iteration = map[string]*env.Builtin{
 		},
 	},
 
+	// Tests:
+	//  equal { map\par { 1 2 3 4 5 } 2 { + 1 } } { 2 3 4 5 6 }
+	//  equal { map\par { } 4 { + 1 } } { }
+	//  equal { map\par list { "aaa" "bb" "c" } 8 ?length? } list { 3 2 1 }
+	//  equal { map\par { 3 1 2 } 3 fn { x } { sleep x * 10 , x } } { 3 1 2 }
+	//  equal { try { map\par { 1 2 "a" 4 } 2 { + 1 } } |message? |contains "item at index 2 failed" } true
+	// Args:
+	// * collection: Collection (Block, List, or String) to map over
+	// * workers: Integer, the most values that are mapped at the same time
+	// * code: Block, Builtin, or Function to evaluate for each value, injecting the value
+	// Returns:
+	// * a new collection with the results in the order of the values, or the failure of the first value that failed
+	"map\\par": {
+		Argsn: 3,
+		Doc:   "Like map, but maps the values in parallel on a bounded number of workers, each with its own copy of the program state.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			switch list := arg0.(type) {
+			case env.Collection:
+				results, err := parallelEach(ps, list, arg1, arg2, "map\\par")
+				if err != nil {
+					return err
+				}
+				return list.MakeNew(results)
+			default:
+				return MakeArgError(ps, 1, []env.Type{env.BlockType, env.ListType, env.StringType}, "map\\par")
+			}
+		},
+	},
+
 	// Tests:
 	//  equal { map\pos { 1 2 3 } 'i { + i } } { 2 4 6 }
 	//  equal { map\pos { } 'i { + i } } { }
@@ -1911,6 +2100,39 @@ var builtins_iteration = map[string]*env.Builtin{
 			}
 		},
 	},
+	// Tests:
+	//  equal { filter\par { 1 2 3 4 } 2 { > 2 } } { 3 4 }
+	//  equal { filter\par list { 1 2 3 4 } 3 { .is-multiple-of 2 } } list { 2 4 }
+	//  equal { filter\par "1234" 2 { .integer > 2 } } { "3" "4" }
+	// Args:
+	// * collection: Collection (Block, List, or String) to filter
+	// * workers: Integer, the most values that are checked at the same time
+	// * code: Block, Builtin, or Function that returns a truthy value for the values to keep
+	// Returns:
+	// * a new collection with the kept values in their order, or the failure of the first value that failed
+	"filter\\par": {
+		Argsn: 3,
+		Doc:   "Like filter, but checks the values in parallel on a bounded number of workers, each with its own copy of the program state.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			switch list := arg0.(type) {
+			case env.Collection:
+				results, err := parallelEach(ps, list, arg1, arg2, "filter\\par")
+				if err != nil {
+					return err
+				}
+				kept := make([]env.Object, 0)
+				for i, res := range results {
+					if util.IsTruthy(res) {
+						kept = append(kept, list.Get(i))
+					}
+				}
+				return list.MakeNew(kept)
+			default:
+				return MakeArgError(ps, 1, []env.Type{env.BlockType, env.ListType, env.StringType}, "filter\\par")
+			}
+		},
+	},
+
 	// Tests:
 	//  equal { seek { 1 2 3 4 } { .is-even } } 2
 	//  equal { seek list { 1 2 3 4 } { .is-even } } 2
@@ -31,9 +31,9 @@ var builtins_printing = map[string]*env.Builtin{
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
 			switch arg := arg0.(type) {
 			case env.String:
-				fmt.Print(arg.Value + " ")
+				fmt.Fprint(ps.Stdout(), arg.Value+" ")
 			default:
-				fmt.Print(arg0.Print(*ps.Idx) + " ")
+				fmt.Fprint(ps.Stdout(), arg0.Print(*ps.Idx)+" ")
 			}
 			return arg0
 		},
@@ -45,9 +45,9 @@ var builtins_printing = map[string]*env.Builtin{
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
 			switch arg := arg0.(type) {
 			case env.String:
-				fmt.Print(arg.Value)
+				fmt.Fprint(ps.Stdout(), arg.Value)
 			default:
-				fmt.Print(arg0.Print(*ps.Idx))
+				fmt.Fprint(ps.Stdout(), arg0.Print(*ps.Idx))
 			}
 			return arg0
 		},
@@ -59,9 +59,9 @@ var builtins_printing = map[string]*env.Builtin{
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
 			switch arg := arg0.(type) {
 			case env.String:
-				fmt.Println(arg.Value)
+				fmt.Fprintln(ps.Stdout(), arg.Value)
 			default:
-				fmt.Println(arg0.Print(*ps.Idx))
+				fmt.Fprintln(ps.Stdout(), arg0.Print(*ps.Idx))
 			}
 			return arg0
 		},
@@ -73,16 +73,16 @@ var builtins_printing = map[string]*env.Builtin{
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
 			switch a := arg0.(type) {
 			case env.String:
-				fmt.Print(a.Value)
+				fmt.Fprint(ps.Stdout(), a.Value)
 			default:
-				fmt.Print(arg0.Print(*ps.Idx))
+				fmt.Fprint(ps.Stdout(), arg0.Print(*ps.Idx))
 			}
-			fmt.Print(" ")
+			fmt.Fprint(ps.Stdout(), " ")
 			switch b := arg1.(type) {
 			case env.String:
-				fmt.Println(b.Value)
+				fmt.Fprintln(ps.Stdout(), b.Value)
 			default:
-				fmt.Println(arg1.Print(*ps.Idx))
+				fmt.Fprintln(ps.Stdout(), arg1.Print(*ps.Idx))
 			}
 			return arg1
 		},
@@ -94,16 +94,16 @@ var builtins_printing = map[string]*env.Builtin{
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
 			switch a := arg0.(type) {
 			case env.String:
-				fmt.Print(a.Value)
+				fmt.Fprint(ps.Stdout(), a.Value)
 			default:
-				fmt.Print(arg0.Print(*ps.Idx))
+				fmt.Fprint(ps.Stdout(), arg0.Print(*ps.Idx))
 			}
-			fmt.Print(" ")
+			fmt.Fprint(ps.Stdout(), " ")
 			switch b := arg1.(type) {
 			case env.String:
-				fmt.Print(b.Value)
+				fmt.Fprint(ps.Stdout(), b.Value)
 			default:
-				fmt.Print(arg1.Print(*ps.Idx))
+				fmt.Fprint(ps.Stdout(), arg1.Print(*ps.Idx))
 			}
 			return arg1
 		},
@@ -115,15 +115,15 @@ var builtins_printing = map[string]*env.Builtin{
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
 			switch a := arg0.(type) {
 			case env.String:
-				fmt.Print(a.Value + " ")
+				fmt.Fprint(ps.Stdout(), a.Value+" ")
 			default:
-				fmt.Print(arg0.Print(*ps.Idx) + " ")
+				fmt.Fprint(ps.Stdout(), arg0.Print(*ps.Idx)+" ")
 			}
 			switch b := arg1.(type) {
 			case env.String:
-				fmt.Print(b.Value + " ")
+				fmt.Fprint(ps.Stdout(), b.Value+" ")
 			default:
-				fmt.Print(arg1.Print(*ps.Idx) + " ")
+				fmt.Fprint(ps.Stdout(), arg1.Print(*ps.Idx)+" ")
 			}
 			return arg1
 		},
@@ -159,7 +159,7 @@ var builtins_printing = map[string]*env.Builtin{
 			case env.String:
 				vals := arg0.Print(*ps.Idx)
 				news := strings.ReplaceAll(tmpl.Value, "{}", vals)
-				fmt.Print(news)
+				fmt.Fprint(ps.Stdout(), news)
 				return arg0
 			default:
 				return MakeArgError(ps, 2, []env.Type{env.StringType}, "prnf")
@@ -175,7 +175,7 @@ var builtins_printing = map[string]*env.Builtin{
 			case env.String:
 				vals := arg0.Print(*ps.Idx)
 				news := strings.ReplaceAll(tmpl.Value, "{}", vals)
-				fmt.Println(news)
+				fmt.Fprintln(ps.Stdout(), news)
 				return arg0
 			default:
 				return MakeArgError(ps, 2, []env.Type{env.StringType}, "printf")
@@ -210,7 +210,7 @@ var builtins_printing = map[string]*env.Builtin{
 			case env.String:
 				vals := arg0.Print(*ps.Idx)
 				news := strings.ReplaceAll(arg.Value, "{}", vals)
-				fmt.Print(news)
+				fmt.Fprint(ps.Stdout(), news)
 			default:
 				return MakeArgError(ps, 1, []env.Type{env.StringType}, "prnv")
 			}
@@ -226,7 +226,7 @@ var builtins_printing = map[string]*env.Builtin{
 			case env.String:
 				vals := arg0.Print(*ps.Idx)
 				news := strings.ReplaceAll(arg.Value, "{}", vals)
-				fmt.Println(news)
+				fmt.Fprintln(ps.Stdout(), news)
 			default:
 				return MakeArgError(ps, 1, []env.Type{env.StringType}, "printv")
 			}
@@ -242,7 +242,7 @@ var builtins_printing = map[string]*env.Builtin{
 			if env.IsPointer(arg0) {
 				p = "REF"
 			}
-			fmt.Println(p + arg0.Inspect(*ps.Idx))
+			fmt.Fprintln(ps.Stdout(), p+arg0.Inspect(*ps.Idx))
 			return arg0
 		},
 	},
@@ -257,7 +257,7 @@ var builtins_printing = map[string]*env.Builtin{
 				if env.IsPointer(arg0) {
 					p = "REF"
 				}
-				fmt.Println(prefix.Value + " " + p + arg0.Inspect(*ps.Idx))
+				fmt.Fprintln(ps.Stdout(), prefix.Value+" "+p+arg0.Inspect(*ps.Idx))
 				return arg0
 			default:
 				return MakeArgError(ps, 2, []env.Type{env.StringType}, "probe\\")
@@ -1,11 +1,26 @@
 package evaldo
 
 import (
+	"context"
 	"time"
 
 	"github.com/refaktor/rye/env"
 )
 
+// SleepTask waits for the duration or until the task the program state runs in is cancelled, it
+// returns the cause of the cancellation
+func SleepTask(ps *env.ProgramState, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	ctx := ps.GoContext()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
+
 var builtins_time = map[string]*env.Builtin{
 
 	//
@@ -385,7 +400,9 @@ var builtins_time = map[string]*env.Builtin{
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
 			switch arg := arg0.(type) {
 			case env.Integer:
-				time.Sleep(time.Duration(int(arg.Value)) * time.Millisecond)
+				if err := SleepTask(ps, time.Duration(int(arg.Value))*time.Millisecond); err != nil {
+					return MakeBuiltinError(ps, "Sleep cancelled: "+err.Error(), "sleep")
+				}
 				return arg
 			default:
 				return MakeArgError(ps, 1, []env.Type{env.IntegerType}, "sleep")
@@ -8,6 +8,7 @@ package evaldo
 import (
 	"encoding/csv"
 	"fmt"
+	"math"
 	"os"
 	"regexp"
 	"slices"
@@ -311,22 +312,34 @@ var Builtins_table = map[string]*env.Builtin{
 		Doc:   "Returns table of rows where specific colum is equal to given value.",
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
 			var spr *env.Table
+			var lazy *env.LazyTable
 			switch s := arg0.(type) {
 			case *env.Table:
 				spr = s
+			case *env.PersistentTable:
+				spr = s.ToTable()
 			case env.Table:
 				spr = &s
+			case *env.LazyTable:
+				lazy = s
 			default:
-				return MakeArgError(ps, 1, []env.Type{env.TableType}, "where-equal")
+				return MakeArgError(ps, 1, []env.Type{env.TableType, env.PersistentTableType, env.LazyTableType}, "where-equal")
 			}
+			var colName string
 			switch col := arg1.(type) {
 			case env.Word:
-				return WhereEquals(ps, spr, ps.Idx.GetWord(col.Index), arg2)
+				colName = ps.Idx.GetWord(col.Index)
 			case env.String:
-				return WhereEquals(ps, spr, col.Value, arg2)
+				colName = col.Value
 			default:
 				return MakeArgError(ps, 2, []env.Type{env.WordType, env.StringType}, "where-equal")
 			}
+			if lazy != nil {
+				return LazyRows(ps, lazy, "where-equal", func(ps *env.ProgramState, chunk *env.Table) env.Object {
+					return WhereEquals(ps, chunk, colName, arg2)
+				})
+			}
+			return WhereEquals(ps, spr, colName, arg2)
 		},
 	},
 	// Tests:
@@ -341,22 +354,34 @@ var Builtins_table = map[string]*env.Builtin{
 		Doc:   "Returns table of rows where specific colum is not equal to given value.",
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
 			var spr *env.Table
+			var lazy *env.LazyTable
 			switch s := arg0.(type) {
 			case *env.Table:
 				spr = s
+			case *env.PersistentTable:
+				spr = s.ToTable()
 			case env.Table:
 				spr = &s
+			case *env.LazyTable:
+				lazy = s
 			default:
-				return MakeArgError(ps, 1, []env.Type{env.TableType}, "where-not-equal")
+				return MakeArgError(ps, 1, []env.Type{env.TableType, env.PersistentTableType, env.LazyTableType}, "where-not-equal")
 			}
+			var colName string
 			switch col := arg1.(type) {
 			case env.Word:
-				return WhereNotEquals(ps, spr, ps.Idx.GetWord(col.Index), arg2)
+				colName = ps.Idx.GetWord(col.Index)
 			case env.String:
-				return WhereNotEquals(ps, spr, col.Value, arg2)
+				colName = col.Value
 			default:
 				return MakeArgError(ps, 2, []env.Type{env.WordType, env.StringType}, "where-not-equal")
 			}
+			if lazy != nil {
+				return LazyRows(ps, lazy, "where-not-equal", func(ps *env.ProgramState, chunk *env.Table) env.Object {
+					return WhereNotEquals(ps, chunk, colName, arg2)
+				})
+			}
+			return WhereNotEquals(ps, spr, colName, arg2)
 		},
 	},
 
@@ -375,22 +400,34 @@ var Builtins_table = map[string]*env.Builtin{
 		Doc:   "Returns table of rows where specific colum is void.",
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
 			var spr *env.Table
+			var lazy *env.LazyTable
 			switch s := arg0.(type) {
 			case *env.Table:
 				spr = s
+			case *env.PersistentTable:
+				spr = s.ToTable()
 			case env.Table:
 				spr = &s
+			case *env.LazyTable:
+				lazy = s
 			default:
-				return MakeArgError(ps, 1, []env.Type{env.TableType}, "where-void")
+				return MakeArgError(ps, 1, []env.Type{env.TableType, env.PersistentTableType, env.LazyTableType}, "where-void")
 			}
+			var colName string
 			switch col := arg1.(type) {
 			case env.Word:
-				return WhereEquals(ps, spr, ps.Idx.GetWord(col.Index), env.NewVoid())
+				colName = ps.Idx.GetWord(col.Index)
 			case env.String:
-				return WhereEquals(ps, spr, col.Value, env.NewVoid())
+				colName = col.Value
 			default:
 				return MakeArgError(ps, 2, []env.Type{env.WordType, env.StringType}, "where-void")
 			}
+			if lazy != nil {
+				return LazyRows(ps, lazy, "where-void", func(ps *env.ProgramState, chunk *env.Table) env.Object {
+					return WhereEquals(ps, chunk, colName, env.NewVoid())
+				})
+			}
+			return WhereEquals(ps, spr, colName, env.NewVoid())
 		},
 	},
 
@@ -409,31 +446,42 @@ var Builtins_table = map[string]*env.Builtin{
 		Doc:   "Returns table of rows where a specific colum matches a regex.",
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) (res env.Object) {
 			var spr *env.Table
+			var lazy *env.LazyTable
 			switch sheet := arg0.(type) {
 			case env.Table:
 				spr = &sheet
 			case *env.Table:
 				spr = sheet
+			case *env.PersistentTable:
+				spr = sheet.ToTable()
+			case *env.LazyTable:
+				lazy = sheet
 			default:
-				return MakeArgError(ps, 1, []env.Type{env.TableType}, "where-match")
+				return MakeArgError(ps, 1, []env.Type{env.TableType, env.PersistentTableType, env.LazyTableType}, "where-match")
 			}
-			switch reNative := arg2.(type) {
-			case env.Native:
-				re, ok := reNative.Value.(*regexp.Regexp)
-				if !ok {
-					return MakeArgError(ps, 2, []env.Type{env.NativeType}, "where-match")
-				}
-				switch col := arg1.(type) {
-				case env.Word:
-					return WhereMatch(ps, spr, ps.Idx.GetWord(col.Index), re)
-				case env.String:
-					return WhereMatch(ps, spr, col.Value, re)
-				default:
-					return MakeArgError(ps, 2, []env.Type{env.WordType, env.StringType}, "where-match")
-				}
+			var colName string
+			switch col := arg1.(type) {
+			case env.Word:
+				colName = ps.Idx.GetWord(col.Index)
+			case env.String:
+				colName = col.Value
 			default:
+				return MakeArgError(ps, 2, []env.Type{env.WordType, env.StringType}, "where-match")
+			}
+			reNative, ok := arg2.(env.Native)
+			if !ok {
 				return MakeArgError(ps, 3, []env.Type{env.NativeType}, "where-match")
 			}
+			re, ok := reNative.Value.(*regexp.Regexp)
+			if !ok {
+				return MakeArgError(ps, 2, []env.Type{env.NativeType}, "where-match")
+			}
+			if lazy != nil {
+				return LazyRows(ps, lazy, "where-match", func(ps *env.ProgramState, chunk *env.Table) env.Object {
+					return WhereMatch(ps, chunk, colName, re)
+				})
+			}
+			return WhereMatch(ps, spr, colName, re)
 		},
 	},
 
@@ -452,27 +500,38 @@ var Builtins_table = map[string]*env.Builtin{
 		Doc:   "Returns table of rows where specific colum contains a given string value.",
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) (res env.Object) {
 			var spr *env.Table
+			var lazy *env.LazyTable
 			switch sheet := arg0.(type) {
 			case env.Table:
 				spr = &sheet
 			case *env.Table:
 				spr = sheet
+			case *env.PersistentTable:
+				spr = sheet.ToTable()
+			case *env.LazyTable:
+				lazy = sheet
 			default:
-				return MakeArgError(ps, 1, []env.Type{env.TableType}, "where-contains")
+				return MakeArgError(ps, 1, []env.Type{env.TableType, env.PersistentTableType, env.LazyTableType}, "where-contains")
 			}
-			switch s := arg2.(type) {
+			var colName string
+			switch col := arg1.(type) {
+			case env.Word:
+				colName = ps.Idx.GetWord(col.Index)
 			case env.String:
-				switch col := arg1.(type) {
-				case env.Word:
-					return WhereContains(ps, spr, ps.Idx.GetWord(col.Index), s.Value, false)
-				case env.String:
-					return WhereContains(ps, spr, col.Value, s.Value, false)
-				default:
-					return MakeArgError(ps, 2, []env.Type{env.WordType, env.StringType}, "where-contains")
-				}
+				colName = col.Value
 			default:
+				return MakeArgError(ps, 2, []env.Type{env.WordType, env.StringType}, "where-contains")
+			}
+			s, ok := arg2.(env.String)
+			if !ok {
 				return MakeArgError(ps, 3, []env.Type{env.StringType}, "where-contains")
 			}
+			if lazy != nil {
+				return LazyRows(ps, lazy, "where-contains", func(ps *env.ProgramState, chunk *env.Table) env.Object {
+					return WhereContains(ps, chunk, colName, s.Value, false)
+				})
+			}
+			return WhereContains(ps, spr, colName, s.Value, false)
 		},
 	},
 
@@ -491,27 +550,38 @@ var Builtins_table = map[string]*env.Builtin{
 		Doc:   "Returns table of rows where specific colum does not contain a given string value.",
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) (res env.Object) {
 			var spr *env.Table
+			var lazy *env.LazyTable
 			switch sheet := arg0.(type) {
 			case env.Table:
 				spr = &sheet
 			case *env.Table:
 				spr = sheet
+			case *env.PersistentTable:
+				spr = sheet.ToTable()
+			case *env.LazyTable:
+				lazy = sheet
 			default:
-				return MakeArgError(ps, 1, []env.Type{env.TableType}, "where-not-contains")
+				return MakeArgError(ps, 1, []env.Type{env.TableType, env.PersistentTableType, env.LazyTableType}, "where-not-contains")
 			}
-			switch s := arg2.(type) {
+			var colName string
+			switch col := arg1.(type) {
+			case env.Word:
+				colName = ps.Idx.GetWord(col.Index)
 			case env.String:
-				switch col := arg1.(type) {
-				case env.Word:
-					return WhereContains(ps, spr, ps.Idx.GetWord(col.Index), s.Value, true)
-				case env.String:
-					return WhereContains(ps, spr, col.Value, s.Value, true)
-				default:
-					return MakeArgError(ps, 2, []env.Type{env.WordType, env.StringType}, "where-not-contains")
-				}
+				colName = col.Value
 			default:
+				return MakeArgError(ps, 2, []env.Type{env.WordType, env.StringType}, "where-not-contains")
+			}
+			s, ok := arg2.(env.String)
+			if !ok {
 				return MakeArgError(ps, 3, []env.Type{env.StringType}, "where-not-contains")
 			}
+			if lazy != nil {
+				return LazyRows(ps, lazy, "where-not-contains", func(ps *env.ProgramState, chunk *env.Table) env.Object {
+					return WhereContains(ps, chunk, colName, s.Value, true)
+				})
+			}
+			return WhereContains(ps, spr, colName, s.Value, true)
 		},
 	},
 	// Example:
@@ -529,22 +599,34 @@ var Builtins_table = map[string]*env.Builtin{
 		Doc:   "Returns table of rows where specific colum is greater than given value.",
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
 			var spr *env.Table
+			var lazy *env.LazyTable
 			switch sheet := arg0.(type) {
 			case env.Table:
 				spr = &sheet
 			case *env.Table:
 				spr = sheet
+			case *env.PersistentTable:
+				spr = sheet.ToTable()
+			case *env.LazyTable:
+				lazy = sheet
 			default:
-				return MakeArgError(ps, 1, []env.Type{env.TableType}, "where-greater")
+				return MakeArgError(ps, 1, []env.Type{env.TableType, env.PersistentTableType, env.LazyTableType}, "where-greater")
 			}
+			var colName string
 			switch col := arg1.(type) {
 			case env.Word:
-				return WhereGreater(ps, spr, ps.Idx.GetWord(col.Index), arg2)
+				colName = ps.Idx.GetWord(col.Index)
 			case env.String:
-				return WhereGreater(ps, spr, col.Value, arg2)
+				colName = col.Value
 			default:
 				return MakeArgError(ps, 2, []env.Type{env.WordType, env.StringType}, "where-greater")
 			}
+			if lazy != nil {
+				return LazyRows(ps, lazy, "where-greater", func(ps *env.ProgramState, chunk *env.Table) env.Object {
+					return WhereGreater(ps, chunk, colName, arg2)
+				})
+			}
+			return WhereGreater(ps, spr, colName, arg2)
 		},
 	},
 	// Example: filting for names that contain "nn"
@@ -562,22 +644,34 @@ var Builtins_table = map[string]*env.Builtin{
 		Doc:   "Returns table of rows where specific colum is lesser than given value.",
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
 			var spr *env.Table
+			var lazy *env.LazyTable
 			switch sheet := arg0.(type) {
 			case env.Table:
 				spr = &sheet
 			case *env.Table:
 				spr = sheet
+			case *env.PersistentTable:
+				spr = sheet.ToTable()
+			case *env.LazyTable:
+				lazy = sheet
 			default:
-				return MakeArgError(ps, 1, []env.Type{env.TableType}, "where-lesser")
+				return MakeArgError(ps, 1, []env.Type{env.TableType, env.PersistentTableType, env.LazyTableType}, "where-lesser")
 			}
+			var colName string
 			switch col := arg1.(type) {
 			case env.Word:
-				return WhereLesser(ps, spr, ps.Idx.GetWord(col.Index), arg2)
+				colName = ps.Idx.GetWord(col.Index)
 			case env.String:
-				return WhereLesser(ps, spr, col.Value, arg2)
+				colName = col.Value
 			default:
 				return MakeArgError(ps, 2, []env.Type{env.WordType, env.StringType}, "where-lesser")
 			}
+			if lazy != nil {
+				return LazyRows(ps, lazy, "where-lesser", func(ps *env.ProgramState, chunk *env.Table) env.Object {
+					return WhereLesser(ps, chunk, colName, arg2)
+				})
+			}
+			return WhereLesser(ps, spr, colName, arg2)
 		},
 	},
 	// Returns a spreadhsheet of rows where the given column is between the given
@@ -598,22 +692,34 @@ var Builtins_table = map[string]*env.Builtin{
 		Doc:   "Returns table of rows where specific colum is between given values.",
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) (res env.Object) {
 			var spr *env.Table
+			var lazy *env.LazyTable
 			switch sheet := arg0.(type) {
 			case env.Table:
 				spr = &sheet
 			case *env.Table:
 				spr = sheet
+			case *env.PersistentTable:
+				spr = sheet.ToTable()
+			case *env.LazyTable:
+				lazy = sheet
 			default:
-				return MakeArgError(ps, 1, []env.Type{env.TableType}, "where-between")
+				return MakeArgError(ps, 1, []env.Type{env.TableType, env.PersistentTableType, env.LazyTableType}, "where-between")
 			}
+			var colName string
 			switch col := arg1.(type) {
 			case env.Word:
-				return WhereBetween(ps, spr, ps.Idx.GetWord(col.Index), arg2, arg3, false)
+				colName = ps.Idx.GetWord(col.Index)
 			case env.String:
-				return WhereBetween(ps, spr, col.Value, arg2, arg3, false)
+				colName = col.Value
 			default:
 				return MakeArgError(ps, 2, []env.Type{env.WordType, env.StringType}, "where-between")
 			}
+			if lazy != nil {
+				return LazyRows(ps, lazy, "where-between", func(ps *env.ProgramState, chunk *env.Table) env.Object {
+					return WhereBetween(ps, chunk, colName, arg2, arg3, false)
+				})
+			}
+			return WhereBetween(ps, spr, colName, arg2, arg3, false)
 		},
 	},
 
@@ -632,22 +738,34 @@ var Builtins_table = map[string]*env.Builtin{
 		Doc:   "Returns table of rows where specific colum is between given values (inclusive).",
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) (res env.Object) {
 			var spr *env.Table
+			var lazy *env.LazyTable
 			switch sheet := arg0.(type) {
 			case env.Table:
 				spr = &sheet
 			case *env.Table:
 				spr = sheet
+			case *env.PersistentTable:
+				spr = sheet.ToTable()
+			case *env.LazyTable:
+				lazy = sheet
 			default:
-				return MakeArgError(ps, 1, []env.Type{env.TableType}, "where-between\\inclusive")
+				return MakeArgError(ps, 1, []env.Type{env.TableType, env.PersistentTableType, env.LazyTableType}, "where-between\\inclusive")
 			}
+			var colName string
 			switch col := arg1.(type) {
 			case env.Word:
-				return WhereBetween(ps, spr, ps.Idx.GetWord(col.Index), arg2, arg3, true)
+				colName = ps.Idx.GetWord(col.Index)
 			case env.String:
-				return WhereBetween(ps, spr, col.Value, arg2, arg3, true)
+				colName = col.Value
 			default:
 				return MakeArgError(ps, 2, []env.Type{env.WordType, env.StringType}, "where-between\\inclusive")
 			}
+			if lazy != nil {
+				return LazyRows(ps, lazy, "where-between\\inclusive", func(ps *env.ProgramState, chunk *env.Table) env.Object {
+					return WhereBetween(ps, chunk, colName, arg2, arg3, true)
+				})
+			}
+			return WhereBetween(ps, spr, colName, arg2, arg3, true)
 		},
 	},
 
@@ -668,27 +786,38 @@ var Builtins_table = map[string]*env.Builtin{
 		Doc:   "Returns table of rows where specific colum value is found in block of values.",
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) (res env.Object) {
 			var spr *env.Table
+			var lazy *env.LazyTable
 			switch s := arg0.(type) {
 			case env.Table:
 				spr = &s
 			case *env.Table:
 				spr = s
+			case *env.PersistentTable:
+				spr = s.ToTable()
+			case *env.LazyTable:
+				lazy = s
 			default:
-				return MakeArgError(ps, 1, []env.Type{env.TableType}, "where-in")
+				return MakeArgError(ps, 1, []env.Type{env.TableType, env.PersistentTableType, env.LazyTableType}, "where-in")
 			}
-			switch vals := arg2.(type) {
-			case env.Block:
-				switch col := arg1.(type) {
-				case env.Word:
-					return WhereIn(ps, spr, ps.Idx.GetWord(col.Index), vals.Series.S)
-				case env.String:
-					return WhereIn(ps, spr, col.Value, vals.Series.S)
-				default:
-					return MakeArgError(ps, 2, []env.Type{env.WordType, env.StringType}, "where-in")
-				}
+			var colName string
+			switch col := arg1.(type) {
+			case env.Word:
+				colName = ps.Idx.GetWord(col.Index)
+			case env.String:
+				colName = col.Value
 			default:
+				return MakeArgError(ps, 2, []env.Type{env.WordType, env.StringType}, "where-in")
+			}
+			vals, ok := arg2.(env.Block)
+			if !ok {
 				return MakeArgError(ps, 3, []env.Type{env.BlockType}, "where-in")
 			}
+			if lazy != nil {
+				return LazyRows(ps, lazy, "where-in", func(ps *env.ProgramState, chunk *env.Table) env.Object {
+					return WhereIn(ps, chunk, colName, vals.Series.S)
+				})
+			}
+			return WhereIn(ps, spr, colName, vals.Series.S)
 		},
 	},
 
@@ -709,27 +838,38 @@ var Builtins_table = map[string]*env.Builtin{
 		Doc:   "Returns table of rows where specific colum value is not found in block of values.",
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) (res env.Object) {
 			var spr *env.Table
+			var lazy *env.LazyTable
 			switch s := arg0.(type) {
 			case env.Table:
 				spr = &s
 			case *env.Table:
 				spr = s
+			case *env.PersistentTable:
+				spr = s.ToTable()
+			case *env.LazyTable:
+				lazy = s
 			default:
-				return MakeArgError(ps, 1, []env.Type{env.TableType}, "where-not-in")
+				return MakeArgError(ps, 1, []env.Type{env.TableType, env.PersistentTableType, env.LazyTableType}, "where-not-in")
 			}
-			switch vals := arg2.(type) {
-			case env.Block:
-				switch col := arg1.(type) {
-				case env.Word:
-					return WhereNotIn(ps, spr, ps.Idx.GetWord(col.Index), vals.Series.S)
-				case env.String:
-					return WhereNotIn(ps, spr, col.Value, vals.Series.S)
-				default:
-					return MakeArgError(ps, 2, []env.Type{env.WordType, env.StringType}, "where-not-in")
-				}
+			var colName string
+			switch col := arg1.(type) {
+			case env.Word:
+				colName = ps.Idx.GetWord(col.Index)
+			case env.String:
+				colName = col.Value
 			default:
+				return MakeArgError(ps, 2, []env.Type{env.WordType, env.StringType}, "where-not-in")
+			}
+			vals, ok := arg2.(env.Block)
+			if !ok {
 				return MakeArgError(ps, 3, []env.Type{env.BlockType}, "where-not-in")
 			}
+			if lazy != nil {
+				return LazyRows(ps, lazy, "where-not-in", func(ps *env.ProgramState, chunk *env.Table) env.Object {
+					return WhereNotIn(ps, chunk, colName, vals.Series.S)
+				})
+			}
+			return WhereNotIn(ps, spr, colName, vals.Series.S)
 		},
 	},
 
@@ -753,50 +893,25 @@ var Builtins_table = map[string]*env.Builtin{
 		Doc:   "Returns table of rows where code block evaluates to true for the column value.",
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
 			var spr *env.Table
+			var lazy *env.LazyTable
 			switch table := arg0.(type) {
 			case env.Table:
 				spr = &table
 			case *env.Table:
 				spr = table
+			case *env.PersistentTable:
+				spr = table.ToTable()
+			case *env.LazyTable:
+				lazy = table
 			default:
-				return MakeArgError(ps, 1, []env.Type{env.TableType}, "where")
+				return MakeArgError(ps, 1, []env.Type{env.TableType, env.PersistentTableType, env.LazyTableType}, "where")
 			}
-
-			switch col := arg1.(type) {
-			case env.Word:
-				colName := ps.Idx.GetWord(col.Index)
-				switch block := arg2.(type) {
-				case env.Block:
-					return WhereBlock(ps, spr, colName, block)
-				case env.Builtin:
-					return WhereBuiltin(ps, spr, colName, block)
-				case env.Function:
-					return WhereFunction(ps, spr, colName, block)
-				default:
-					return MakeArgError(ps, 3, []env.Type{env.BlockType, env.BuiltinType, env.FunctionType}, "where")
-				}
-			case env.String:
-				colName := col.Value
-				switch block := arg2.(type) {
-				case env.Block:
-					return WhereBlock(ps, spr, colName, block)
-				case env.Builtin:
-					return WhereBuiltin(ps, spr, colName, block)
-				case env.Function:
-					return WhereFunction(ps, spr, colName, block)
-				default:
-					return MakeArgError(ps, 3, []env.Type{env.BlockType, env.BuiltinType, env.FunctionType}, "where")
-				}
-			case env.Block:
-				switch block := arg2.(type) {
-				case env.Block:
-					return WhereBlockMultiCol(ps, spr, col, block)
-				default:
-					return MakeArgError(ps, 3, []env.Type{env.BlockType}, "where")
-				}
-			default:
-				return MakeArgError(ps, 2, []env.Type{env.WordType, env.StringType, env.BlockType}, "where")
+			if lazy != nil {
+				return LazyRows(ps, lazy, "where", func(ps *env.ProgramState, chunk *env.Table) env.Object {
+					return Where(ps, chunk, arg1, arg2)
+				})
 			}
+			return Where(ps, spr, arg1, arg2)
 		},
 	},
 
@@ -816,21 +931,24 @@ var Builtins_table = map[string]*env.Builtin{
 		Doc:   "Returns table with duplicate rows removed based on specified column(s).",
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
 			var spr *env.Table
+			var lazy *env.LazyTable
 			switch s := arg0.(type) {
 			case env.Table:
 				spr = &s
 			case *env.Table:
 				spr = s
+			case *env.LazyTable:
+				lazy = s
 			default:
-				return MakeArgError(ps, 1, []env.Type{env.TableType}, "distinct")
+				return MakeArgError(ps, 1, []env.Type{env.TableType, env.LazyTableType}, "distinct")
 			}
+			var cols []string
 			switch col := arg1.(type) {
 			case env.Word:
-				return Distinct(ps, spr, []string{ps.Idx.GetWord(col.Index)})
+				cols = []string{ps.Idx.GetWord(col.Index)}
 			case env.String:
-				return Distinct(ps, spr, []string{col.Value})
+				cols = []string{col.Value}
 			case env.Block:
-				var cols []string
 				for _, word := range col.Series.S {
 					switch w := word.(type) {
 					case env.Word:
@@ -843,10 +961,13 @@ var Builtins_table = map[string]*env.Builtin{
 						return MakeBuiltinError(ps, "Column names block must contain only words or strings.", "distinct")
 					}
 				}
-				return Distinct(ps, spr, cols)
 			default:
 				return MakeArgError(ps, 2, []env.Type{env.WordType, env.StringType, env.BlockType}, "distinct")
 			}
+			if lazy != nil {
+				return LazyDistinct(ps, lazy, cols)
+			}
+			return Distinct(ps, spr, cols)
 		},
 	},
 
@@ -866,13 +987,16 @@ var Builtins_table = map[string]*env.Builtin{
 		Doc:   "Returns table with void values in specified column replaced with given value.",
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
 			var spr *env.Table
+			var lazy *env.LazyTable
 			switch s := arg0.(type) {
 			case env.Table:
 				spr = &s
 			case *env.Table:
 				spr = s
+			case *env.LazyTable:
+				lazy = s
 			default:
-				return MakeArgError(ps, 1, []env.Type{env.TableType}, "fill-void")
+				return MakeArgError(ps, 1, []env.Type{env.TableType, env.LazyTableType}, "fill-void")
 			}
 			var colName string
 			switch col := arg1.(type) {
@@ -883,6 +1007,11 @@ var Builtins_table = map[string]*env.Builtin{
 			default:
 				return MakeArgError(ps, 2, []env.Type{env.WordType, env.StringType}, "fill-void")
 			}
+			if lazy != nil {
+				return LazyRows(ps, lazy, "fill-void", func(ps *env.ProgramState, chunk *env.Table) env.Object {
+					return FillVoid(ps, chunk, colName, arg2)
+				})
+			}
 			return FillVoid(ps, spr, colName, arg2)
 		},
 	},
@@ -904,50 +1033,23 @@ var Builtins_table = map[string]*env.Builtin{
 		Doc:   "Returns count of rows where code block evaluates to true for the column value.",
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
 			var spr *env.Table
+			var lazy *env.LazyTable
 			switch table := arg0.(type) {
 			case env.Table:
 				spr = &table
 			case *env.Table:
 				spr = table
+			case *env.LazyTable:
+				lazy = table
 			default:
-				return MakeArgError(ps, 1, []env.Type{env.TableType}, "count-where")
+				return MakeArgError(ps, 1, []env.Type{env.TableType, env.LazyTableType}, "count-where")
 			}
-
-			switch col := arg1.(type) {
-			case env.Word:
-				colName := ps.Idx.GetWord(col.Index)
-				switch block := arg2.(type) {
-				case env.Block:
-					return CountWhereBlock(ps, spr, colName, block)
-				case env.Builtin:
-					return CountWhereBuiltin(ps, spr, colName, block)
-				case env.Function:
-					return CountWhereFunction(ps, spr, colName, block)
-				default:
-					return MakeArgError(ps, 3, []env.Type{env.BlockType, env.BuiltinType, env.FunctionType}, "count-where")
-				}
-			case env.String:
-				colName := col.Value
-				switch block := arg2.(type) {
-				case env.Block:
-					return CountWhereBlock(ps, spr, colName, block)
-				case env.Builtin:
-					return CountWhereBuiltin(ps, spr, colName, block)
-				case env.Function:
-					return CountWhereFunction(ps, spr, colName, block)
-				default:
-					return MakeArgError(ps, 3, []env.Type{env.BlockType, env.BuiltinType, env.FunctionType}, "count-where")
-				}
-			case env.Block:
-				switch block := arg2.(type) {
-				case env.Block:
-					return CountWhereBlockMultiCol(ps, spr, col, block)
-				default:
-					return MakeArgError(ps, 3, []env.Type{env.BlockType}, "count-where")
-				}
-			default:
-				return MakeArgError(ps, 2, []env.Type{env.WordType, env.StringType, env.BlockType}, "count-where")
+			if lazy != nil {
+				return LazyCount(ps, lazy, "count-where", func(chunk *env.Table) env.Object {
+					return CountWhere(ps, chunk, arg1, arg2)
+				})
 			}
+			return CountWhere(ps, spr, arg1, arg2)
 		},
 	},
 
@@ -1108,6 +1210,7 @@ var Builtins_table = map[string]*env.Builtin{
 					return MakeArgError(ps, 2, []env.Type{env.BlockType, env.NativeType}, "add-rows!")
 				}
 			case *env.PersistentTable:
+				rows := make([][]any, 0)
 				switch data1 := arg1.(type) {
 				case env.Block:
 					data := data1.Series
@@ -1117,12 +1220,24 @@ var Builtins_table = map[string]*env.Builtin{
 							k1 := data.Pop()
 							rowd[ii] = k1
 						}
-						spr.AddRow(*env.NewTableRow(rowd, spr))
+						rows = append(rows, rowd)
+					}
+				case env.Native:
+					tableRows, ok := data1.Value.([]env.TableRow)
+					if !ok {
+						return MakeBuiltinError(ps, "Native value is not table rows", "add-rows!")
+					}
+					for _, row := range tableRows {
+						rows = append(rows, row.Values)
 					}
-					return spr
 				default:
-					return MakeArgError(ps, 2, []env.Type{env.BlockType}, "add-rows!")
+					return MakeArgError(ps, 2, []env.Type{env.BlockType, env.NativeType}, "add-rows!")
+				}
+				// all rows are stored in one transaction, or none if one of them fails
+				if err := spr.AppendRows(rows); err != nil {
+					return MakeBuiltinError(ps, err.Error(), "add-rows!")
 				}
+				return spr
 			default:
 				return MakeArgError(ps, 1, []env.Type{env.TableType, env.PersistentTableType}, "add-rows!")
 			}
@@ -1197,6 +1312,53 @@ var Builtins_table = map[string]*env.Builtin{
 				default:
 					return MakeArgError(ps, 2, []env.Type{env.IntegerType}, "update-row!")
 				}
+			case *env.PersistentTable:
+				idx, ok := arg1.(env.Integer)
+				if !ok {
+					return MakeArgError(ps, 2, []env.Type{env.IntegerType}, "update-row!")
+				}
+				if idx.Value < 1 || idx.Value > int64(len(spr.Rows)) {
+					errMsg := fmt.Sprintf("update-row! called with row index %d, but table only has %d rows", idx.Value, len(spr.Rows))
+					return MakeError(ps, errMsg)
+				}
+				row := spr.Rows[idx.Value-1]
+				vals := make([]any, len(row.Values))
+				copy(vals, row.Values)
+				switch updater := arg2.(type) {
+				case env.Function:
+					CallFunction_CollectArgs(updater, ps, row, false, ps.Ctx)
+					switch ret := ps.Res.(type) {
+					case env.Dict:
+						for i, col := range spr.Cols {
+							if val, ok := ret.Data[col]; ok {
+								vals[i] = val
+							}
+						}
+					case env.TableRow:
+						copy(vals, ret.Values)
+					default:
+						return MakeError(ps, fmt.Sprintf(
+							"Function given to update-row! should have returned a Dict or a TableRow, but returned a %s %#v instead",
+							NameOfRyeType(ret.Type()), ret,
+						))
+					}
+				case env.Dict:
+					for keyStr, val := range updater.Data {
+						index := spr.GetColumnIndex(keyStr)
+						if index < 0 {
+							return MakeError(ps, "Column "+keyStr+" was not found")
+						}
+						vals[index] = val
+					}
+				case env.TableRow:
+					copy(vals, updater.Values)
+				default:
+					return MakeArgError(ps, 3, []env.Type{env.FunctionType, env.DictType, env.TableRowType}, "update-row!")
+				}
+				if err := spr.UpdateRow(int(idx.Value-1), vals); err != nil {
+					return MakeBuiltinError(ps, err.Error(), "update-row!")
+				}
+				return spr
 			default:
 				return MakeNeedsThawedArgError(ps, "update-row!")
 			}
@@ -1230,8 +1392,22 @@ var Builtins_table = map[string]*env.Builtin{
 				default:
 					return MakeArgError(ps, 2, []env.Type{env.BlockType, env.NativeType}, "remove-row!")
 				}
+			case *env.PersistentTable:
+				switch data1 := arg1.(type) {
+				case env.Integer:
+					if data1.Value > 0 && data1.Value <= int64(len(spr.Rows)) {
+						if err := spr.RemoveRow(int(data1.Value - 1)); err != nil {
+							return MakeBuiltinError(ps, err.Error(), "remove-row!")
+						}
+						return spr
+					} else {
+						return MakeError(ps, fmt.Sprintf("Table had less then %d rows", data1.Value))
+					}
+				default:
+					return MakeArgError(ps, 2, []env.Type{env.IntegerType}, "remove-row!")
+				}
 			default:
-				return MakeArgError(ps, 1, []env.Type{env.TableType}, "remove-row!")
+				return MakeArgError(ps, 1, []env.Type{env.TableType, env.PersistentTableType}, "remove-row!")
 			}
 		},
 	},
@@ -1249,41 +1425,50 @@ var Builtins_table = map[string]*env.Builtin{
 		Argsn: 2,
 		Doc:   "Returns table with just given columns. Use lsetwords (:newName) to rename the previous column.",
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
-			switch spr := arg0.(type) {
+			var spr env.Table
+			var lazy *env.LazyTable
+			switch s := arg0.(type) {
 			case env.Table:
-				switch col := arg1.(type) {
-				case env.Block:
-					cols := make([]string, 0)
-					colNames := make([]string, 0)
+				spr = s
+			case *env.LazyTable:
+				lazy = s
+			default:
+				return MakeArgError(ps, 1, []env.Type{env.TableType, env.LazyTableType}, "columns?")
+			}
+			col, ok := arg1.(env.Block)
+			if !ok {
+				return MakeArgError(ps, 1, []env.Type{env.BlockType}, "columns?")
+			}
+			cols := make([]string, 0)
+			colNames := make([]string, 0)
 
-					for _, obj := range col.Series.S {
-						switch ww := obj.(type) {
-						case env.String:
-							cols = append(cols, ww.Value)
-							colNames = append(colNames, ww.Value)
-						case env.Tagword:
-							colName := ps.Idx.GetWord(ww.Index)
-							cols = append(cols, colName)
-							colNames = append(colNames, colName)
-						case env.LSetword:
-							// Rename the previous column
-							if len(colNames) == 0 {
-								return MakeBuiltinError(ps, "LSetword :"+ps.Idx.GetWord(ww.Index)+" found but no previous column to rename", "columns?")
-							}
-							// Replace the last column name with the new name from lsetword
-							newName := ps.Idx.GetWord(ww.Index)
-							colNames[len(colNames)-1] = newName
-						default:
-							return MakeBuiltinError(ps, "Expected string, tagword, or lsetword in columns specification", "columns?")
-						}
+			for _, obj := range col.Series.S {
+				switch ww := obj.(type) {
+				case env.String:
+					cols = append(cols, ww.Value)
+					colNames = append(colNames, ww.Value)
+				case env.Tagword:
+					colName := ps.Idx.GetWord(ww.Index)
+					cols = append(cols, colName)
+					colNames = append(colNames, colName)
+				case env.LSetword:
+					// Rename the previous column
+					if len(colNames) == 0 {
+						return MakeBuiltinError(ps, "LSetword :"+ps.Idx.GetWord(ww.Index)+" found but no previous column to rename", "columns?")
 					}
-					return spr.ColumnsRenamed(ps, cols, colNames)
+					// Replace the last column name with the new name from lsetword
+					newName := ps.Idx.GetWord(ww.Index)
+					colNames[len(colNames)-1] = newName
 				default:
-					return MakeArgError(ps, 1, []env.Type{env.BlockType}, "columns?")
+					return MakeBuiltinError(ps, "Expected string, tagword, or lsetword in columns specification", "columns?")
 				}
-			default:
-				return MakeArgError(ps, 1, []env.Type{env.TableType}, "columns?")
 			}
+			if lazy != nil {
+				return LazyRows(ps, lazy, "columns?", func(ps *env.ProgramState, chunk *env.Table) env.Object {
+					return chunk.ColumnsRenamed(ps, cols, colNames)
+				})
+			}
+			return spr.ColumnsRenamed(ps, cols, colNames)
 		},
 	},
 	// Example: Get sheet column names
@@ -1301,8 +1486,12 @@ var Builtins_table = map[string]*env.Builtin{
 				return spr.GetColumns()
 			case env.Table:
 				return spr.GetColumns()
+			case *env.PersistentTable:
+				return spr.GetColumns()
+			case *env.LazyTable:
+				return spr.GetColumns()
 			default:
-				return MakeArgError(ps, 1, []env.Type{env.TableType}, "headers?")
+				return MakeArgError(ps, 1, []env.Type{env.TableType, env.PersistentTableType, env.LazyTableType}, "headers?")
 			}
 		},
 	},
@@ -1335,6 +1524,39 @@ var Builtins_table = map[string]*env.Builtin{
 				default:
 					return MakeArgError(ps, 2, []env.Type{env.WordType, env.StringType}, "column?")
 				}
+			case *env.PersistentTable:
+				var name string
+				switch col := arg1.(type) {
+				case env.Word:
+					name = ps.Idx.GetWord(col.Index)
+				case env.String:
+					name = col.Value
+				default:
+					return MakeArgError(ps, 2, []env.Type{env.WordType, env.StringType}, "column?")
+				}
+				res := spr.GetColumn(name)
+				if _, isErr := res.(*env.Error); isErr {
+					ps.FailureFlag = true
+				}
+				return res
+			case *env.LazyTable:
+				var name string
+				switch col := arg1.(type) {
+				case env.Word:
+					name = ps.Idx.GetWord(col.Index)
+				case env.String:
+					name = col.Value
+				default:
+					return MakeArgError(ps, 2, []env.Type{env.WordType, env.StringType}, "column?")
+				}
+				// only the values of the column are kept in memory
+				return LazyCollect(ps, spr, "column?", func(chunk *env.Table) env.Object {
+					res := chunk.GetColumn(name)
+					if _, isErr := res.(*env.Error); isErr {
+						ps.FailureFlag = true
+					}
+					return res
+				})
 			case env.Block:
 				switch col := arg1.(type) {
 				case env.Integer:
@@ -1356,7 +1578,7 @@ var Builtins_table = map[string]*env.Builtin{
 					return MakeArgError(ps, 2, []env.Type{env.WordType, env.StringType}, "column?")
 				}
 			default:
-				return MakeArgError(ps, 1, []env.Type{env.TableType}, "column?")
+				return MakeArgError(ps, 1, []env.Type{env.TableType, env.LazyTableType}, "column?")
 			}
 		},
 	},
@@ -1373,18 +1595,13 @@ var Builtins_table = map[string]*env.Builtin{
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
 			switch spr := arg0.(type) {
 			case env.Table:
-				switch rmCol := arg1.(type) {
-				case env.Word:
-					return DropColumn(ps, spr, *env.NewString(ps.Idx.GetWord(rmCol.Index)))
-				case env.String:
-					return DropColumn(ps, spr, rmCol)
-				case env.Block:
-					return DropColumnBlock(ps, spr, rmCol)
-				default:
-					return MakeArgError(ps, 2, []env.Type{env.WordType, env.StringType, env.BlockType}, "drop-column")
-				}
+				return DropColumnArg(ps, spr, arg1)
+			case *env.LazyTable:
+				return LazyRows(ps, spr, "drop-column", func(ps *env.ProgramState, chunk *env.Table) env.Object {
+					return DropColumnArg(ps, *chunk, arg1)
+				})
 			}
-			return MakeArgError(ps, 1, []env.Type{env.TableType}, "drop-column")
+			return MakeArgError(ps, 1, []env.Type{env.TableType, env.LazyTableType}, "drop-column")
 		},
 	},
 	// Tests:
@@ -1429,50 +1646,13 @@ var Builtins_table = map[string]*env.Builtin{
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
 			switch spr := arg0.(type) {
 			case env.Table:
-				switch newCol := arg1.(type) {
-				case env.Word:
-					switch fromCols := arg2.(type) {
-					case env.Block:
-						switch code := arg3.(type) {
-						case env.Block:
-							return GenerateColumn(ps, spr, newCol, fromCols, code)
-						default:
-							return MakeArgError(ps, 4, []env.Type{env.BlockType}, "gen-column")
-						}
-					case env.Word:
-						switch replaceBlock := arg3.(type) {
-						case env.Block:
-							if replaceBlock.Series.Len() != 2 {
-								return MakeBuiltinError(ps, "Replacement block must contain a regex object and replacement string.", "genadd-umn")
-							}
-							regexNative, ok := replaceBlock.Series.S[0].(env.Native)
-							if !ok {
-								return MakeBuiltinError(ps, "First element of replacement block must be a regex object.", "genaddumn")
-							}
-							regex, ok := regexNative.Value.(*regexp.Regexp)
-							if !ok {
-								return MakeBuiltinError(ps, "First element of replacement block must be a regex object.", "gen-column")
-							}
-							replaceStr, ok := replaceBlock.Series.S[1].(env.String)
-							if !ok {
-								return MakeBuiltinError(ps, "Second element of replacement block must be a string.", "gen-column")
-							}
-							err := GenerateColumnRegexReplace(ps, &spr, newCol, fromCols, regex, replaceStr.Value)
-							if err != nil {
-								return err
-							}
-							return spr
-						default:
-							return MakeArgError(ps, 3, []env.Type{env.BlockType}, "gen-column")
-						}
-					default:
-						return MakeArgError(ps, 3, []env.Type{env.BlockType}, "gen-column")
-					}
-				default:
-					return MakeArgError(ps, 2, []env.Type{env.WordType}, "gen-column")
-				}
+				return GenColumn(ps, spr, arg1, arg2, arg3)
+			case *env.LazyTable:
+				return LazyRows(ps, spr, "gen-column", func(ps *env.ProgramState, chunk *env.Table) env.Object {
+					return GenColumn(ps, *chunk, arg1, arg2, arg3)
+				})
 			default:
-				return MakeArgError(ps, 1, []env.Type{env.TableType}, "gen-column")
+				return MakeArgError(ps, 1, []env.Type{env.TableType, env.LazyTableType}, "gen-column")
 			}
 		},
 	},
@@ -1652,6 +1832,10 @@ var Builtins_table = map[string]*env.Builtin{
 		Argsn: 3,
 		Doc:   "Sorts row by given column, changes table in place.",
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			arg0, errObj := MaterializeLazy(ps, arg0, "order-by")
+			if errObj != nil {
+				return errObj
+			}
 			dir, ok := arg2.(env.Word)
 			if !ok {
 				return MakeArgError(ps, 3, []env.Type{env.WordType}, "sort-by!")
@@ -1722,8 +1906,28 @@ var Builtins_table = map[string]*env.Builtin{
 				default:
 					return MakeArgError(ps, 2, []env.Type{env.BlockType}, "add-indexes!")
 				}
+			case *env.PersistentTable:
+				switch col := arg1.(type) {
+				case env.Block:
+					cols := make([]string, col.Series.Len())
+					for c := range col.Series.S {
+						switch ww := col.Series.S[c].(type) {
+						case env.Word:
+							cols[c] = ps.Idx.GetWord(ww.Index)
+						default:
+							return MakeError(ps, "Block of tagwords needed")
+						}
+					}
+					// the indexed columns are stored with the table, indexes are rebuilt when it's opened
+					if err := spr.SetIndexes(cols); err != nil {
+						return MakeBuiltinError(ps, err.Error(), "add-indexes!")
+					}
+					return spr
+				default:
+					return MakeArgError(ps, 2, []env.Type{env.BlockType}, "add-indexes!")
+				}
 			default:
-				return MakeArgError(ps, 1, []env.Type{env.TableType}, "add-indexes!")
+				return MakeArgError(ps, 1, []env.Type{env.TableType, env.PersistentTableType}, "add-indexes!")
 			}
 		},
 	},
@@ -1741,8 +1945,14 @@ var Builtins_table = map[string]*env.Builtin{
 					res = append(res, *env.NewString(col))
 				}
 				return *env.NewBlock(*env.NewTSeries(res))
+			case *env.PersistentTable:
+				res := make([]env.Object, 0)
+				for _, col := range spr.IndexedColumns() {
+					res = append(res, *env.NewString(col))
+				}
+				return *env.NewBlock(*env.NewTSeries(res))
 			default:
-				return MakeArgError(ps, 1, []env.Type{env.TableType}, "indexes?")
+				return MakeArgError(ps, 1, []env.Type{env.TableType, env.PersistentTableType}, "indexes?")
 			}
 		},
 	},
@@ -1761,8 +1971,15 @@ var Builtins_table = map[string]*env.Builtin{
 				default:
 					return MakeArgError(ps, 2, []env.Type{env.DecimalType}, "autotype")
 				}
+			case *env.LazyTable:
+				switch percent := arg1.(type) {
+				case env.Decimal:
+					return LazyAutoType(ps, spr, percent.Value)
+				default:
+					return MakeArgError(ps, 2, []env.Type{env.DecimalType}, "autotype")
+				}
 			default:
-				return MakeArgError(ps, 1, []env.Type{env.TableType}, "autotype")
+				return MakeArgError(ps, 1, []env.Type{env.TableType, env.LazyTableType}, "autotype")
 			}
 		},
 	},
@@ -1770,6 +1987,8 @@ var Builtins_table = map[string]*env.Builtin{
 	//  names: table { "id" "name" } { 1 "Paul" 2 "Chani" 3 "Vladimir" } ,
 	//  houses: table { "id" "house" } { 1 "Atreides" 3 "Harkonnen" } ,
 	//  names .left-join houses 'id 'id
+	// Example: join on several columns
+	//  sales .left-join targets { 'region 'month } { 'region 'month }
 	// Tests:
 	//  equal {
 	//    names: table { "id" "name" } { 1 "Paul" 2 "Chani" 3 "Vladimir" } ,
@@ -1783,36 +2002,23 @@ var Builtins_table = map[string]*env.Builtin{
 	//
 	//    names .left-join houses 'id 'id |column? "name"
 	//  } { "Paul" "Chani" "Vladimir" }
+	//  equal {
+	//    a: table { "x" "y" "v" } { 1 1 "a" 1 2 "b" 2 1 "c" } ,
+	//    b: table { "x" "y" "v" } { 1 2 "B" 2 1 "C" } ,
+	//    a .left-join b { 'x 'y } { 'x 'y } |column? "v_2"
+	//  } { _ "B" "C" }
+	// Args:
+	// * table1
+	// * table2
+	// * columns1 - key column (word or string) or block of key columns of the first table
+	// * columns2 - key column or block of key columns of the second table
+	// Returns:
+	// * table with all rows of the first table and the columns of both, clashing columns of the second table get a _2 suffix
 	"left-join": {
 		Argsn: 4,
 		Doc:   "Left joins two tables on the given columns.",
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) (res env.Object) {
-			switch spr1 := arg0.(type) {
-			case env.Table:
-				switch spr2 := arg1.(type) {
-				case env.Table:
-					switch col1 := arg2.(type) {
-					case env.Word:
-						col2, ok := arg3.(env.Word)
-						if !ok {
-							return MakeArgError(ps, 4, []env.Type{env.WordType}, "left-join")
-						}
-						return LeftJoin(ps, spr1, spr2, ps.Idx.GetWord(col1.Index), ps.Idx.GetWord(col2.Index), false)
-					case env.String:
-						col2, ok := arg3.(env.String)
-						if !ok {
-							MakeArgError(ps, 4, []env.Type{env.StringType}, "left-join")
-						}
-						return LeftJoin(ps, spr1, spr2, col1.Value, col2.Value, false)
-					default:
-						return MakeArgError(ps, 3, []env.Type{env.WordType, env.StringType}, "left-join")
-					}
-				default:
-					return MakeArgError(ps, 2, []env.Type{env.TableType}, "left-join")
-				}
-			default:
-				return MakeArgError(ps, 1, []env.Type{env.TableType}, "left-join")
-			}
+			return joinBuiltin(ps, arg0, arg1, arg2, arg3, "left", "left-join")
 		},
 	},
 	// Example: join two tables
@@ -1833,42 +2039,115 @@ var Builtins_table = map[string]*env.Builtin{
 	//
 	//    names .inner-join houses 'id 'id |column? "name"
 	//  } {  "Paul" "Vladimir" }
+	// Args:
+	// * table1
+	// * table2
+	// * columns1 - key column (word or string) or block of key columns of the first table
+	// * columns2 - key column or block of key columns of the second table
+	// Returns:
+	// * table with the matching rows of both tables
 	"inner-join": {
 		Argsn: 4,
 		Doc:   "Inner joins two tables on the given columns.",
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) (res env.Object) {
-			switch spr1 := arg0.(type) {
-			case env.Table:
-				switch spr2 := arg1.(type) {
-				case env.Table:
-					switch col1 := arg2.(type) {
-					case env.Word:
-						col2, ok := arg3.(env.Word)
-						if !ok {
-							return MakeArgError(ps, 4, []env.Type{env.WordType}, "inner-join")
-						}
-						return LeftJoin(ps, spr1, spr2, ps.Idx.GetWord(col1.Index), ps.Idx.GetWord(col2.Index), true)
-					case env.String:
-						col2, ok := arg3.(env.String)
-						if !ok {
-							MakeArgError(ps, 4, []env.Type{env.StringType}, "inner-join")
-						}
-						return LeftJoin(ps, spr1, spr2, col1.Value, col2.Value, true)
-					default:
-						return MakeArgError(ps, 3, []env.Type{env.WordType, env.StringType}, "inner-join")
-					}
-				default:
-					return MakeArgError(ps, 2, []env.Type{env.TableType}, "inner-join")
-				}
-			default:
-				return MakeArgError(ps, 1, []env.Type{env.TableType}, "inner-join")
-			}
+			return joinBuiltin(ps, arg0, arg1, arg2, arg3, "inner", "inner-join")
+		},
+	},
+	// Example: all houses, with the names of their members
+	//  names .right-join houses 'id 'id
+	// Tests:
+	//  equal {
+	//    names: table { "id" "name" } { 1 "Paul" 3 "Vladimir" } ,
+	//    houses: table { "id" "house" } { 1 "Atreides" 2 "Corrino" 3 "Harkonnen" } ,
+	//    names .right-join houses 'id 'id |column? "name"
+	//  } { "Paul" _ "Vladimir" }
+	// Args:
+	// * table1
+	// * table2
+	// * columns1 - key column (word or string) or block of key columns of the first table
+	// * columns2 - key column or block of key columns of the second table
+	// Returns:
+	// * table with all rows of the second table, in its order, and the columns of both
+	"right-join": {
+		Argsn: 4,
+		Doc:   "Right joins two tables on the given columns.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) (res env.Object) {
+			return joinBuiltin(ps, arg0, arg1, arg2, arg3, "right", "right-join")
+		},
+	},
+	// Example: all names and all houses
+	//  names .full-join houses 'id 'id
+	// Tests:
+	//  equal {
+	//    names: table { "id" "name" } { 1 "Paul" 2 "Chani" } ,
+	//    houses: table { "id" "house" } { 1 "Atreides" 3 "Harkonnen" } ,
+	//    names .full-join houses 'id 'id |column? "house"
+	//  } { "Atreides" _ "Harkonnen" }
+	// Args:
+	// * table1
+	// * table2
+	// * columns1 - key column (word or string) or block of key columns of the first table
+	// * columns2 - key column or block of key columns of the second table
+	// Returns:
+	// * table with the rows of a left join, followed by the rows of the second table without a match
+	"full-join": {
+		Argsn: 4,
+		Doc:   "Full outer joins two tables on the given columns.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) (res env.Object) {
+			return joinBuiltin(ps, arg0, arg1, arg2, arg3, "full", "full-join")
+		},
+	},
+	// Example: names that belong to a house
+	//  names .semi-join houses 'id 'id
+	// Tests:
+	//  equal {
+	//    names: table { "id" "name" } { 1 "Paul" 2 "Chani" 3 "Vladimir" } ,
+	//    houses: table { "id" "house" } { 1 "Atreides" 1 "Fremen" 3 "Harkonnen" } ,
+	//    names .semi-join houses 'id 'id |column? "name"
+	//  } { "Paul" "Vladimir" }
+	// Args:
+	// * table1
+	// * table2
+	// * columns1 - key column (word or string) or block of key columns of the first table
+	// * columns2 - key column or block of key columns of the second table
+	// Returns:
+	// * table with the rows of the first table that have a match in the second, each once, with columns of the first table
+	"semi-join": {
+		Argsn: 4,
+		Doc:   "Returns the rows of the first table that have a matching row in the second table.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) (res env.Object) {
+			return joinBuiltin(ps, arg0, arg1, arg2, arg3, "semi", "semi-join")
+		},
+	},
+	// Example: names that don't belong to any house
+	//  names .anti-join houses 'id 'id
+	// Tests:
+	//  equal {
+	//    names: table { "id" "name" } { 1 "Paul" 2 "Chani" 3 "Vladimir" } ,
+	//    houses: table { "id" "house" } { 1 "Atreides" 3 "Harkonnen" } ,
+	//    names .anti-join houses 'id 'id |column? "name"
+	//  } { "Chani" }
+	// Args:
+	// * table1
+	// * table2
+	// * columns1 - key column (word or string) or block of key columns of the first table
+	// * columns2 - key column or block of key columns of the second table
+	// Returns:
+	// * table with the rows of the first table that have no match in the second, with columns of the first table
+	"anti-join": {
+		Argsn: 4,
+		Doc:   "Returns the rows of the first table that have no matching row in the second table.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) (res env.Object) {
+			return joinBuiltin(ps, arg0, arg1, arg2, arg3, "anti", "anti-join")
 		},
 	},
 	// Example: group table rows by name, running various aggregations on the val column
 	//  table { "name" "val" } { "a" 1 "b" 6 "a" 5 "b" 10 "a" 7 }
 	// 	|group-by 'name { 'name count 'val sum 'val min 'val max 'val avg }
 	// 	|order-by! 'name 'asc
+	// Example: name the result columns and use a custom function, it gets a block of the group's values
+	//  biggest: fn { vals } { vals .sort .last }
+	//  orders |group-by { 'region 'month } { n: 'id count total: 'amount sum 'amount median 'amount biggest }
 	// Tests:
 	//  equal {
 	//    table { "name" "val" } { "a" 1 "b" 6 "a" 5 "b" 10 "a" 7 }
@@ -1884,65 +2163,206 @@ var Builtins_table = map[string]*env.Builtin{
 	//    table { "name" "val" } { "a" 1 "b" 6 "a" 5 "b" 10 "a" 12 }
 	// 	  |group-by 'name { 'name count 'val avg } |column? "val_avg" |sort
 	//   } { 6.0 8.0 }
+	//  equal {
+	//    table { "name" "val" } { "a" 1 "b" 6 "a" 5 "b" 10 "a" 12 }
+	// 	  |group-by 'name { n: 'val count mid: 'val median 'val first 'val last } |header?
+	//   } list { "name" "n" "mid" "val_first" "val_last" }
+	//  equal {
+	//    table { "name" "val" } { "a" 1 "b" 6 "a" 5 "b" 10 "a" 12 }
+	// 	  |group-by 'name { 'val median 'val distinct-count } |column? "val_median"
+	//   } { 5.0 8.0 }
+	//  equal {
+	//    table { "name" "val" } { "a" 2 "a" 4 "a" 4 "a" 4 "a" 5 "a" 5 "a" 7 "a" 9 }
+	// 	  |group-by 'name { 'val stddev } |column? "val_stddev" |first |> 2.138
+	//   } true
+	//  equal {
+	//    cnt: fn { vals } { length? vals }
+	//    table { "name" "val" } { "a" 1 "b" 6 "a" 5 }
+	// 	  |group-by 'name { 'val cnt } |column? "val_cnt"
+	//   } { 2 1 }
+	// Args:
+	// * table
+	// * columns - column name (word or string) or block of column names to group by
+	// * aggregations - block of column (tagword or string) and function pairs, optionally preceded by a setword with the result column name,
+	//   a function can be a word of a function defined in the current context
+	// Returns:
+	// * table with a row for each group in order of appearance, grouping columns followed by the aggregations
+	//   (count, sum, min, max, avg, median, stddev, first, last, distinct-count, or a function that gets a block of the group's values)
 	"group-by": {
 		Argsn: 3,
 		Doc:   "Groups a table by the given column(s) and (optional) aggregations.",
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) (res env.Object) {
-			switch spr := arg0.(type) {
+			var spr *env.Table
+			var lazy *env.LazyTable
+			switch s := arg0.(type) {
 			case env.Table:
-				switch aggBlock := arg2.(type) {
-				case env.Block:
-					if len(aggBlock.Series.S)%2 != 0 {
-						return MakeBuiltinError(ps, "Aggregation block must contain pairs of column name and function for each aggregation.", "group-by")
-					}
-					aggregations := make(map[string][]string)
-					for i := 0; i < len(aggBlock.Series.S); i += 2 {
-						col := aggBlock.Series.S[i]
-						fun, ok := aggBlock.Series.S[i+1].(env.Word)
-						if !ok {
-							return MakeBuiltinError(ps, "Aggregation function must be a word", "group-by")
-						}
-						colStr := ""
-						switch col := col.(type) {
-						case env.Tagword:
-							colStr = ps.Idx.GetWord(col.Index)
-						case env.String:
-							colStr = col.Value
-						default:
-							return MakeBuiltinError(ps, "Aggregation column must be a word or string", "group-by")
-						}
-						funStr := ps.Idx.GetWord(fun.Index)
-						aggregations[colStr] = append(aggregations[colStr], funStr)
-					}
-					switch col := arg1.(type) {
-					case env.Word:
-						return GroupBy(ps, spr, []string{ps.Idx.GetWord(col.Index)}, aggregations)
-					case env.String:
-						return GroupBy(ps, spr, []string{col.Value}, aggregations)
-					case env.Block:
-						cols := make([]string, col.Series.Len())
-						for c := range col.Series.S {
-							switch ww := col.Series.S[c].(type) {
-							case env.String:
-								cols[c] = ww.Value
-							case env.Tagword:
-								cols[c] = ps.Idx.GetWord(ww.Index)
-							case env.Word:
-								cols[c] = ps.Idx.GetWord(ww.Index)
-							default:
-								return MakeBuiltinError(ps, "Block must contain only strings or words for column names", "group-by")
-							}
-						}
-						return GroupBy(ps, spr, cols, aggregations)
-					default:
-						return MakeArgError(ps, 2, []env.Type{env.WordType, env.StringType, env.BlockType}, "group-by")
-					}
-				default:
-					return MakeArgError(ps, 3, []env.Type{env.BlockType}, "group-by")
+				spr = &s
+			case *env.Table:
+				spr = s
+			case *env.LazyTable:
+				lazy = s
+			default:
+				return MakeArgError(ps, 1, []env.Type{env.TableType, env.LazyTableType}, "group-by")
+			}
+			cols, errObj := tableColumnNames(ps, arg1, 2, "group-by")
+			if errObj != nil {
+				return errObj
+			}
+			aggBlock, ok := arg2.(env.Block)
+			if !ok {
+				return MakeArgError(ps, 3, []env.Type{env.BlockType}, "group-by")
+			}
+			aggs, errObj := ParseAggregations(ps, aggBlock, "group-by")
+			if errObj != nil {
+				return errObj
+			}
+			if lazy == nil {
+				return GroupBy(ps, *spr, cols, aggs)
+			}
+			// lazy tables are grouped chunk by chunk, only the groups are kept in memory
+			g, errObj := NewTableGrouper(ps, lazy.Cols, cols, aggs, "group-by")
+			if errObj != nil {
+				return errObj
+			}
+			err := lazy.Each(func(chunk *env.Table) (bool, error) {
+				if errObj := g.Add(ps, chunk); errObj != nil {
+					return false, &lazyStageError{obj: errObj}
 				}
+				return false, nil
+			})
+			if err != nil {
+				return lazyTableError(ps, err, "group-by")
+			}
+			return g.Result(ps)
+		},
+	},
+
+	// Example: total amount per region (rows) and month (columns)
+	//  sales |pivot 'region 'month 'amount 'sum
+	// Tests:
+	//  equal {
+	//    table { "region" "month" "amount" } { "eu" "jan" 1 "eu" "feb" 2 "us" "jan" 3 "eu" "jan" 4 }
+	//    |pivot 'region 'month 'amount 'sum |header?
+	//  } list { "region" "jan" "feb" }
+	//  equal {
+	//    table { "region" "month" "amount" } { "eu" "jan" 1 "eu" "feb" 2 "us" "jan" 3 "eu" "jan" 4 }
+	//    |pivot 'region 'month 'amount 'sum |column? "jan"
+	//  } { 5.0 3.0 }
+	//  equal {
+	//    table { "region" "month" "amount" } { "eu" "jan" 1 "eu" "feb" 2 "us" "jan" 3 }
+	//    |pivot 'region 'month 'amount 'count |column? "feb"
+	//  } { 1 _ }
+	// Args:
+	// * table
+	// * rows - column name or block of column names whose values become the rows
+	// * columns - column whose values become the new columns
+	// * values - column with the values to aggregate
+	// * aggregation - count, sum, min, max, avg, median, stddev, first, last, distinct-count or a function that gets a block of values
+	// Returns:
+	// * table with a row for each combination of row values and a column for each value of the columns column, cells without values are void
+	"pivot": {
+		Argsn: 5,
+		Doc:   "Reshapes a table so the values of one column become columns, aggregating the values of another column in each cell.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			arg0, errObj := MaterializeLazy(ps, arg0, "pivot")
+			if errObj != nil {
+				return errObj
+			}
+			var spr *env.Table
+			switch s := arg0.(type) {
+			case env.Table:
+				spr = &s
+			case *env.Table:
+				spr = s
+			default:
+				return MakeArgError(ps, 1, []env.Type{env.TableType}, "pivot")
+			}
+			rowCols, errObj := tableColumnNames(ps, arg1, 2, "pivot")
+			if errObj != nil {
+				return errObj
+			}
+			pivotCols, errObj := tableColumnNames(ps, arg2, 3, "pivot")
+			if errObj != nil {
+				return errObj
+			}
+			valueCols, errObj := tableColumnNames(ps, arg3, 4, "pivot")
+			if errObj != nil {
+				return errObj
+			}
+			if len(pivotCols) != 1 || len(valueCols) != 1 {
+				return MakeBuiltinError(ps, "Pivot needs one columns column and one values column.", "pivot")
+			}
+			agg := TableAggregation{Col: valueCols[0]}
+			switch fun := arg4.(type) {
+			case env.Word:
+				agg.Fun, agg.Custom, errObj = aggregationFunction(ps, fun.Index, "pivot")
+			case env.Tagword:
+				agg.Fun, agg.Custom, errObj = aggregationFunction(ps, fun.Index, "pivot")
+			case env.Function:
+				agg = TableAggregation{Col: valueCols[0], Fun: "fn", Custom: fun}
+			case env.Builtin:
+				agg = TableAggregation{Col: valueCols[0], Fun: "fn", Custom: fun}
 			default:
-				return MakeArgError(ps, 1, []env.Type{env.TableType}, "group-by")
+				return MakeArgError(ps, 5, []env.Type{env.WordType, env.FunctionType, env.BuiltinType}, "pivot")
 			}
+			if errObj != nil {
+				return errObj
+			}
+			return Pivot(ps, spr, rowCols, pivotCols[0], agg)
+		},
+	},
+
+	// Example: turn month columns into rows of region, month and amount
+	//  report |unpivot { 'region } 'month 'amount
+	// Tests:
+	//  equal {
+	//    table { "region" "jan" "feb" } { "eu" 1 2 "us" 3 4 }
+	//    |unpivot { 'region } 'month 'amount |column? "month"
+	//  } { "jan" "feb" "jan" "feb" }
+	//  equal {
+	//    table { "region" "jan" "feb" } { "eu" 1 2 "us" 3 4 }
+	//    |unpivot 'region 'month 'amount |column? "amount"
+	//  } { 1 2 3 4 }
+	// Args:
+	// * table
+	// * keep - column name or block of column names that stay as they are
+	// * name - name of the new column with the former column names
+	// * value - name of the new column with the values
+	// Returns:
+	// * table with a row for each row and other column of the input table
+	"unpivot": {
+		Argsn: 4,
+		Doc:   "Reshapes a table so its columns (except the kept ones) become rows of column name and value.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			arg0, errObj := MaterializeLazy(ps, arg0, "unpivot")
+			if errObj != nil {
+				return errObj
+			}
+			var spr *env.Table
+			switch s := arg0.(type) {
+			case env.Table:
+				spr = &s
+			case *env.Table:
+				spr = s
+			default:
+				return MakeArgError(ps, 1, []env.Type{env.TableType}, "unpivot")
+			}
+			keep, errObj := tableColumnNames(ps, arg1, 2, "unpivot")
+			if errObj != nil {
+				return errObj
+			}
+			names, errObj := tableColumnNames(ps, arg2, 3, "unpivot")
+			if errObj != nil {
+				return errObj
+			}
+			values, errObj := tableColumnNames(ps, arg3, 4, "unpivot")
+			if errObj != nil {
+				return errObj
+			}
+			if len(names) != 1 || len(values) != 1 {
+				return MakeBuiltinError(ps, "Unpivot needs one name and one value column name.", "unpivot")
+			}
+			return Unpivot(ps, spr, keep, names[0], values[0])
 		},
 	},
 
@@ -2209,6 +2629,124 @@ var Builtins_table = map[string]*env.Builtin{
 		},
 	},
 
+	// Example:
+	//  Load\csv\lazy %access-log.csv |where-equal "status" "500" |column? "path"
+	// Tests:
+	//  equal {
+	//	 cc os
+	//   f:: mktmp ++ "/lazy.csv"
+	//   table { "a" "b" } { 1 "x" 2 "y" 3 "x" } |Save\csv* f
+	//   Load\csv\lazy f |where-equal 'b "x" |column? 'a
+	//  } { "1" "3" }
+	//  equal {
+	//	 cc os
+	//   f:: mktmp ++ "/lazy.csv"
+	//   table { "a" "b" } { 1 "x" 2 "y" 3 "x" } |Save\csv* f
+	//   Load\csv\lazy f |autotype 1.0 |where-greater 'a 1 |column? 'a
+	//  } { 2 3 }
+	//  equal {
+	//	 cc os
+	//   f:: mktmp ++ "/lazy.csv"
+	//   table { "a" "b" } { 1 "x" 2 "y" 3 "x" } |Save\csv* f
+	//   l:: Load\csv\lazy f
+	//   [ ( first l ) -> "b" ( nth l 3 ) -> "a" ]
+	//  } { "x" "3" }
+	// Args:
+	// * file-uri - location of csv file to load
+	// Returns:
+	// * lazy table, the rows are read each time the table is consumed
+	//   where-*, fill-void, gen-column, columns?, drop-column, distinct and autotype pass the rows on in chunks,
+	//   column?, count-where, group-by, head, first, nth, length? and is-empty stream them and
+	//   order-by, joins, pivot, unpivot, Save\csv, Save\tsv, format\csv and format\tsv read them into memory
+	// Tags: #table #loading #csv
+	"file-uri//Load\\csv\\lazy": {
+		Argsn: 1,
+		Doc:   "Returns a lazy table of a .csv file, rows are streamed through where-*, gen-column, distinct, ... and only read into memory by materialize or builtins that need all of them.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			switch file := arg0.(type) {
+			case env.Uri:
+				return LoadCsvLazy(ps, file.GetPath(), ',', "Load\\csv\\lazy")
+			default:
+				return MakeArgError(ps, 1, []env.Type{env.UriType}, "Load\\csv\\lazy")
+			}
+		},
+	},
+
+	// Example:
+	//  Load\tsv\lazy %export.tsv |distinct 'user |length?
+	// Tests:
+	//  equal {
+	//	 cc os
+	//   f:: mktmp ++ "/lazy.tsv"
+	//   table { "user" "n" } { "a" 1 "b" 2 "a" 3 } |Save\tsv* f
+	//   Load\tsv\lazy f |distinct 'user |length?
+	//  } 2
+	//  equal {
+	//	 cc os
+	//   f:: mktmp ++ "/lazy.tsv"
+	//   table { "user" "n" } { "a" 1 "b" 2 "a" 3 } |Save\tsv* f
+	//   Load\tsv\lazy f |where-equal 'user "a" |column? 'n
+	//  } { "1" "3" }
+	//  equal {
+	//	 cc os
+	//   f:: mktmp ++ "/lazy.tsv"
+	//   table { "user" "n" } { "a" 1 "b" 2 "a" 3 } |Save\tsv* f
+	//   Load\tsv\lazy f |drop-column 'user |order-by 'n 'desc |column? 'n
+	//  } { "3" "2" "1" }
+	//  equal {
+	//	 cc os
+	//   f:: mktmp ++ "/lazy.tsv"
+	//   table { "user" "n" } { "a" 1 "b" 2 "a" 3 } |Save\tsv* f
+	//   Load\tsv\lazy f |count-where 'user { = "a" }
+	//  } 2
+	// Args:
+	// * file-uri - location of tsv file to load
+	// Returns:
+	// * lazy table, the rows are read each time the table is consumed
+	// Tags: #table #loading #csv
+	"file-uri//Load\\tsv\\lazy": {
+		Argsn: 1,
+		Doc:   "Returns a lazy table of a .tsv file, rows are streamed through where-*, gen-column, distinct, ... and only read into memory by materialize or builtins that need all of them.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			switch file := arg0.(type) {
+			case env.Uri:
+				return LoadCsvLazy(ps, file.GetPath(), '\t', "Load\\tsv\\lazy")
+			default:
+				return MakeArgError(ps, 1, []env.Type{env.UriType}, "Load\\tsv\\lazy")
+			}
+		},
+	},
+
+	// Example:
+	//  Load\csv\lazy %big.csv |where-contains 'path "/api/" |materialize |order-by! 'time 'desc
+	// Tests:
+	//  equal { table { 'a } { 1 2 } |materialize |length? } 2
+	// Args:
+	// * table - lazy or in-memory table
+	// Returns:
+	// * table with all rows in memory, an in-memory table is returned as it is
+	// Tags: #table #loading
+	"materialize": {
+		Argsn: 1,
+		Doc:   "Reads all rows of a lazy table through its stages into an in-memory table.",
+		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			switch spr := arg0.(type) {
+			case *env.LazyTable:
+				t, err := spr.Materialize()
+				if err != nil {
+					return lazyTableError(ps, err, "materialize")
+				}
+				return *t
+			case env.Table:
+				return spr
+			case *env.Table:
+				return spr
+			default:
+				return MakeArgError(ps, 1, []env.Type{env.TableType, env.LazyTableType}, "materialize")
+			}
+		},
+	},
+
 	// Example:
 	//  equal {
 	//	 cc os
@@ -2226,6 +2764,10 @@ var Builtins_table = map[string]*env.Builtin{
 		Argsn: 2,
 		Doc:   "Saves a table to a .csv file.",
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			arg1, errObj := MaterializeLazy(ps, arg1, "file-uri//Save\\csv")
+			if errObj != nil {
+				return errObj
+			}
 			switch file := arg0.(type) {
 			case env.Uri:
 				switch spr := arg1.(type) {
@@ -2306,6 +2848,10 @@ var Builtins_table = map[string]*env.Builtin{
 		Argsn: 2,
 		Doc:   "Saves a table to a .tsv file.",
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			arg1, errObj := MaterializeLazy(ps, arg1, "file-uri//Save\\tsv")
+			if errObj != nil {
+				return errObj
+			}
 			switch file := arg0.(type) {
 			case env.Uri:
 				switch spr := arg1.(type) {
@@ -2595,15 +3141,31 @@ var Builtins_table = map[string]*env.Builtin{
 	// ##### Persistent Tables #####  "Functions for persistent tables using BadgerDB."
 	//
 
+	// Opens a table stored in a BadgerDB database, creating the database and the table if needed.
+	// Every change (add-rows!, update-row!, remove-row!, add-indexes!) is written to disk before
+	// it returns. Filtering functions (where-*) work on the current rows and use the indexes.
+	// Cells can be integers, decimals, strings, booleans, dates, datetimes, words or void.
+	// Example:
+	//  people: persistent-table { "name" "age" } "data/db" "people"
+	//  people .add-rows! { "Jim" 30 "Anne" 25 }
+	//  people .add-indexes! { name }
+	//  people .where-equal 'name "Anne"
+	//  people .close-persistent-table!
 	// Tests:
-	//  ; equal { persistent-table { "a" "b" } "/tmp/test_db" "test_table" |type? } 'persistent-table
+	//  equal { pt: persistent-table { "a" "b" } "/tmp/rye_test_db" "test_table" , pt .close-persistent-table! , type? pt } 'persistenttable
+	//  equal { pt: persistent-table { "a" "b" } "/tmp/rye_test_db" "test_table" , n: pt .length? , pt .add-rows! { 1 2 3 4 } , pt .close-persistent-table! , pt2: persistent-table { "a" "b" } "/tmp/rye_test_db" "test_table" , m: pt2 .length? , pt2 .close-persistent-table! , m - n } 2
+	//  equal { pt: persistent-table { "a" "b" } "/tmp/rye_test_db" "test_table" , pt .add-indexes! { a } , pt .add-rows! { 7 8 } , res: pt .where-equal 'a 7 |length? , pt .close-persistent-table! , res > 0 } true
+	//  error { persistent-table { "x" } "/tmp/rye_test_db" "test_table" }
+	//  error { persistent-table { "a" "b" } "/tmp/rye_test_db" 'test_table }
 	// Args:
-	//  * columns - block of column names
-	//  * db-path - path to BadgerDB database
+	//  * columns - block of column names, must match the columns of an existing table
+	//  * db-path - path to BadgerDB database directory, it can hold many tables
 	//  * table-name - name of the table
+	// Returns:
+	//  * persistent table
 	"persistent-table": {
 		Argsn: 3,
-		Doc:   "Creates a persistent table using BadgerDB for storage",
+		Doc:   "Opens or creates a persistent table stored in BadgerDB.",
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
 			cols, err := ColNames(ps, arg0, "persistent-table")
 			if err != nil {
@@ -2614,7 +3176,7 @@ var Builtins_table = map[string]*env.Builtin{
 			case env.String:
 				switch tableName := arg2.(type) {
 				case env.String:
-					pt, err := env.NewPersistentTable(cols, dbPath.Value, tableName.Value)
+					pt, err := env.NewPersistentTable(ps.Idx, cols, dbPath.Value, tableName.Value)
 					if err != nil {
 						return MakeBuiltinError(ps, fmt.Sprintf("Failed to create persistent table: %v", err), "persistent-table")
 					}
@@ -2629,8 +3191,10 @@ var Builtins_table = map[string]*env.Builtin{
 	},
 
 	// Tests:
-	//  ; equal { pt: persistent-table { "a" "b" } "/tmp/test_db" "test_table"
-	//  ;        pt .close-persistent-table! |type? } 'persistent-table
+	//  equal { pt: persistent-table { "a" "b" } "/tmp/rye_test_db" "test_table" , pt .close-persistent-table! |type? } 'persistenttable
+	//  error { pt: persistent-table { "a" "b" } "/tmp/rye_test_db" "test_table" , pt .close-persistent-table! , pt .add-rows! { 1 2 } }
+	//  equal { pt: persistent-table { "a" "b" } "/tmp/rye_test_db" "test_table" , n: pt .length? , pt .close-persistent-table! , try { pt .remove-row! 1 } , pt .length? - n } 0
+	//  error { close-persistent-table! table { "a" } { 1 } }
 	// Args:
 	//  * persistent-table - the persistent table to close
 	"close-persistent-table!": {
@@ -2782,6 +3346,10 @@ var Builtins_table = map[string]*env.Builtin{
 		Argsn: 1,
 		Doc:   "Converts a table to a CSV string.",
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			arg0, errObj := MaterializeLazy(ps, arg0, "format\\csv")
+			if errObj != nil {
+				return errObj
+			}
 			switch spr := arg0.(type) {
 			case env.Table:
 				var buf strings.Builder
@@ -2842,6 +3410,10 @@ var Builtins_table = map[string]*env.Builtin{
 		Argsn: 1,
 		Doc:   "Converts a table to a TSV string.",
 		Fn: func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			arg0, errObj := MaterializeLazy(ps, arg0, "format\\tsv")
+			if errObj != nil {
+				return errObj
+			}
 			switch spr := arg0.(type) {
 			case env.Table:
 				var buf strings.Builder
@@ -3260,6 +3832,20 @@ func DropColumnBlock(ps *env.ProgramState, s env.Table, names env.Block) env.Obj
 	return DropColumns(ps, s, toDrop)
 }
 
+// DropColumnArg removes the column or the block of columns from the table
+func DropColumnArg(ps *env.ProgramState, spr env.Table, col env.Object) env.Object {
+	switch rmCol := col.(type) {
+	case env.Word:
+		return DropColumn(ps, spr, *env.NewString(ps.Idx.GetWord(rmCol.Index)))
+	case env.String:
+		return DropColumn(ps, spr, rmCol)
+	case env.Block:
+		return DropColumnBlock(ps, spr, rmCol)
+	default:
+		return MakeArgError(ps, 2, []env.Type{env.WordType, env.StringType, env.BlockType}, "drop-column")
+	}
+}
+
 func DropColumn(ps *env.ProgramState, s env.Table, name env.String) env.Object {
 	return DropColumns(ps, s, []env.String{name})
 }
@@ -3325,6 +3911,53 @@ func RenameColumn(ps *env.ProgramState, s *env.Table, oldName env.String, newNam
 	return s
 }
 
+// GenColumn adds the column newCol to the table, its values are computed from the fromCols columns
+// by the code, or by a regexp replacement of the fromCols column
+func GenColumn(ps *env.ProgramState, spr env.Table, newCol env.Object, fromCols env.Object, code env.Object) env.Object {
+	switch newCol := newCol.(type) {
+	case env.Word:
+		switch fromCols := fromCols.(type) {
+		case env.Block:
+			switch code := code.(type) {
+			case env.Block:
+				return GenerateColumn(ps, spr, newCol, fromCols, code)
+			default:
+				return MakeArgError(ps, 4, []env.Type{env.BlockType}, "gen-column")
+			}
+		case env.Word:
+			switch replaceBlock := code.(type) {
+			case env.Block:
+				if replaceBlock.Series.Len() != 2 {
+					return MakeBuiltinError(ps, "Replacement block must contain a regex object and replacement string.", "genadd-umn")
+				}
+				regexNative, ok := replaceBlock.Series.S[0].(env.Native)
+				if !ok {
+					return MakeBuiltinError(ps, "First element of replacement block must be a regex object.", "genaddumn")
+				}
+				regex, ok := regexNative.Value.(*regexp.Regexp)
+				if !ok {
+					return MakeBuiltinError(ps, "First element of replacement block must be a regex object.", "gen-column")
+				}
+				replaceStr, ok := replaceBlock.Series.S[1].(env.String)
+				if !ok {
+					return MakeBuiltinError(ps, "Second element of replacement block must be a string.", "gen-column")
+				}
+				err := GenerateColumnRegexReplace(ps, &spr, newCol, fromCols, regex, replaceStr.Value)
+				if err != nil {
+					return err
+				}
+				return spr
+			default:
+				return MakeArgError(ps, 3, []env.Type{env.BlockType}, "gen-column")
+			}
+		default:
+			return MakeArgError(ps, 3, []env.Type{env.BlockType}, "gen-column")
+		}
+	default:
+		return MakeArgError(ps, 2, []env.Type{env.WordType}, "gen-column")
+	}
+}
+
 func GenerateColumn(ps *env.ProgramState, s env.Table, name env.Word, extractCols env.Block, code env.Block) env.Object {
 	// add name to columns
 	s.Cols = append(s.Cols, ps.Idx.GetWord(name.Index))
@@ -3563,6 +4196,46 @@ func WhereNotIn(ps *env.ProgramState, s *env.Table, name string, b []env.Object)
 	return *nspr
 }
 
+// Where returns the rows of the table where the code returns a truthy value for the column's value,
+// col is a column name or a block of column names
+func Where(ps *env.ProgramState, spr *env.Table, col env.Object, code env.Object) env.Object {
+	switch col := col.(type) {
+	case env.Word:
+		colName := ps.Idx.GetWord(col.Index)
+		switch block := code.(type) {
+		case env.Block:
+			return WhereBlock(ps, spr, colName, block)
+		case env.Builtin:
+			return WhereBuiltin(ps, spr, colName, block)
+		case env.Function:
+			return WhereFunction(ps, spr, colName, block)
+		default:
+			return MakeArgError(ps, 3, []env.Type{env.BlockType, env.BuiltinType, env.FunctionType}, "where")
+		}
+	case env.String:
+		colName := col.Value
+		switch block := code.(type) {
+		case env.Block:
+			return WhereBlock(ps, spr, colName, block)
+		case env.Builtin:
+			return WhereBuiltin(ps, spr, colName, block)
+		case env.Function:
+			return WhereFunction(ps, spr, colName, block)
+		default:
+			return MakeArgError(ps, 3, []env.Type{env.BlockType, env.BuiltinType, env.FunctionType}, "where")
+		}
+	case env.Block:
+		switch block := code.(type) {
+		case env.Block:
+			return WhereBlockMultiCol(ps, spr, col, block)
+		default:
+			return MakeArgError(ps, 3, []env.Type{env.BlockType}, "where")
+		}
+	default:
+		return MakeArgError(ps, 2, []env.Type{env.WordType, env.StringType, env.BlockType}, "where")
+	}
+}
+
 func WhereBlock(ps *env.ProgramState, s *env.Table, name string, block env.Block) env.Object {
 	idx := slices.Index(s.Cols, name)
 	if idx < 0 {
@@ -3681,6 +4354,12 @@ func WhereBlockMultiCol(ps *env.ProgramState, s *env.Table, cols env.Block, code
 }
 
 func Distinct(ps *env.ProgramState, s *env.Table, colNames []string) env.Object {
+	return DistinctSeen(ps, s, colNames, make(map[string]bool))
+}
+
+// DistinctSeen skips rows with keys that are already in seen and adds the keys of the rows it
+// keeps, so a lazy table can be deduplicated chunk by chunk
+func DistinctSeen(ps *env.ProgramState, s *env.Table, colNames []string, seen map[string]bool) env.Object {
 	// Get column indices
 	colIdxs := make([]int, len(colNames))
 	for i, name := range colNames {
@@ -3692,7 +4371,6 @@ func Distinct(ps *env.ProgramState, s *env.Table, colNames []string) env.Object
 	}
 
 	nspr := env.NewTable(s.Cols)
-	seen := make(map[string]bool)
 
 	for _, row := range s.Rows {
 		// Build key from column values
@@ -3741,6 +4419,46 @@ func FillVoid(ps *env.ProgramState, s *env.Table, colName string, fillValue env.
 	return *nspr
 }
 
+// CountWhere returns the number of rows where the code returns a truthy value for the column's value,
+// col is a column name or a block of column names
+func CountWhere(ps *env.ProgramState, spr *env.Table, col env.Object, code env.Object) env.Object {
+	switch col := col.(type) {
+	case env.Word:
+		colName := ps.Idx.GetWord(col.Index)
+		switch block := code.(type) {
+		case env.Block:
+			return CountWhereBlock(ps, spr, colName, block)
+		case env.Builtin:
+			return CountWhereBuiltin(ps, spr, colName, block)
+		case env.Function:
+			return CountWhereFunction(ps, spr, colName, block)
+		default:
+			return MakeArgError(ps, 3, []env.Type{env.BlockType, env.BuiltinType, env.FunctionType}, "count-where")
+		}
+	case env.String:
+		colName := col.Value
+		switch block := code.(type) {
+		case env.Block:
+			return CountWhereBlock(ps, spr, colName, block)
+		case env.Builtin:
+			return CountWhereBuiltin(ps, spr, colName, block)
+		case env.Function:
+			return CountWhereFunction(ps, spr, colName, block)
+		default:
+			return MakeArgError(ps, 3, []env.Type{env.BlockType, env.BuiltinType, env.FunctionType}, "count-where")
+		}
+	case env.Block:
+		switch block := code.(type) {
+		case env.Block:
+			return CountWhereBlockMultiCol(ps, spr, col, block)
+		default:
+			return MakeArgError(ps, 3, []env.Type{env.BlockType}, "count-where")
+		}
+	default:
+		return MakeArgError(ps, 2, []env.Type{env.WordType, env.StringType, env.BlockType}, "count-where")
+	}
+}
+
 func CountWhereBlock(ps *env.ProgramState, s *env.Table, name string, block env.Block) env.Object {
 	idx := slices.Index(s.Cols, name)
 	if idx < 0 {
@@ -3916,12 +4634,26 @@ func WhereBetween(ps *env.ProgramState, s *env.Table, name string, val1 env.Obje
 }
 
 func AutoType(ps *env.ProgramState, s *env.Table, percent float64) env.Object {
-	colTypeCount := make(map[int]map[string]int)
-	for i := range s.Cols {
+	colTypeCount := newAutoTypeCounts(len(s.Cols))
+	countAutoTypes(colTypeCount, s.Rows)
+	return *convertAutoTypes(s, autoTypeColumns(colTypeCount, len(s.Rows), percent))
+}
+
+func newAutoTypeCounts(nCols int) []map[string]int {
+	colTypeCount := make([]map[string]int, nCols)
+	for i := range colTypeCount {
 		colTypeCount[i] = make(map[string]int)
 	}
-	for _, row := range s.Rows {
+	return colTypeCount
+}
+
+// countAutoTypes counts the values of each column that look like integers, decimals or strings
+func countAutoTypes(colTypeCount []map[string]int, rows []env.TableRow) {
+	for _, row := range rows {
 		for i, val := range row.Values {
+			if i >= len(colTypeCount) {
+				break
+			}
 			switch stringVal := val.(type) {
 			case env.String:
 				if _, err := strconv.Atoi(stringVal.Value); err == nil {
@@ -3940,25 +4672,34 @@ func AutoType(ps *env.ProgramState, s *env.Table, percent float64) env.Object {
 			}
 		}
 	}
+}
 
-	lenRows := len(s.Rows)
-	newS := env.NewTable(s.Cols)
-	for range s.Rows {
-		newRow := make([]any, len(s.Cols))
-		newS.AddRow(*env.NewTableRow(newRow, newS))
-	}
-
+// autoTypeColumns picks the type of each column, the percent of rows that must have it
+func autoTypeColumns(colTypeCount []map[string]int, lenRows int, percent float64) []string {
+	types := make([]string, len(colTypeCount))
 	for colNum, typeCount := range colTypeCount {
 		minRows := int(float64(lenRows) * percent)
-		var newType string
 		// if there's a mix of floats and ints, make it a float
 		if typeCount["dec"] > 0 && typeCount["dec"]+typeCount["int"] >= minRows {
-			newType = "dec"
+			types[colNum] = "dec"
 		} else if typeCount["int"] >= minRows {
-			newType = "int"
+			types[colNum] = "int"
 		} else {
-			newType = "str"
+			types[colNum] = "str"
 		}
+	}
+	return types
+}
+
+// convertAutoTypes returns a table with the values of the columns converted to the types
+func convertAutoTypes(s *env.Table, types []string) *env.Table {
+	newS := env.NewTable(s.Cols)
+	for range s.Rows {
+		newRow := make([]any, len(s.Cols))
+		newS.AddRow(*env.NewTableRow(newRow, newS))
+	}
+
+	for colNum, newType := range types {
 		for i, row := range s.Rows {
 			switch newType {
 			case "int":
@@ -3993,229 +4734,582 @@ func AutoType(ps *env.ProgramState, s *env.Table, percent float64) env.Object {
 		}
 	}
 
-	return *newS
+	return newS
 }
 
-func LeftJoin(ps *env.ProgramState, s1 env.Table, s2 env.Table, col1 string, col2 string, innerJoin bool) env.Object {
-	if !slices.Contains(s1.Cols, col1) {
-		return MakeBuiltinError(ps, "Column not found in first table.", "left-join")
+func joinBuiltin(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, kind string, fnName string) env.Object {
+	// joins need all rows of both tables
+	arg0, errObj := MaterializeLazy(ps, arg0, fnName)
+	if errObj != nil {
+		return errObj
+	}
+	arg1, errObj = MaterializeLazy(ps, arg1, fnName)
+	if errObj != nil {
+		return errObj
 	}
-	if !slices.Contains(s2.Cols, col2) {
-		return MakeBuiltinError(ps, "Column not found in second table.", "left-join")
+	var s1, s2 env.Table
+	switch spr := arg0.(type) {
+	case env.Table:
+		s1 = spr
+	case *env.Table:
+		s1 = *spr
+	default:
+		return MakeArgError(ps, 1, []env.Type{env.TableType}, fnName)
+	}
+	switch spr := arg1.(type) {
+	case env.Table:
+		s2 = spr
+	case *env.Table:
+		s2 = *spr
+	default:
+		return MakeArgError(ps, 2, []env.Type{env.TableType}, fnName)
+	}
+	cols1, errObj := tableColumnNames(ps, arg2, 3, fnName)
+	if errObj != nil {
+		return errObj
 	}
+	cols2, errObj := tableColumnNames(ps, arg3, 4, fnName)
+	if errObj != nil {
+		return errObj
+	}
+	return Join(ps, s1, s2, cols1, cols2, kind, fnName)
+}
 
-	combinedCols := make([]string, len(s1.Cols)+len(s2.Cols))
-	copy(combinedCols, s1.Cols)
-	for i, v := range s2.Cols {
-		if slices.Contains(combinedCols, v) {
-			combinedCols[i+len(s1.Cols)] = v + "_2"
-		} else {
-			combinedCols[i+len(s1.Cols)] = v
+// joinKey returns the key of the row for a hash join, values of different types never match
+func joinKey(ps *env.ProgramState, row env.TableRow, idxs []int) string {
+	var b strings.Builder
+	for _, idx := range idxs {
+		var val env.Object = env.Void{}
+		if idx < len(row.Values) {
+			val = env.ToRyeValue(row.Values[idx])
 		}
+		b.WriteString(strconv.Itoa(int(val.Type())))
+		b.WriteByte(':')
+		b.WriteString(val.Print(*ps.Idx))
+		b.WriteByte(0)
 	}
-	nspr := env.NewTable(combinedCols)
-	for i, row1 := range s1.GetRows() {
-		val1, err := s1.GetRowValue(col1, row1)
-		if err != nil {
-			return MakeError(ps, fmt.Sprintf("Couldn't retrieve value at row %d (%s)", i, err))
+	return b.String()
+}
+
+// Join is a hash join of two tables on one or more key columns. Kind is left, inner, right, full,
+// semi or anti. Rows of the second table are hashed once, so the join takes linear time.
+func Join(ps *env.ProgramState, s1 env.Table, s2 env.Table, cols1 []string, cols2 []string, kind string, fnName string) env.Object {
+	if len(cols1) == 0 || len(cols1) != len(cols2) {
+		return MakeBuiltinError(ps, "Both tables need the same number of key columns.", fnName)
+	}
+	idxs1 := make([]int, len(cols1))
+	idxs2 := make([]int, len(cols2))
+	for i := range cols1 {
+		idxs1[i] = slices.Index(s1.Cols, cols1[i])
+		if idxs1[i] < 0 {
+			return MakeBuiltinError(ps, "Column "+cols1[i]+" not found in first table.", fnName)
+		}
+		idxs2[i] = slices.Index(s2.Cols, cols2[i])
+		if idxs2[i] < 0 {
+			return MakeBuiltinError(ps, "Column "+cols2[i]+" not found in second table.", fnName)
+		}
+	}
+
+	if kind == "right" {
+		// a right join is a left join with the tables swapped, the columns keep their order
+		build := make(map[string][]int)
+		for i, row := range s1.Rows {
+			key := joinKey(ps, row, idxs1)
+			build[key] = append(build[key], i)
+		}
+		nspr := env.NewTable(joinedColumns(s1.Cols, s2.Cols))
+		for _, row2 := range s2.Rows {
+			matches := build[joinKey(ps, row2, idxs2)]
+			if len(matches) == 0 {
+				nspr.AddRow(*env.NewTableRow(joinedRow(nil, row2.Values, len(s1.Cols), len(s2.Cols)), nspr))
+			}
+			for _, i := range matches {
+				nspr.AddRow(*env.NewTableRow(joinedRow(s1.Rows[i].Values, row2.Values, len(s1.Cols), len(s2.Cols)), nspr))
+			}
+		}
+		return *nspr
+	}
+
+	build := make(map[string][]int)
+	for j, row := range s2.Rows {
+		key := joinKey(ps, row, idxs2)
+		build[key] = append(build[key], j)
+	}
+
+	if kind == "semi" || kind == "anti" {
+		nspr := env.NewTable(slices.Clone(s1.Cols))
+		for _, row1 := range s1.Rows {
+			_, found := build[joinKey(ps, row1, idxs1)]
+			if found == (kind == "semi") {
+				nspr.AddRow(*env.NewTableRow(row1.Values, nspr))
+			}
 		}
+		return *nspr
+	}
 
-		// the row ids of the second table which match the values in the current first table row
-		var s2RowIds []int
-		// use index if available
-		if ix, ok := s2.Indexes[col2]; ok {
-			if rowIds, ok := ix[val1]; ok {
-				s2RowIds = rowIds
+	nspr := env.NewTable(joinedColumns(s1.Cols, s2.Cols))
+	matched := make([]bool, len(s2.Rows))
+	for _, row1 := range s1.Rows {
+		matches := build[joinKey(ps, row1, idxs1)]
+		if len(matches) == 0 && kind != "inner" {
+			nspr.AddRow(*env.NewTableRow(joinedRow(row1.Values, nil, len(s1.Cols), len(s2.Cols)), nspr))
+		}
+		for _, j := range matches {
+			matched[j] = true
+			nspr.AddRow(*env.NewTableRow(joinedRow(row1.Values, s2.Rows[j].Values, len(s1.Cols), len(s2.Cols)), nspr))
+		}
+	}
+	if kind == "full" {
+		for j, row2 := range s2.Rows {
+			if !matched[j] {
+				nspr.AddRow(*env.NewTableRow(joinedRow(nil, row2.Values, len(s1.Cols), len(s2.Cols)), nspr))
 			}
+		}
+	}
+	return *nspr
+}
+
+// joinedColumns returns the columns of both tables, clashing columns of the second table get a _2
+// suffix (or _3, ... if that name is also taken)
+func joinedColumns(cols1 []string, cols2 []string) []string {
+	combined := slices.Clone(cols1)
+	for _, col := range cols2 {
+		name := col
+		for n := 2; slices.Contains(combined, name) || (name != col && slices.Contains(cols2, name)); n++ {
+			name = col + "_" + strconv.Itoa(n)
+		}
+		combined = append(combined, name)
+	}
+	return combined
+}
+
+// joinedRow returns the values of both rows, a missing row has void values
+func joinedRow(vals1 []any, vals2 []any, n1 int, n2 int) []any {
+	newRow := make([]any, n1+n2)
+	for i := range n1 {
+		if vals1 != nil && i < len(vals1) {
+			newRow[i] = vals1[i]
 		} else {
-			for j, row2 := range s2.GetRows() {
-				val2, err := s2.GetRowValue(col2, row2)
-				if err != nil {
-					return MakeError(ps, fmt.Sprintf("Couldn't retrieve value at row %d (%s)", j, err))
-				}
-				val1o, ok := val1.(env.Object)
-				if ok {
-					val2o, ok1 := val2.(env.Object)
-					if ok1 {
-						if val1o.Equal(val2o) {
-							s2RowIds = append(s2RowIds, j)
-							continue
-						}
-					} else {
-						if env.RyeToRaw(val1o, ps.Idx) == val2 {
-							s2RowIds = append(s2RowIds, j)
-							continue
-						}
-					}
-				}
-				val1s, ok := val1.(string)
-				if ok {
-					if val1s == val2.(string) {
-						s2RowIds = append(s2RowIds, j)
-						continue
-					}
-				}
+			newRow[i] = env.Void{}
+		}
+	}
+	for i := range n2 {
+		if vals2 != nil && i < len(vals2) {
+			newRow[n1+i] = vals2[i]
+		} else {
+			newRow[n1+i] = env.Void{}
+		}
+	}
+	return newRow
+}
+
+// tableColumnNames returns the column names of a word, tagword, string or block of them
+func tableColumnNames(ps *env.ProgramState, arg env.Object, argn int, fnName string) ([]string, env.Object) {
+	switch col := arg.(type) {
+	case env.Word:
+		return []string{ps.Idx.GetWord(col.Index)}, nil
+	case env.Tagword:
+		return []string{ps.Idx.GetWord(col.Index)}, nil
+	case env.String:
+		return []string{col.Value}, nil
+	case env.Block:
+		cols := make([]string, col.Series.Len())
+		for c := range col.Series.S {
+			switch ww := col.Series.S[c].(type) {
+			case env.String:
+				cols[c] = ww.Value
+			case env.Tagword:
+				cols[c] = ps.Idx.GetWord(ww.Index)
+			case env.Word:
+				cols[c] = ps.Idx.GetWord(ww.Index)
+			default:
+				return nil, MakeBuiltinError(ps, "Block must contain only strings or words for column names", fnName)
 			}
 		}
-		if innerJoin && len(s2RowIds) == 0 {
+		return cols, nil
+	default:
+		return nil, MakeArgError(ps, argn, []env.Type{env.WordType, env.StringType, env.BlockType}, fnName)
+	}
+}
+
+// TableAggregation is an aggregation of a column's values in a group
+type TableAggregation struct {
+	Name   string // name of the result column
+	Col    string
+	Fun    string
+	Custom env.Object // function or builtin that gets a block of the values
+}
+
+var tableAggregations = []string{"count", "sum", "min", "max", "avg", "median", "stddev", "first", "last", "distinct-count"}
+
+// ParseAggregations parses a block of [name:] 'column function, functions that aren't built in are
+// looked up in the current context. The result column is named column_function if no name is given.
+func ParseAggregations(ps *env.ProgramState, block env.Block, fnName string) ([]TableAggregation, env.Object) {
+	aggs := make([]TableAggregation, 0)
+	name := ""
+	for i := 0; i < len(block.Series.S); i++ {
+		switch item := block.Series.S[i].(type) {
+		case env.Setword:
+			name = ps.Idx.GetWord(item.Index)
 			continue
+		case env.Tagword, env.String:
+		default:
+			return nil, MakeBuiltinError(ps, "Aggregation column must be a word or string", fnName)
 		}
-		buildCombinedRow := func(row1Values []any, row2Values []any) []any {
-			newRow := make([]any, len(combinedCols))
-			copy(newRow, row1Values)
-
-			if row2Values != nil {
-				// copy values from second table
-				for i, v := range row2Values {
-					newRow[i+len(s1.Cols)] = v
-				}
-			} else {
-				// fill with Void{} values when no match
-				for i := len(s1.Cols); i < len(combinedCols); i++ {
-					newRow[i] = env.Void{}
-				}
-			}
-			return newRow
+		if i+1 >= len(block.Series.S) {
+			return nil, MakeBuiltinError(ps, "Aggregation block must contain pairs of column name and function for each aggregation.", fnName)
+		}
+		agg := TableAggregation{}
+		switch col := block.Series.S[i].(type) {
+		case env.Tagword:
+			agg.Col = ps.Idx.GetWord(col.Index)
+		case env.String:
+			agg.Col = col.Value
+		}
+		fun, ok := block.Series.S[i+1].(env.Word)
+		if !ok {
+			return nil, MakeBuiltinError(ps, "Aggregation function must be a word", fnName)
+		}
+		i++
+		var errObj env.Object
+		agg.Fun, agg.Custom, errObj = aggregationFunction(ps, fun.Index, fnName)
+		if errObj != nil {
+			return nil, errObj
+		}
+		agg.Name = name
+		if agg.Name == "" {
+			agg.Name = agg.Col + "_" + agg.Fun
+		}
+		name = ""
+		aggs = append(aggs, agg)
+	}
+	if name != "" {
+		return nil, MakeBuiltinError(ps, "Aggregation name "+name+": must be followed by a column and function", fnName)
+	}
+	return aggs, nil
+}
+
+// aggregationFunction returns the name of the aggregation and the function of a custom one
+func aggregationFunction(ps *env.ProgramState, index int, fnName string) (string, env.Object, env.Object) {
+	name := ps.Idx.GetWord(index)
+	if slices.Contains(tableAggregations, name) {
+		return name, nil, nil
+	}
+	custom, found := ps.Ctx.Get(index)
+	if !found {
+		return "", nil, MakeBuiltinError(ps, fmt.Sprintf("Unknown aggregation function: %s", name), fnName)
+	}
+	switch custom.(type) {
+	case env.Function, env.Builtin:
+		return name, custom, nil
+	default:
+		return "", nil, MakeBuiltinError(ps, fmt.Sprintf("Aggregation %s must be a function", name), fnName)
+	}
+}
+
+// aggState accumulates the values of one aggregation in one group. Only median and custom
+// functions keep the values, the others need constant memory.
+type aggState struct {
+	rows     int
+	n        int     // number of numeric values
+	sum      float64 // sum of numeric values
+	mean, m2 float64 // running mean and sum of squared differences (Welford) for stddev
+	min, max float64
+	first    env.Object
+	last     env.Object
+	distinct map[string]bool
+	values   []env.Object
+}
+
+func (a *aggState) add(ps *env.ProgramState, agg *TableAggregation, val env.Object, fnName string) env.Object {
+	a.rows++
+	switch agg.Fun {
+	case "count":
+		return nil
+	case "first":
+		if a.rows == 1 {
+			a.first = val
 		}
+		return nil
+	case "last":
+		a.last = val
+		return nil
+	case "distinct-count":
+		if val.Type() != env.VoidType {
+			if a.distinct == nil {
+				a.distinct = make(map[string]bool)
+			}
+			a.distinct[val.Print(*ps.Idx)] = true
+		}
+		return nil
+	}
+	if agg.Custom != nil {
+		a.values = append(a.values, val)
+		return nil
+	}
+	var num float64
+	switch v := val.(type) {
+	case env.Integer:
+		num = float64(v.Value)
+	case env.Decimal:
+		num = v.Value
+	case env.Void:
+		// missing values are skipped by numeric aggregations
+		return nil
+	default:
+		return MakeBuiltinError(ps, fmt.Sprintf("Aggregation column %s value must be a number", agg.Col), fnName)
+	}
+	a.n++
+	a.sum += num
+	if a.n == 1 || num < a.min {
+		a.min = num
+	}
+	if a.n == 1 || num > a.max {
+		a.max = num
+	}
+	delta := num - a.mean
+	a.mean += delta / float64(a.n)
+	a.m2 += delta * (num - a.mean)
+	if agg.Fun == "median" {
+		a.values = append(a.values, val)
+	}
+	return nil
+}
 
-		if len(s2RowIds) > 0 {
-			// add a row for each matching record from s2
-			for _, s2RowId := range s2RowIds {
-				row2Values := s2.GetRow(ps, s2RowId).Values
-				combinedValues := buildCombinedRow(row1.Values, row2Values)
-				nspr.AddRow(*env.NewTableRow(combinedValues, nspr))
+func (a *aggState) result(ps *env.ProgramState, agg *TableAggregation) env.Object {
+	if agg.Custom != nil {
+		vals := *env.NewBlock(*env.NewTSeries(a.values))
+		switch fn := agg.Custom.(type) {
+		case env.Function:
+			CallFunctionArgsN(fn, ps, ps.Ctx, vals)
+			return ps.Res
+		case env.Builtin:
+			return DirectlyCallBuiltin(ps, fn, vals, nil)
+		}
+	}
+	switch agg.Fun {
+	case "count":
+		return *env.NewInteger(int64(a.rows))
+	case "first":
+		return a.first
+	case "last":
+		return a.last
+	case "distinct-count":
+		return *env.NewInteger(int64(len(a.distinct)))
+	case "sum":
+		return *env.NewDecimal(a.sum)
+	}
+	if a.n == 0 {
+		return *env.NewVoid()
+	}
+	switch agg.Fun {
+	case "min":
+		return *env.NewDecimal(a.min)
+	case "max":
+		return *env.NewDecimal(a.max)
+	case "avg":
+		return *env.NewDecimal(a.sum / float64(a.n))
+	case "median":
+		nums := make([]float64, len(a.values))
+		for i, v := range a.values {
+			switch v := v.(type) {
+			case env.Integer:
+				nums[i] = float64(v.Value)
+			case env.Decimal:
+				nums[i] = v.Value
 			}
-		} else {
-			// add a single row with Void values for s2 columns
-			combinedValues := buildCombinedRow(row1.Values, nil)
-			nspr.AddRow(*env.NewTableRow(combinedValues, nspr))
 		}
+		slices.Sort(nums)
+		if len(nums)%2 == 1 {
+			return *env.NewDecimal(nums[len(nums)/2])
+		}
+		return *env.NewDecimal((nums[len(nums)/2-1] + nums[len(nums)/2]) / 2)
+	case "stddev":
+		// sample standard deviation
+		if a.n < 2 {
+			return *env.NewVoid()
+		}
+		return *env.NewDecimal(math.Sqrt(a.m2 / float64(a.n-1)))
 	}
-	return *nspr
+	return *env.NewVoid()
+}
+
+type tableGroup struct {
+	vals   []any
+	states []aggState
+}
+
+// TableGrouper groups rows in one pass, rows can be added in several tables (chunks of a lazy table).
+// Groups are kept in the order of their first row.
+type TableGrouper struct {
+	fnName  string
+	cols    []string
+	colIdxs []int
+	aggs    []TableAggregation
+	aggIdxs []int
+	keys    map[string]int
+	groups  []*tableGroup
 }
 
-func GroupBy(ps *env.ProgramState, s env.Table, cols []string, aggregations map[string][]string) env.Object {
-	// Validate that all grouping columns exist
+func NewTableGrouper(ps *env.ProgramState, tableCols []string, cols []string, aggs []TableAggregation, fnName string) (*TableGrouper, env.Object) {
+	g := &TableGrouper{fnName: fnName, cols: cols, aggs: aggs, keys: make(map[string]int)}
 	for _, col := range cols {
-		if !slices.Contains(s.Cols, col) {
-			return MakeBuiltinError(ps, fmt.Sprintf("Column '%s' not found.", col), "group-by")
+		idx := slices.Index(tableCols, col)
+		if idx < 0 {
+			return nil, MakeBuiltinError(ps, fmt.Sprintf("Column '%s' not found.", col), fnName)
+		}
+		g.colIdxs = append(g.colIdxs, idx)
+	}
+	for _, agg := range aggs {
+		idx := slices.Index(tableCols, agg.Col)
+		if idx < 0 {
+			return nil, MakeBuiltinError(ps, fmt.Sprintf("Column '%s' not found.", agg.Col), fnName)
 		}
+		g.aggIdxs = append(g.aggIdxs, idx)
 	}
+	return g, nil
+}
 
-	aggregatesByGroup := make(map[string]map[string]float64)
-	countByGroup := make(map[string]int)
+// Add adds the rows of the table to their groups, it returns an error object or nil
+func (g *TableGrouper) Add(ps *env.ProgramState, s *env.Table) env.Object {
+	keyParts := make([]string, len(g.colIdxs))
 	for i, row := range s.Rows {
-		// Create composite key for multi-column grouping
-		groupKeyParts := make([]string, len(cols))
-		for j, col := range cols {
-			groupingVal, err := s.GetRowValue(col, row)
-			if err != nil {
-				return MakeError(ps, fmt.Sprintf("Couldn't retrieve value at row %d (%s)", i, err))
+		for j, idx := range g.colIdxs {
+			if idx >= len(row.Values) {
+				return MakeError(ps, fmt.Sprintf("Couldn't retrieve value at row %d (row is too short)", i))
 			}
-			var groupValStr string
-			switch val := groupingVal.(type) {
-			case env.String:
-				groupValStr = val.Value
-			case string:
-				groupValStr = val
-			case env.Integer:
-				groupValStr = strconv.Itoa(int(val.Value))
-			case int:
-				groupValStr = strconv.Itoa(val)
-			default:
-				return MakeBuiltinError(ps, "Grouping column value must be a string or number", "group-by")
+			keyParts[j] = env.ToRyeValue(row.Values[idx]).Print(*ps.Idx)
+		}
+		key := strings.Join(keyParts, "\x00")
+		gi, ok := g.keys[key]
+		if !ok {
+			group := &tableGroup{vals: make([]any, len(g.colIdxs)), states: make([]aggState, len(g.aggs))}
+			for j, idx := range g.colIdxs {
+				group.vals[j] = env.ToRyeValue(row.Values[idx])
+			}
+			gi = len(g.groups)
+			g.keys[key] = gi
+			g.groups = append(g.groups, group)
+		}
+		group := g.groups[gi]
+		for a := range g.aggs {
+			idx := g.aggIdxs[a]
+			if idx >= len(row.Values) {
+				return MakeError(ps, fmt.Sprintf("Couldn't retrieve value at row %d (row is too short)", i))
+			}
+			if err := group.states[a].add(ps, &g.aggs[a], env.ToRyeValue(row.Values[idx]), g.fnName); err != nil {
+				return err
 			}
-			groupKeyParts[j] = groupValStr
 		}
-		// Join with "|" separator to create unique composite key
-		groupKey := strings.Join(groupKeyParts, "|")
+	}
+	return nil
+}
 
-		if _, ok := aggregatesByGroup[groupKey]; !ok {
-			aggregatesByGroup[groupKey] = make(map[string]float64)
+// Result returns a table with the grouping columns and an aggregation column for each aggregation
+func (g *TableGrouper) Result(ps *env.ProgramState) env.Object {
+	newCols := slices.Clone(g.cols)
+	for _, agg := range g.aggs {
+		if !slices.Contains(newCols, agg.Name) {
+			newCols = append(newCols, agg.Name)
 		}
-		groupAggregates := aggregatesByGroup[groupKey]
-
-		for aggCol, funs := range aggregations {
-			for _, fun := range funs {
-				colAgg := aggCol + "_" + fun
-				if fun == "count" {
-					// Count aggregation can be applied on any of the grouping columns
-					isGroupingCol := false
-					for _, gcol := range cols {
-						if aggCol == gcol {
-							isGroupingCol = true
-							break
-						}
-					}
-					if !isGroupingCol {
-						return MakeBuiltinError(ps, "Count aggregation can only be applied on the grouping columns", "group-by")
-					}
-					groupAggregates[colAgg]++
-					continue
-				}
-				valObj, err := s.GetRowValue(aggCol, row)
-				if err != nil {
-					return MakeError(ps, fmt.Sprintf("Couldn't retrieve value at row %d (%s)", i, err))
-				}
-				var val float64
-				switch valObj := env.ToRyeValue(valObj).(type) {
-				case env.Integer:
-					val = float64(valObj.Value)
-				case env.Decimal:
-					val = valObj.Value
-				default:
-					return MakeBuiltinError(ps, "Aggregation column value must be a number", "group-by")
-				}
-				switch fun {
-				case "sum":
-					groupAggregates[colAgg] += val
-				case "avg":
-					groupAggregates[colAgg] += val
-					countByGroup[groupKey]++
-				case "min":
-					if min, ok := groupAggregates[colAgg]; !ok || val < min {
-						groupAggregates[colAgg] = val
-					}
-				case "max":
-					if max, ok := groupAggregates[colAgg]; !ok || val > max {
-						groupAggregates[colAgg] = val
-					}
-				default:
-					return MakeBuiltinError(ps, fmt.Sprintf("Unknown aggregation function: %s", fun), "group-by")
-				}
+	}
+	newS := env.NewTable(newCols)
+	for _, group := range g.groups {
+		newRow := make([]any, len(newCols))
+		copy(newRow, group.vals)
+		for a := range g.aggs {
+			val := group.states[a].result(ps, &g.aggs[a])
+			if ps.ErrorFlag {
+				return ps.Res
 			}
+			newRow[slices.Index(newCols, g.aggs[a].Name)] = val
 		}
+		newS.AddRow(*env.NewTableRow(newRow, newS))
+	}
+	return *newS
+}
+
+func GroupBy(ps *env.ProgramState, s env.Table, cols []string, aggs []TableAggregation) env.Object {
+	g, errObj := NewTableGrouper(ps, s.Cols, cols, aggs, "group-by")
+	if errObj != nil {
+		return errObj
+	}
+	if errObj := g.Add(ps, &s); errObj != nil {
+		return errObj
+	}
+	return g.Result(ps)
+}
+
+// Pivot groups the rows by rowCols and the values of pivotCol, and returns a table with a column
+// for each value of pivotCol (in order of appearance) with the aggregation of that group
+func Pivot(ps *env.ProgramState, s *env.Table, rowCols []string, pivotCol string, agg TableAggregation) env.Object {
+	agg.Name = "value"
+	grouped := GroupBy(ps, *s, append(slices.Clone(rowCols), pivotCol), []TableAggregation{agg})
+	gt, ok := grouped.(env.Table)
+	if !ok {
+		return grouped
 	}
 
-	// Create result columns: grouping columns + aggregation columns
-	newCols := make([]string, len(cols))
-	copy(newCols, cols)
-	for aggCol, funs := range aggregations {
-		for _, fun := range funs {
-			newCols = append(newCols, aggCol+"_"+fun)
+	pivotIdx := len(rowCols)
+	newCols := slices.Clone(rowCols)
+	for _, row := range gt.Rows {
+		name := row.Values[pivotIdx].(env.Object).Print(*ps.Idx)
+		if !slices.Contains(newCols[len(rowCols):], name) {
+			newCols = append(newCols, name)
 		}
 	}
 	newS := env.NewTable(newCols)
-
-	for groupKey, groupAggregates := range aggregatesByGroup {
-		newRow := make([]any, len(newCols))
-		// Split the composite key back into individual column values
-		groupKeyParts := strings.Split(groupKey, "|")
-		for i, part := range groupKeyParts {
-			newRow[i] = *env.NewString(part)
+	rowIdx := make(map[string]int)
+	keyParts := make([]string, len(rowCols))
+	for _, row := range gt.Rows {
+		for j := range rowCols {
+			keyParts[j] = row.Values[j].(env.Object).Print(*ps.Idx)
+		}
+		key := strings.Join(keyParts, "\x00")
+		ri, ok := rowIdx[key]
+		if !ok {
+			vals := make([]any, len(newCols))
+			copy(vals, row.Values[:len(rowCols)])
+			for j := len(rowCols); j < len(vals); j++ {
+				vals[j] = *env.NewVoid()
+			}
+			ri = len(newS.Rows)
+			rowIdx[key] = ri
+			newS.AddRow(*env.NewTableRow(vals, newS))
 		}
+		name := row.Values[pivotIdx].(env.Object).Print(*ps.Idx)
+		col := len(rowCols) + slices.Index(newCols[len(rowCols):], name)
+		newS.Rows[ri].Values[col] = row.Values[pivotIdx+1]
+	}
+	return *newS
+}
 
-		// Add aggregation results
-		for i, colName := range newCols[len(cols):] {
-			if strings.HasSuffix(colName, "_count") {
-				newRow[len(cols)+i] = *env.NewInteger(int64(groupAggregates[colName]))
-			} else if strings.HasSuffix(colName, "_avg") {
-				newRow[len(cols)+i] = *env.NewDecimal(groupAggregates[colName] / float64(countByGroup[groupKey]))
-			} else {
-				newRow[len(cols)+i] = *env.NewDecimal(groupAggregates[colName])
+// Unpivot returns a row for each value of the columns not in keep, with the kept values, the column name and the value
+func Unpivot(ps *env.ProgramState, s *env.Table, keep []string, nameCol string, valueCol string) env.Object {
+	keepIdxs := make([]int, len(keep))
+	for i, col := range keep {
+		keepIdxs[i] = slices.Index(s.Cols, col)
+		if keepIdxs[i] < 0 {
+			return MakeBuiltinError(ps, fmt.Sprintf("Column '%s' not found.", col), "unpivot")
+		}
+	}
+	newS := env.NewTable(append(slices.Clone(keep), nameCol, valueCol))
+	for _, row := range s.Rows {
+		for ci, col := range s.Cols {
+			if slices.Contains(keepIdxs, ci) {
+				continue
+			}
+			vals := make([]any, 0, len(keep)+2)
+			for _, idx := range keepIdxs {
+				vals = append(vals, row.Values[idx])
 			}
+			var val any = *env.NewVoid()
+			if ci < len(row.Values) {
+				val = row.Values[ci]
+			}
+			vals = append(vals, *env.NewString(col), val)
+			newS.AddRow(*env.NewTableRow(vals, newS))
 		}
-		newS.AddRow(*env.NewTableRow(newRow, newS))
 	}
 	return *newS
 }
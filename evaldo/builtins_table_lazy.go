@@ -0,0 +1,331 @@
+//go:build !no_table
+// +build !no_table
+
+package evaldo
+
+import (
+	"encoding/csv"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/jinzhu/copier"
+	"github.com/refaktor/rye/env"
+)
+
+// Lazy tables stream their rows in chunks. Table builtins that look at each row on its own add a
+// stage to the pipeline of a lazy table with LazyRows, a few consumers stream the rows to compute
+// their result and builtins that need all rows read them into memory with MaterializeLazy.
+
+// lazyStageError carries the Rye error of a stage out of the pipeline
+type lazyStageError struct {
+	obj       env.Object
+	errorFlag bool // the stage raised an error, not a failure
+}
+
+func (e *lazyStageError) Error() string {
+	if err, ok := e.obj.(*env.Error); ok {
+		return err.Message
+	}
+	return "lazy table stage failed"
+}
+
+// lazyTableError returns the error of a stage as it was, other errors (reading the source) become builtin errors
+func lazyTableError(ps *env.ProgramState, err error, fnName string) env.Object {
+	if se, ok := err.(*lazyStageError); ok {
+		if se.errorFlag {
+			ps.ErrorFlag = true
+		} else if !ps.ErrorFlag {
+			ps.FailureFlag = true
+		}
+		return se.obj
+	}
+	return MakeBuiltinError(ps, err.Error(), fnName)
+}
+
+// LazyTableStage adds a stage to the lazy table. Start is called at the start of each pass with the
+// program state of the pass and returns the function called with each chunk, that returns a table
+// (the rows for the next stage) or an error, and true if the pass can stop. The stage is first run
+// on an empty chunk, so wrong arguments or columns fail right away and not when the rows are read.
+func LazyTableStage(ps *env.ProgramState, lt *env.LazyTable, name string, start func(ps *env.ProgramState) func(chunk env.Table) (env.Object, bool)) env.Object {
+	// rows are read later, maybe by another call or goroutine, so each pass gets its own copy of the
+	// state, code blocks given to the builtin are evaluated in the context where the stage was added
+	psStage := env.ProgramState{}
+	if err := copier.Copy(&psStage, ps); err != nil {
+		return MakeBuiltinError(ps, err.Error(), name)
+	}
+	newState := func() (*env.ProgramState, error) {
+		psPass := &env.ProgramState{}
+		if err := copier.Copy(psPass, &psStage); err != nil {
+			return nil, err
+		}
+		psPass.FailureFlag = false
+		psPass.ErrorFlag = false
+		psPass.ReturnFlag = false
+		return psPass, nil
+	}
+	run := func(ps *env.ProgramState, step func(chunk env.Table) (env.Object, bool), chunk *env.Table) (*env.Table, bool, error) {
+		res, stop := step(*chunk)
+		if ps.ErrorFlag || ps.FailureFlag {
+			return nil, false, &lazyStageError{obj: res, errorFlag: ps.ErrorFlag}
+		}
+		switch t := res.(type) {
+		case env.Table:
+			return &t, stop, nil
+		case *env.Table:
+			return t, stop, nil
+		case *env.Error:
+			return nil, false, &lazyStageError{obj: t}
+		default:
+			return nil, false, &lazyStageError{obj: MakeBuiltinError(ps, "Stage didn't return a table.", name)}
+		}
+	}
+	psProbe, err := newState()
+	if err != nil {
+		return MakeBuiltinError(ps, err.Error(), name)
+	}
+	probe, _, err := run(psProbe, start(psProbe), env.NewTable(slices.Clone(lt.Cols)))
+	if err != nil {
+		return lazyTableError(ps, err, name)
+	}
+	return lt.With(env.LazyStage{
+		Name: name,
+		Cols: probe.Cols,
+		Start: func() func(chunk *env.Table) (*env.Table, bool, error) {
+			psPass, err := newState()
+			if err != nil {
+				return func(chunk *env.Table) (*env.Table, bool, error) {
+					return nil, false, err
+				}
+			}
+			step := start(psPass)
+			return func(chunk *env.Table) (*env.Table, bool, error) {
+				return run(psPass, step, chunk)
+			}
+		},
+	})
+}
+
+// LazyRows adds a stage that calls fn with the program state of the pass and each chunk of rows, fn
+// returns the rows for the next stage
+func LazyRows(ps *env.ProgramState, lt *env.LazyTable, name string, fn func(ps *env.ProgramState, chunk *env.Table) env.Object) env.Object {
+	return LazyTableStage(ps, lt, name, func(ps *env.ProgramState) func(chunk env.Table) (env.Object, bool) {
+		return func(chunk env.Table) (env.Object, bool) {
+			return fn(ps, &chunk), false
+		}
+	})
+}
+
+// LazyCollect streams the rows and joins the blocks fn returns for each chunk
+func LazyCollect(ps *env.ProgramState, lt *env.LazyTable, name string, fn func(chunk *env.Table) env.Object) env.Object {
+	// an empty chunk first, so wrong columns fail before the source is read
+	res := fn(env.NewTable(slices.Clone(lt.Cols)))
+	if ps.ErrorFlag || ps.FailureFlag {
+		return res
+	}
+	vals := make([]env.Object, 0)
+	err := lt.Each(func(chunk *env.Table) (bool, error) {
+		res := fn(chunk)
+		blk, ok := res.(env.Block)
+		if !ok || ps.ErrorFlag || ps.FailureFlag {
+			return false, &lazyStageError{obj: res, errorFlag: ps.ErrorFlag}
+		}
+		vals = append(vals, blk.Series.S...)
+		return false, nil
+	})
+	if err != nil {
+		return lazyTableError(ps, err, name)
+	}
+	return *env.NewBlock(*env.NewTSeries(vals))
+}
+
+// LazyCount streams the rows and sums the integers fn returns for each chunk
+func LazyCount(ps *env.ProgramState, lt *env.LazyTable, name string, fn func(chunk *env.Table) env.Object) env.Object {
+	res := fn(env.NewTable(slices.Clone(lt.Cols)))
+	if ps.ErrorFlag || ps.FailureFlag {
+		return res
+	}
+	var count int64
+	err := lt.Each(func(chunk *env.Table) (bool, error) {
+		res := fn(chunk)
+		n, ok := res.(env.Integer)
+		if !ok || ps.ErrorFlag || ps.FailureFlag {
+			return false, &lazyStageError{obj: res, errorFlag: ps.ErrorFlag}
+		}
+		count += n.Value
+		return false, nil
+	})
+	if err != nil {
+		return lazyTableError(ps, err, name)
+	}
+	return *env.NewInteger(count)
+}
+
+// MaterializeLazy reads all rows of a lazy table into memory, other values are returned as they are
+func MaterializeLazy(ps *env.ProgramState, arg env.Object, fnName string) (env.Object, env.Object) {
+	lt, ok := arg.(*env.LazyTable)
+	if !ok {
+		return arg, nil
+	}
+	t, err := lt.Materialize()
+	if err != nil {
+		return nil, lazyTableError(ps, err, fnName)
+	}
+	return *t, nil
+}
+
+// LazyDistinct adds a stage that passes on the first row for each combination of the columns' values
+func LazyDistinct(ps *env.ProgramState, lt *env.LazyTable, colNames []string) env.Object {
+	return LazyTableStage(ps, lt, "distinct", func(ps *env.ProgramState) func(chunk env.Table) (env.Object, bool) {
+		seen := make(map[string]bool)
+		return func(chunk env.Table) (env.Object, bool) {
+			return DistinctSeen(ps, &chunk, colNames, seen), false
+		}
+	})
+}
+
+// LazyHead adds a stage that passes on the first n rows and then stops reading the source
+func LazyHead(ps *env.ProgramState, lt *env.LazyTable, n int) env.Object {
+	if n < 0 {
+		return MakeBuiltinError(ps, "Lazy table can't exclude rows from the end, materialize it first.", "head")
+	}
+	return LazyTableStage(ps, lt, "head", func(ps *env.ProgramState) func(chunk env.Table) (env.Object, bool) {
+		left := n
+		return func(chunk env.Table) (env.Object, bool) {
+			if len(chunk.Rows) >= left {
+				chunk.Rows = chunk.Rows[:left]
+				left = 0
+				return chunk, true
+			}
+			left -= len(chunk.Rows)
+			return chunk, false
+		}
+	})
+}
+
+// LazyRow reads rows until the one at the (zero based) index and returns it, or nil and the number of rows
+func LazyRow(ps *env.ProgramState, lt *env.LazyTable, idx int, fnName string) (env.Object, int, env.Object) {
+	var row env.Object
+	n := 0
+	err := lt.Each(func(chunk *env.Table) (bool, error) {
+		if idx < n+len(chunk.Rows) {
+			row = chunk.Rows[idx-n]
+			return true, nil
+		}
+		n += len(chunk.Rows)
+		return false, nil
+	})
+	if err != nil {
+		return nil, n, lazyTableError(ps, err, fnName)
+	}
+	return row, n, nil
+}
+
+// LazyAutoType adds a stage that converts the columns to the types AutoType would pick. The types are
+// counted over all rows with a separate pass at the start of each pass, so only the counts are kept.
+func LazyAutoType(ps *env.ProgramState, lt *env.LazyTable, percent float64) env.Object {
+	return LazyTableStage(ps, lt, "autotype", func(ps *env.ProgramState) func(chunk env.Table) (env.Object, bool) {
+		var types []string
+		return func(chunk env.Table) (env.Object, bool) {
+			if len(chunk.Rows) == 0 {
+				return chunk, false
+			}
+			if types == nil {
+				counts := newAutoTypeCounts(len(lt.Cols))
+				n := 0
+				err := lt.Each(func(c *env.Table) (bool, error) {
+					countAutoTypes(counts, c.Rows)
+					n += len(c.Rows)
+					return false, nil
+				})
+				if err != nil {
+					return lazyTableError(ps, err, "autotype"), false
+				}
+				types = autoTypeColumns(counts, n, percent)
+			}
+			return convertAutoTypes(&chunk, types), false
+		}
+	})
+}
+
+// LazyIsEmpty reads rows until the first one passes all stages
+func LazyIsEmpty(ps *env.ProgramState, lt *env.LazyTable) env.Object {
+	empty := true
+	err := lt.Each(func(chunk *env.Table) (bool, error) {
+		empty = false
+		return true, nil
+	})
+	if err != nil {
+		return lazyTableError(ps, err, "is-empty")
+	}
+	return *env.NewBoolean(empty)
+}
+
+// LazyLength streams the rows and counts them
+func LazyLength(ps *env.ProgramState, lt *env.LazyTable) env.Object {
+	n, err := lt.Count()
+	if err != nil {
+		return lazyTableError(ps, err, "length?")
+	}
+	return *env.NewInteger(int64(n))
+}
+
+// csvRowReader reads the rows of a csv file after the header
+type csvRowReader struct {
+	f *os.File
+	r *csv.Reader
+}
+
+func (c *csvRowReader) Next() ([]any, error) {
+	rec, err := c.r.Read()
+	if err != nil {
+		return nil, err
+	}
+	row := make([]any, len(rec))
+	for i, v := range rec {
+		row[i] = *env.NewString(v)
+	}
+	return row, nil
+}
+
+func (c *csvRowReader) Close() error {
+	return c.f.Close()
+}
+
+func openCsvRows(path string, separator rune) (*csvRowReader, []string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	r := csv.NewReader(f)
+	r.Comma = separator
+	r.ReuseRecord = true
+	header, err := r.Read()
+	if err != nil {
+		f.Close()
+		if err == io.EOF {
+			return nil, nil, io.ErrUnexpectedEOF
+		}
+		return nil, nil, err
+	}
+	return &csvRowReader{f, r}, slices.Clone(header), nil
+}
+
+// LoadCsvLazy reads the header of a csv file and returns a lazy table that reads the rows each time it's consumed
+func LoadCsvLazy(ps *env.ProgramState, path string, separator rune, fnName string) env.Object {
+	reader, header, err := openCsvRows(path, separator)
+	if err == io.ErrUnexpectedEOF {
+		return MakeBuiltinError(ps, "File is empty", fnName)
+	}
+	if err != nil {
+		return MakeBuiltinError(ps, "Unable to read input file: "+err.Error(), fnName)
+	}
+	reader.Close()
+	return env.NewLazyTable(header, path, func() (env.RowReader, error) {
+		reader, _, err := openCsvRows(path, separator)
+		if err != nil {
+			return nil, err
+		}
+		return reader, nil
+	})
+}
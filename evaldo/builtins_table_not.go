@@ -0,0 +1,29 @@
+//go:build no_table
+// +build no_table
+
+package evaldo
+
+import (
+	"github.com/refaktor/rye/env"
+)
+
+// Builtins_table is empty when the no_table build tag is active.
+var Builtins_table = map[string]*env.Builtin{}
+
+// Lazy tables are loaded by table builtins, so the collection builtins only get them in builds with tables
+
+func LazyHead(ps *env.ProgramState, lt *env.LazyTable, n int) env.Object {
+	return MakeBuiltinError(ps, "Tables are not included in this build.", "head")
+}
+
+func LazyIsEmpty(ps *env.ProgramState, lt *env.LazyTable) env.Object {
+	return MakeBuiltinError(ps, "Tables are not included in this build.", "is-empty")
+}
+
+func LazyLength(ps *env.ProgramState, lt *env.LazyTable) env.Object {
+	return MakeBuiltinError(ps, "Tables are not included in this build.", "length?")
+}
+
+func LazyRow(ps *env.ProgramState, lt *env.LazyTable, idx int, fnName string) (env.Object, int, env.Object) {
+	return nil, 0, MakeBuiltinError(ps, "Tables are not included in this build.", fnName)
+}
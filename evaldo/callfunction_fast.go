@@ -65,7 +65,10 @@ func FastCallFunction(fn env.Function, ps *env.ProgramState, args []env.Object,
 	psX.WorkingPath = ps.WorkingPath
 	psX.ScriptPath = ps.ScriptPath
 	psX.LiveObj = ps.LiveObj
+	psX.Modules = ps.Modules
+	psX.ImportChain = ps.ImportChain
 	psX.Embedded = ps.Embedded
+	psX.ScriptArgIdx = ps.ScriptArgIdx
 
 	// Evaluate the function body
 	if len(args) > 0 {
@@ -1,6 +1,7 @@
 package evaldo
 
 import (
+	"context"
 	"fmt"
 	"strconv"
 	"strings"
@@ -24,6 +25,36 @@ var NoInspectMode bool
 // behaviour for embedded/WASM/b_norepl builds that never import the console package.
 var OfferDebuggingOptionsHook func(es *env.ProgramState, genv *env.Idxs, tag string) = func(es *env.ProgramState, genv *env.Idxs, tag string) {}
 
+// DebuggerHook is called before each expression of a block is evaluated, when a debugger is attached
+// (see the debugger package) or coverage is recorded (see the cover package). It's nil otherwise,
+// so the evaluator only pays for a nil check.
+var DebuggerHook func(ps *env.ProgramState)
+
+// CallHook and ReturnHook are called around the evaluation of a word that calls a function or a
+// builtin, so with the collection of its arguments, EnterHook and LeaveHook around the code of the
+// function or builtin, when a profiler is attached (see the profiler package). They're nil otherwise.
+var CallHook func(ps *env.ProgramState, word env.Object, fn env.Object)
+var ReturnHook func(ps *env.ProgramState)
+var EnterHook func(ps *env.ProgramState, fn env.Object)
+var LeaveHook func(ps *env.ProgramState)
+
+// SourceLoadedHook is called with the files that are loaded to be evaluated (imported, included and
+// loaded files), when a tool needs to map their code to source lines. It's nil otherwise.
+var SourceLoadedHook func(file string, content string, block env.Block)
+
+// ExitHook is called by the exit builtin before the process exits, so tools can write their reports.
+var ExitHook func()
+
+// SourceLoaded calls the SourceLoadedHook if the file loaded to a block
+func SourceLoaded(file string, content string, loaded env.Object) {
+	if SourceLoadedHook == nil {
+		return
+	}
+	if block, ok := loaded.(env.Block); ok {
+		SourceLoadedHook(file, content, block)
+	}
+}
+
 // EvalBlock is the main entry point for evaluating a block of code.
 // Called from: Throughout the codebase - main evaluation loops, builtins, function calls
 // Purpose: Dispatches to the appropriate dialect-specific evaluator (Rye2, Eyr, Rye0, Rye00)
@@ -88,7 +119,8 @@ func EvalBlockInj_Rye2(ps *env.ProgramState, inj env.Object, injnow bool) {
 	// fmt.Println("---------------------------------------------")
 	// repeats evaluating expressions to the end of the block
 	// nothing is passed between expressions, except through context
-	origInj := inj // save the original injection value so commas always re-inject it
+	origInj := inj  // save the original injection value so commas always re-inject it
+	hookedPos := -1 // an injected value can be consumed without moving, the debugger sees each position once
 	for ps.Ser.Pos() < ps.Ser.Len() {
 		// Check MaxOps limit (instruction tally guard).
 		// MaxOps == 0 means unlimited (default); any positive value caps total expression evaluations.
@@ -100,6 +132,16 @@ func EvalBlockInj_Rye2(ps *env.ProgramState, inj env.Object, injnow bool) {
 				return
 			}
 		}
+		// A cancelled task (task-group, with-timeout) stops at the next expression
+		if ps.TaskCtx != nil && ps.TaskCtx.Err() != nil {
+			ps.ErrorFlag = true
+			ps.Res = env.NewError("Task cancelled: " + context.Cause(ps.TaskCtx).Error())
+			return
+		}
+		if DebuggerHook != nil && ps.Ser.Pos() != hookedPos {
+			hookedPos = ps.Ser.Pos()
+			DebuggerHook(ps)
+		}
 		injnow = EvalExpressionInj(ps, inj, injnow)
 		if ps.Injnow {
 			if ps.Inj != nil {
@@ -1045,6 +1087,15 @@ func EvalWord(ps *env.ProgramState, word env.Object, leftVal env.Object, toLeft
 	// fmt.Println("----EvalObject:0")
 	// If found initially or via methods namespace
 	if found {
+		if CallHook != nil {
+			switch object.Type() {
+			case env.BuiltinType, env.FunctionType:
+				CallHook(ps, word, object)
+				EvalObject(ps, object, leftVal, toLeft, session, pipeSecond, firstVal, opword, dotword)
+				ReturnHook(ps)
+				return
+			}
+		}
 		// Eval the value (object) word was bound to.
 		// session may be nil for regular words (not context paths) - EvalObject handles nil ctx.
 		EvalObject(ps, object, leftVal, toLeft, session, pipeSecond, firstVal, opword, dotword) //ww0128a *
@@ -1509,11 +1560,17 @@ func CallFunction_CollectArgs(fn env.Function, ps *env.ProgramState, arg0_ env.O
 	//	if ctx != nil {
 	//		result = EvalBlockInCtx(es, ctx)
 	//	} else {
+	if EnterHook != nil {
+		EnterHook(ps, fn)
+	}
 	if arg0 != nil {
 		EvalBlockInj(ps, arg0, true)
 	} else {
 		Eval(ps)
 	}
+	if LeaveHook != nil {
+		LeaveHook(ps)
+	}
 	// Handle failure based on ReturnFlag and position:
 	// - If ReturnFlag is set (via ^fail or return), always propagate failure to caller
 	// - If failure is from the last expression of the function body, treat it as a return value
@@ -1700,8 +1757,9 @@ func CallFunctionArgsN(fn env.Function, ps *env.ProgramState, ctx *env.RyeCtx, a
 	}
 	defer cleanup()
 
-	for i, argWord := range fn.Spec.Series.S {
-		index := argWord.(env.Word).Index
+	// the spec can end with a doc string, so only the first Argsn values are words
+	for i := 0; i < fn.Argsn && i < len(args); i++ {
+		index := fn.Spec.Series.S[i].(env.Word).Index
 		psX.Ctx.SetVar(index, args[i])
 	}
 	if len(args) > 0 {
@@ -2035,7 +2093,13 @@ func CallBuiltin_CollectArgs(bi env.Builtin, ps *env.ProgramState, arg0_ env.Obj
 		}
 		ps.Res = bi.Fn(ps, args...)
 	*/
-	ps.Res = bi.Fn(ps, arg0, arg1, arg2, arg3, arg4)
+	if EnterHook != nil {
+		EnterHook(ps, bi)
+		ps.Res = bi.Fn(ps, arg0, arg1, arg2, arg3, arg4)
+		LeaveHook(ps)
+	} else {
+		ps.Res = bi.Fn(ps, arg0, arg1, arg2, arg3, arg4)
+	}
 	if ps.Res == nil {
 		ps.Res = env.NewError4(0, "Builtin returned a invalid value (nil)", nil, nil)
 		ps.ErrorFlag = true
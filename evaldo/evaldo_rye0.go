@@ -567,6 +567,8 @@ func Rye0_CallFunction(fn env.Function, ps *env.ProgramState, arg0 env.Object, t
 	psX.WorkingPath = ps.WorkingPath
 	psX.ScriptPath = ps.ScriptPath
 	psX.LiveObj = ps.LiveObj
+	psX.Modules = ps.Modules
+	psX.ImportChain = ps.ImportChain
 	psX.Embedded = ps.Embedded
 
 	// Evaluate the function body
@@ -0,0 +1,228 @@
+package evaldo
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/refaktor/rye/env"
+	"github.com/refaktor/rye/loader"
+)
+
+// Modules are rye files imported with import. Each one is evaluated once per program in its own
+// context, whose parent are the builtins, so it doesn't see or change the importer's words. The
+// context is returned, the importer names it (import %lib.rye :lib) and reaches its words with
+// context paths (lib/fn). A module that calls export { fn1 fn2 } returns only those words, the
+// others stay private to the module.
+
+// ModuleVendorDir is the directory in the project where installed libraries are kept
+const ModuleVendorDir = "rye_modules"
+
+// ModuleSearchPath returns the directories a module path is looked up in: the directory of the
+// importing file, the project directory, the directories listed in RYE_PATH and the vendored
+// libraries of the project.
+func ModuleSearchPath(ps *env.ProgramState) []string {
+	reg := moduleRegistry(ps)
+	dir, err := filepath.Abs(filepath.Dir(ps.ScriptPath))
+	if err != nil {
+		dir = filepath.Dir(ps.ScriptPath)
+	}
+	dirs := []string{dir}
+	if reg.Root != "" && reg.Root != dir {
+		dirs = append(dirs, reg.Root)
+	}
+	for _, dir := range filepath.SplitList(os.Getenv("RYE_PATH")) {
+		if dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+	if reg.Root != "" {
+		dirs = append(dirs, filepath.Join(reg.Root, ModuleVendorDir))
+	}
+	return dirs
+}
+
+// ResolveModule finds the file of a module. Absolute paths and paths starting with ./ or ../ are
+// only looked up from the importing file, others in all directories of the search path. A path
+// to a directory imports its main.rye.
+func ResolveModule(ps *env.ProgramState, path string) (string, error) {
+	dirs := ModuleSearchPath(ps)
+	if filepath.IsAbs(path) {
+		dirs = []string{""}
+	}
+	if strings.HasPrefix(path, "./") || strings.HasPrefix(path, "../") {
+		dirs = dirs[:1]
+	}
+	for _, dir := range dirs {
+		full := filepath.Join(dir, path)
+		if info, err := os.Stat(full); err == nil && info.IsDir() {
+			// a directory, like an installed package, is imported through its main.rye
+			full = filepath.Join(full, "main.rye")
+		}
+		if info, err := os.Stat(full); err == nil && !info.IsDir() {
+			if abs, err := filepath.Abs(full); err == nil {
+				return abs, nil
+			}
+			return full, nil
+		}
+	}
+	return "", fmt.Errorf("module %s not found in: %s", path, strings.Join(dirs, ", "))
+}
+
+// ImportModule evaluates the module file once and returns its exported context, later imports
+// return the cached context. A module that imports itself through other modules is an error.
+func ImportModule(ps *env.ProgramState, uri env.Uri, fnName string) env.Object {
+	fileIdx, _ := ps.Idx.GetIndex("file")
+	if uri.Scheme.Index != fileIdx {
+		return MakeBuiltinError(ps, "Only file modules can be imported.", fnName)
+	}
+	reg := moduleRegistry(ps)
+
+	var path string
+	var err error
+	if ps.Embedded && ps.EmbeddedFS != nil {
+		// embedded programs have their files under the buildtemp/ prefix
+		path = "buildtemp/" + filepath.ToSlash(filepath.Clean(uri.GetPath()))
+	} else {
+		path, err = ResolveModule(ps, uri.GetPath())
+		if err != nil {
+			return MakeBuiltinError(ps, err.Error(), fnName)
+		}
+	}
+	cached, chain, first := reg.Begin(ps.ImportChain, path)
+	if chain != nil {
+		return MakeBuiltinError(ps, "Circular import: "+strings.Join(chain, " -> "), fnName)
+	}
+	if !first {
+		return cached
+	}
+	mctx, errObj := evalModule(ps, path, fnName)
+	reg.End(path, mctx)
+	if errObj != nil {
+		return errObj
+	}
+	return mctx
+}
+
+// evalModule evaluates the module file in a new context and returns the context with the words it exports
+func evalModule(ps *env.ProgramState, path string, fnName string) (*env.RyeCtx, env.Object) {
+	var content []byte
+	var err error
+	if ps.Embedded && ps.EmbeddedFS != nil {
+		content, err = readEmbeddedFile(ps, path)
+	} else {
+		content, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, MakeBuiltinError(ps, err.Error(), fnName)
+	}
+
+	ser := ps.Ser
+	ctx := ps.Ctx
+	script := ps.ScriptPath
+	chain := ps.ImportChain
+	ps.ScriptPath = path
+	ps.ImportChain = append(slices.Clone(chain), path)
+	defer func() {
+		ps.Ser = ser
+		ps.Ctx = ctx
+		ps.ScriptPath = script
+		ps.ImportChain = chain
+	}()
+	block := loader.LoadString(string(content), false, ps)
+	SourceLoaded(path, string(content), block)
+	mctx := env.NewEnv2(rootContext(ps.Ctx), path)
+	switch blk := block.(type) {
+	case env.Block:
+		ps.Ser = blk.Series
+		ps.Ctx = mctx
+		Eval(ps)
+		MaybeDisplayFailureOrError(ps, ps.Idx, fnName)
+	case env.Error:
+		return nil, MakeBuiltinError(ps, blk.Message, fnName)
+	}
+	if ps.ReturnFlag || ps.ErrorFlag || ps.FailureFlag {
+		return nil, ps.Res
+	}
+	exports := moduleRegistry(ps).Exports(path)
+	if exports == nil {
+		return mctx, nil
+	}
+	// only the exported words are in the returned context, exported functions get the module's
+	// context so they still see the others
+	ectx := env.NewEnv2(mctx.Parent, path)
+	for _, word := range exports {
+		val, ok := mctx.GetCurrent(word)
+		if !ok {
+			return nil, MakeBuiltinError(ps, "Exported word "+ps.Idx.GetWord(word)+" is not defined in "+path, fnName)
+		}
+		if fn, ok := val.(env.Function); ok && fn.Ctx == nil && !fn.Pure {
+			fn.Ctx = mctx
+			val = fn
+		}
+		ectx.Set(word, val)
+	}
+	return ectx, nil
+}
+
+// ExportModuleWords sets the words the module being imported exports. The block holds words,
+// get-words or strings, export can be called more than once.
+func ExportModuleWords(ps *env.ProgramState, block env.Block, fnName string) env.Object {
+	if len(ps.ImportChain) == 0 {
+		return MakeBuiltinError(ps, "Only modules (files that are imported) can export words.", fnName)
+	}
+	words := make([]int, 0, block.Series.Len())
+	for _, obj := range block.Series.S {
+		switch w := obj.(type) {
+		case env.Word:
+			words = append(words, w.Index)
+		case env.Getword:
+			words = append(words, w.Index)
+		case env.String:
+			words = append(words, ps.Idx.IndexWord(w.Value))
+		case env.Comma:
+			continue
+		default:
+			return MakeBuiltinError(ps, "Block of words to export expected.", fnName)
+		}
+	}
+	if !moduleRegistry(ps).Export(ps.ImportChain[len(ps.ImportChain)-1], words) {
+		return MakeBuiltinError(ps, "Words can only be exported while the module is evaluated.", fnName)
+	}
+	return block
+}
+
+func moduleRegistry(ps *env.ProgramState) *env.ModuleRegistry {
+	if ps.Modules == nil {
+		ps.Modules = env.NewModuleRegistry()
+	}
+	if ps.Modules.Root == "" {
+		// the project directory is the one of the main script, or the working directory in the console
+		if ps.ScriptPath != "" {
+			ps.Modules.Root, _ = filepath.Abs(filepath.Dir(ps.ScriptPath))
+		} else {
+			ps.Modules.Root = ps.WorkingPath
+		}
+	}
+	return ps.Modules
+}
+
+func readEmbeddedFile(ps *env.ProgramState, path string) ([]byte, error) {
+	type embedReader interface {
+		ReadFile(name string) ([]byte, error)
+	}
+	if embFS, ok := (*ps.EmbeddedFS).(embedReader); ok {
+		return embFS.ReadFile(path)
+	}
+	return nil, fmt.Errorf("EmbeddedFS does not implement ReadFile")
+}
+
+// rootContext returns the outermost context, the one with the builtins
+func rootContext(ctx *env.RyeCtx) *env.RyeCtx {
+	for ctx.Parent != nil {
+		ctx = ctx.Parent
+	}
+	return ctx
+}
@@ -0,0 +1,183 @@
+package evaldo
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jinzhu/copier"
+	"github.com/refaktor/rye/env"
+	"github.com/refaktor/rye/loader"
+)
+
+// writeModules writes the files into a temporary project directory and returns it
+func writeModules(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// runImporting evaluates code as the main script of the project, with import and export
+// registered like the baseio builtins do
+func runImporting(t *testing.T, dir string, code string) *env.ProgramState {
+	t.Helper()
+	ps := env.NewProgramState()
+	RegisterBuiltins(ps)
+	ps.Ctx.Set(ps.Idx.IndexWord("import"), *env.NewBuiltin(func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+		uri, ok := arg0.(env.Uri)
+		if !ok {
+			return MakeArgError(ps, 1, []env.Type{env.UriType}, "import")
+		}
+		return ImportModule(ps, uri, "import")
+	}, 1, false, false, "import"))
+	ps.Ctx.Set(ps.Idx.IndexWord("export"), *env.NewBuiltin(func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+		block, ok := arg0.(env.Block)
+		if !ok {
+			return MakeArgError(ps, 1, []env.Type{env.BlockType}, "export")
+		}
+		return ExportModuleWords(ps, block, "export")
+	}, 1, false, false, "export"))
+	ps.ScriptPath = filepath.Join(dir, "main.rye")
+	block, ok := loader.LoadString(code, false, ps).(env.Block)
+	if !ok {
+		t.Fatal("code didn't load")
+	}
+	ps = env.AddToProgramStateNEWWithLocation(ps, &block, ps.Idx)
+	EvalBlockInj(ps, nil, false)
+	return ps
+}
+
+func moduleResult(t *testing.T, ps *env.ProgramState) string {
+	t.Helper()
+	if ps.ErrorFlag || ps.FailureFlag {
+		t.Fatalf("import failed: %s", ps.Res.Inspect(*ps.Idx))
+	}
+	return ps.Res.Print(*ps.Idx)
+}
+
+func TestImportIsCached(t *testing.T) {
+	dir := writeModules(t, map[string]string{
+		"counter.rye": "var 'n 0\nbump: does { change! n + 1 'n n }\n",
+	})
+	// the second import returns the same context, so the state of the module is shared
+	ps := runImporting(t, dir, "a: import %counter.rye\nb: import %counter.rye\na/bump\nb/bump\n")
+	if res := moduleResult(t, ps); res != "2" {
+		t.Errorf("expected the second import to share the module, got %s", res)
+	}
+	if loaded := ps.Modules.Loaded(); len(loaded) != 1 || filepath.Base(loaded[0]) != "counter.rye" {
+		t.Errorf("expected counter.rye to be loaded once, got %v", loaded)
+	}
+}
+
+func TestImportCycle(t *testing.T) {
+	dir := writeModules(t, map[string]string{
+		"a.rye": "b: import %b.rye\nx: 1\n",
+		"b.rye": "a: import %a.rye\ny: 2\n",
+	})
+	ps := runImporting(t, dir, "import %a.rye\n")
+	if !ps.ErrorFlag && !ps.FailureFlag {
+		t.Fatal("expected the circular import to fail")
+	}
+	msg := ps.Res.Inspect(*ps.Idx)
+	if !strings.Contains(msg, "Circular import") || !strings.Contains(msg, "a.rye -> ") || !strings.Contains(msg, "b.rye") {
+		t.Errorf("expected the import chain in the error, got %s", msg)
+	}
+	if len(ps.Modules.Loaded()) != 0 {
+		t.Errorf("failed modules shouldn't be cached, got %v", ps.Modules.Loaded())
+	}
+}
+
+func TestImportSearchPath(t *testing.T) {
+	dir := writeModules(t, map[string]string{
+		"lib/util.rye":             "name: \"util\"\n",
+		"rye_modules/pkg/main.rye": "name: \"pkg\"\n",
+		"sub/local.rye":            "name: \"local\"\n",
+		"sub/importer.rye":         "local: import %./local.rye\nname: local/name\n",
+	})
+	shared := writeModules(t, map[string]string{
+		"shared.rye": "name: \"shared\"\n",
+	})
+	t.Setenv("RYE_PATH", shared)
+
+	cases := map[string]string{
+		// the project directory
+		"m: import %lib/util.rye\nm/name\n": "util",
+		// a directory in rye_modules is imported through its main.rye
+		"m: import %pkg\nm/name\n": "pkg",
+		// directories listed in RYE_PATH
+		"m: import %shared.rye\nm/name\n": "shared",
+		// ./ paths are relative to the importing module
+		"m: import %sub/importer.rye\nm/name\n": "local",
+	}
+	for code, expected := range cases {
+		if res := moduleResult(t, runImporting(t, dir, code)); res != expected {
+			t.Errorf("%q: expected %s, got %s", code, expected, res)
+		}
+	}
+
+	ps := runImporting(t, dir, "import %./shared.rye\n")
+	if !ps.FailureFlag && !ps.ErrorFlag {
+		t.Error("expected ./shared.rye to be looked up only next to the script")
+	}
+}
+
+func TestImportExports(t *testing.T) {
+	dir := writeModules(t, map[string]string{
+		"lib.rye":    "export { greet }\nhelper: fn { n } { \"Hi \" ++ n }\ngreet: fn { n } { helper n }\n",
+		"broken.rye": "export { missing }\nx: 1\n",
+	})
+	// exported functions still use the module's private words
+	if res := moduleResult(t, runImporting(t, dir, "lib: import %lib.rye\nlib/greet \"Jo\"\n")); res != "Hi Jo" {
+		t.Errorf("expected the exported function, got %s", res)
+	}
+	if ps := runImporting(t, dir, "lib: import %lib.rye\nlib/helper \"Jo\"\n"); !ps.ErrorFlag && !ps.FailureFlag {
+		t.Errorf("expected the helper to be private, got %s", ps.Res.Inspect(*ps.Idx))
+	}
+	if ps := runImporting(t, dir, "import %broken.rye\n"); !ps.ErrorFlag && !ps.FailureFlag {
+		t.Error("expected exporting an undefined word to fail")
+	}
+	if ps := runImporting(t, dir, "export { x }\n"); !ps.ErrorFlag && !ps.FailureFlag {
+		t.Error("expected export outside of a module to fail")
+	}
+}
+
+func TestImportConcurrently(t *testing.T) {
+	dir := writeModules(t, map[string]string{
+		"slow.rye": "sleep 50\nname: \"slow\"\n",
+	})
+	ps := runImporting(t, dir, "1")
+	uri := *env.NewFileUri(ps.Idx, filepath.Join(dir, "slow.rye"))
+	// program states of goroutines share the registry, the module is evaluated once and no import
+	// sees the other one as a circular import
+	results := make([]env.Object, 4)
+	var wg sync.WaitGroup
+	for i := range results {
+		psG := env.ProgramState{}
+		if err := copier.Copy(&psG, &ps); err != nil {
+			t.Fatal(err)
+		}
+		psG.Idx = ps.Idx // copier gives the copy of the word index its own lock, only the registry is tested here
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = ImportModule(&psG, uri, "import")
+		}()
+	}
+	wg.Wait()
+	for i, res := range results {
+		if res != results[0] {
+			t.Errorf("import %d: expected the same module context, got %s", i, res.Inspect(*ps.Idx))
+		}
+	}
+}
@@ -0,0 +1,28 @@
+#!/bin/sh
+# A scripted MCP client that sends the same requests to the example server over stdio,
+# or over HTTP with "sh client.sh http" while http-server.rye is running.
+
+RYE=${RYE:-rye}
+
+requests() {
+	cat <<'JSON'
+{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-06-18","capabilities":{},"clientInfo":{"name":"client.sh","version":"0.1"}}}
+{"jsonrpc":"2.0","method":"notifications/initialized"}
+{"jsonrpc":"2.0","id":2,"method":"tools/list"}
+{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"add","arguments":{"a":2,"b":40}}}
+{"jsonrpc":"2.0","id":4,"method":"tools/call","params":{"name":"shout","arguments":{"text":"hello"}}}
+{"jsonrpc":"2.0","id":5,"method":"resources/list"}
+{"jsonrpc":"2.0","id":6,"method":"resources/read","params":{"uri":"mem://motd"}}
+{"jsonrpc":"2.0","id":7,"method":"prompts/get","params":{"name":"review","arguments":{"code":"a: 1"}}}
+JSON
+}
+
+if [ "$1" = "http" ]; then
+	requests | while read -r line; do
+		curl -s -X POST -H "Content-Type: application/json" -H "Accept: application/json, text/event-stream" \
+			-d "$line" http://localhost:8080/mcp
+		echo
+	done
+else
+	requests | "$RYE" "$(dirname "$0")/server.rye"
+fi
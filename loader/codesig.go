@@ -5,33 +5,48 @@ package loader
 
 import (
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"strings"
 
 	"github.com/refaktor/rye/security"
 )
 
-func checkCodeSignature(content string) int {
+var (
+	ErrNoCodeSignature      = errors.New("no rye signature found")
+	ErrInvalidCodeSignature = errors.New("rye signature is not valid with any trusted public key")
+)
+
+// VerifyCodeSignature checks the ;ryesig signature at the end of the content against the trusted public keys
+func VerifyCodeSignature(content string) error {
 	parts := strings.SplitN(content, ";ryesig ", 2)
-	content = strings.TrimSpace(parts[0])
 	if len(parts) != 2 {
-		fmt.Println("\x1b[33m" + "No rye signature found. Exiting." + "\x1b[0m")
-		return -1
+		return ErrNoCodeSignature
 	}
-
-	signature := parts[1]
-	sig := strings.TrimSpace(signature)
-	bsig, err := hex.DecodeString(sig)
+	bsig, err := hex.DecodeString(strings.TrimSpace(parts[1]))
 	if err != nil {
-		fmt.Println("\x1b[33m" + "Invalid signature format: " + err.Error() + "\x1b[0m")
-		return -2
+		return fmt.Errorf("invalid signature format: %w", err)
 	}
-
 	// Verify the signature using the security package
-	if security.VerifySignature([]byte(content), bsig) {
-		return 1 // Signature is valid
+	if !security.VerifySignature([]byte(strings.TrimSpace(parts[0])), bsig) {
+		return ErrInvalidCodeSignature
 	}
+	return nil
+}
 
-	fmt.Println("\x1b[33m" + "Rye signature is not valid with any trusted public key! Exiting." + "\x1b[0m")
-	return -2
+func checkCodeSignature(content string) int {
+	err := VerifyCodeSignature(content)
+	switch {
+	case err == nil:
+		return 1 // Signature is valid
+	case errors.Is(err, ErrNoCodeSignature):
+		fmt.Println("\x1b[33m" + "No rye signature found. Exiting." + "\x1b[0m")
+		return -1
+	case errors.Is(err, ErrInvalidCodeSignature):
+		fmt.Println("\x1b[33m" + "Rye signature is not valid with any trusted public key! Exiting." + "\x1b[0m")
+		return -2
+	default:
+		fmt.Println("\x1b[33m" + "Invalid signature format: " + errors.Unwrap(err).Error() + "\x1b[0m")
+		return -2
+	}
 }
@@ -3,6 +3,14 @@
 
 package loader
 
+import "errors"
+
 func checkCodeSignature(content string) int {
 	return 1 // Signature is valid
 }
+
+// VerifyCodeSignature can't check signatures without the security package, so nothing passes as
+// verified in these builds
+func VerifyCodeSignature(content string) error {
+	return errors.New("code signature verification not supported in this build")
+}
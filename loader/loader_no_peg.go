@@ -238,6 +238,12 @@ func (l *Lexer) startToken() {
 	l.tokenStart = l.pos
 }
 
+// TokenSpan returns the byte offsets of the start and the end of the last token in the input,
+// the formatter uses them to copy the tokens as they were written
+func (l *Lexer) TokenSpan() (int, int) {
+	return l.tokenStart, l.pos
+}
+
 // extractSourceLine extracts the source line between two positions
 func (l *Lexer) extractSourceLine(start, end int) string {
 	if start < 0 {
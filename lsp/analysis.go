@@ -0,0 +1,288 @@
+// Package lsp implements a language server for Rye. Syntax errors come from the loader,
+// documentation and arity of builtins come from a program state with all builtins registered.
+package lsp
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/refaktor/rye/env"
+	"github.com/refaktor/rye/loader"
+)
+
+// Token is a lexer token with a 0-based line and column
+type Token struct {
+	Type  int
+	Value string
+	Line  int
+	Col   int
+}
+
+// End returns the column just after the token
+func (t Token) End() int {
+	return t.Col + len(t.Value)
+}
+
+// Definition is a word set in a document with a setword, modword or left-setword
+type Definition struct {
+	Name       string
+	URI        string
+	Line       int
+	Col        int
+	IsFunction bool
+	Args       []string // argument names if the value is a function literal
+}
+
+// Document is an analysed Rye source file
+type Document struct {
+	URI         string
+	Text        string
+	Tokens      []Token
+	Definitions []Definition
+}
+
+// functionMakers are the words that create functions out of a spec and a body block
+var functionMakers = map[string]bool{
+	"fn": true, "fn1": true, "fnc": true, "pfn": true, "does": true, "closure": true, "fn\\par": true, "fn\\in": true,
+}
+
+// NewDocument tokenizes the text and collects the definitions in it
+func NewDocument(uri string, text string) *Document {
+	d := &Document{URI: uri, Text: text}
+	d.Tokens = Tokenize(text)
+	d.Definitions = collectDefinitions(uri, d.Tokens)
+	return d
+}
+
+// Tokenize runs the no-PEG lexer over the text. It stops at the first lexer error,
+// the rest of the document is reported by diagnostics.
+func Tokenize(text string) []Token {
+	toks, _ := tokenize(text)
+	return toks
+}
+
+func tokenize(text string) ([]Token, *Diagnostic) {
+	toks := make([]Token, 0, len(text)/4)
+	// the lexer doesn't count a newline at the very start and counts columns of the first line
+	// from 0 and of others from 1, a leading space makes all lines count from 1
+	lex := loader.NewLexer(" " + text)
+	for {
+		tk := lex.NextToken()
+		col := tk.Col - 1
+		switch tk.Type {
+		case loader.NPEG_TOKEN_EOF:
+			return toks, nil
+		case loader.NPEG_TOKEN_ERROR:
+			return toks, &Diagnostic{Line: max(tk.Line-1, 0), Col: max(col, 0), Message: tk.Value}
+		}
+		toks = append(toks, Token{Type: tk.Type, Value: tk.Value, Line: tk.Line - 1, Col: col})
+	}
+}
+
+// WordName returns the bare word of a word-like token, without sigils like : . | ? '
+func WordName(tok Token) (string, bool) {
+	v := tok.Value
+	switch tok.Type {
+	case loader.NPEG_TOKEN_WORD, loader.NPEG_TOKEN_GENWORD, loader.NPEG_TOKEN_CPATH:
+		return v, true
+	case loader.NPEG_TOKEN_SETWORD:
+		return strings.TrimSuffix(v, ":"), true
+	case loader.NPEG_TOKEN_MODWORD:
+		return strings.TrimSuffix(v, "::"), true
+	case loader.NPEG_TOKEN_LSETWORD:
+		return strings.TrimPrefix(v, ":"), true
+	case loader.NPEG_TOKEN_LMODWORD:
+		return strings.TrimPrefix(v, "::"), true
+	case loader.NPEG_TOKEN_OPWORD, loader.NPEG_TOKEN_DOTWORD:
+		return strings.TrimPrefix(strings.TrimPrefix(v, "."), "*"), true
+	case loader.NPEG_TOKEN_PIPEWORD, loader.NPEG_TOKEN_ONECHARPIPE:
+		return strings.TrimPrefix(v, "|"), true
+	case loader.NPEG_TOKEN_GETWORD:
+		return strings.TrimPrefix(v, "?"), true
+	case loader.NPEG_TOKEN_TAGWORD:
+		return strings.TrimPrefix(v, "'"), true
+	case loader.NPEG_TOKEN_OPCPATH, loader.NPEG_TOKEN_PIPECPATH, loader.NPEG_TOKEN_GETCPATH:
+		return strings.TrimLeft(v, ".|?"), true
+	}
+	return "", false
+}
+
+// IsDefining reports if the token binds a word
+func IsDefining(tok Token) bool {
+	switch tok.Type {
+	case loader.NPEG_TOKEN_SETWORD, loader.NPEG_TOKEN_MODWORD, loader.NPEG_TOKEN_LSETWORD, loader.NPEG_TOKEN_LMODWORD:
+		return true
+	}
+	return false
+}
+
+// IsInjecting reports if the token takes its first argument from the left (op and pipe words)
+func IsInjecting(tok Token) bool {
+	switch tok.Type {
+	case loader.NPEG_TOKEN_OPWORD, loader.NPEG_TOKEN_DOTWORD, loader.NPEG_TOKEN_PIPEWORD, loader.NPEG_TOKEN_ONECHARPIPE, loader.NPEG_TOKEN_OPCPATH, loader.NPEG_TOKEN_PIPECPATH:
+		return true
+	}
+	return false
+}
+
+func collectDefinitions(uri string, toks []Token) []Definition {
+	defs := make([]Definition, 0)
+	for i, tok := range toks {
+		if !IsDefining(tok) {
+			continue
+		}
+		name, _ := WordName(tok)
+		def := Definition{Name: name, URI: uri, Line: tok.Line, Col: tok.Col}
+		// setword followed by a function literal: name: fn { a b } { ... }
+		if tok.Type == loader.NPEG_TOKEN_SETWORD || tok.Type == loader.NPEG_TOKEN_MODWORD {
+			if i+1 < len(toks) && toks[i+1].Type == loader.NPEG_TOKEN_WORD && functionMakers[toks[i+1].Value] {
+				def.IsFunction = true
+				if toks[i+1].Value != "does" && i+2 < len(toks) && toks[i+2].Type == loader.NPEG_TOKEN_BLOCK_START {
+					def.Args = specArgs(toks[i+3:])
+				}
+			}
+		}
+		defs = append(defs, def)
+	}
+	return defs
+}
+
+// specArgs reads argument names of a function spec block, skipping docstrings and
+// type constraint blocks, until the closing brace
+func specArgs(toks []Token) []string {
+	args := make([]string, 0)
+	depth := 0
+	for _, tok := range toks {
+		switch tok.Type {
+		case loader.NPEG_TOKEN_BLOCK_START, loader.NPEG_TOKEN_BBLOCK_START, loader.NPEG_TOKEN_GROUP_START:
+			depth++
+		case loader.NPEG_TOKEN_BLOCK_END, loader.NPEG_TOKEN_BBLOCK_END, loader.NPEG_TOKEN_GROUP_END:
+			if depth == 0 {
+				return args
+			}
+			depth--
+		case loader.NPEG_TOKEN_WORD:
+			if depth == 0 {
+				args = append(args, tok.Value)
+			}
+		}
+	}
+	return args
+}
+
+// TokenAt returns the index of the token under (or just before) the position
+func (d *Document) TokenAt(line int, col int) int {
+	for i, tok := range d.Tokens {
+		if tok.Line == line && tok.Col <= col && col <= tok.End() {
+			return i
+		}
+	}
+	return -1
+}
+
+// DefinitionsOf returns all definitions of a word in the document
+func (d *Document) DefinitionsOf(name string) []Definition {
+	res := make([]Definition, 0)
+	for _, def := range d.Definitions {
+		if def.Name == name {
+			res = append(res, def)
+		}
+	}
+	return res
+}
+
+// Diagnostic is a syntax problem found by the loader
+type Diagnostic struct {
+	Line    int // 0-based
+	Col     int // 0-based
+	Message string
+}
+
+var ansiRegex = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+var locationRegex = regexp.MustCompile(`Location: line (\d+), column (\d+)`)
+var errorLineRegex = regexp.MustCompile(`(?m)^Error: (.*)$`)
+
+// Diagnose checks the text for lexer errors and unbalanced brackets first, as their positions
+// are exact, and then loads it with loader.LoadString to catch the rest
+func Diagnose(text string) []Diagnostic {
+	toks, lexErr := tokenize(text)
+	if lexErr != nil {
+		return []Diagnostic{*lexErr}
+	}
+	if d := checkBrackets(toks); d != nil {
+		return []Diagnostic{*d}
+	}
+	ps := env.NewProgramState()
+	res := loader.LoadString(text, false, ps)
+	err, ok := res.(env.Error)
+	if !ok {
+		return nil
+	}
+	return []Diagnostic{parseLoaderError(err.Message)}
+}
+
+// closers maps the opening bracket tokens to the closing character they expect
+var closers = map[int]string{
+	loader.NPEG_TOKEN_BLOCK_START:       "}",
+	loader.NPEG_TOKEN_OPBLOCK_START:     "}",
+	loader.NPEG_TOKEN_LIST_BLOCK_START:  "}",
+	loader.NPEG_TOKEN_DICT_BLOCK_START:  "}",
+	loader.NPEG_TOKEN_BBLOCK_START:      "]",
+	loader.NPEG_TOKEN_OPBBLOCK_START:    "]",
+	loader.NPEG_TOKEN_LIST_BBLOCK_START: "]",
+	loader.NPEG_TOKEN_DICT_BBLOCK_START: "]",
+	loader.NPEG_TOKEN_GROUP_START:       ")",
+	loader.NPEG_TOKEN_OPGROUP_START:     ")",
+}
+
+var closerTokens = map[int]string{
+	loader.NPEG_TOKEN_BLOCK_END:  "}",
+	loader.NPEG_TOKEN_BBLOCK_END: "]",
+	loader.NPEG_TOKEN_GROUP_END:  ")",
+}
+
+// checkBrackets reports the first closing bracket that doesn't match, or the innermost
+// bracket left open at the end of the text
+func checkBrackets(toks []Token) *Diagnostic {
+	stack := make([]Token, 0)
+	for _, tok := range toks {
+		if _, ok := closers[tok.Type]; ok {
+			stack = append(stack, tok)
+			continue
+		}
+		closer, ok := closerTokens[tok.Type]
+		if !ok {
+			continue
+		}
+		if len(stack) == 0 {
+			return &Diagnostic{Line: tok.Line, Col: tok.Col, Message: "Unexpected '" + closer + "' without an opening bracket"}
+		}
+		open := stack[len(stack)-1]
+		if closers[open.Type] != closer {
+			return &Diagnostic{Line: tok.Line, Col: tok.Col, Message: "Unexpected '" + closer + "', expected '" + closers[open.Type] + "' to close the bracket on line " + strconv.Itoa(open.Line+1)}
+		}
+		stack = stack[:len(stack)-1]
+	}
+	if len(stack) > 0 {
+		open := stack[len(stack)-1]
+		return &Diagnostic{Line: open.Line, Col: open.Col, Message: "Bracket is never closed, expected '" + closers[open.Type] + "'"}
+	}
+	return nil
+}
+
+// parseLoaderError extracts the position and the message from the loader's error text
+func parseLoaderError(msg string) Diagnostic {
+	plain := ansiRegex.ReplaceAllString(msg, "")
+	diag := Diagnostic{Message: strings.TrimSpace(plain)}
+	if m := locationRegex.FindStringSubmatch(plain); m != nil {
+		line, _ := strconv.Atoi(m[1])
+		col, _ := strconv.Atoi(m[2])
+		diag.Line = max(line-1, 0)
+		diag.Col = max(col-1, 0)
+	}
+	if m := errorLineRegex.FindStringSubmatch(plain); m != nil {
+		diag.Message = strings.TrimSpace(m[1])
+	}
+	return diag
+}
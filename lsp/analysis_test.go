@@ -0,0 +1,76 @@
+package lsp
+
+import (
+	"testing"
+)
+
+func TestTokenPositions(t *testing.T) {
+	toks := Tokenize("\nab: 1\n  print ab")
+	if len(toks) != 4 {
+		t.Fatalf("expected 4 tokens, got %d: %+v", len(toks), toks)
+	}
+	expected := []Token{{Line: 1, Col: 0}, {Line: 1, Col: 4}, {Line: 2, Col: 2}, {Line: 2, Col: 8}}
+	for i, e := range expected {
+		if toks[i].Line != e.Line || toks[i].Col != e.Col {
+			t.Errorf("token %d (%s): expected %d:%d, got %d:%d", i, toks[i].Value, e.Line, e.Col, toks[i].Line, toks[i].Col)
+		}
+	}
+}
+
+func TestDefinitions(t *testing.T) {
+	doc := NewDocument("file:///x.rye", "x: 1\nadd: fn { a b \"adds\" } { a + b }\nhello: does { print 1 }\n10 :y")
+	defs := map[string]Definition{}
+	for _, d := range doc.Definitions {
+		defs[d.Name] = d
+	}
+	if len(defs) != 4 {
+		t.Fatalf("expected 4 definitions, got %+v", doc.Definitions)
+	}
+	if defs["x"].IsFunction || !defs["add"].IsFunction || !defs["hello"].IsFunction {
+		t.Errorf("wrong function detection: %+v", doc.Definitions)
+	}
+	if args := defs["add"].Args; len(args) != 2 || args[0] != "a" || args[1] != "b" {
+		t.Errorf("expected args a b, got %v", args)
+	}
+	if defs["y"].Line != 3 || defs["y"].Col != 3 {
+		t.Errorf("expected y at 3:3, got %d:%d", defs["y"].Line, defs["y"].Col)
+	}
+	if i := doc.TokenAt(1, 1); i < 0 || doc.Tokens[i].Value != "add:" {
+		t.Errorf("expected add: at 1:1, got %d", i)
+	}
+}
+
+func TestDiagnose(t *testing.T) {
+	cases := []struct {
+		text string
+		line int
+		col  int
+	}{
+		{"print 1\nx: 2 ]\n", 1, 5},
+		{"a: { 1 [ 2 } ]", 0, 11},
+		{"x: { 1\nprint 2\n", 0, 3},
+		{"\n\n  foo: \"abc", 2, 7},
+	}
+	for _, c := range cases {
+		diags := Diagnose(c.text)
+		if len(diags) != 1 {
+			t.Errorf("%q: expected one diagnostic, got %+v", c.text, diags)
+			continue
+		}
+		if diags[0].Line != c.line || diags[0].Col != c.col {
+			t.Errorf("%q: expected %d:%d, got %d:%d (%s)", c.text, c.line, c.col, diags[0].Line, diags[0].Col, diags[0].Message)
+		}
+	}
+	if diags := Diagnose("x: { 1 2 } |print\nfn { a } { a }"); len(diags) != 0 {
+		t.Errorf("expected no diagnostics, got %+v", diags)
+	}
+}
+
+func TestCleanDoc(t *testing.T) {
+	if d := cleanDoc("Prints a value (print) (print)"); d != "Prints a value" {
+		t.Errorf("got %q", d)
+	}
+	if d := cleanDoc("Adds two numbers (or more)"); d != "Adds two numbers (or more)" {
+		t.Errorf("got %q", d)
+	}
+}
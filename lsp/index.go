@@ -0,0 +1,162 @@
+package lsp
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/refaktor/rye/env"
+)
+
+// Entry describes a word known to the running Rye, a builtin or a function in a context
+type Entry struct {
+	Name   string // word, or Kind//method for generic methods, or context/word
+	Kind   string // builtin, function, method, context
+	Doc    string
+	Argsn  int
+	Args   []string
+	Method bool
+}
+
+// Index holds documentation of all builtins and functions of the program state
+type Index struct {
+	Words   map[string]Entry
+	Methods map[string][]Entry // method word -> entries for each kind that defines it
+	Names   []string           // sorted, used for completion
+}
+
+// NewIndex walks the root context, one level of subcontexts and the generic methods
+func NewIndex(ps *env.ProgramState) *Index {
+	ix := &Index{Words: make(map[string]Entry), Methods: make(map[string][]Entry)}
+	for idx, obj := range ps.Ctx.GetState() {
+		name := ps.Idx.GetWord(idx)
+		if e, ok := entryFor(ps, name, obj); ok {
+			ix.Words[name] = e
+			if ctx, ok := obj.(*env.RyeCtx); ok {
+				ix.addContext(ps, name, ctx)
+			}
+		}
+	}
+	for kind := range ps.Gen.GetKinds() {
+		kindName := ps.Idx.GetWord(kind)
+		for _, meth := range ps.Gen.GetMethods(kind) {
+			obj, _ := ps.Gen.Get(kind, meth)
+			methName := ps.Idx.GetWord(meth)
+			if e, ok := entryFor(ps, kindName+"//"+methName, obj); ok {
+				e.Method = true
+				e.Kind = "method"
+				ix.Methods[methName] = append(ix.Methods[methName], e)
+			}
+		}
+	}
+	for _, entries := range ix.Methods {
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	}
+	ix.Names = make([]string, 0, len(ix.Words)+len(ix.Methods))
+	for name := range ix.Words {
+		ix.Names = append(ix.Names, name)
+	}
+	for name := range ix.Methods {
+		if _, ok := ix.Words[name]; !ok {
+			ix.Names = append(ix.Names, name)
+		}
+	}
+	sort.Strings(ix.Names)
+	return ix
+}
+
+func (ix *Index) addContext(ps *env.ProgramState, ctxName string, ctx *env.RyeCtx) {
+	for idx, obj := range ctx.GetState() {
+		name := ctxName + "/" + ps.Idx.GetWord(idx)
+		if e, ok := entryFor(ps, name, obj); ok && e.Kind != "context" {
+			ix.Words[name] = e
+		}
+	}
+}
+
+func entryFor(ps *env.ProgramState, name string, obj env.Object) (Entry, bool) {
+	switch o := obj.(type) {
+	case env.Builtin:
+		return Entry{Name: name, Kind: "builtin", Doc: cleanDoc(o.Doc), Argsn: o.Argsn}, true
+	case *env.Builtin:
+		return Entry{Name: name, Kind: "builtin", Doc: cleanDoc(o.Doc), Argsn: o.Argsn}, true
+	case env.Function:
+		return Entry{Name: name, Kind: "function", Doc: o.Doc, Argsn: o.Argsn, Args: functionArgs(ps, o)}, true
+	case *env.Function:
+		return Entry{Name: name, Kind: "function", Doc: o.Doc, Argsn: o.Argsn, Args: functionArgs(ps, *o)}, true
+	case *env.RyeCtx:
+		return Entry{Name: name, Kind: "context", Doc: o.Doc}, true
+	}
+	return Entry{}, false
+}
+
+// cleanDoc removes the " (word)" suffixes that registration appends to builtin docs
+func cleanDoc(doc string) string {
+	for strings.HasSuffix(doc, ")") {
+		i := strings.LastIndex(doc, " (")
+		if i < 0 || strings.ContainsAny(doc[i+2:len(doc)-1], " ()") {
+			break
+		}
+		doc = doc[:i]
+	}
+	return doc
+}
+
+func functionArgs(ps *env.ProgramState, fn env.Function) []string {
+	args := make([]string, 0, fn.Argsn)
+	for _, obj := range fn.Spec.Series.S {
+		if w, ok := obj.(env.Word); ok {
+			args = append(args, ps.Idx.GetWord(w.Index))
+		}
+	}
+	return args
+}
+
+// Lookup returns the entries documenting a word. A plain word and methods of the same name
+// can both exist, so all of them are returned.
+func (ix *Index) Lookup(name string) []Entry {
+	res := make([]Entry, 0)
+	if e, ok := ix.Words[name]; ok {
+		res = append(res, e)
+	}
+	res = append(res, ix.Methods[name]...)
+	return res
+}
+
+// Signature returns "name arg1 arg2" for an entry, inventing argument names if they aren't known
+func (e Entry) Signature() string {
+	var sb strings.Builder
+	sb.WriteString(e.Name)
+	for _, a := range e.ArgNames() {
+		sb.WriteString(" ")
+		sb.WriteString(a)
+	}
+	return sb.String()
+}
+
+// ArgNames returns argument names, arg1 .. argN for builtins
+func (e Entry) ArgNames() []string {
+	if len(e.Args) > 0 {
+		return e.Args
+	}
+	names := make([]string, e.Argsn)
+	for i := range names {
+		names[i] = "arg" + strconv.Itoa(i+1)
+	}
+	return names
+}
+
+// Markdown formats the entry for hovers and completion documentation
+func (e Entry) Markdown() string {
+	var sb strings.Builder
+	sb.WriteString("```rye\n" + e.Signature() + "\n```\n")
+	sb.WriteString("*" + e.Kind)
+	if e.Kind != "context" {
+		sb.WriteString(", " + strconv.Itoa(e.Argsn) + " args")
+	}
+	sb.WriteString("*")
+	if e.Doc != "" {
+		sb.WriteString("\n\n" + e.Doc)
+	}
+	return sb.String()
+}
@@ -0,0 +1,439 @@
+package lsp
+
+import (
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/refaktor/rye/env"
+
+	"github.com/tliron/glsp"
+	protocol "github.com/tliron/glsp/protocol_3_16"
+	"github.com/tliron/glsp/server"
+)
+
+const serverName = "rye-lsp"
+
+// Server keeps the open documents, the workspace files and the builtin index
+type Server struct {
+	index     *Index
+	mu        sync.Mutex
+	docs      map[string]*Document // open documents by uri
+	workspace map[string]*Document // .rye files under the workspace root by uri
+	roots     []string
+	handler   protocol.Handler
+}
+
+// NewServer creates a language server. The program state should have all builtins registered,
+// it's only read to build documentation and completion data.
+func NewServer(ps *env.ProgramState) *Server {
+	s := &Server{
+		index:     NewIndex(ps),
+		docs:      make(map[string]*Document),
+		workspace: make(map[string]*Document),
+	}
+	s.handler = protocol.Handler{
+		Initialize:                s.initialize,
+		Initialized:               func(ctx *glsp.Context, params *protocol.InitializedParams) error { return nil },
+		Shutdown:                  func(ctx *glsp.Context) error { return nil },
+		SetTrace:                  func(ctx *glsp.Context, params *protocol.SetTraceParams) error { return nil },
+		TextDocumentDidOpen:       s.didOpen,
+		TextDocumentDidChange:     s.didChange,
+		TextDocumentDidSave:       s.didSave,
+		TextDocumentDidClose:      s.didClose,
+		TextDocumentHover:         s.hover,
+		TextDocumentCompletion:    s.completion,
+		TextDocumentSignatureHelp: s.signatureHelp,
+		TextDocumentDefinition:    s.definition,
+	}
+	return s
+}
+
+// Serve runs the language server on stdin and stdout until the client exits
+func Serve(ps *env.ProgramState) error {
+	return server.NewServer(&NewServer(ps).handler, serverName, false).RunStdio()
+}
+
+func (s *Server) initialize(ctx *glsp.Context, params *protocol.InitializeParams) (any, error) {
+	capabilities := s.handler.CreateServerCapabilities()
+	full := protocol.TextDocumentSyncKindFull
+	capabilities.TextDocumentSync = &protocol.TextDocumentSyncOptions{
+		OpenClose: &protocol.True,
+		Change:    &full,
+		Save:      &protocol.SaveOptions{IncludeText: &protocol.True},
+	}
+	capabilities.CompletionProvider = &protocol.CompletionOptions{TriggerCharacters: []string{".", "|", "/"}}
+	capabilities.SignatureHelpProvider = &protocol.SignatureHelpOptions{TriggerCharacters: []string{" "}}
+
+	s.mu.Lock()
+	for _, folder := range params.WorkspaceFolders {
+		if path, ok := uriToPath(folder.URI); ok {
+			s.roots = append(s.roots, path)
+		}
+	}
+	if len(s.roots) == 0 && params.RootURI != nil {
+		if path, ok := uriToPath(*params.RootURI); ok {
+			s.roots = append(s.roots, path)
+		}
+	}
+	s.scanWorkspace()
+	s.mu.Unlock()
+
+	version := "0.1"
+	return protocol.InitializeResult{
+		Capabilities: capabilities,
+		ServerInfo:   &protocol.InitializeResultServerInfo{Name: serverName, Version: &version},
+	}, nil
+}
+
+// scanWorkspace analyses all .rye files under the workspace roots, for go-to-definition
+// into files that aren't open
+func (s *Server) scanWorkspace() {
+	for _, root := range s.roots {
+		_ = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if d.IsDir() {
+				if name := d.Name(); path != root && (strings.HasPrefix(name, ".") || name == "node_modules") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if filepath.Ext(path) != ".rye" {
+				return nil
+			}
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return nil
+			}
+			uri := pathToURI(path)
+			s.workspace[uri] = NewDocument(uri, string(content))
+			return nil
+		})
+	}
+}
+
+func (s *Server) update(ctx *glsp.Context, uri string, text string) {
+	doc := NewDocument(uri, text)
+	s.mu.Lock()
+	s.docs[uri] = doc
+	if _, ok := s.workspace[uri]; ok {
+		s.workspace[uri] = doc
+	}
+	s.mu.Unlock()
+
+	diags := make([]protocol.Diagnostic, 0)
+	severity := protocol.DiagnosticSeverityError
+	source := "rye"
+	for _, d := range Diagnose(text) {
+		pos := protocol.Position{Line: protocol.UInteger(d.Line), Character: protocol.UInteger(d.Col)}
+		diags = append(diags, protocol.Diagnostic{
+			Range:    protocol.Range{Start: pos, End: protocol.Position{Line: pos.Line, Character: pos.Character + 1}},
+			Severity: &severity,
+			Source:   &source,
+			Message:  d.Message,
+		})
+	}
+	ctx.Notify(protocol.ServerTextDocumentPublishDiagnostics, protocol.PublishDiagnosticsParams{URI: uri, Diagnostics: diags})
+}
+
+func (s *Server) didOpen(ctx *glsp.Context, params *protocol.DidOpenTextDocumentParams) error {
+	s.update(ctx, params.TextDocument.URI, params.TextDocument.Text)
+	return nil
+}
+
+func (s *Server) didChange(ctx *glsp.Context, params *protocol.DidChangeTextDocumentParams) error {
+	// we ask for full sync, so the last change holds the whole text
+	for i := len(params.ContentChanges) - 1; i >= 0; i-- {
+		switch change := params.ContentChanges[i].(type) {
+		case protocol.TextDocumentContentChangeEventWhole:
+			s.update(ctx, params.TextDocument.URI, change.Text)
+			return nil
+		case protocol.TextDocumentContentChangeEvent:
+			if change.Range == nil {
+				s.update(ctx, params.TextDocument.URI, change.Text)
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Server) didSave(ctx *glsp.Context, params *protocol.DidSaveTextDocumentParams) error {
+	if params.Text != nil {
+		s.update(ctx, params.TextDocument.URI, *params.Text)
+	}
+	return nil
+}
+
+func (s *Server) didClose(ctx *glsp.Context, params *protocol.DidCloseTextDocumentParams) error {
+	s.mu.Lock()
+	delete(s.docs, params.TextDocument.URI)
+	s.mu.Unlock()
+	ctx.Notify(protocol.ServerTextDocumentPublishDiagnostics, protocol.PublishDiagnosticsParams{URI: params.TextDocument.URI, Diagnostics: []protocol.Diagnostic{}})
+	return nil
+}
+
+func (s *Server) document(uri string) *Document {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.docs[uri]
+}
+
+// wordAt returns the bare word under the cursor and its token
+func (s *Server) wordAt(uri string, pos protocol.Position) (string, *Token, *Document) {
+	doc := s.document(uri)
+	if doc == nil {
+		return "", nil, nil
+	}
+	i := doc.TokenAt(int(pos.Line), int(pos.Character))
+	if i < 0 {
+		return "", nil, doc
+	}
+	name, ok := WordName(doc.Tokens[i])
+	if !ok {
+		return "", nil, doc
+	}
+	return name, &doc.Tokens[i], doc
+}
+
+func (s *Server) hover(ctx *glsp.Context, params *protocol.HoverParams) (*protocol.Hover, error) {
+	name, tok, doc := s.wordAt(params.TextDocument.URI, params.Position)
+	if tok == nil {
+		return nil, nil
+	}
+	parts := make([]string, 0)
+	if defs := doc.DefinitionsOf(name); len(defs) > 0 {
+		parts = append(parts, definitionMarkdown(defs[0]))
+	}
+	for _, e := range s.index.Lookup(name) {
+		parts = append(parts, e.Markdown())
+	}
+	if len(parts) == 0 {
+		return nil, nil
+	}
+	rng := tokenRange(*tok)
+	return &protocol.Hover{
+		Contents: protocol.MarkupContent{Kind: protocol.MarkupKindMarkdown, Value: strings.Join(parts, "\n\n---\n\n")},
+		Range:    &rng,
+	}, nil
+}
+
+func definitionMarkdown(def Definition) string {
+	if def.IsFunction {
+		return "```rye\n" + strings.TrimSpace(def.Name+" "+strings.Join(def.Args, " ")) + "\n```\n*function defined on line " + strconv.Itoa(def.Line+1) + "*"
+	}
+	return "```rye\n" + def.Name + "\n```\n*word set on line " + strconv.Itoa(def.Line+1) + "*"
+}
+
+func (s *Server) completion(ctx *glsp.Context, params *protocol.CompletionParams) (any, error) {
+	doc := s.document(params.TextDocument.URI)
+	prefix := ""
+	if doc != nil {
+		prefix = linePrefix(doc.Text, int(params.Position.Line), int(params.Position.Character))
+	}
+	items := make([]protocol.CompletionItem, 0)
+	seen := make(map[string]bool)
+	if doc != nil {
+		for _, def := range doc.Definitions {
+			if seen[def.Name] || !strings.HasPrefix(def.Name, prefix) {
+				continue
+			}
+			seen[def.Name] = true
+			kind := protocol.CompletionItemKindVariable
+			if def.IsFunction {
+				kind = protocol.CompletionItemKindFunction
+			}
+			detail := "line " + strconv.Itoa(def.Line+1)
+			items = append(items, protocol.CompletionItem{Label: def.Name, Kind: &kind, Detail: &detail})
+		}
+	}
+	for _, name := range s.index.Names {
+		if seen[name] || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		seen[name] = true
+		entries := s.index.Lookup(name)
+		kind := protocol.CompletionItemKindFunction
+		if entries[0].Method {
+			kind = protocol.CompletionItemKindMethod
+		} else if entries[0].Kind == "context" {
+			kind = protocol.CompletionItemKindModule
+		}
+		detail := entries[0].Signature()
+		docs := protocol.MarkupContent{Kind: protocol.MarkupKindMarkdown, Value: entries[0].Markdown()}
+		items = append(items, protocol.CompletionItem{Label: name, Kind: &kind, Detail: &detail, Documentation: docs})
+	}
+	return items, nil
+}
+
+// linePrefix returns the part of the word left of the cursor, without op/pipe sigils
+func linePrefix(text string, line int, col int) string {
+	lines := strings.Split(text, "\n")
+	if line >= len(lines) {
+		return ""
+	}
+	l := lines[line]
+	if col > len(l) {
+		col = len(l)
+	}
+	start := col
+	for start > 0 && !strings.ContainsRune(" \t{}[]()\"", rune(l[start-1])) {
+		start--
+	}
+	return strings.TrimLeft(l[start:col], ".|?:'")
+}
+
+func (s *Server) signatureHelp(ctx *glsp.Context, params *protocol.SignatureHelpParams) (*protocol.SignatureHelp, error) {
+	doc := s.document(params.TextDocument.URI)
+	if doc == nil {
+		return nil, nil
+	}
+	line, col := int(params.Position.Line), int(params.Position.Character)
+	// find the closest word left of the cursor on the same line that still waits for arguments,
+	// a whole block counts as one argument and an unclosed block ends the search
+	given := 0
+	depth := 0
+	for i := len(doc.Tokens) - 1; i >= 0; i-- {
+		tok := doc.Tokens[i]
+		if tok.Line > line || (tok.Line == line && tok.Col >= col) {
+			continue
+		}
+		if tok.Line < line && depth == 0 {
+			break
+		}
+		if _, ok := closerTokens[tok.Type]; ok {
+			depth++
+			continue
+		}
+		if _, ok := closers[tok.Type]; ok {
+			if depth == 0 {
+				break
+			}
+			depth--
+			if depth == 0 {
+				given++
+			}
+			continue
+		}
+		if depth > 0 {
+			continue
+		}
+		name, ok := WordName(tok)
+		if !ok || IsDefining(tok) {
+			given++
+			continue
+		}
+		sig, argsn := s.signatureFor(doc, name)
+		offset := 0
+		if IsInjecting(tok) {
+			offset = 1
+		}
+		if sig == nil || given+offset >= argsn {
+			given++
+			continue
+		}
+		active := protocol.UInteger(given + offset)
+		zero := protocol.UInteger(0)
+		return &protocol.SignatureHelp{Signatures: []protocol.SignatureInformation{*sig}, ActiveSignature: &zero, ActiveParameter: &active}, nil
+	}
+	return nil, nil
+}
+
+func (s *Server) signatureFor(doc *Document, name string) (*protocol.SignatureInformation, int) {
+	var args []string
+	var docText string
+	found := false
+	for _, def := range doc.DefinitionsOf(name) {
+		if def.IsFunction {
+			args, found = def.Args, true
+			break
+		}
+	}
+	if !found {
+		entries := s.index.Lookup(name)
+		if len(entries) == 0 || entries[0].Kind == "context" {
+			return nil, 0
+		}
+		args, docText = entries[0].ArgNames(), entries[0].Doc
+	}
+	if len(args) == 0 {
+		return nil, 0
+	}
+	sig := protocol.SignatureInformation{Label: name + " " + strings.Join(args, " ")}
+	if docText != "" {
+		sig.Documentation = docText
+	}
+	for _, a := range args {
+		sig.Parameters = append(sig.Parameters, protocol.ParameterInformation{Label: a})
+	}
+	return &sig, len(args)
+}
+
+func (s *Server) definition(ctx *glsp.Context, params *protocol.DefinitionParams) (any, error) {
+	name, tok, doc := s.wordAt(params.TextDocument.URI, params.Position)
+	if tok == nil {
+		return nil, nil
+	}
+	locs := make([]protocol.Location, 0)
+	// the current document first, then other open documents and workspace files
+	for _, def := range doc.DefinitionsOf(name) {
+		locs = append(locs, definitionLocation(def))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for uri, d := range s.docs {
+		if uri == doc.URI {
+			continue
+		}
+		for _, def := range d.DefinitionsOf(name) {
+			locs = append(locs, definitionLocation(def))
+		}
+	}
+	for uri, d := range s.workspace {
+		if _, open := s.docs[uri]; open {
+			continue
+		}
+		for _, def := range d.DefinitionsOf(name) {
+			locs = append(locs, definitionLocation(def))
+		}
+	}
+	if len(locs) == 0 {
+		return nil, nil
+	}
+	return locs, nil
+}
+
+func definitionLocation(def Definition) protocol.Location {
+	start := protocol.Position{Line: protocol.UInteger(def.Line), Character: protocol.UInteger(def.Col)}
+	end := protocol.Position{Line: start.Line, Character: start.Character + protocol.UInteger(len(def.Name))}
+	return protocol.Location{URI: def.URI, Range: protocol.Range{Start: start, End: end}}
+}
+
+func tokenRange(tok Token) protocol.Range {
+	return protocol.Range{
+		Start: protocol.Position{Line: protocol.UInteger(tok.Line), Character: protocol.UInteger(tok.Col)},
+		End:   protocol.Position{Line: protocol.UInteger(tok.Line), Character: protocol.UInteger(tok.End())},
+	}
+}
+
+func uriToPath(uri string) (string, bool) {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return "", false
+	}
+	return filepath.FromSlash(u.Path), true
+}
+
+func pathToURI(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}).String()
+}
@@ -0,0 +1,159 @@
+package profiler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/pprof/profile"
+)
+
+// WriteFile writes folded stacks to .folded and .txt files and a pprof profile to others
+func (p *Profiler) WriteFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	write := p.WritePprof
+	if strings.HasSuffix(path, ".folded") || strings.HasSuffix(path, ".txt") {
+		write = p.WriteFolded
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// WriteFolded writes the samples as folded stacks, one line of frames separated by ; and the
+// microseconds spent in them, the input of flamegraph.pl and speedscope
+func (p *Profiler) WriteFolded(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, s := range p.Samples() {
+		names := make([]string, len(s.Stack))
+		for i, f := range s.Stack {
+			names[i] = strings.ReplaceAll(f.String(), ";", ",")
+		}
+		fmt.Fprintf(bw, "%s %d\n", strings.Join(names, ";"), s.Time.Microseconds())
+	}
+	return bw.Flush()
+}
+
+// WritePprof writes the samples as a gzipped pprof profile with the samples, the wall time and the
+// allocations of each stack, go tool pprof reads it
+func (p *Profiler) WritePprof(w io.Writer) error {
+	prof := &profile.Profile{
+		SampleType: []*profile.ValueType{
+			{Type: "samples", Unit: "count"},
+			{Type: "wall", Unit: "nanoseconds"},
+			{Type: "alloc_space", Unit: "bytes"},
+			{Type: "alloc_objects", Unit: "count"},
+		},
+		DefaultSampleType: "wall",
+		PeriodType:        &profile.ValueType{Type: "wall", Unit: "nanoseconds"},
+		Period:            int64(p.Interval),
+		TimeNanos:         time.Now().UnixNano(),
+	}
+	functions := make(map[[2]string]*profile.Function)
+	locations := make(map[Frame]*profile.Location)
+	for _, s := range p.Samples() {
+		sample := &profile.Sample{Value: []int64{s.Count, int64(s.Time), s.AllocBytes, s.AllocObjects}}
+		// pprof stacks start with the leaf
+		for _, f := range slices.Backward(s.Stack) {
+			loc, ok := locations[f]
+			if !ok {
+				file := f.File
+				if f.Builtin {
+					file = "builtin"
+				}
+				fn, ok := functions[[2]string{f.Name, file}]
+				if !ok {
+					fn = &profile.Function{ID: uint64(len(prof.Function) + 1), Name: f.Name, SystemName: f.Name, Filename: file}
+					functions[[2]string{f.Name, file}] = fn
+					prof.Function = append(prof.Function, fn)
+				}
+				loc = &profile.Location{ID: uint64(len(prof.Location) + 1), Line: []profile.Line{{Function: fn, Line: int64(f.Line)}}}
+				locations[f] = loc
+				prof.Location = append(prof.Location, loc)
+			}
+			sample.Location = append(sample.Location, loc)
+		}
+		prof.Sample = append(prof.Sample, sample)
+	}
+	return prof.Write(w)
+}
+
+// funcStat is the time spent in a function (flat) and in it and what it called (cum)
+type funcStat struct {
+	name       string
+	flat, cum  time.Duration
+	allocBytes int64
+}
+
+// WriteTop writes the n functions and builtins with the most time spent in them
+func (p *Profiler) WriteTop(w io.Writer, n int) {
+	stats := make(map[string]*funcStat)
+	var total time.Duration
+	for _, s := range p.Samples() {
+		total += s.Time
+		seen := make(map[string]bool)
+		for i, f := range s.Stack {
+			name := f.Name
+			if !f.Builtin && f.File != "" {
+				name += " (" + f.File + ")"
+			}
+			st, ok := stats[name]
+			if !ok {
+				st = &funcStat{name: name}
+				stats[name] = st
+			}
+			if !seen[name] {
+				st.cum += s.Time
+				seen[name] = true
+			}
+			if i == len(s.Stack)-1 {
+				st.flat += s.Time
+				st.allocBytes += s.AllocBytes
+			}
+		}
+	}
+	list := make([]*funcStat, 0, len(stats))
+	for _, st := range stats {
+		list = append(list, st)
+	}
+	slices.SortFunc(list, func(a, b *funcStat) int {
+		if a.flat != b.flat {
+			return int(b.flat - a.flat)
+		}
+		return strings.Compare(a.name, b.name)
+	})
+	if len(list) > n {
+		list = list[:n]
+	}
+	fmt.Fprintf(w, "%10s %6s %10s %6s %10s  %s\n", "flat", "flat%", "cum", "cum%", "alloc", "function")
+	for _, st := range list {
+		fmt.Fprintf(w, "%10s %5.1f%% %10s %5.1f%% %10s  %s\n", st.flat.Round(time.Microsecond), percent(st.flat, total),
+			st.cum.Round(time.Microsecond), percent(st.cum, total), formatBytes(st.allocBytes), st.name)
+	}
+}
+
+func percent(d, total time.Duration) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(d) / float64(total)
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fMB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fkB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", n)
+}
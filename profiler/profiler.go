@@ -0,0 +1,423 @@
+// Package profiler is a sampling profiler for Rye code. The evaluator tells it which word calls a
+// function or builtin (evaldo.CallHook), when their code runs (evaldo.EnterHook, evaldo.LeaveHook)
+// and which expression runs (evaldo.DebuggerHook), so it keeps a stack of Rye frames with their
+// source lines. A ticker samples the stack and charges it the wall time and the allocations since
+// the previous sample.
+//
+// Functions called by builtins (map, for, ...) have no word, they are named by the line they start
+// at (fn lib.rye:12). Code that runs in other goroutines (go, map\par, task groups, ...) runs in
+// copies of the program state, each of them has its own stack under a goroutine frame. A sample
+// charges the wall time to the stacks of all goroutines that are running and splits the
+// allocations between them.
+package profiler
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime/metrics"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/refaktor/rye/debugger"
+	"github.com/refaktor/rye/env"
+	"github.com/refaktor/rye/evaldo"
+)
+
+// DefaultInterval is the time between samples
+const DefaultInterval = time.Millisecond
+
+// Frame is a function, builtin or the script on the stack, with the line it's at
+type Frame struct {
+	Name    string
+	File    string
+	Line    int
+	Builtin bool
+}
+
+func (f Frame) String() string {
+	if f.Builtin || f.File == "" {
+		return f.Name
+	}
+	return fmt.Sprintf("%s (%s:%d)", f.Name, f.File, f.Line)
+}
+
+type frame struct {
+	Frame
+	depth    int  // the call depth the code of the frame runs at
+	explicit bool // pushed by the enter hook and popped by the leave hook
+}
+
+// goroutineFrame is the root frame of the stacks of program states other than the profiled one
+const goroutineFrame = "goroutine"
+
+// thread is the stack of a program state
+type thread struct {
+	stack   []frame
+	pending []pendingCall // the words that are collecting the arguments of their calls
+	ran     bool          // a hook was called since the last sample
+}
+
+type pendingCall struct {
+	name    string
+	id      uintptr // the identity of the function or builtin the word is bound to
+	entered bool
+}
+
+// Sample is a stack, root first, with the time and allocations charged to it
+type Sample struct {
+	Stack        []Frame
+	Count        int64
+	Time         time.Duration
+	AllocBytes   int64
+	AllocObjects int64
+}
+
+// Profiler samples the Rye stacks between Start and Stop
+type Profiler struct {
+	Interval time.Duration
+
+	mu      sync.Mutex
+	smap    *debugger.SourceMap
+	main    *thread
+	threads map[*env.ProgramState]*thread
+	seen    []*thread          // threads in the order they were first seen, so samples don't depend on the map
+	names   map[uintptr]string // the names of the builtins, for the ones called without words
+	samples map[string]*Sample
+	order   []string // sample keys in the order they were first seen
+	last    time.Time
+	metrics []metrics.Sample
+	// the allocations counted up to the last sample
+	allocBytes, allocObjects int64
+
+	stop chan struct{}
+	done chan struct{}
+
+	prevHook   func(ps *env.ProgramState)
+	prevLoaded func(file string, content string, block env.Block)
+}
+
+func New() *Profiler {
+	return &Profiler{
+		Interval: DefaultInterval,
+		smap:     debugger.NewSourceMap("", "", env.Block{}),
+		samples:  make(map[string]*Sample),
+		metrics:  []metrics.Sample{{Name: "/gc/heap/allocs:bytes"}, {Name: "/gc/heap/allocs:objects"}},
+	}
+}
+
+// AddFile maps the blocks of a loaded file to source lines, files loaded while the profiler runs
+// are added by the hook. The block can also be a block inside the file.
+func (p *Profiler) AddFile(file string, content string, block env.Block) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.smap.Add(file, content, block)
+}
+
+func (p *Profiler) sourceLoaded(file string, content string, block env.Block) {
+	if p.prevLoaded != nil {
+		p.prevLoaded(file, content, block)
+	}
+	p.AddFile(file, content, block)
+}
+
+// Start installs the hooks and starts sampling, the root frame is the code that's profiled, at
+// the call depth of the program state that runs it
+func (p *Profiler) Start(ps *env.ProgramState, root string) {
+	p.main = &thread{stack: []frame{{Frame: Frame{Name: root, File: ps.ScriptPath}, depth: ps.CallDepth}}}
+	p.threads = map[*env.ProgramState]*thread{ps: p.main}
+	p.seen = []*thread{p.main}
+	p.names = make(map[uintptr]string)
+	for ctx := ps.Ctx; ctx != nil; ctx = ctx.Parent {
+		for idx, obj := range ctx.GetState() {
+			if bi, ok := obj.(env.Builtin); ok {
+				if id := identity(bi); p.names[id] == "" {
+					p.names[id] = ps.Idx.GetWord(idx)
+				}
+			}
+		}
+	}
+	p.prevHook = evaldo.DebuggerHook
+	p.prevLoaded = evaldo.SourceLoadedHook
+	evaldo.DebuggerHook = p.hook
+	evaldo.SourceLoadedHook = p.sourceLoaded
+	evaldo.CallHook = p.call
+	evaldo.ReturnHook = p.ret
+	evaldo.EnterHook = p.enter
+	evaldo.LeaveHook = p.leave
+
+	metrics.Read(p.metrics)
+	p.allocBytes, p.allocObjects = int64(p.metrics[0].Value.Uint64()), int64(p.metrics[1].Value.Uint64())
+	p.last = time.Now()
+	p.stop = make(chan struct{})
+	p.done = make(chan struct{})
+	go p.run()
+}
+
+// Stop takes the last sample and removes the hooks
+func (p *Profiler) Stop() {
+	if p.stop == nil {
+		return
+	}
+	close(p.stop)
+	<-p.done
+	p.stop = nil
+	evaldo.CallHook = nil
+	evaldo.ReturnHook = nil
+	evaldo.EnterHook = nil
+	evaldo.LeaveHook = nil
+	evaldo.DebuggerHook = p.prevHook
+	evaldo.SourceLoadedHook = p.prevLoaded
+}
+
+func (p *Profiler) run() {
+	defer close(p.done)
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.sample()
+		case <-p.stop:
+			p.sample()
+			return
+		}
+	}
+}
+
+// sample charges the time since the last sample to the current stacks of the goroutines and splits
+// the allocations between them
+func (p *Profiler) sample() {
+	now := time.Now()
+	metrics.Read(p.metrics)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	bytes, objects := p.metrics[0].Value.Uint64(), p.metrics[1].Value.Uint64()
+	elapsed := now.Sub(p.last)
+	p.last = now
+
+	// a goroutine that returned to its root frame and doesn't run code anymore has ended
+	stacks := make([][]frame, 0, len(p.seen))
+	for _, t := range p.seen {
+		if t == p.main || len(t.stack) > 1 || t.ran {
+			stacks = append(stacks, t.stack)
+		}
+		t.ran = false
+	}
+	n := int64(len(stacks))
+	allocBytes, allocObjects := int64(bytes)-p.allocBytes, int64(objects)-p.allocObjects
+	for i, stack := range stacks {
+		s := p.sampleOf(stack)
+		s.Count++
+		s.Time += elapsed
+		s.AllocBytes += allocBytes / n
+		s.AllocObjects += allocObjects / n
+		if i == 0 {
+			s.AllocBytes += allocBytes % n
+			s.AllocObjects += allocObjects % n
+		}
+	}
+	p.allocBytes, p.allocObjects = int64(bytes), int64(objects)
+}
+
+// sampleOf returns the sample of the stack, a new one the first time the stack is seen
+func (p *Profiler) sampleOf(stack []frame) *Sample {
+	var key strings.Builder
+	for _, f := range stack {
+		fmt.Fprintf(&key, "%s\x00%s\x00%d\x00%t\x01", f.Name, f.File, f.Line, f.Builtin)
+	}
+	s, ok := p.samples[key.String()]
+	if !ok {
+		s = &Sample{Stack: make([]Frame, len(stack))}
+		for i, f := range stack {
+			s.Stack[i] = f.Frame
+		}
+		p.samples[key.String()] = s
+		p.order = append(p.order, key.String())
+	}
+	return s
+}
+
+// thread returns the stack of the program state, the first hook of a copy of the program state
+// that runs in another goroutine starts its stack. It's called with the mutex locked.
+func (p *Profiler) thread(ps *env.ProgramState) *thread {
+	t, ok := p.threads[ps]
+	if !ok {
+		t = &thread{stack: []frame{{Frame: Frame{Name: goroutineFrame, File: ps.ScriptPath}, depth: ps.CallDepth}}}
+		p.threads[ps] = t
+		p.seen = append(p.seen, t)
+	}
+	t.ran = true
+	return t
+}
+
+// call is installed as evaldo.CallHook, the name of the word is used for the frame when the
+// function or builtin it's bound to is entered
+func (p *Profiler) call(ps *env.ProgramState, word env.Object, fn env.Object) {
+	p.mu.Lock()
+	t := p.thread(ps)
+	t.pending = append(t.pending, pendingCall{name: wordName(ps, word), id: identity(fn)})
+	p.mu.Unlock()
+}
+
+// ret is installed as evaldo.ReturnHook
+func (p *Profiler) ret(ps *env.ProgramState) {
+	p.mu.Lock()
+	if t := p.thread(ps); len(t.pending) > 0 {
+		t.pending = t.pending[:len(t.pending)-1]
+	}
+	p.mu.Unlock()
+}
+
+// enter is installed as evaldo.EnterHook, it pushes the frame of the function or builtin whose
+// arguments were collected
+func (p *Profiler) enter(ps *env.ProgramState, fn env.Object) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t := p.thread(ps)
+	f := frame{depth: ps.CallDepth, explicit: true}
+	switch fn := fn.(type) {
+	case env.Function:
+		// until its first expression the frame is where the function is defined
+		f.File = fn.Body.FileName
+		f.Line = fn.Body.Line
+		// code loaded from strings has no file name, the source map knows where it's from
+		if file, line, ok := p.smap.Line(fn.Body.Series, 0); ok {
+			f.File = file
+			f.Line = line
+		}
+		f.Name = fmt.Sprintf("fn %s:%d", filepath.Base(f.File), f.Line)
+	default:
+		f.Builtin = true
+		f.Name = p.names[identity(fn)]
+		if f.Name == "" {
+			f.Name = "builtin"
+		}
+	}
+	// builtins like map call functions without words, a call is only named by the word that
+	// was evaluated for it
+	if n := len(t.pending); n > 0 && !t.pending[n-1].entered && t.pending[n-1].id == identity(fn) {
+		t.pending[n-1].entered = true
+		f.Name = t.pending[n-1].name
+	}
+	t.stack = append(t.stack, f)
+}
+
+// leave is installed as evaldo.LeaveHook, it pops the frame of the function or builtin and the
+// frames of the functions a builtin called
+func (p *Profiler) leave(ps *env.ProgramState) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t := p.thread(ps)
+	for i := len(t.stack) - 1; i > 0; i-- {
+		if t.stack[i].explicit {
+			t.stack = t.stack[:i]
+			return
+		}
+	}
+}
+
+// hook is installed as evaldo.DebuggerHook, it moves the innermost Rye frame to the line of the
+// expression and pushes a frame for a function called by a builtin
+func (p *Profiler) hook(ps *env.ProgramState) {
+	if p.prevHook != nil {
+		p.prevHook(ps)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t := p.thread(ps)
+	file, line, ok := p.smap.Line(ps.Ser, ps.Ser.Pos())
+	// functions called by builtins return without the return hook, their frames are popped when
+	// the code of a lower frame runs again
+	for len(t.stack) > 1 {
+		top := t.stack[len(t.stack)-1]
+		if top.explicit || top.depth <= ps.CallDepth {
+			break
+		}
+		t.stack = t.stack[:len(t.stack)-1]
+	}
+	i := t.ryeFrame()
+	if ps.CallDepth > t.stack[i].depth {
+		name := "fn"
+		if ok {
+			name = fmt.Sprintf("fn %s:%d", filepath.Base(file), line)
+		}
+		t.stack = append(t.stack, frame{Frame: Frame{Name: name}, depth: ps.CallDepth})
+		i = len(t.stack) - 1
+	}
+	if ok {
+		t.stack[i].File = file
+		t.stack[i].Line = line
+	}
+}
+
+// ryeFrame returns the index of the innermost frame that isn't a builtin
+func (t *thread) ryeFrame() int {
+	for i := len(t.stack) - 1; i > 0; i-- {
+		if !t.stack[i].Builtin {
+			return i
+		}
+	}
+	return 0
+}
+
+// Samples returns the samples in the order their stacks were first seen
+func (p *Profiler) Samples() []Sample {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	res := make([]Sample, len(p.order))
+	for i, key := range p.order {
+		res[i] = *p.samples[key]
+	}
+	return res
+}
+
+// Profile runs the code of the block with the profiler and returns it stopped, the block's lines
+// are mapped from the file it was loaded from
+func Profile(ps *env.ProgramState, block env.Block, interval time.Duration) *Profiler {
+	p := New()
+	if interval > 0 {
+		p.Interval = interval
+	}
+	file := block.FileName
+	if file == "" {
+		file = ps.ScriptPath
+	}
+	if content, err := os.ReadFile(file); err == nil {
+		p.AddFile(file, string(content), block)
+	}
+	ser := ps.Ser
+	ps.Ser = block.Series
+	p.Start(ps, "profile")
+	evaldo.EvalBlockInj(ps, nil, false)
+	p.Stop()
+	ps.Ser = ser
+	return p
+}
+
+// identity tells builtins apart by their Go function and functions by their body
+func identity(fn env.Object) uintptr {
+	switch fn := fn.(type) {
+	case env.Builtin:
+		return reflect.ValueOf(fn.Fn).Pointer()
+	case env.Function:
+		return reflect.ValueOf(fn.Body.Series.S).Pointer()
+	}
+	return 0
+}
+
+func wordName(ps *env.ProgramState, word env.Object) string {
+	switch w := word.(type) {
+	case env.Word:
+		return ps.Idx.GetWord(w.Index)
+	case env.Opword:
+		return ps.Idx.GetWord(w.Index)
+	case env.Pipeword:
+		return ps.Idx.GetWord(w.Index)
+	case env.Dotword:
+		return ps.Idx.GetWord(w.Index)
+	}
+	return word.Print(*ps.Idx)
+}
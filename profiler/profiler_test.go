@@ -0,0 +1,125 @@
+package profiler
+
+import (
+	"bytes"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/profile"
+
+	"github.com/refaktor/rye/env"
+	"github.com/refaktor/rye/evaldo"
+	"github.com/refaktor/rye/loader"
+)
+
+const script = `fib: fn { n } { either n < 2 { n } { ( fib n - 1 ) + fib n - 2 } }
+double: fn { x } { x * 2 }
+{ 1 2 3 } |map { .double } |probe
+fib 20
+`
+
+func TestProfile(t *testing.T) {
+	// with a single P the sampler rarely gets to run while the script does
+	if runtime.GOMAXPROCS(0) < 2 {
+		defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(2))
+	}
+	ps := env.NewProgramState()
+	evaldo.RegisterBuiltins(ps)
+	block, ok := loader.LoadString(script, false, ps).(env.Block)
+	if !ok {
+		t.Fatal("script didn't load")
+	}
+	prof := New()
+	prof.Interval = 100 * time.Microsecond
+	prof.AddFile("fib.rye", script, block)
+	ps = env.AddToProgramStateNEWWithLocation(ps, &block, ps.Idx)
+	prof.Start(ps, "fib.rye")
+	evaldo.EvalBlockInj(ps, nil, false)
+	prof.Stop()
+	if ps.ErrorFlag {
+		t.Fatal(ps.Res.Inspect(*ps.Idx))
+	}
+	if evaldo.CallHook != nil || evaldo.DebuggerHook != nil {
+		t.Error("the hooks should be removed")
+	}
+
+	var folded bytes.Buffer
+	if err := prof.WriteFolded(&folded); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(folded.String(), "fib.rye (fib.rye:4);fib (fib.rye:1);either;fib (fib.rye:1)") {
+		t.Errorf("expected the stacks of fib, got:\n%s", folded.String())
+	}
+
+	var buf bytes.Buffer
+	if err := prof.WritePprof(&buf); err != nil {
+		t.Fatal(err)
+	}
+	pp, err := profile.Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	functions := make(map[string]string)
+	for _, fn := range pp.Function {
+		functions[fn.Name] = fn.Filename
+	}
+	if functions["fib"] != "fib.rye" || functions["either"] != "builtin" {
+		t.Errorf("unexpected functions %v", functions)
+	}
+	var wall int64
+	for _, s := range pp.Sample {
+		wall += s.Value[1]
+	}
+	if wall <= 0 {
+		t.Error("expected the samples to have wall time")
+	}
+}
+
+func TestProfileGoroutines(t *testing.T) {
+	if runtime.GOMAXPROCS(0) < 2 {
+		defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(2))
+	}
+	code := `fib: fn { n } { either n < 2 { n } { ( fib n - 1 ) + fib n - 2 } }
+spin: fn { n } { fib n }
+{ 18 19 } |map\par 2 ?spin
+`
+	ps := env.NewProgramState()
+	evaldo.RegisterBuiltins(ps)
+	block, ok := loader.LoadString(code, false, ps).(env.Block)
+	if !ok {
+		t.Fatal("script didn't load")
+	}
+	prof := New()
+	prof.Interval = 100 * time.Microsecond
+	prof.AddFile("par.rye", code, block)
+	ps = env.AddToProgramStateNEWWithLocation(ps, &block, ps.Idx)
+	prof.Start(ps, "par.rye")
+	evaldo.EvalBlockInj(ps, nil, false)
+	prof.Stop()
+	if ps.ErrorFlag || ps.FailureFlag {
+		t.Fatal(ps.Res.Inspect(*ps.Idx))
+	}
+
+	var folded bytes.Buffer
+	if err := prof.WriteFolded(&folded); err != nil {
+		t.Fatal(err)
+	}
+	workers := false
+	for _, line := range strings.Split(folded.String(), "\n") {
+		// the workers' functions have stacks of their own, they aren't on the stack of the script
+		if strings.HasPrefix(line, "par.rye") && strings.Contains(line, "fib") {
+			t.Errorf("expected the worker's calls off the script's stack, got %s", line)
+		}
+		if strings.HasPrefix(line, "goroutine (par.rye:2);fib (par.rye:1)") {
+			workers = true
+		}
+	}
+	if !workers {
+		t.Errorf("expected the stacks of the workers, got:\n%s", folded.String())
+	}
+	if !strings.Contains(folded.String(), "par.rye (par.rye:3);map\\par ") {
+		t.Errorf("expected the script waiting in map\\par, got:\n%s", folded.String())
+	}
+}
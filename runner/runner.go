@@ -26,9 +26,17 @@ import (
 	"github.com/refaktor/rye/contrib"
 	"github.com/refaktor/rye/batteries"
 	ryeconsole "github.com/refaktor/rye/console"
+	"github.com/refaktor/rye/cover"
+	"github.com/refaktor/rye/debugger"
 	"github.com/refaktor/rye/env"
 	"github.com/refaktor/rye/evaldo"
 	"github.com/refaktor/rye/loader"
+	"github.com/refaktor/rye/profiler"
+	"github.com/refaktor/rye/lsp"
+	"github.com/refaktor/rye/ryefmt"
+	"github.com/refaktor/rye/ryelint"
+	"github.com/refaktor/rye/ryepkg"
+	"github.com/refaktor/rye/ryetest"
 	"github.com/refaktor/rye/security"
 	"github.com/refaktor/rye/util"
 )
@@ -75,6 +83,15 @@ var (
 
 	// Inspect/debugging options
 	NoInspect = flag.Bool("noinspect", false, "Exit immediately on error without showing debugging options")
+
+	// Coverage options
+	Cover        = flag.Bool("cover", false, "Record which lines of the script and the files it loads are evaluated")
+	CoverProfile = flag.String("coverprofile", "rye.cover", "Coverage profile written with --cover")
+	CoverHTML    = flag.String("coverhtml", "", "HTML coverage report written with --cover")
+	CoverMin     = flag.Float64("covermin", 0, "Exit with 1 if the coverage is below the percentage, with --cover")
+
+	// Profiler options
+	Profile = flag.String("profile", "", "Write a profile of the Rye functions and builtins to the file, folded stacks for .folded and .txt, pprof otherwise")
 )
 
 // TODO 20251107: This is temporary experiment, to make builtins like forever respond to ctrl+d, ctrl+z, ...
@@ -230,6 +247,13 @@ func DoMain(regfn func(*env.ProgramState) error) {
 		fmt.Println("\n \033[1mCommands:\033[0m (optional)")
 		fmt.Println("  cont[inue]\n     Continue console from the last save")
 		fmt.Println("  here\n     Starts in Rye here mode (wip)")
+		fmt.Println("  lsp\n     Starts the Rye language server on stdin/stdout")
+		fmt.Println("  debug [-b file:line ...] [filename]\n     Runs a Rye file in the terminal debugger")
+		fmt.Println("  dap\n     Starts the Rye debug adapter (Debug Adapter Protocol) on stdin/stdout")
+		fmt.Println("  pkg add|install|remove|list|verify|mirror\n     Manages the Rye libraries of the project in rye_modules")
+		fmt.Println("  test [-run regexp] [-p n] [-format text|tap|junit] [-o file] [-cover] [paths]\n     Runs the tests in *_test.rye files")
+		fmt.Println("  lint [-enable rules] [-disable rules] [-format text|json] [paths]\n     Checks Rye code for common mistakes like unbound words and missing arguments")
+		fmt.Println("  fmt [-w | -check] [paths]\n     Formats Rye code, prints it, writes it to the files or lists the unformatted files")
 		fmt.Println(" \033[1mExamples:\033[0m")
 		fmt.Println("\033[33m  rye                                  \033[36m# enters console/REPL")
 		fmt.Println("\033[33m  rye -do \"print 33 * 42\"              \033[36m# evaluates the do code")
@@ -356,6 +380,20 @@ func DoMain(regfn func(*env.ProgramState) error) {
 					main_rysh()
 				} else if args[0] == "rwk" {
 					main_ryk()
+				} else if args[0] == "lsp" {
+					main_rye_lsp()
+				} else if args[0] == "debug" {
+					main_rye_debug(args[1:], regfn)
+				} else if args[0] == "dap" {
+					main_rye_dap(regfn)
+				} else if args[0] == "pkg" {
+					main_rye_pkg(args[1:])
+				} else if args[0] == "test" {
+					main_rye_test(args[1:], regfn)
+				} else if args[0] == "fmt" {
+					main_rye_fmt(args[1:])
+				} else if args[0] == "lint" {
+					main_rye_lint(args[1:], regfn)
 				} else if args[0] == "here" {
 					if *do != "" {
 						main_rye_file("", false, true, true, *console, code, *lang, regfn, *stin)
@@ -456,6 +494,182 @@ func dotsToMainRye(ryeFile string) string {
 	return ryeFile
 }
 
+//
+// main for the language server, editors start it as "rye lsp" and talk to it over stdin/stdout
+//
+
+func main_rye_lsp() {
+	block, genv := loader.LoadStringNoPEG(" 1 ", false)
+	es := env.NewProgramStateOLD(block.(env.Block).Series, genv)
+	evaldo.RegisterBuiltins(es)
+	baseio.Register(es)
+	batteries.RegisterBatteries(es)
+	contrib.RegisterBuiltins(es, &evaldo.BuiltinNames)
+
+	if err := lsp.Serve(es); err != nil {
+		handleError(err, "running language server", true)
+	}
+}
+
+//
+// main for the debugger, "rye debug file.rye" debugs in the terminal, editors start "rye dap"
+//
+
+type breakpointFlags []string
+
+func (b *breakpointFlags) String() string {
+	return strings.Join(*b, ",")
+}
+
+func (b *breakpointFlags) Set(value string) error {
+	*b = append(*b, value)
+	return nil
+}
+
+func main_rye_debug(args []string, regfn func(*env.ProgramState) error) {
+	fs := flag.NewFlagSet("debug", flag.ExitOnError)
+	var breakpoints breakpointFlags
+	fs.Var(&breakpoints, "b", "Breakpoint as file:line or line, can be repeated")
+	fs.Usage = func() {
+		fmt.Println("Usage: rye debug [-b file:line ...] file.rye")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	file := fs.Arg(0)
+	ps, err := debugProgramState(file, regfn)
+	if err != nil {
+		handleError(err, "setting up the debugger", true)
+		return
+	}
+	if err := debugger.RunTerminal(ps, file, breakpoints); err != nil {
+		handleError(err, "debugging "+file, true)
+	}
+}
+
+func main_rye_dap(regfn func(*env.ProgramState) error) {
+	err := debugger.ServeDAP(func(program string) (*env.ProgramState, error) {
+		return debugProgramState(program, regfn)
+	})
+	if err != nil {
+		handleError(err, "running debug adapter", true)
+	}
+}
+
+// debugProgramState registers the same builtins as main_rye_file does for a script, it's used by
+// the debugger and the test runner
+func debugProgramState(file string, regfn func(*env.ProgramState) error) (*env.ProgramState, error) {
+	ps := env.NewProgramState()
+	ps.ScriptPath = file
+	workingPath, err := os.Getwd()
+	if err != nil {
+		workingPath = "."
+	}
+	ps.WorkingPath = workingPath
+
+	evaldo.RegisterBuiltins(ps)
+	baseio.Register(ps)
+	batteries.RegisterBatteries(ps)
+	evaldo.RegisterVarBuiltins(ps)
+	contrib.RegisterBuiltins(ps, &evaldo.BuiltinNames)
+	if err := regfn(ps); err != nil {
+		return nil, err
+	}
+	return ps, nil
+}
+
+// startCover records the coverage of the script and the files it loads, the returned function
+// writes the reports, it's also called if the script exits
+func startCover(file string, content string, block env.Block) func() {
+	prof := cover.New()
+	if file != "" {
+		prof.AddFile(file, content, block)
+	}
+	prof.Start()
+	var once sync.Once
+	finish := func() {
+		once.Do(func() {
+			prof.Stop()
+			evaldo.ExitHook = nil
+			if err := prof.Report(os.Stderr, *CoverProfile, *CoverHTML, *CoverMin); err != nil {
+				handleError(err, "coverage", true)
+			}
+		})
+	}
+	evaldo.ExitHook = finish
+	return finish
+}
+
+// startProfile profiles the script, the returned function writes the profile, it's also called if
+// the script exits
+func startProfile(ps *env.ProgramState, file string, content string, block env.Block) func() {
+	prof := profiler.New()
+	root := "main"
+	if file != "" {
+		prof.AddFile(file, content, block)
+		root = filepath.Base(file)
+	}
+	prof.Start(ps, root)
+	prevExit := evaldo.ExitHook
+	var once sync.Once
+	finish := func() {
+		once.Do(func() {
+			prof.Stop()
+			evaldo.ExitHook = prevExit
+			if err := prof.WriteFile(*Profile); err != nil {
+				handleError(err, "writing the profile", false)
+			} else {
+				fmt.Fprintln(os.Stderr, "[profile] written to "+*Profile)
+			}
+			if prevExit != nil {
+				prevExit()
+			}
+		})
+	}
+	evaldo.ExitHook = finish
+	return finish
+}
+
+//
+// main for the package manager, "rye pkg install" installs the libraries of rye.pkg into rye_modules
+//
+
+func main_rye_pkg(args []string) {
+	if err := ryepkg.Main(args); err != nil {
+		handleError(err, "rye pkg", true)
+	}
+}
+
+//
+// main for the test runner, "rye test" runs the tests of the *_test.rye files in the current directory
+//
+
+func main_rye_test(args []string, regfn func(*env.ProgramState) error) {
+	os.Exit(ryetest.Main(args, func(file string) (*env.ProgramState, error) {
+		return debugProgramState(file, regfn)
+	}))
+}
+
+//
+// main for the formatter, "rye fmt" formats the given *.rye files or directories, or stdin
+//
+
+func main_rye_fmt(args []string) {
+	os.Exit(ryefmt.Main(args))
+}
+
+//
+// main for the linter, "rye lint" checks the *.rye files in the current directory
+//
+
+func main_rye_lint(args []string, regfn func(*env.ProgramState) error) {
+	os.Exit(ryelint.Main(args, func() (*env.ProgramState, error) {
+		return debugProgramState("", regfn)
+	}))
+}
+
 //
 // main for awk like functionality with rye language
 //
@@ -1035,6 +1249,10 @@ func main_rye_file(file string, sig bool, subc bool, here bool, interactive bool
 		ps.EmbeddedFS = &embFS
 	}
 	ps.ScriptPath = file
+	// the script's arguments follow the script, after the options of rye (rye -cover main.rye test)
+	if !Option_Embed_Main && flag.Parsed() && flag.NArg() > 0 {
+		ps.ScriptArgIdx = len(os.Args) - flag.NArg() + 1
+	}
 
 	workingPath, err := os.Getwd()
 	if err != nil {
@@ -1097,6 +1315,13 @@ func main_rye_file(file string, sig bool, subc bool, here bool, interactive bool
 
 		ps = env.AddToProgramStateNEWWithLocation(ps, &val, ps.Idx)
 
+		if *Cover {
+			defer startCover(file, " "+content+"\n"+code, val)()
+		}
+		if *Profile != "" {
+			defer startProfile(ps, file, " "+content+"\n"+code, val)()
+		}
+
 		if subc {
 			ctx := ps.Ctx
 			ps.Ctx = env.NewEnv(ctx)
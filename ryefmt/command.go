@@ -0,0 +1,135 @@
+package ryefmt
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Main runs the rye fmt command with the arguments after "fmt" and returns the exit code: 0 if
+// all went well, 1 if a file couldn't be formatted or, with -check, isn't formatted, 2 for wrong
+// usage. Without files the code is read from stdin and written to stdout.
+func Main(args []string) int {
+	flags := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	write := flags.Bool("w", false, "Write the formatted code to the files instead of stdout")
+	check := flags.Bool("check", false, "List the files that aren't formatted and fail if there are any, for CI")
+	flags.Usage = func() {
+		fmt.Println("Usage: rye fmt [-w | -check] [files or directories]")
+		fmt.Println("\n Formats Rye code, the *.rye files of directories or stdin if no files are given.")
+		fmt.Println("\n Options:")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *write && *check {
+		fmt.Fprintln(os.Stderr, "Use either -w or -check")
+		return 2
+	}
+
+	if flags.NArg() == 0 {
+		src, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		res, err := Format(src)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "<stdin>:", err)
+			return 1
+		}
+		if *check {
+			if !bytes.Equal(src, res) {
+				fmt.Println("<stdin>")
+				return 1
+			}
+			return 0
+		}
+		os.Stdout.Write(res)
+		return 0
+	}
+
+	files, err := Discover(flags.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	code := 0
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			code = 1
+			continue
+		}
+		res, err := Format(src)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", file, err)
+			code = 1
+			continue
+		}
+		switch {
+		case *check:
+			if !bytes.Equal(src, res) {
+				fmt.Println(file)
+				code = 1
+			}
+		case *write:
+			if bytes.Equal(src, res) {
+				continue
+			}
+			info, err := os.Stat(file)
+			if err == nil {
+				err = os.WriteFile(file, res, info.Mode().Perm())
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				code = 1
+			}
+		default:
+			os.Stdout.Write(res)
+		}
+	}
+	return code
+}
+
+// Discover returns the files and the *.rye files in the directories, rye_modules and hidden
+// directories are skipped
+func Discover(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				name := d.Name()
+				if p != path && (name == "rye_modules" || strings.HasPrefix(name, ".")) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if strings.HasSuffix(p, ".rye") {
+				files = append(files, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
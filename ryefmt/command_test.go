@@ -0,0 +1,65 @@
+package ryefmt
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runMain runs the command with its output in a file and returns the exit code and the output
+func runMain(t *testing.T, args ...string) (int, string) {
+	t.Helper()
+	out, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	stdout := os.Stdout
+	os.Stdout = out
+	code := Main(args)
+	os.Stdout = stdout
+	res, err := os.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code, string(res)
+}
+
+func TestMainCheck(t *testing.T) {
+	dir := t.TempDir()
+	formatted := filepath.Join(dir, "ok.rye")
+	unformatted := filepath.Join(dir, "lib", "bad.rye")
+	os.MkdirAll(filepath.Dir(unformatted), 0755)
+	os.WriteFile(formatted, []byte("x: 1 + 2\n"), 0644)
+	os.WriteFile(unformatted, []byte("x:   1 +  2\n"), 0644)
+
+	if code, out := runMain(t, "-check", formatted); code != 0 || out != "" {
+		t.Errorf("expected 0 for a formatted file, got %d %q", code, out)
+	}
+	// the files that aren't formatted are listed, and aren't changed
+	if code, out := runMain(t, "-check", dir); code != 1 || strings.TrimSpace(out) != unformatted {
+		t.Errorf("expected 1 and the unformatted file, got %d %q", code, out)
+	}
+	if src, _ := os.ReadFile(unformatted); string(src) != "x:   1 +  2\n" {
+		t.Errorf("-check changed the file: %q", src)
+	}
+
+	if code, _ := runMain(t, "-w", dir); code != 0 {
+		t.Errorf("expected -w to succeed, got %d", code)
+	}
+	if code, out := runMain(t, "-check", dir); code != 0 || out != "" {
+		t.Errorf("expected 0 after -w, got %d %q", code, out)
+	}
+
+	os.WriteFile(unformatted, []byte("x: { 1 2\n"), 0644)
+	if code, _ := runMain(t, "-check", unformatted); code != 1 {
+		t.Errorf("expected 1 for code that doesn't load, got %d", code)
+	}
+	if code, _ := runMain(t, "-check", "-w", dir); code != 2 {
+		t.Errorf("expected 2 for -check with -w, got %d", code)
+	}
+	if code, _ := runMain(t, "-check", filepath.Join(dir, "missing.rye")); code != 2 {
+		t.Errorf("expected 2 for a missing file, got %d", code)
+	}
+}
@@ -0,0 +1,186 @@
+// Package ryefmt formats Rye code. The code is split into tokens by the lexer of the no-PEG loader,
+// which keeps the comments, and printed with one space between the tokens of a line, at most one
+// empty line between lines and a tab of indentation for each block, group or list that is open
+// at the start of a line. Lines that start with an op-word or a pipe-word continue the expression
+// of the line before them and are indented one more tab.
+//
+// Line breaks stay where they were written, the formatter doesn't join or split lines.
+package ryefmt
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/refaktor/rye/env"
+	"github.com/refaktor/rye/loader"
+)
+
+// Format returns the canonical form of the Rye code. The code must load, the tokens of the
+// formatted code are checked to be the same as the tokens of the code.
+func Format(src []byte) ([]byte, error) {
+	input := strings.ReplaceAll(string(src), "\r\n", "\n")
+	if res, _ := loader.LoadStringNoPEG(input, false); res.Type() == env.ErrorType {
+		return nil, fmt.Errorf("%s", strings.TrimSpace(res.(env.Error).Message))
+	}
+
+	var out strings.Builder
+	body := input
+	// the bang line isn't Rye code, it's kept as it is
+	if strings.HasPrefix(body, "#!") {
+		head, rest, _ := strings.Cut(body, "\n")
+		out.WriteString(strings.TrimRight(head, " \t") + "\n")
+		body = rest
+	}
+	toks, err := tokenize(body)
+	if err != nil {
+		return nil, err
+	}
+	n := out.Len()
+	if err := format(&out, toks); err != nil {
+		return nil, err
+	}
+
+	// a bug in the formatter must not change the code
+	res, err := tokenize(out.String()[n:])
+	if err != nil || !slices.EqualFunc(toks, res, func(a, b token) bool { return a.typ == b.typ && a.text == b.text }) {
+		return nil, fmt.Errorf("the formatted code doesn't have the same tokens")
+	}
+	return []byte(out.String()), nil
+}
+
+type token struct {
+	typ  int
+	text string
+	line int
+}
+
+// tokenize returns the tokens of the code with their text as it was written and the line they
+// start at
+func tokenize(body string) ([]token, error) {
+	var toks []token
+	l := loader.NewLexer(body)
+	line, counted := 1, 0
+	for {
+		tok := l.NextToken()
+		if tok.Type == loader.NPEG_TOKEN_EOF {
+			return toks, nil
+		}
+		if tok.Type == loader.NPEG_TOKEN_ERROR {
+			return nil, fmt.Errorf("line %d: %s", tok.Line, tok.Value)
+		}
+		start, end := l.TokenSpan()
+		end = min(end, len(body))
+		// the lines are counted from the input, the lexer's line of a token is off by one when
+		// the input starts with an empty line
+		line += strings.Count(body[counted:start], "\n")
+		counted = start
+		text := body[start:end]
+		if tok.Type == loader.NPEG_TOKEN_COMMENT {
+			text = strings.TrimRight(text, " \t\r")
+		}
+		toks = append(toks, token{tok.Type, text, line})
+	}
+}
+
+type printer struct {
+	out      *strings.Builder
+	lineNo   int      // the number of output lines
+	tokens   []string // the tokens of the line that is being collected
+	types    []int
+	open     []int // the output lines of the open brackets
+	lastLine int   // the input line the previous token ended on
+}
+
+func format(out *strings.Builder, toks []token) error {
+	p := &printer{out: out}
+	for _, tok := range toks {
+		if len(p.tokens) > 0 && tok.line > p.lastLine {
+			p.flush()
+			if tok.line > p.lastLine+1 {
+				p.out.WriteString("\n")
+			}
+		}
+		p.tokens = append(p.tokens, tok.text)
+		p.types = append(p.types, tok.typ)
+		p.lastLine = tok.line + strings.Count(tok.text, "\n")
+	}
+	if len(p.tokens) > 0 {
+		p.flush()
+	}
+	if len(p.open) > 0 {
+		return fmt.Errorf("line %d: the block isn't closed", p.lastLine)
+	}
+	return nil
+}
+
+// flush writes the collected line with its indentation
+func (p *printer) flush() {
+	i := 0
+	// the brackets a line starts with close before the line is indented
+	for ; i < len(p.types) && isClosing(p.types[i]); i++ {
+		p.close()
+	}
+	indent := p.depth()
+	if isContinuation(p.types[0]) {
+		indent++
+	}
+	for ; i < len(p.types); i++ {
+		switch {
+		case isClosing(p.types[i]):
+			p.close()
+		case isOpening(p.types[i]):
+			p.open = append(p.open, p.lineNo)
+		}
+	}
+
+	p.out.WriteString(strings.Repeat("\t", indent))
+	p.out.WriteString(strings.Join(p.tokens, " "))
+	p.out.WriteString("\n")
+	p.lineNo++
+	p.tokens = p.tokens[:0]
+	p.types = p.types[:0]
+}
+
+func (p *printer) close() {
+	if len(p.open) > 0 {
+		p.open = p.open[:len(p.open)-1]
+	}
+}
+
+// depth is the number of lines with open brackets, brackets opened on the same line indent once
+func (p *printer) depth() int {
+	depth := 0
+	for i, line := range p.open {
+		if i == 0 || line != p.open[i-1] {
+			depth++
+		}
+	}
+	return depth
+}
+
+func isOpening(typ int) bool {
+	switch typ {
+	case loader.NPEG_TOKEN_BLOCK_START, loader.NPEG_TOKEN_BBLOCK_START, loader.NPEG_TOKEN_OPBBLOCK_START,
+		loader.NPEG_TOKEN_LIST_BLOCK_START, loader.NPEG_TOKEN_LIST_BBLOCK_START,
+		loader.NPEG_TOKEN_DICT_BLOCK_START, loader.NPEG_TOKEN_DICT_BBLOCK_START,
+		loader.NPEG_TOKEN_GROUP_START, loader.NPEG_TOKEN_OPGROUP_START, loader.NPEG_TOKEN_OPBLOCK_START:
+		return true
+	}
+	return false
+}
+
+func isClosing(typ int) bool {
+	return typ == loader.NPEG_TOKEN_BLOCK_END || typ == loader.NPEG_TOKEN_BBLOCK_END || typ == loader.NPEG_TOKEN_GROUP_END
+}
+
+// isContinuation tells if a line that starts with the token continues the expression before it
+func isContinuation(typ int) bool {
+	switch typ {
+	case loader.NPEG_TOKEN_OPWORD, loader.NPEG_TOKEN_DOTWORD, loader.NPEG_TOKEN_PIPEWORD, loader.NPEG_TOKEN_ONECHARPIPE,
+		loader.NPEG_TOKEN_OPCPATH, loader.NPEG_TOKEN_PIPECPATH, loader.NPEG_TOKEN_OPBLOCK_START,
+		loader.NPEG_TOKEN_OPBBLOCK_START, loader.NPEG_TOKEN_OPGROUP_START:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package ryefmt
+
+import "testing"
+
+func TestFormat(t *testing.T) {
+	src := "\n\nx:   { 1 ,  2\n  } \nadd: fn { a b } {   ; adds   \n    a   + b\n|print\n\n\n\n  .inc\n    either a { { \n  b\n} } { a\n}\n}\ns: `one\n  two` |print   len\n"
+	want := "x: { 1 , 2\n}\nadd: fn { a b } { ; adds\n\ta + b\n\t\t|print\n\n\t\t.inc\n\teither a { {\n\t\tb\n\t} } { a\n\t}\n}\ns: `one\n  two` |print len\n"
+	res, err := Format([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res) != want {
+		t.Errorf("unexpected format:\n%s", res)
+	}
+	again, err := Format(res)
+	if err != nil || string(again) != want {
+		t.Errorf("formatting the formatted code changed it:\n%s", again)
+	}
+	if _, err := Format([]byte("x: { 1 2")); err == nil {
+		t.Error("code that doesn't load should be an error")
+	}
+}
+
+func TestFormatCases(t *testing.T) {
+	cases := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"op-words", "x: 1   + 2  *   3\n", "x: 1 + 2 * 3\n"},
+		{"op-word continues the line", "x: 1\n    + 2\n", "x: 1\n\t+ 2\n"},
+		{"pipe-words", "{ 1 2 } |map   { .inc }   |print\n", "{ 1 2 } |map { .inc } |print\n"},
+		{"pipe-word continues the line", "{ 1 2 } \n|map { .inc }\n", "{ 1 2 }\n\t|map { .inc }\n"},
+		{"dot-words", "x: a  .add   1\n", "x: a .add 1\n"},
+		{"commas", "print 1  ,   print 2 ,  print 3\n", "print 1 , print 2 , print 3\n"},
+		{"commas in blocks", "f: fn { } {   a  ,   b }\n", "f: fn { } { a , b }\n"},
+		{"trailing comments", "x: 1   ; one   \ny: 2 ;two\n", "x: 1 ; one\ny: 2 ;two\n"},
+		{"comment lines", "; alone   \n  ; indented\n{\n; in a block\n}\n", "; alone\n; indented\n{\n\t; in a block\n}\n"},
+		{"string with ;", "s: \"a ; b\"   |print\n", "s: \"a ; b\" |print\n"},
+		{"string with ; and a comment", "s: \"a;b\"   ; c ; d\n", "s: \"a;b\" ; c ; d\n"},
+		{"multiline string with ;", "s: `one ;\n   two`   ; c\n", "s: `one ;\n   two` ; c\n"},
+		{"formatted", "x: 1 + 2\n\n{ 1 2 }\n\t|map { .inc }\n", "x: 1 + 2\n\n{ 1 2 }\n\t|map { .inc }\n"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res, err := Format([]byte(c.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(res) != c.want {
+				t.Errorf("expected %q, got %q", c.want, res)
+			}
+			if again, err := Format(res); err != nil || string(again) != c.want {
+				t.Errorf("formatting the formatted code changed it: %q", again)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package ryelint
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/refaktor/rye/env"
+	"github.com/refaktor/rye/ryefmt"
+)
+
+// Main runs the rye lint command with the arguments after "lint" and returns the exit code: 0 if
+// no issues were found, 1 if there were issues or a file didn't load, 2 for wrong usage. The
+// setup function returns the program state with the builtins the code is checked against.
+func Main(args []string, setup func() (*env.ProgramState, error)) int {
+	fs := flag.NewFlagSet("lint", flag.ContinueOnError)
+	enable := fs.String("enable", "", "Run only these rules, separated by commas")
+	disable := fs.String("disable", "", "Don't run these rules, separated by commas")
+	format := fs.String("format", "text", "Output format: text or json")
+	fs.Usage = func() {
+		fmt.Println("Usage: rye lint [options] [files or directories]")
+		fmt.Println("\n Checks Rye code for common mistakes, the *.rye files in the current directory if none are given.")
+		fmt.Println("\n Options:")
+		fs.PrintDefaults()
+		fmt.Println("\n Rules:")
+		for _, r := range Rules {
+			fmt.Printf("  %-18s %s\n", r.Name, r.Doc)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *format != "text" && *format != "json" {
+		fmt.Fprintln(os.Stderr, "Unknown format "+*format+", use text or json")
+		return 2
+	}
+
+	var rules []string
+	if *enable != "" {
+		rules = strings.Split(*enable, ",")
+	} else {
+		for _, r := range Rules {
+			rules = append(rules, r.Name)
+		}
+	}
+	if *disable != "" {
+		for _, name := range strings.Split(*disable, ",") {
+			if !knownRule(name) {
+				fmt.Fprintln(os.Stderr, "Unknown rule", name)
+				return 2
+			}
+			rules = remove(rules, name)
+		}
+	}
+	ps, err := setup()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	linter, err := New(ps, rules)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	files, err := ryefmt.Discover(paths)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	code := 0
+	issues := make([]Issue, 0)
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err == nil {
+			var found []Issue
+			found, err = linter.File(file, content)
+			issues = append(issues, found...)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			code = 1
+		}
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(issues)
+	} else {
+		for _, issue := range issues {
+			fmt.Println(issue)
+		}
+	}
+	if len(issues) > 0 {
+		return 1
+	}
+	return code
+}
+
+func knownRule(name string) bool {
+	for _, r := range Rules {
+		if r.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func remove(rules []string, name string) []string {
+	res := rules[:0]
+	for _, r := range rules {
+		if r != name {
+			res = append(res, r)
+		}
+	}
+	return res
+}
@@ -0,0 +1,508 @@
+// Package ryelint finds common mistakes in Rye code without running it. A file is loaded into
+// blocks, and the blocks that are code (the file, the blocks given to builtins, the bodies of
+// functions) are walked expression by expression, with the words looked up in the builtins of the
+// program state and in the words the file sets. The blocks given to dialects like dict and table
+// and the blocks that are only values are data, their words aren't checked.
+//
+// A word is bound if the file, or a .rye file it refers to with a file uri, sets it with a
+// set-word, a mod-word, a tag-word (var 'x 1) or as a function parameter. Words of contexts that
+// are entered with with or do\in aren't known.
+package ryelint
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/refaktor/rye/debugger"
+	"github.com/refaktor/rye/env"
+	"github.com/refaktor/rye/loader"
+	"github.com/refaktor/rye/ryetest"
+)
+
+// Rule is a check of the linter
+type Rule struct {
+	Name string
+	Doc  string
+}
+
+// Rules are the checks the linter knows, all of them run by default
+var Rules = []Rule{
+	{"unused-setword", "A word is set but never used in the file"},
+	{"unbound-word", "A word isn't a builtin and the file doesn't set it"},
+	{"arity", "A builtin or function gets fewer arguments than it takes"},
+	{"return-outside-fn", "A returning builtin like ^check or ^fix is used outside of a function"},
+	{"shadow-builtin", "A set-word replaces a builtin like print"},
+}
+
+// Issue is a mistake found in a file
+type Issue struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func (i Issue) String() string {
+	return fmt.Sprintf("%s:%d: %s (%s)", i.File, i.Line, i.Message, i.Rule)
+}
+
+// Linter checks files against the builtins of a program state
+type Linter struct {
+	ps    *env.ProgramState
+	rules map[string]bool
+}
+
+// New returns a linter that runs the rules, all of them if rules is nil
+func New(ps *env.ProgramState, rules []string) (*Linter, error) {
+	l := &Linter{ps: ps, rules: make(map[string]bool)}
+	for _, r := range Rules {
+		l.rules[r.Name] = rules == nil
+	}
+	for _, name := range rules {
+		if _, ok := l.rules[name]; !ok {
+			return nil, fmt.Errorf("unknown rule %s", name)
+		}
+		l.rules[name] = true
+	}
+	return l, nil
+}
+
+// the builtins that create functions and which of their arguments are the parameters and the body
+var fnBuiltins = map[string][2]int{
+	"fn": {0, 1}, "pfn": {0, 1}, "closure": {0, 1}, "fn\\cc": {0, 1}, "fnc": {0, 2}, "does": {-1, 0}, "fn1": {-1, 0},
+}
+
+// the builtins that take blocks of data, the words in them aren't evaluated
+var dataBuiltins = map[string]bool{
+	"dict": true, "list": true, "table": true, "spreadsheet": true, "validate": true, "kind": true, "parse-args": true,
+}
+
+// the builtins whose first argument is a block of code, the first argument of other builtins is
+// usually the data they work on (length? { 1 2 }, map { 1 2 } { .inc })
+var codeBuiltins = map[string]bool{
+	"do": true, "try": true, "forever": true, "defer": true, "go": true, "time-it": true, "profile": true,
+}
+
+// the builtins whose blocks are the code of a context, their set-words are its fields
+var contextBuiltins = map[string]bool{
+	"context": true, "extends": true, "isolate": true, "raw-context": true, "private": true, "private\\": true,
+}
+
+// unknownArity is the arity of the words the file sets to values that aren't functions, or to
+// functions with different arities, calls of them aren't checked
+const unknownArity = -1
+
+type fileLint struct {
+	*Linter
+	file   string
+	smap   *debugger.SourceMap
+	defs   map[int]bool // the words the file sets
+	uses   map[int]bool // the words the file uses
+	arity  map[int]int  // the arity of the functions the file sets
+	issues []Issue
+}
+
+// scope is what is known about the block of code that is walked
+type scope struct {
+	top    bool // the block of the file
+	inFn   bool // in the body of a function
+	inCtx  bool // the set-words are fields of a context
+	inject bool // the block may be evaluated with an injected value
+}
+
+// File lints the Rye code of a file
+func (l *Linter) File(file string, content []byte) ([]Issue, error) {
+	res := loader.LoadString(string(content), false, l.ps)
+	block, ok := res.(env.Block)
+	if !ok {
+		if e, ok := res.(env.Error); ok {
+			return nil, fmt.Errorf("%s: %s", file, e.Message)
+		}
+		return nil, fmt.Errorf("%s: the file didn't load", file)
+	}
+	f := &fileLint{
+		Linter: l,
+		file:   file,
+		smap:   debugger.NewSourceMap(file, string(content), block),
+		defs:   make(map[int]bool),
+		uses:   make(map[int]bool),
+		arity:  make(map[int]int),
+	}
+	if strings.HasSuffix(file, "_test.rye") {
+		// the test runner adds test, equal, ... to the test files
+		for name, bi := range ryetest.Builtins() {
+			idx := l.ps.Idx.IndexWord(name)
+			f.defs[idx] = true
+			f.arity[idx] = bi.Argsn
+		}
+	}
+	f.collect(block, filepath.Dir(file), map[string]bool{file: true})
+	f.code(block, scope{top: true})
+	slices.SortStableFunc(f.issues, func(a, b Issue) int { return a.Line - b.Line })
+	return f.issues, nil
+}
+
+func (f *fileLint) report(rule string, ser env.TSeries, pos int, format string, args ...any) {
+	if !f.rules[rule] {
+		return
+	}
+	_, line, _ := f.smap.Line(ser, pos)
+	f.issues = append(f.issues, Issue{File: f.file, Line: line, Rule: rule, Message: fmt.Sprintf(format, args...)})
+}
+
+func (f *fileLint) name(idx int) string {
+	return f.ps.Idx.GetWord(idx)
+}
+
+// setArity remembers what a word is set to, calls of words set to different things aren't checked
+func (f *fileLint) setArity(idx int, arity int) {
+	if old, ok := f.arity[idx]; ok && old != arity {
+		arity = unknownArity
+	}
+	f.arity[idx] = arity
+}
+
+// collect goes through all the values of the block and notes the words that are set and used, and
+// the arity of the functions that are set. The .rye files the block refers to are collected too,
+// for the words they set.
+func (f *fileLint) collect(block env.Block, dir string, seen map[string]bool) {
+	ser := block.Series.S
+	for i, obj := range ser {
+		switch o := obj.(type) {
+		case env.Setword:
+			f.defs[o.Index] = true
+			f.setArity(o.Index, f.valueArity(ser, i+1))
+		case env.Modword:
+			f.defs[o.Index] = true
+			f.setArity(o.Index, f.valueArity(ser, i+1))
+		case env.LSetword:
+			f.defs[o.Index] = true
+			f.setArity(o.Index, unknownArity)
+		case env.LModword:
+			f.defs[o.Index] = true
+			f.setArity(o.Index, unknownArity)
+		case env.Tagword:
+			f.defs[o.Index] = true
+			f.uses[o.Index] = true
+			f.setArity(o.Index, unknownArity)
+		case env.Word:
+			f.uses[o.Index] = true
+			// the parameters of functions are set when they are called
+			if spec, ok := fnBuiltins[f.name(o.Index)]; ok && spec[0] == 0 && i+1 < len(ser) {
+				if params, ok := ser[i+1].(env.Block); ok {
+					for _, p := range params.Series.S {
+						if w, ok := p.(env.Word); ok {
+							f.defs[w.Index] = true
+							f.setArity(w.Index, unknownArity)
+						}
+					}
+				}
+			}
+		case env.Getword:
+			f.uses[o.Index] = true
+		case env.Opword:
+			f.uses[o.Index] = true
+		case env.Dotword:
+			f.uses[o.Index] = true
+		case env.Pipeword:
+			f.uses[o.Index] = true
+		case env.CPath:
+			for _, w := range o.Words {
+				f.uses[w.Index] = true
+			}
+		case env.Uri:
+			f.collectFile(o, dir, seen)
+		case env.Block:
+			f.collect(o, dir, seen)
+		}
+	}
+}
+
+// collectFile collects the words a .rye file the code refers to sets, so the words of imported
+// files are bound
+func (f *fileLint) collectFile(uri env.Uri, dir string, seen map[string]bool) {
+	path := uri.GetPath()
+	if !strings.HasSuffix(path, ".rye") {
+		return
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	if seen[path] {
+		return
+	}
+	seen[path] = true
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	if block, ok := loader.LoadString(string(content), false, f.ps).(env.Block); ok {
+		// only the words it sets are of interest, what it uses doesn't make the words of this file used
+		uses := f.uses
+		f.uses = make(map[int]bool)
+		f.collect(block, filepath.Dir(path), seen)
+		f.uses = uses
+	}
+}
+
+// valueArity returns the arity of the function the value at pos creates
+func (f *fileLint) valueArity(ser []env.Object, pos int) int {
+	if pos >= len(ser) {
+		return unknownArity
+	}
+	w, ok := ser[pos].(env.Word)
+	if !ok {
+		return unknownArity
+	}
+	spec, ok := fnBuiltins[f.name(w.Index)]
+	if !ok {
+		return unknownArity
+	}
+	if spec[0] < 0 {
+		if f.name(w.Index) == "fn1" {
+			return 1
+		}
+		return 0
+	}
+	pos += 1 + spec[0]
+	if pos >= len(ser) {
+		return unknownArity
+	}
+	params, ok := ser[pos].(env.Block)
+	if !ok {
+		return unknownArity
+	}
+	n := 0
+	for _, p := range params.Series.S {
+		if _, ok := p.(env.Word); ok {
+			n++
+		}
+	}
+	return n
+}
+
+// lookup returns the builtin or function the word is bound to in the program state
+func (f *fileLint) lookup(idx int) (env.Object, bool) {
+	return f.ps.Ctx.Get(idx)
+}
+
+// callArity returns the number of arguments a word takes and if it's a builtin, the arity is
+// unknownArity for words that aren't bound to functions
+func (f *fileLint) callArity(idx int) (int, bool) {
+	if arity, ok := f.arity[idx]; ok {
+		return arity, false
+	}
+	if obj, ok := f.lookup(idx); ok {
+		return builtinArity(obj), true
+	}
+	// generic words are builtins of kinds, called by the kind of the first argument, the kinds
+	// can take different numbers of arguments
+	name := f.name(idx)
+	arity, builtin := unknownArity, false
+	if name != "" && name[0] >= 'A' && name[0] <= 'Z' {
+		for kind := range f.ps.Gen.GetKinds() {
+			if obj, ok := f.ps.Gen.Get(kind, idx); ok {
+				if n := builtinArity(obj); !builtin || n < arity {
+					arity = n
+				}
+				builtin = true
+			}
+		}
+	}
+	return arity, builtin
+}
+
+func builtinArity(obj env.Object) int {
+	switch fn := obj.(type) {
+	case env.Builtin:
+		return fn.Argsn
+	case env.VarBuiltin:
+		return fn.Argsn
+	case env.Function:
+		return fn.Argsn
+	}
+	return unknownArity
+}
+
+// bound tells if the word is set by the file or is a builtin, reports it if it isn't
+func (f *fileLint) bound(idx int, ser env.TSeries, pos int, sc scope) {
+	name := f.name(idx)
+	if strings.HasPrefix(name, "^") && !sc.inFn {
+		f.report("return-outside-fn", ser, pos, "%s returns from a function but it's used outside of one", name)
+	}
+	if f.defs[idx] {
+		return
+	}
+	if _, ok := f.lookup(idx); ok {
+		return
+	}
+	if _, builtin := f.callArity(idx); builtin {
+		return
+	}
+	f.report("unbound-word", ser, pos, "%s isn't bound, it's not a builtin and the file doesn't set it", name)
+}
+
+// set checks a set-word at pos
+func (f *fileLint) set(idx int, ser env.TSeries, pos int, sc scope) {
+	if sc.inCtx || f.name(idx) == "" {
+		return
+	}
+	if obj, ok := f.lookup(idx); ok && builtinArity(obj) != unknownArity {
+		f.report("shadow-builtin", ser, pos, "%s: replaces the builtin %s", f.name(idx), f.name(idx))
+	}
+	// the functions a file sets are its exports
+	if arity, ok := f.arity[idx]; ok && sc.top && arity != unknownArity {
+		return
+	}
+	if !f.uses[idx] {
+		f.report("unused-setword", ser, pos, "%s is set but never used", f.name(idx))
+	}
+}
+
+// code walks the expressions of a block of code
+func (f *fileLint) code(block env.Block, sc scope) {
+	ser := block.Series
+	inject := sc.inject
+	for i := 0; i < len(ser.S); {
+		if _, ok := ser.S[i].(env.Comma); ok {
+			i++
+			continue
+		}
+		i, inject = f.expr(ser, i, sc, inject)
+	}
+}
+
+// expr walks an expression and the op-words, dot-words, pipe-words and left set-words that
+// continue it, it returns the position after it and if the expression ended with a dot-word,
+// its value is then injected into the next expression
+func (f *fileLint) expr(ser env.TSeries, i int, sc scope, inject bool) (int, bool) {
+	i = f.term(ser, i, sc, inject)
+	dot := false
+	for i < len(ser.S) {
+		switch w := ser.S[i].(type) {
+		case env.Opword:
+			i = f.call(ser, i, w.Index, 1, sc, false)
+		case env.Dotword:
+			i = f.call(ser, i, w.Index, 1, sc, false)
+			dot = true
+			continue
+		case env.Pipeword:
+			i = f.call(ser, i, w.Index, 1, sc, false)
+		case env.LSetword:
+			f.set(w.Index, ser, i, sc)
+			i++
+		case env.LModword:
+			i++
+		default:
+			return i, dot
+		}
+		dot = false
+	}
+	return i, dot
+}
+
+// term walks a value or a call with its arguments
+func (f *fileLint) term(ser env.TSeries, i int, sc scope, inject bool) int {
+	switch o := ser.S[i].(type) {
+	case env.Word:
+		return f.call(ser, i, o.Index, 0, sc, inject)
+	case env.Getword:
+		f.bound(o.Index, ser, i, sc)
+	case env.Setword:
+		f.set(o.Index, ser, i, sc)
+		return f.value(ser, i, f.name(o.Index)+":", sc)
+	case env.Modword:
+		return f.value(ser, i, f.name(o.Index)+"::", sc)
+	case env.Block:
+		// blocks are data, only the values of [ ] and the expression of ( ) are evaluated
+		if o.Mode != 0 {
+			f.code(o, scope{inFn: sc.inFn})
+		}
+	}
+	return i + 1
+}
+
+// value walks the expression a set-word at i sets the word to
+func (f *fileLint) value(ser env.TSeries, i int, word string, sc scope) int {
+	if i+1 >= len(ser.S) || isComma(ser.S[i+1]) {
+		f.report("arity", ser, i, "%s has no value", word)
+		return i + 1
+	}
+	i, _ = f.expr(ser, i+1, scope{inFn: sc.inFn}, false)
+	return i
+}
+
+// call walks the arguments of the word at i, given of them are the value on its left
+func (f *fileLint) call(ser env.TSeries, i int, idx int, given int, sc scope, inject bool) int {
+	f.bound(idx, ser, i, sc)
+	arity, builtin := f.callArity(idx)
+	name := f.name(idx)
+	if _, ok := ser.S[i].(env.Opword); ok {
+		name = strings.TrimPrefix(name, "_")
+	}
+	at := i
+	i++
+	for n := given; n < arity; n++ {
+		if i >= len(ser.S) || isComma(ser.S[i]) {
+			// the value injected into a block is the first argument of the first call
+			if !(inject && n == arity-1) {
+				f.report("arity", ser, at, "%s takes %d %s, got %d", name, arity, plural(arity, "argument"), n)
+			}
+			return i
+		}
+		if block, ok := ser.S[i].(env.Block); ok && block.Mode == 0 {
+			if builtin {
+				f.blockArg(name, n, block, sc)
+			}
+			i++
+			continue
+		}
+		i = f.arg(ser, i, sc)
+	}
+	return i
+}
+
+// arg walks an argument, a value or a call, with the op-words and dot-words that continue it
+func (f *fileLint) arg(ser env.TSeries, i int, sc scope) int {
+	i = f.term(ser, i, sc, false)
+	for i < len(ser.S) {
+		switch w := ser.S[i].(type) {
+		case env.Opword:
+			i = f.call(ser, i, w.Index, 1, sc, false)
+		case env.Dotword:
+			i = f.call(ser, i, w.Index, 1, sc, false)
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// blockArg walks a block given to a builtin as code, unless the builtin takes data
+func (f *fileLint) blockArg(name string, n int, block env.Block, sc scope) {
+	if spec, ok := fnBuiltins[name]; ok {
+		if n == spec[1] {
+			f.code(block, scope{inFn: true, inject: spec[0] < 0})
+		}
+		return
+	}
+	if dataBuiltins[name] || (n == 0 && !codeBuiltins[name] && !contextBuiltins[name]) {
+		return
+	}
+	f.code(block, scope{inFn: sc.inFn, inCtx: contextBuiltins[name], inject: true})
+}
+
+func isComma(obj env.Object) bool {
+	_, ok := obj.(env.Comma)
+	return ok
+}
+
+func plural(n int, word string) string {
+	if n == 1 {
+		return word
+	}
+	return word + "s"
+}
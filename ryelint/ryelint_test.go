@@ -0,0 +1,53 @@
+package ryelint
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/refaktor/rye/env"
+	"github.com/refaktor/rye/evaldo"
+)
+
+const script = `print: fn { x } { x }
+add-one: fn { x } {
+	unused: x + 1
+	x + 1
+}
+data: dict { name "Jim" age 30 }
+print add-one ,
+^check data "no data"
+missing 1 , for { 1 2 } { .inc |print }
+join { "a" "b" } |length?
+`
+
+func TestFile(t *testing.T) {
+	ps := env.NewProgramState()
+	evaldo.RegisterBuiltins(ps)
+	linter, err := New(ps, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issues, err := linter.File("script.rye", []byte(script))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, issue := range issues {
+		got = append(got, issue.String())
+	}
+	want := []string{
+		"script.rye:1: print: replaces the builtin print (shadow-builtin)",
+		"script.rye:3: unused is set but never used (unused-setword)",
+		"script.rye:7: add-one takes 1 argument, got 0 (arity)",
+		"script.rye:8: ^check returns from a function but it's used outside of one (return-outside-fn)",
+		"script.rye:9: missing isn't bound, it's not a builtin and the file doesn't set it (unbound-word)",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unexpected issues:\n%s", strings.Join(got, "\n"))
+	}
+
+	linter, _ = New(ps, []string{"arity"})
+	if issues, _ := linter.File("script.rye", []byte(script)); len(issues) != 1 || issues[0].Rule != "arity" {
+		t.Errorf("only the arity rule should run, got %v", issues)
+	}
+}
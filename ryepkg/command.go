@@ -0,0 +1,108 @@
+package ryepkg
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/refaktor/rye/security"
+)
+
+func usage() {
+	fmt.Println("Usage: rye pkg <command> [options]")
+	fmt.Println("\n Commands:")
+	fmt.Println("  add name source [ref]  adds a library from a git url or local directory and installs it")
+	fmt.Println("  install                installs the libraries of " + ManifestFile + " as locked in " + LockFile)
+	fmt.Println("  remove name            removes a library")
+	fmt.Println("  list                   lists the installed libraries")
+	fmt.Println("  verify                 checks the installed files against the hashes in " + LockFile)
+	fmt.Println("  mirror dir             copies the installed libraries to a mirror for offline installs")
+	fmt.Println("\n Options (before the arguments):")
+}
+
+// Main runs the rye pkg command with the arguments after "pkg"
+func Main(args []string) error {
+	if len(args) == 0 {
+		usage()
+		return errors.New("missing command")
+	}
+	fs := flag.NewFlagSet("pkg "+args[0], flag.ContinueOnError)
+	dir := fs.String("dir", ".", "Project directory")
+	mirror := fs.String("mirror", os.Getenv("RYE_PKG_MIRROR"), "Mirror directory with a copy of each library under its name (default $RYE_PKG_MIRROR)")
+	offline := fs.Bool("offline", false, "Don't fetch git sources, install only from the mirror and local directories")
+	keys := fs.String("keys", "", "File with trusted ed25519 public keys in hex, all .rye files must be signed")
+	fs.Usage = func() {
+		usage()
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	p := &Project{Dir: *dir, Mirror: *mirror, Offline: *offline, Log: os.Stdout}
+	if *keys != "" {
+		if err := loadKeys(*keys); err != nil {
+			return err
+		}
+		p.Signed = true
+	}
+
+	rest := fs.Args()
+	var err error
+	switch args[0] {
+	case "add":
+		if len(rest) < 2 || len(rest) > 3 {
+			fs.Usage()
+			return errors.New("add expects name source [ref]")
+		}
+		pkg := Package{Name: rest[0], Source: rest[1]}
+		if len(rest) == 3 {
+			pkg.Ref = rest[2]
+		}
+		_, err = p.Add(pkg)
+	case "install":
+		_, err = p.Install()
+	case "remove":
+		if len(rest) != 1 {
+			return errors.New("remove expects the name of a library")
+		}
+		_, err = p.Remove(rest[0])
+	case "list":
+		var locks map[string]Locked
+		locks, err = ReadLock(p.Dir)
+		for _, name := range sortedNames(locks) {
+			l := locks[name]
+			fmt.Printf("%-20s %s %s %s\n", name, l.Source, dash(l.Ref), dash(l.Rev))
+		}
+	case "verify":
+		err = p.Verify()
+		if err == nil {
+			fmt.Println("all libraries match " + LockFile)
+		}
+	case "mirror":
+		if len(rest) != 1 {
+			return errors.New("mirror expects a directory")
+		}
+		err = p.ExportMirror(rest[0])
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown command %s", args[0])
+	}
+	return err
+}
+
+// loadKeys trusts the keys in the file. Unlike the keys of a security policy the file doesn't
+// have to be owned by root, it's only used to check the libraries that get installed.
+func loadKeys(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := security.LoadPublicKeysFromStrings(strings.Fields(string(content))); err != nil {
+		return err
+	}
+	security.CurrentCodeSigEnabled = true
+	return nil
+}
@@ -0,0 +1,546 @@
+// Package ryepkg installs pure-Rye libraries into the vendored directory of a project, where
+// import finds them. The libraries are listed in the rye.pkg manifest, and the rye.lock lockfile
+// records the exact git commit and a hash of the files of each installed library, so the same
+// files are installed again later, on another machine or from an offline mirror.
+package ryepkg
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/refaktor/rye/evaldo"
+	"github.com/refaktor/rye/loader"
+)
+
+const (
+	ManifestFile = "rye.pkg"
+	LockFile     = "rye.lock"
+)
+
+// Package is a library listed in the manifest, a line "name source [ref]"
+type Package struct {
+	Name   string
+	Source string // git url or local directory
+	Ref    string // git branch, tag or commit, empty for the default branch
+}
+
+// Locked is a library as it was installed, a line "name source ref rev hash" of the lockfile
+type Locked struct {
+	Package
+	Rev  string // commit of a git source, empty for local directories
+	Hash string // hash of the installed files
+}
+
+// Project is a directory with a manifest. Libraries are installed into its rye_modules directory.
+type Project struct {
+	Dir     string
+	Mirror  string // directory with a copy (or git clone) of each library under its name, used before the source
+	Offline bool   // never fetch git sources, only the mirror and local directories are used
+	Signed  bool   // all .rye files of the libraries must have a valid ;ryesig signature
+	Log     io.Writer
+}
+
+func (p *Project) vendorDir() string {
+	return filepath.Join(p.Dir, evaldo.ModuleVendorDir)
+}
+
+func (p *Project) logf(format string, a ...any) {
+	if p.Log != nil {
+		fmt.Fprintf(p.Log, format, a...)
+	}
+}
+
+// ReadManifest reads the libraries of the project, a missing manifest has none
+func ReadManifest(dir string) ([]Package, error) {
+	rows, err := readRows(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		return nil, err
+	}
+	pkgs := make([]Package, 0, len(rows))
+	for _, row := range rows {
+		if len(row.fields) < 2 || len(row.fields) > 3 {
+			return nil, fmt.Errorf("%s:%d: expected name source [ref]", ManifestFile, row.line)
+		}
+		pkg := Package{Name: row.fields[0], Source: row.fields[1]}
+		if len(row.fields) == 3 {
+			pkg.Ref = row.fields[2]
+		}
+		if err := checkPackage(pkg); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", ManifestFile, row.line, err)
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	return pkgs, nil
+}
+
+func WriteManifest(dir string, pkgs []Package) error {
+	var b strings.Builder
+	b.WriteString("; Rye libraries of the project: name source [ref]\n")
+	for _, pkg := range pkgs {
+		b.WriteString(pkg.Name + " " + pkg.Source)
+		if pkg.Ref != "" {
+			b.WriteString(" " + pkg.Ref)
+		}
+		b.WriteString("\n")
+	}
+	return os.WriteFile(filepath.Join(dir, ManifestFile), []byte(b.String()), 0644)
+}
+
+// ReadLock reads the installed libraries by name, a missing lockfile has none
+func ReadLock(dir string) (map[string]Locked, error) {
+	rows, err := readRows(filepath.Join(dir, LockFile))
+	if err != nil {
+		return nil, err
+	}
+	locks := make(map[string]Locked, len(rows))
+	for _, row := range rows {
+		if len(row.fields) != 5 {
+			return nil, fmt.Errorf("%s:%d: expected name source ref rev hash", LockFile, row.line)
+		}
+		f := row.fields
+		lock := Locked{Package{f[0], f[1], undash(f[2])}, undash(f[3]), f[4]}
+		if err := checkPackage(lock.Package); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", LockFile, row.line, err)
+		}
+		if err := checkRev(lock.Rev); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", LockFile, row.line, err)
+		}
+		locks[f[0]] = lock
+	}
+	return locks, nil
+}
+
+func WriteLock(dir string, locks []Locked) error {
+	var b strings.Builder
+	b.WriteString("; Generated by rye pkg, do not edit: name source ref rev hash\n")
+	for _, l := range locks {
+		fmt.Fprintf(&b, "%s %s %s %s %s\n", l.Name, l.Source, dash(l.Ref), dash(l.Rev), l.Hash)
+	}
+	return os.WriteFile(filepath.Join(dir, LockFile), []byte(b.String()), 0644)
+}
+
+type row struct {
+	line   int
+	fields []string
+}
+
+// readRows returns the fields of the lines that aren't empty or ; comments
+func readRows(path string) ([]row, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rows := make([]row, 0)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, ";") {
+			continue
+		}
+		rows = append(rows, row{n, strings.Fields(line)})
+	}
+	return rows, scanner.Err()
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func undash(s string) string {
+	if s == "-" {
+		return ""
+	}
+	return s
+}
+
+func checkName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\ `) || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("invalid library name %q", name)
+	}
+	return nil
+}
+
+// checkPackage checks the name, and that the source and ref can't be taken for options of git
+func checkPackage(pkg Package) error {
+	if err := checkName(pkg.Name); err != nil {
+		return err
+	}
+	if pkg.Source == "" || strings.HasPrefix(pkg.Source, "-") {
+		return fmt.Errorf("invalid source %q", pkg.Source)
+	}
+	if strings.HasPrefix(pkg.Ref, "-") {
+		return fmt.Errorf("invalid ref %q", pkg.Ref)
+	}
+	return nil
+}
+
+// checkRev checks that a locked commit is a full hex commit id (sha1 or sha256), or empty
+func checkRev(rev string) error {
+	if rev == "" {
+		return nil
+	}
+	if len(rev) != 40 && len(rev) != 64 {
+		return fmt.Errorf("invalid commit %q", rev)
+	}
+	for _, c := range rev {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return fmt.Errorf("invalid commit %q", rev)
+		}
+	}
+	return nil
+}
+
+// HashDir hashes the names and contents of all files in the directory, in a way that doesn't
+// depend on the file system: "sha256:" and the sha256 of the sorted lines "<file sha256>  <path>".
+func HashDir(dir string) (string, error) {
+	lines := make([]string, 0)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		sum := sha256.Sum256(content)
+		lines = append(lines, hex.EncodeToString(sum[:])+"  "+filepath.ToSlash(rel)+"\n")
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	slices.Sort(lines)
+	sum := sha256.Sum256([]byte(strings.Join(lines, "")))
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// Install installs all libraries of the manifest. Libraries in the lockfile with the same source
+// and ref are installed at the locked commit and must have the locked hash, the others are
+// resolved again and their new commit and hash are locked. Libraries that were removed from the
+// manifest are removed from the vendored directory.
+func (p *Project) Install() ([]Locked, error) {
+	pkgs, err := ReadManifest(p.Dir)
+	if err != nil {
+		return nil, err
+	}
+	old, err := ReadLock(p.Dir)
+	if err != nil {
+		return nil, err
+	}
+	locks := make([]Locked, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		var lock *Locked
+		if l, ok := old[pkg.Name]; ok && l.Source == pkg.Source && l.Ref == pkg.Ref {
+			lock = &l
+		}
+		installed, err := p.install(pkg, lock)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", pkg.Name, err)
+		}
+		locks = append(locks, installed)
+	}
+	for name := range old {
+		if !slices.ContainsFunc(pkgs, func(pkg Package) bool { return pkg.Name == name }) {
+			p.logf("removing %s\n", name)
+			if err := os.RemoveAll(filepath.Join(p.vendorDir(), name)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return locks, WriteLock(p.Dir, locks)
+}
+
+// Add adds the library to the manifest, or replaces the one with the same name, and installs the
+// libraries
+func (p *Project) Add(pkg Package) ([]Locked, error) {
+	if err := checkPackage(pkg); err != nil {
+		return nil, err
+	}
+	pkgs, err := ReadManifest(p.Dir)
+	if err != nil {
+		return nil, err
+	}
+	updated := slices.Clone(pkgs)
+	if i := slices.IndexFunc(updated, func(o Package) bool { return o.Name == pkg.Name }); i >= 0 {
+		updated[i] = pkg
+	} else {
+		updated = append(updated, pkg)
+	}
+	if err := WriteManifest(p.Dir, updated); err != nil {
+		return nil, err
+	}
+	locks, err := p.Install()
+	if err != nil {
+		// a library that can't be installed isn't added
+		if werr := WriteManifest(p.Dir, pkgs); werr != nil {
+			return nil, werr
+		}
+	}
+	return locks, err
+}
+
+// Remove removes the library from the manifest and the vendored directory
+func (p *Project) Remove(name string) ([]Locked, error) {
+	pkgs, err := ReadManifest(p.Dir)
+	if err != nil {
+		return nil, err
+	}
+	i := slices.IndexFunc(pkgs, func(o Package) bool { return o.Name == name })
+	if i < 0 {
+		return nil, fmt.Errorf("library %s is not in %s", name, ManifestFile)
+	}
+	if err := WriteManifest(p.Dir, slices.Delete(pkgs, i, i+1)); err != nil {
+		return nil, err
+	}
+	return p.Install()
+}
+
+// Verify checks that the installed files of all locked libraries still have the locked hash
+func (p *Project) Verify() error {
+	locks, err := ReadLock(p.Dir)
+	if err != nil {
+		return err
+	}
+	bad := make([]string, 0)
+	for _, name := range sortedNames(locks) {
+		hash, err := HashDir(filepath.Join(p.vendorDir(), name))
+		if err != nil {
+			bad = append(bad, name+" ("+err.Error()+")")
+		} else if hash != locks[name].Hash {
+			bad = append(bad, name+" (hash "+hash+", locked "+locks[name].Hash+")")
+		}
+	}
+	if len(bad) > 0 {
+		return fmt.Errorf("installed libraries don't match %s: %s", LockFile, strings.Join(bad, ", "))
+	}
+	return nil
+}
+
+// ExportMirror copies the installed libraries to a mirror directory, that can later be used to
+// install them offline
+func (p *Project) ExportMirror(dir string) error {
+	locks, err := ReadLock(p.Dir)
+	if err != nil {
+		return err
+	}
+	for _, name := range sortedNames(locks) {
+		target := filepath.Join(dir, name)
+		if err := os.RemoveAll(target); err != nil {
+			return err
+		}
+		if err := copyTree(filepath.Join(p.vendorDir(), name), target); err != nil {
+			return err
+		}
+		p.logf("mirrored %s\n", name)
+	}
+	return nil
+}
+
+func sortedNames(locks map[string]Locked) []string {
+	names := make([]string, 0, len(locks))
+	for name := range locks {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// install fetches the library into a staging directory next to its place in the vendored
+// directory, checks it and then replaces the installed one
+func (p *Project) install(pkg Package, lock *Locked) (Locked, error) {
+	if err := os.MkdirAll(p.vendorDir(), 0755); err != nil {
+		return Locked{}, err
+	}
+	staging, err := os.MkdirTemp(p.vendorDir(), "."+pkg.Name+"-")
+	if err != nil {
+		return Locked{}, err
+	}
+	defer os.RemoveAll(staging)
+
+	want := pkg.Ref
+	if lock != nil && lock.Rev != "" {
+		want = lock.Rev
+	}
+	rev, err := p.fetch(pkg, want, staging)
+	if err != nil {
+		return Locked{}, err
+	}
+	if lock != nil && rev == "" {
+		// a mirror keeps plain copies, the hash tells that it's the locked commit
+		rev = lock.Rev
+	}
+	if p.Signed {
+		if err := verifySignatures(staging); err != nil {
+			return Locked{}, err
+		}
+	}
+	hash, err := HashDir(staging)
+	if err != nil {
+		return Locked{}, err
+	}
+	if lock != nil && hash != lock.Hash {
+		return Locked{}, fmt.Errorf("hash %s doesn't match the locked %s", hash, lock.Hash)
+	}
+
+	target := filepath.Join(p.vendorDir(), pkg.Name)
+	if err := os.RemoveAll(target); err != nil {
+		return Locked{}, err
+	}
+	if err := os.Rename(staging, target); err != nil {
+		return Locked{}, err
+	}
+	p.logf("installed %s %s %s\n", pkg.Name, dash(rev), hash)
+	return Locked{pkg, rev, hash}, nil
+}
+
+// fetch copies the files of the library into dir and returns the commit, if it's known. The
+// mirror is used first, git sources are cloned and local directories copied.
+func (p *Project) fetch(pkg Package, want string, dir string) (string, error) {
+	if p.Mirror != "" {
+		src := filepath.Join(p.Mirror, pkg.Name)
+		if info, err := os.Stat(src); err == nil && info.IsDir() {
+			if isGitRepo(src) {
+				return gitFetch(src, want, dir)
+			}
+			return "", copyTree(src, dir)
+		}
+	}
+	if isGitURL(pkg.Source) {
+		if p.Offline {
+			return "", fmt.Errorf("%s is not in the mirror and can't be fetched offline", pkg.Source)
+		}
+		return gitFetch(pkg.Source, want, dir)
+	}
+	src := pkg.Source
+	if !filepath.IsAbs(src) {
+		src = filepath.Join(p.Dir, src)
+	}
+	if isGitRepo(src) && want != "" {
+		return gitFetch(src, want, dir)
+	}
+	return "", copyTree(src, dir)
+}
+
+func isGitURL(source string) bool {
+	return strings.Contains(source, "://") || strings.HasPrefix(source, "git@") || strings.HasSuffix(source, ".git")
+}
+
+func isGitRepo(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, ".git"))
+	return err == nil
+}
+
+// gitFetch clones the repository, checks out the commit, branch or tag and copies its files
+// without the .git directory into dir
+func gitFetch(source string, want string, dir string) (string, error) {
+	clone, err := os.MkdirTemp("", "ryepkg-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(clone)
+	// the checks of the manifest and lockfile already refuse these, a source or ref that starts with
+	// - would be taken for an option
+	if strings.HasPrefix(source, "-") || strings.HasPrefix(want, "-") {
+		return "", fmt.Errorf("invalid source %q or ref %q", source, want)
+	}
+	if _, err := git("", "clone", "--quiet", "--", source, clone); err != nil {
+		return "", err
+	}
+	if want != "" {
+		// the -- after the ref makes git take it only as a commit, branch or tag, not as a path
+		if _, err := git(clone, "checkout", "--quiet", want, "--"); err != nil {
+			return "", err
+		}
+	}
+	rev, err := git(clone, "rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	return rev, copyTree(clone, dir)
+}
+
+func git(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %w %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// copyTree copies the files of src into dst, without .git directories
+func copyTree(src string, dst string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", src)
+	}
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, path)
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(target, 0755)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, content, 0644)
+	})
+}
+
+// verifySignatures checks the code signatures of all .rye files against the trusted public keys
+func verifySignatures(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || filepath.Ext(path) != ".rye" {
+			return err
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := loader.VerifyCodeSignature(string(content)); err != nil {
+			rel, _ := filepath.Rel(dir, path)
+			return fmt.Errorf("%s: %w", filepath.ToSlash(rel), err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,137 @@
+package ryepkg
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/refaktor/rye/security"
+)
+
+func writeFile(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInstallLockAndOfflineMirror(t *testing.T) {
+	root := t.TempDir()
+	proj := filepath.Join(root, "proj")
+	writeFile(t, filepath.Join(proj, "main.rye"), "import %lib :lib\n")
+	writeFile(t, filepath.Join(root, "lib", "main.rye"), "sq: fn { x } { x * x }\n")
+	writeFile(t, filepath.Join(root, "lib", "util", "more.rye"), "cube: fn { x } { x * x * x }\n")
+
+	p := &Project{Dir: proj}
+	locks, err := p.Add(Package{Name: "lib", Source: "../lib"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locks) != 1 || !strings.HasPrefix(locks[0].Hash, "sha256:") {
+		t.Fatalf("unexpected lock %v", locks)
+	}
+	if _, err := os.Stat(filepath.Join(proj, "rye_modules", "lib", "util", "more.rye")); err != nil {
+		t.Fatal("library was not installed:", err)
+	}
+	if err := p.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the mirror is used instead of the source, and must have the locked files
+	mirror := filepath.Join(root, "mirror")
+	if err := p.ExportMirror(mirror); err != nil {
+		t.Fatal(err)
+	}
+	os.RemoveAll(filepath.Join(root, "lib"))
+	os.RemoveAll(filepath.Join(proj, "rye_modules"))
+	offline := &Project{Dir: proj, Mirror: mirror, Offline: true}
+	if _, err := offline.Install(); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(mirror, "lib", "main.rye"), "sq: fn { x } { x + x }\n")
+	if _, err := offline.Install(); err == nil || !strings.Contains(err.Error(), "doesn't match the locked") {
+		t.Fatalf("expected hash mismatch, got %v", err)
+	}
+	writeFile(t, filepath.Join(proj, "rye_modules", "lib", "main.rye"), "changed")
+	if err := p.Verify(); err == nil {
+		t.Fatal("changed library passed verify")
+	}
+
+	if _, err := offline.Remove("lib"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(proj, "rye_modules", "lib")); !os.IsNotExist(err) {
+		t.Fatal("removed library is still installed")
+	}
+}
+
+func TestInstallSigned(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enabled := security.CurrentCodeSigEnabled
+	defer func() { security.CurrentCodeSigEnabled = enabled }()
+	if err := security.LoadPublicKeysFromStrings([]string{hex.EncodeToString(pub)}); err != nil {
+		t.Fatal(err)
+	}
+	security.CurrentCodeSigEnabled = true
+
+	root := t.TempDir()
+	code := "sq: fn { x } { x * x }"
+	sig := hex.EncodeToString(ed25519.Sign(priv, []byte(code)))
+	writeFile(t, filepath.Join(root, "signed", "main.rye"), code+"\n;ryesig "+sig+"\n")
+	writeFile(t, filepath.Join(root, "unsigned", "main.rye"), code+"\n")
+
+	writeFile(t, filepath.Join(root, "proj", "main.rye"), "import %signed :s\n")
+	p := &Project{Dir: filepath.Join(root, "proj"), Signed: true}
+	if _, err := p.Add(Package{Name: "signed", Source: "../signed"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Add(Package{Name: "unsigned", Source: "../unsigned"}); err == nil {
+		t.Fatal("unsigned library was installed")
+	}
+	if pkgs, _ := ReadManifest(p.Dir); len(pkgs) != 1 {
+		t.Fatalf("library that failed to install is in the manifest: %v", pkgs)
+	}
+}
+
+func TestRefuseOptionLikeArguments(t *testing.T) {
+	root := t.TempDir()
+	p := &Project{Dir: root}
+	for _, pkg := range []Package{
+		{Name: "lib", Source: "--upload-pack=touch /tmp/x"},
+		{Name: "lib", Source: "https://example.com/lib.git", Ref: "--orphan=x"},
+	} {
+		if _, err := p.Add(pkg); err == nil || !strings.Contains(err.Error(), "invalid") {
+			t.Errorf("expected %v to be refused, got %v", pkg, err)
+		}
+	}
+	if _, err := gitFetch("-c", "", t.TempDir()); err == nil {
+		t.Error("expected gitFetch to refuse an option as the source")
+	}
+
+	writeFile(t, filepath.Join(root, ManifestFile), "lib ../lib -b\n")
+	if _, err := ReadManifest(root); err == nil {
+		t.Error("expected a ref starting with - in the manifest to be refused")
+	}
+	rev := strings.Repeat("0123456789", 4)
+	for lock, ok := range map[string]bool{
+		"lib ../lib - " + rev + " sha256:0\n":                        true,
+		"lib ../lib - - sha256:0\n":                                  true,
+		"lib ../lib - --output=x sha256:0\n":                         false,
+		"lib ../lib - main sha256:0\n":                               false,
+		"lib ../lib - " + strings.ToUpper(rev[:39]) + "g sha256:0\n": false,
+	} {
+		writeFile(t, filepath.Join(root, LockFile), lock)
+		if _, err := ReadLock(root); (err == nil) != ok {
+			t.Errorf("%q: expected ok %v, got %v", lock, ok, err)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package ryetest
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"runtime"
+
+	"github.com/refaktor/rye/cover"
+	"github.com/refaktor/rye/env"
+)
+
+// Main runs the rye test command with the arguments after "test" and returns the exit code:
+// 0 if all tests passed, 1 if a test failed, a file couldn't be evaluated or the coverage is below
+// -covermin, 2 for wrong usage.
+func Main(args []string, setup func(file string) (*env.ProgramState, error)) int {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	run := fs.String("run", "", "Run only the tests whose group / name matches the regular expression")
+	parallel := fs.Int("p", runtime.NumCPU(), "Number of test files run in parallel")
+	format := fs.String("format", "text", "Output format: text, tap or junit")
+	output := fs.String("o", "", "Write the report to the file instead of stdout")
+	verbose := fs.Bool("v", false, "List the passed tests too (text format)")
+	coverOn := fs.Bool("cover", false, "Record which lines of the imported and loaded files the tests run")
+	coverProfile := fs.String("coverprofile", "", "Write the coverage profile to the file (implies -cover)")
+	coverHTML := fs.String("coverhtml", "", "Write the HTML coverage report to the file (implies -cover)")
+	coverMin := fs.Float64("covermin", 0, "Fail if the total coverage is below the percentage (implies -cover)")
+	fs.Usage = func() {
+		fmt.Println("Usage: rye test [options] [files or directories]")
+		fmt.Println("\n Runs the tests of *_test.rye files, in the current directory if none are given.")
+		fmt.Println("\n Options:")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	opts := Options{Parallel: *parallel, Setup: setup}
+	if *run != "" {
+		re, err := regexp.Compile(*run)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Invalid -run expression:", err)
+			return 2
+		}
+		opts.Filter = re
+	}
+	if *format != "text" && *format != "tap" && *format != "junit" {
+		fmt.Fprintln(os.Stderr, "Unknown format "+*format+", use text, tap or junit")
+		return 2
+	}
+
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	files, err := Discover(paths)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if len(files) == 0 {
+		fmt.Fprintln(os.Stderr, "No *_test.rye files found")
+		return 1
+	}
+
+	var prof *cover.Profile
+	if *coverOn || *coverProfile != "" || *coverHTML != "" || *coverMin > 0 {
+		prof = cover.New()
+		prof.Start()
+	}
+	// what the tests and the interpreter print while they run goes to stderr when the report is
+	// on stdout, so it stays valid TAP or XML
+	stdout := os.Stdout
+	if *format != "text" && *output == "" {
+		os.Stdout = os.Stderr
+	}
+	results := Run(files, opts)
+	os.Stdout = stdout
+	if prof != nil {
+		prof.Stop()
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		defer f.Close()
+		w = f
+	}
+	switch *format {
+	case "tap":
+		WriteTAP(w, results)
+	case "junit":
+		if err := WriteJUnit(w, results); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	default:
+		WriteText(w, results, *verbose)
+	}
+	code := 0
+	if !Summarize(results).OK() {
+		code = 1
+	}
+	if prof != nil {
+		// the summary goes to stderr when the report is on stdout, so it stays valid TAP or XML
+		var sw io.Writer = os.Stdout
+		if *format != "text" && *output == "" {
+			sw = os.Stderr
+		}
+		if err := prof.Report(sw, *coverProfile, *coverHTML, *coverMin); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			code = 1
+		}
+	}
+	return code
+}
@@ -0,0 +1,197 @@
+package ryetest
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Summary counts the results of a run
+type Summary struct {
+	Passed, Failed, FileErrors int
+	Duration                   time.Duration
+}
+
+func Summarize(results []FileResult) Summary {
+	var s Summary
+	for _, fr := range results {
+		s.Duration += fr.Duration
+		if fr.Error != nil {
+			s.FileErrors++
+		}
+		for _, r := range fr.Results {
+			if r.Passed() {
+				s.Passed++
+			} else {
+				s.Failed++
+			}
+		}
+	}
+	return s
+}
+
+// OK is true if no test failed and all files could be evaluated
+func (s Summary) OK() bool {
+	return s.Failed == 0 && s.FileErrors == 0
+}
+
+// WriteText writes the results for the terminal, failures with their locations
+func WriteText(w io.Writer, results []FileResult, verbose bool) {
+	for _, fr := range results {
+		if fr.Error != nil {
+			fmt.Fprintf(w, "\033[31mFAIL\033[0m %s\n    %s\n", fr.File, fr.Error)
+			continue
+		}
+		for _, r := range fr.Results {
+			if r.Passed() {
+				if verbose {
+					fmt.Fprintf(w, "\033[32mok\033[0m   %s: %s (%s)\n", fr.File, r.Test.FullName(), r.Duration.Round(time.Microsecond))
+				}
+				continue
+			}
+			fmt.Fprintf(w, "\033[31mFAIL\033[0m %s: %s\n", fr.File, r.Test.FullName())
+			for _, f := range r.Failures {
+				fmt.Fprintf(w, "    %s\n", f)
+			}
+		}
+	}
+	s := Summarize(results)
+	status := "\033[32mPASS\033[0m"
+	if !s.OK() {
+		status = "\033[31mFAIL\033[0m"
+	}
+	fmt.Fprintf(w, "%s %d passed, %d failed", status, s.Passed, s.Failed)
+	if s.FileErrors > 0 {
+		fmt.Fprintf(w, ", %d files with errors", s.FileErrors)
+	}
+	fmt.Fprintf(w, " in %d files (%s)\n", len(results), s.Duration.Round(time.Millisecond))
+}
+
+// WriteTAP writes the results in the Test Anything Protocol version 13, a file that couldn't be
+// evaluated is one failed test point
+func WriteTAP(w io.Writer, results []FileResult) {
+	n := 0
+	for _, fr := range results {
+		if fr.Error != nil {
+			n++
+		}
+		n += len(fr.Results)
+	}
+	fmt.Fprintln(w, "TAP version 13")
+	fmt.Fprintf(w, "1..%d\n", n)
+	i := 0
+	for _, fr := range results {
+		if fr.Error != nil {
+			i++
+			fmt.Fprintf(w, "not ok %d - %s\n", i, tapEscape(fr.File))
+			writeTAPDiagnostics(w, []Failure{*fr.Error})
+		}
+		for _, r := range fr.Results {
+			i++
+			name := tapEscape(fr.File + ": " + r.Test.FullName())
+			if r.Passed() {
+				fmt.Fprintf(w, "ok %d - %s\n", i, name)
+			} else {
+				fmt.Fprintf(w, "not ok %d - %s\n", i, name)
+				writeTAPDiagnostics(w, r.Failures)
+			}
+		}
+	}
+}
+
+func tapEscape(s string) string {
+	return strings.NewReplacer("#", "\\#", "\n", " ").Replace(s)
+}
+
+func writeTAPDiagnostics(w io.Writer, failures []Failure) {
+	fmt.Fprintln(w, "  ---")
+	fmt.Fprintln(w, "  failures:")
+	for _, f := range failures {
+		fmt.Fprintf(w, "    - message: %q\n", f.Message)
+		if f.File != "" {
+			fmt.Fprintf(w, "      file: %q\n", f.File)
+			fmt.Fprintf(w, "      line: %d\n", f.Line)
+		}
+	}
+	fmt.Fprintln(w, "  ...")
+}
+
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Errors   int          `xml:"errors,attr"`
+	Time     string       `xml:"time,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Errors   int         `xml:"errors,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	File      string        `xml:"file,attr,omitempty"`
+	Line      int           `xml:"line,attr,omitempty"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// WriteJUnit writes the results as JUnit XML, a test suite for each file. A file that couldn't be
+// evaluated is a suite with one errored test case.
+func WriteJUnit(w io.Writer, results []FileResult) error {
+	s := Summarize(results)
+	doc := junitSuites{Tests: s.Passed + s.Failed + s.FileErrors, Failures: s.Failed, Errors: s.FileErrors, Time: seconds(s.Duration)}
+	for _, fr := range results {
+		suite := junitSuite{Name: fr.File, Time: seconds(fr.Duration)}
+		if fr.Error != nil {
+			suite.Errors = 1
+			suite.Cases = append(suite.Cases, junitCase{
+				Name: fr.File, Classname: fr.File, File: fr.File, Time: seconds(fr.Duration),
+				Error: &junitMessage{Message: fr.Error.Message, Text: fr.Error.String()},
+			})
+		}
+		for _, r := range fr.Results {
+			c := junitCase{Name: r.Test.FullName(), Classname: fr.File, File: r.Test.File, Line: r.Test.Line, Time: seconds(r.Duration)}
+			if !r.Passed() {
+				suite.Failures++
+				lines := make([]string, len(r.Failures))
+				for i, f := range r.Failures {
+					lines[i] = f.String()
+				}
+				c.Failure = &junitMessage{Message: r.Failures[0].Message, Text: strings.Join(lines, "\n")}
+			}
+			suite.Cases = append(suite.Cases, c)
+		}
+		suite.Tests = len(suite.Cases)
+		doc.Suites = append(doc.Suites, suite)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,366 @@
+// Package ryetest runs the tests of *_test.rye files. A test file is evaluated once, the tests it
+// declares with test "name" { ... } are collected and then each one is run in its own context,
+// whose parent is the context of the file, so the words a test sets don't reach other tests.
+//
+//	test-group "math"
+//	test "adds" { equal { 1 + 2 } 3 }
+//	test "divides by zero" { error { 1 / 0 } }
+package ryetest
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/refaktor/rye/debugger"
+	"github.com/refaktor/rye/env"
+	"github.com/refaktor/rye/evaldo"
+	"github.com/refaktor/rye/loader"
+)
+
+// Test is a test declared in a test file
+type Test struct {
+	Group string
+	Name  string
+	File  string
+	Line  int
+	body  env.Block
+}
+
+// FullName is the name the filter is matched against and reports show
+func (t *Test) FullName() string {
+	if t.Group == "" {
+		return t.Name
+	}
+	return t.Group + " / " + t.Name
+}
+
+// Failure is a failed assertion or an error, with the source location it happened at
+type Failure struct {
+	Message string
+	File    string
+	Line    int
+}
+
+func (f Failure) String() string {
+	if f.File == "" {
+		return f.Message
+	}
+	return fmt.Sprintf("%s:%d: %s", f.File, f.Line, f.Message)
+}
+
+type Result struct {
+	Test     *Test
+	Failures []Failure
+	Duration time.Duration
+}
+
+func (r *Result) Passed() bool {
+	return len(r.Failures) == 0
+}
+
+// FileResult holds the results of the tests of a file, or the error that stopped the file from
+// being evaluated
+type FileResult struct {
+	File     string
+	Error    *Failure
+	Results  []Result
+	Duration time.Duration
+}
+
+type Options struct {
+	Filter   *regexp.Regexp // only tests whose full name matches are run
+	Parallel int            // number of files run at the same time
+	// Setup returns a program state with the builtins registered, for the test file
+	Setup func(file string) (*env.ProgramState, error)
+}
+
+// Discover returns the test files among the paths, directories are searched recursively,
+// without hidden directories and installed libraries
+func Discover(paths []string) ([]string, error) {
+	files := make([]string, 0)
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if p != path && (strings.HasPrefix(d.Name(), ".") || d.Name() == evaldo.ModuleVendorDir) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if strings.HasSuffix(d.Name(), "_test.rye") {
+				files = append(files, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	slices.Sort(files)
+	return slices.Compact(files), nil
+}
+
+// setupMutex serializes the setup of program states, registering builtins isn't safe to do concurrently
+var setupMutex sync.Mutex
+
+// Run runs the test files, up to opts.Parallel at the same time. The results are in the order of the files.
+func Run(files []string, opts Options) []FileResult {
+	results := make([]FileResult, len(files))
+	workers := max(opts.Parallel, 1)
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range min(workers, len(files)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = RunFile(files[i], opts)
+			}
+		}()
+	}
+	for i := range files {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// RunFile evaluates the test file and runs the tests it declares
+func RunFile(file string, opts Options) FileResult {
+	start := time.Now()
+	fr := FileResult{File: file}
+	fail := func(failure *Failure) FileResult {
+		fr.Error = failure
+		fr.Duration = time.Since(start)
+		return fr
+	}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return fail(&Failure{Message: err.Error(), File: file})
+	}
+	setupMutex.Lock()
+	ps, err := opts.Setup(file)
+	setupMutex.Unlock()
+	if err != nil {
+		return fail(&Failure{Message: err.Error(), File: file})
+	}
+	ps.ScriptPath = file
+
+	var block env.Block
+	switch val := loader.LoadString(string(content), false, ps).(type) {
+	case env.Block:
+		block = val
+	case env.Error:
+		return fail(&Failure{Message: val.Message, File: file})
+	}
+	run := &fileRun{file: file, smap: debugger.NewSourceMap(file, string(content), block), tests: make([]*Test, 0)}
+	fileCtx := env.NewEnv(ps.Ctx)
+	for name, bu := range run.builtins() {
+		fileCtx.Set(ps.Idx.IndexWord(name), *bu)
+	}
+	ps.Ctx = fileCtx
+	ps = env.AddToProgramStateNEWWithLocation(ps, &block, ps.Idx)
+	if failure := run.eval(ps, block.Series); failure != nil {
+		return fail(failure)
+	}
+
+	for _, t := range run.tests {
+		if opts.Filter != nil && !opts.Filter.MatchString(t.FullName()) {
+			continue
+		}
+		fr.Results = append(fr.Results, run.runTest(ps, fileCtx, t))
+	}
+	fr.Duration = time.Since(start)
+	return fr
+}
+
+// fileRun collects the tests of a file and the failures of the test that is running
+type fileRun struct {
+	file    string
+	smap    *debugger.SourceMap
+	group   string
+	tests   []*Test
+	current *Result
+}
+
+func (r *fileRun) runTest(ps *env.ProgramState, fileCtx *env.RyeCtx, t *Test) Result {
+	start := time.Now()
+	res := Result{Test: t}
+	r.current = &res
+	ps.Ctx = env.NewEnv(fileCtx)
+	if failure := r.eval(ps, t.body.Series); failure != nil {
+		res.Failures = append(res.Failures, *failure)
+	}
+	ps.Ctx = fileCtx
+	r.current = nil
+	res.Duration = time.Since(start)
+	return res
+}
+
+// eval evaluates the series and returns the error or unhandled failure it ended with
+func (r *fileRun) eval(ps *env.ProgramState, ser env.TSeries) (failure *Failure) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			failure = &Failure{Message: fmt.Sprint("panic: ", rec), File: r.file}
+		}
+	}()
+	prev := ps.Ser
+	ps.Ser = *env.NewTSeries(ser.S)
+	evaldo.Eval(ps)
+	if ps.ErrorFlag || ps.FailureFlag {
+		failure = r.failure(errorMessage(ps, ps.Res), ps.Ser)
+	}
+	ps.Ser = prev
+	ps.ErrorFlag = false
+	ps.FailureFlag = false
+	ps.ReturnFlag = false
+	return failure
+}
+
+// failure returns a failure at the line of the value the series was at
+func (r *fileRun) failure(msg string, ser env.TSeries) *Failure {
+	f := &Failure{Message: msg, File: r.file}
+	if loc, ok := r.smap.Location(ser, ser.GetPos()-1); ok {
+		f.File = loc.Filename
+		f.Line = loc.Line
+	}
+	return f
+}
+
+func errorMessage(ps *env.ProgramState, res env.Object) string {
+	if err, ok := res.(*env.Error); ok {
+		return "error: " + err.Message
+	}
+	if res == nil {
+		return "error"
+	}
+	return "error: " + res.Print(*ps.Idx)
+}
+
+// evalCode evaluates the code of an assertion in a context whose parent is the context of the
+// test, like the equal of the builtins' tests, so assertions can set the same words
+func evalCode(ps *env.ProgramState, code env.Block) (env.Object, bool) {
+	ser := ps.Ser
+	ctx := ps.Ctx
+	ps.Ser = *env.NewTSeries(code.Series.S)
+	ps.Ctx = env.NewEnv(ctx)
+	evaldo.Eval(ps)
+	ps.Ser = ser
+	ps.Ctx = ctx
+	failed := ps.ErrorFlag || ps.FailureFlag
+	ps.ErrorFlag = false
+	ps.FailureFlag = false
+	ps.ReturnFlag = false
+	return ps.Res, failed
+}
+
+func (r *fileRun) assertion(ps *env.ProgramState, fnName string) (*Result, env.Object) {
+	if r.current == nil {
+		return nil, evaldo.MakeBuiltinError(ps, "Can only be used inside a test.", fnName)
+	}
+	return r.current, nil
+}
+
+// Builtins returns the builtins the runner adds to test files, for tools that check their code
+func Builtins() map[string]*env.Builtin {
+	return (&fileRun{}).builtins()
+}
+
+func (r *fileRun) builtins() map[string]*env.Builtin {
+	return map[string]*env.Builtin{
+		"test-group": env.NewBuiltin(func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			switch name := arg0.(type) {
+			case env.String:
+				r.group = name.Value
+				return arg0
+			default:
+				return evaldo.MakeArgError(ps, 1, []env.Type{env.StringType}, "test-group")
+			}
+		}, 1, false, false, "Names the group of the tests declared after it."),
+
+		"test": env.NewBuiltin(func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			name, ok := arg0.(env.String)
+			if !ok {
+				return evaldo.MakeArgError(ps, 1, []env.Type{env.StringType}, "test")
+			}
+			body, ok := arg1.(env.Block)
+			if !ok {
+				return evaldo.MakeArgError(ps, 2, []env.Type{env.BlockType}, "test")
+			}
+			if r.current != nil {
+				return evaldo.MakeBuiltinError(ps, "Tests can't be declared inside a test.", "test")
+			}
+			t := &Test{Group: r.group, Name: name.Value, File: r.file, Line: body.Line, body: body}
+			if loc, ok := r.smap.Location(ps.Ser, ps.Ser.GetPos()-1); ok {
+				t.Line = loc.Line
+			}
+			r.tests = append(r.tests, t)
+			return arg0
+		}, 2, false, false, "Declares a test, the block is run in its own context after the file is evaluated."),
+
+		"equal": env.NewBuiltin(func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			res, errObj := r.assertion(ps, "equal")
+			if errObj != nil {
+				return errObj
+			}
+			code, ok := arg0.(env.Block)
+			if !ok {
+				return evaldo.MakeArgError(ps, 1, []env.Type{env.BlockType}, "equal")
+			}
+			got, failed := evalCode(ps, code)
+			switch {
+			case failed:
+				res.Failures = append(res.Failures, *r.failure("expected "+arg1.Inspect(*ps.Idx)+", got "+errorMessage(ps, got), ps.Ser))
+			case got == nil || !got.Equal(arg1):
+				gotStr := "nothing"
+				if got != nil {
+					gotStr = got.Inspect(*ps.Idx)
+				}
+				res.Failures = append(res.Failures, *r.failure("expected "+arg1.Inspect(*ps.Idx)+", got "+gotStr, ps.Ser))
+			default:
+				return *env.NewBoolean(true)
+			}
+			return *env.NewBoolean(false)
+		}, 2, false, false, "Checks that the block evaluates to the value."),
+
+		"error": env.NewBuiltin(func(ps *env.ProgramState, arg0 env.Object, arg1 env.Object, arg2 env.Object, arg3 env.Object, arg4 env.Object) env.Object {
+			res, errObj := r.assertion(ps, "error")
+			if errObj != nil {
+				return errObj
+			}
+			code, ok := arg0.(env.Block)
+			if !ok {
+				return evaldo.MakeArgError(ps, 1, []env.Type{env.BlockType}, "error")
+			}
+			got, failed := evalCode(ps, code)
+			if _, isErr := got.(*env.Error); failed || isErr {
+				return *env.NewBoolean(true)
+			}
+			gotStr := "nothing"
+			if got != nil {
+				gotStr = got.Inspect(*ps.Idx)
+			}
+			res.Failures = append(res.Failures, *r.failure("expected an error, got "+gotStr, ps.Ser))
+			return *env.NewBoolean(false)
+		}, 1, false, false, "Checks that the block fails or returns an error."),
+	}
+}
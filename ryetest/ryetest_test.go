@@ -0,0 +1,144 @@
+package ryetest
+
+import (
+	"bytes"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/refaktor/rye/env"
+	"github.com/refaktor/rye/evaldo"
+)
+
+func setup(file string) (*env.ProgramState, error) {
+	ps := env.NewProgramState()
+	evaldo.RegisterBuiltins(ps)
+	return ps, nil
+}
+
+func writeTests(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string]string{
+		"math_test.rye": `base: 10
+test-group "math"
+test "adds" {
+	equal { 1 + 2 } 3
+	equal { base + 1 } 11
+}
+test "sets a word" { x: 1 equal { x } 1 }
+test "doesn't see other tests" { error { x } }
+test "fails" {
+	equal { 1 + 1 } 3
+}
+`,
+		"sub/broken_test.rye": "x: 1\ny: not-defined\n",
+		"helper.rye":          "test \"not a test file\" { equal { 1 } 2 }\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestRunFiles(t *testing.T) {
+	dir := writeTests(t)
+	files, err := Discover([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("expected 2 test files, got %v", files)
+	}
+	results := Run(files, Options{Parallel: 2, Setup: setup})
+
+	math := results[0]
+	if math.Error != nil || len(math.Results) != 4 {
+		t.Fatalf("unexpected results of math_test.rye: %+v", math)
+	}
+	for _, r := range math.Results[:3] {
+		if !r.Passed() {
+			t.Errorf("%s failed: %v", r.Test.FullName(), r.Failures)
+		}
+	}
+	failed := math.Results[3]
+	if failed.Passed() || failed.Failures[0].Line != 10 || failed.Test.Line != 9 {
+		t.Errorf("expected a failure at line 10 of the test at line 9, got %+v %v", failed.Test, failed.Failures)
+	}
+	broken := results[1]
+	if broken.Error == nil || broken.Error.Line != 2 || !strings.Contains(broken.Error.Message, "not-defined") {
+		t.Errorf("expected an error at line 2 of broken_test.rye, got %+v", broken.Error)
+	}
+	if s := Summarize(results); s.Passed != 3 || s.Failed != 1 || s.FileErrors != 1 || s.OK() {
+		t.Errorf("unexpected summary %+v", s)
+	}
+
+	filtered := Run(files[:1], Options{Filter: regexp.MustCompile("math / adds"), Setup: setup})
+	if len(filtered[0].Results) != 1 {
+		t.Errorf("filter should leave one test, got %d", len(filtered[0].Results))
+	}
+}
+
+func TestReports(t *testing.T) {
+	dir := writeTests(t)
+	files, _ := Discover([]string{dir})
+	results := Run(files, Options{Parallel: 1, Setup: setup})
+
+	var tap bytes.Buffer
+	WriteTAP(&tap, results)
+	out := tap.String()
+	if !strings.HasPrefix(out, "TAP version 13\n1..5\n") || strings.Count(out, "not ok") != 2 {
+		t.Errorf("unexpected TAP output:\n%s", out)
+	}
+
+	var junit bytes.Buffer
+	if err := WriteJUnit(&junit, results); err != nil {
+		t.Fatal(err)
+	}
+	var doc junitSuites
+	if err := xml.Unmarshal(junit.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Tests != 5 || doc.Failures != 1 || doc.Errors != 1 || len(doc.Suites) != 2 {
+		t.Errorf("unexpected JUnit totals %+v", doc)
+	}
+}
+
+func TestReportOnStdoutStaysValid(t *testing.T) {
+	dir := writeTests(t)
+	if err := os.WriteFile(filepath.Join(dir, "noisy_test.rye"), []byte("test \"prints\" { print \"noise\" equal { 1 } 1 }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	out := make(chan string)
+	go func() {
+		var buf bytes.Buffer
+		buf.ReadFrom(r)
+		out <- buf.String()
+	}()
+	code := Main([]string{"-format", "tap", "-p", "1", dir}, setup)
+	os.Stdout = stdout
+	w.Close()
+	report := <-out
+
+	if code != 1 {
+		t.Errorf("expected exit code 1, got %d", code)
+	}
+	if !strings.HasPrefix(report, "TAP version 13\n") || strings.Contains(report, "noise") {
+		t.Errorf("the report should only have TAP on stdout, got:\n%s", report)
+	}
+}